@@ -24,6 +24,7 @@ import (
 	"go.skia.org/infra/go/metadata"
 	"go.skia.org/infra/go/sklog"
 
+	"go.skia.org/infra/autoroll/go/autoroll_policy"
 	"go.skia.org/infra/autoroll/go/autoroller"
 	"go.skia.org/infra/autoroll/go/repo_manager"
 	"go.skia.org/infra/go/common"
@@ -46,8 +47,12 @@ var (
 	childName       = flag.String("childName", "Skia", "Name of the project to roll.")
 	childPath       = flag.String("childPath", "src/third_party/skia", "Path within parent repo of the project to roll.")
 	childBranch     = flag.String("child_branch", "master", "Branch of the project we want to roll.")
+	cipdPackage     = flag.String("cipd_package", "", "Roll this CIPD package instead of a child repo. Its instance ID is pinned in --cipd_version_file.")
+	cipdRef         = flag.String("cipd_ref", "latest", "CIPD ref or tag of the instance of --cipd_package to roll to.")
+	cipdVersionFile = flag.String("cipd_version_file", "", "Path within parent repo of the file which pins the instance of --cipd_package.")
 	cqExtraTrybots  = flag.String("cqExtraTrybots", "", "Comma-separated list of trybots to run.")
 	depot_tools     = flag.String("depot_tools", "", "Path to the depot_tools installation. If empty, assumes depot_tools is in PATH.")
+	extraChildPaths = flag.String("extra_child_paths", "", "Comma-separated paths within parent repo of additional projects to roll in the same CL as --childPath.")
 	host            = flag.String("host", "localhost", "HTTP service host")
 	local           = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
 	parentRepo      = flag.String("parent_repo", common.REPO_CHROMIUM, "Repo to roll into.")
@@ -150,6 +155,31 @@ func modeJsonHandler(w http.ResponseWriter, r *http.Request) {
 	statusJsonHandler(w, r)
 }
 
+func policyJsonHandler(w http.ResponseWriter, r *http.Request) {
+	if !login.IsGoogler(r) {
+		httputils.ReportError(w, r, fmt.Errorf("User does not have edit rights."), "You must be logged in with an @google.com account to do that.")
+		return
+	}
+
+	var policy struct {
+		Message string                  `json:"message"`
+		Policy  *autoroll_policy.Policy `json:"policy"`
+	}
+	defer util.Close(r.Body)
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		httputils.ReportError(w, r, err, "Failed to decode request body.")
+		return
+	}
+
+	if err := arb.SetPolicy(policy.Policy, login.LoggedInAs(r), policy.Message); err != nil {
+		httputils.ReportError(w, r, err, "Failed to set AutoRoll policy.")
+		return
+	}
+
+	// Return the ARB status.
+	statusJsonHandler(w, r)
+}
+
 func statusJsonHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	// Obtain the status info. Only display error messages if the user
@@ -184,6 +214,7 @@ func runServer() {
 	r.PathPrefix("/res/").HandlerFunc(httputils.MakeResourceHandler(*resourcesDir))
 	r.HandleFunc("/", mainHandler)
 	r.HandleFunc("/json/mode", modeJsonHandler).Methods("POST")
+	r.HandleFunc("/json/policy", policyJsonHandler).Methods("POST")
 	r.HandleFunc("/json/status", httputils.CorsHandler(statusJsonHandler))
 	r.HandleFunc("/json/version", skiaversion.JsonHandler)
 	r.HandleFunc("/oauth2callback/", login.OAuth2CallbackHandler)
@@ -273,7 +304,15 @@ func main() {
 	sklog.Infof("Sheriff: %s", strings.Join(emails, ", "))
 
 	// Start the autoroller.
-	arb, err = autoroller.NewAutoRoller(*workdir, *parentRepo, *parentBranch, *childPath, *childBranch, cqExtraTrybots, emails, g, time.Minute, 15*time.Minute, *depot_tools, *rollIntoAndroid, *strategy)
+	rmConfig := &autoroller.RepoManagerConfig{
+		CIPDPackage:     *cipdPackage,
+		CIPDRef:         *cipdRef,
+		CIPDVersionFile: *cipdVersionFile,
+	}
+	if *extraChildPaths != "" {
+		rmConfig.ExtraChildPaths = strings.Split(*extraChildPaths, ",")
+	}
+	arb, err = autoroller.NewAutoRoller(*workdir, *parentRepo, *parentBranch, *childPath, *childBranch, cqExtraTrybots, emails, g, time.Minute, 15*time.Minute, *depot_tools, *rollIntoAndroid, *strategy, rmConfig)
 	if err != nil {
 
 		sklog.Fatal(err)
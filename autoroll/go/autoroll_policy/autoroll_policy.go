@@ -0,0 +1,443 @@
+package autoroll_policy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.skia.org/infra/go/autoroll"
+	"go.skia.org/infra/go/sklog"
+)
+
+const (
+	POLICY_HISTORY_LENGTH = 25
+
+	// TIME_OF_DAY_FORMAT is the format of the start and end of TimeWindows.
+	TIME_OF_DAY_FORMAT = "15:04"
+)
+
+var (
+	// VALID_DAYS are the valid days of the week of TimeWindows.
+	VALID_DAYS = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+)
+
+// TimeWindow is a range of the time of day, on some days of the week, during
+// which new rolls may be uploaded.
+type TimeWindow struct {
+	Days  []string `json:"days"`  // Days of the week, eg. "Mon". Empty means every day.
+	Start string   `json:"start"` // Time of day in TIME_OF_DAY_FORMAT, eg. "09:00".
+	End   string   `json:"end"`   // Time of day, exclusive. If not after Start, the window extends into the next day.
+}
+
+// Policy controls when the AutoRoller may upload new rolls. The zero Policy
+// doesn't restrict the AutoRoller at all.
+type Policy struct {
+	MaxRolls    int           `json:"maxRolls"`    // Maximum number of rolls uploaded per RollPeriod. Zero means no limit.
+	RollPeriod  string        `json:"rollPeriod"`  // Duration, eg. "24h". Required if MaxRolls is set.
+	TimeZone    string        `json:"timeZone"`    // Time zone of the Windows, eg. "America/New_York". Empty means UTC.
+	Windows     []*TimeWindow `json:"windows"`     // If not empty, rolls are only uploaded during one of the windows.
+	BackoffBase string        `json:"backoffBase"` // Duration. After N consecutive failed rolls, wait BackoffBase * 2^(N-1). Empty disables back-off.
+	BackoffMax  string        `json:"backoffMax"`  // Duration. The maximum back-off. Empty means no maximum.
+}
+
+// Copy returns a copy of the Policy.
+func (p *Policy) Copy() *Policy {
+	rv := &Policy{
+		MaxRolls:    p.MaxRolls,
+		RollPeriod:  p.RollPeriod,
+		TimeZone:    p.TimeZone,
+		BackoffBase: p.BackoffBase,
+		BackoffMax:  p.BackoffMax,
+	}
+	if p.Windows != nil {
+		rv.Windows = make([]*TimeWindow, 0, len(p.Windows))
+		for _, w := range p.Windows {
+			days := make([]string, len(w.Days))
+			copy(days, w.Days)
+			rv.Windows = append(rv.Windows, &TimeWindow{
+				Days:  days,
+				Start: w.Start,
+				End:   w.End,
+			})
+		}
+	}
+	return rv
+}
+
+// window is a parsed TimeWindow.
+type window struct {
+	days  map[time.Weekday]bool // Empty means every day.
+	start time.Duration         // Since midnight.
+	end   time.Duration         // Since midnight.
+}
+
+// hasDay returns true if the window starts on the given day.
+func (w *window) hasDay(d time.Weekday) bool {
+	return len(w.days) == 0 || w.days[d]
+}
+
+// parsedPolicy is a parsed Policy.
+type parsedPolicy struct {
+	maxRolls    int
+	rollPeriod  time.Duration
+	location    *time.Location
+	windows     []*window
+	backoffBase time.Duration
+	backoffMax  time.Duration
+}
+
+// parseDuration parses the given duration, which may be empty.
+func parseDuration(name, d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	rv, err := time.ParseDuration(d)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %s", name, err)
+	}
+	if rv < 0 {
+		return 0, fmt.Errorf("%s must not be negative.", name)
+	}
+	return rv, nil
+}
+
+// parseTimeOfDay parses the given time of day in TIME_OF_DAY_FORMAT and
+// returns the duration since midnight.
+func parseTimeOfDay(t string) (time.Duration, error) {
+	parsed, err := time.Parse(TIME_OF_DAY_FORMAT, t)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day %q: %s", t, err)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// parse parses and validates the Policy.
+func (p *Policy) parse() (*parsedPolicy, error) {
+	rv := &parsedPolicy{
+		maxRolls: p.MaxRolls,
+		location: time.UTC,
+	}
+	var err error
+	if rv.rollPeriod, err = parseDuration("RollPeriod", p.RollPeriod); err != nil {
+		return nil, err
+	}
+	if rv.maxRolls < 0 {
+		return nil, fmt.Errorf("MaxRolls must not be negative.")
+	}
+	if rv.maxRolls > 0 && rv.rollPeriod == 0 {
+		return nil, fmt.Errorf("MaxRolls requires a RollPeriod.")
+	}
+	if rv.backoffBase, err = parseDuration("BackoffBase", p.BackoffBase); err != nil {
+		return nil, err
+	}
+	if rv.backoffMax, err = parseDuration("BackoffMax", p.BackoffMax); err != nil {
+		return nil, err
+	}
+	if p.TimeZone != "" {
+		if rv.location, err = time.LoadLocation(p.TimeZone); err != nil {
+			return nil, fmt.Errorf("Invalid TimeZone: %s", err)
+		}
+	}
+	for _, w := range p.Windows {
+		parsed := &window{
+			days: make(map[time.Weekday]bool, len(w.Days)),
+		}
+		for _, d := range w.Days {
+			found := false
+			for i, valid := range VALID_DAYS {
+				if d == valid {
+					parsed.days[time.Weekday(i)] = true
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("Invalid day %q; must be one of %s.", d, strings.Join(VALID_DAYS, ", "))
+			}
+		}
+		if parsed.start, err = parseTimeOfDay(w.Start); err != nil {
+			return nil, err
+		}
+		if parsed.end, err = parseTimeOfDay(w.End); err != nil {
+			return nil, err
+		}
+		rv.windows = append(rv.windows, parsed)
+	}
+	return rv, nil
+}
+
+// Validate returns an error if the Policy is invalid.
+func (p *Policy) Validate() error {
+	_, err := p.parse()
+	return err
+}
+
+// Throttle describes why a Policy doesn't allow new rolls.
+type Throttle struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"` // The earliest time new rolls may be allowed again. Zero if unknown.
+}
+
+// Copy returns a copy of the Throttle.
+func (t *Throttle) Copy() *Throttle {
+	return &Throttle{
+		Reason: t.Reason,
+		Until:  t.Until,
+	}
+}
+
+// isFailure returns true if the given roll failed.
+func isFailure(roll *autoroll.AutoRollIssue) bool {
+	return roll.Closed && (roll.Result == autoroll.ROLL_RESULT_FAILURE || roll.Result == autoroll.ROLL_RESULT_DRY_RUN_FAILURE)
+}
+
+// checkBackoff returns a Throttle if the most recent rolls failed and the
+// back-off hasn't passed yet.
+func (p *parsedPolicy) checkBackoff(now time.Time, rolls []*autoroll.AutoRollIssue) *Throttle {
+	if p.backoffBase == 0 {
+		return nil
+	}
+	failures := 0
+	for _, roll := range rolls {
+		if !isFailure(roll) {
+			break
+		}
+		failures++
+	}
+	if failures == 0 {
+		return nil
+	}
+	backoff := p.backoffBase
+	for i := 1; i < failures && (p.backoffMax == 0 || backoff < p.backoffMax); i++ {
+		backoff *= 2
+	}
+	if p.backoffMax > 0 && backoff > p.backoffMax {
+		backoff = p.backoffMax
+	}
+	until := rolls[0].Modified.Add(backoff)
+	if !now.Before(until) {
+		return nil
+	}
+	return &Throttle{
+		Reason: fmt.Sprintf("Backing off for %s after %d consecutive failed rolls.", backoff, failures),
+		Until:  until,
+	}
+}
+
+// checkMaxRolls returns a Throttle if MaxRolls rolls were uploaded during the
+// last RollPeriod.
+func (p *parsedPolicy) checkMaxRolls(now time.Time, rolls []*autoroll.AutoRollIssue) *Throttle {
+	if p.maxRolls == 0 || len(rolls) < p.maxRolls {
+		return nil
+	}
+	until := rolls[p.maxRolls-1].Created.Add(p.rollPeriod)
+	if !now.Before(until) {
+		return nil
+	}
+	return &Throttle{
+		Reason: fmt.Sprintf("Uploaded %d rolls in the last %s.", p.maxRolls, p.rollPeriod),
+		Until:  until,
+	}
+}
+
+// checkWindows returns a Throttle if the given time is outside of all time
+// windows.
+func (p *parsedPolicy) checkWindows(now time.Time) *Throttle {
+	if len(p.windows) == 0 {
+		return nil
+	}
+	// Use the time of day on the wall clock, which differs from the time
+	// since midnight on days with a daylight saving time transition.
+	local := now.In(p.location)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	yesterday := (local.Weekday() + 6) % 7
+	for _, w := range p.windows {
+		if w.start < w.end {
+			if w.hasDay(local.Weekday()) && sinceMidnight >= w.start && sinceMidnight < w.end {
+				return nil
+			}
+		} else {
+			// The window extends into the next day.
+			if w.hasDay(local.Weekday()) && sinceMidnight >= w.start {
+				return nil
+			}
+			if w.hasDay(yesterday) && sinceMidnight < w.end {
+				return nil
+			}
+		}
+	}
+
+	// Find the start of the next window.
+	var until time.Time
+	for i := 0; i <= 7; i++ {
+		for _, w := range p.windows {
+			start := time.Date(local.Year(), local.Month(), local.Day()+i, 0, int(w.start/time.Minute), 0, 0, p.location)
+			if !w.hasDay(start.Weekday()) {
+				continue
+			}
+			if start.After(now) && (until.IsZero() || start.Before(until)) {
+				until = start
+			}
+		}
+		if !until.IsZero() {
+			break
+		}
+	}
+	return &Throttle{
+		Reason: "Outside of the allowed time windows.",
+		Until:  until,
+	}
+}
+
+// Check returns a Throttle if the Policy doesn't allow uploading a new roll at
+// the given time, or nil if it does. rolls are the most recent rolls, most
+// recent first, and must contain at least MaxRolls rolls if there are that
+// many. If several restrictions apply, the one which lasts the longest is
+// returned.
+func (p *Policy) Check(now time.Time, rolls []*autoroll.AutoRollIssue) (*Throttle, error) {
+	parsed, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	var rv *Throttle
+	for _, t := range []*Throttle{
+		parsed.checkBackoff(now, rolls),
+		parsed.checkMaxRolls(now, rolls),
+		parsed.checkWindows(now),
+	} {
+		if t != nil && (rv == nil || t.Until.After(rv.Until)) {
+			rv = t
+		}
+	}
+	return rv, nil
+}
+
+// PolicyChange is a struct used for describing a change in the AutoRoll
+// policy.
+type PolicyChange struct {
+	Message string    `json:"message"`
+	Policy  *Policy   `json:"policy"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+}
+
+// Copy returns a copy of the PolicyChange.
+func (c *PolicyChange) Copy() *PolicyChange {
+	return &PolicyChange{
+		Message: c.Message,
+		Policy:  c.Policy.Copy(),
+		Time:    c.Time,
+		User:    c.User,
+	}
+}
+
+// PolicyHistory is a struct used for storing and retrieving policy change
+// history.
+type PolicyHistory struct {
+	db      *db
+	history []*PolicyChange
+	mtx     sync.RWMutex
+}
+
+// NewPolicyHistory returns a PolicyHistory instance.
+func NewPolicyHistory(dbFile string) (*PolicyHistory, error) {
+	d, err := openDB(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	ph := &PolicyHistory{
+		db: d,
+	}
+	if err := ph.refreshHistory(); err != nil {
+		return nil, err
+	}
+	return ph, nil
+}
+
+// Close closes the database held by the PolicyHistory.
+func (ph *PolicyHistory) Close() error {
+	return ph.db.Close()
+}
+
+// Add inserts a new PolicyChange.
+func (ph *PolicyHistory) Add(p *Policy, user, message string) error {
+	if p == nil {
+		return fmt.Errorf("No policy given.")
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	policyChange := &PolicyChange{
+		Message: message,
+		Policy:  p.Copy(),
+		Time:    time.Now(),
+		User:    user,
+	}
+
+	ph.mtx.Lock()
+	defer ph.mtx.Unlock()
+	if err := ph.db.SetPolicy(policyChange); err != nil {
+		return err
+	}
+	return ph.refreshHistory()
+}
+
+// CurrentPolicy returns the current policy, which is the most recently added
+// PolicyChange.
+func (ph *PolicyHistory) CurrentPolicy() *PolicyChange {
+	ph.mtx.RLock()
+	defer ph.mtx.RUnlock()
+	if len(ph.history) > 0 {
+		return ph.history[0].Copy()
+	} else {
+		sklog.Errorf("Policy history is empty even after initialization!")
+		return &PolicyChange{
+			Message: "Policy history is empty!",
+			Policy:  &Policy{},
+			Time:    time.Now(),
+			User:    "autoroller",
+		}
+	}
+}
+
+// GetHistory returns a slice of the most recent PolicyChanges, most recent
+// first.
+func (ph *PolicyHistory) GetHistory() []*PolicyChange {
+	ph.mtx.RLock()
+	defer ph.mtx.RUnlock()
+	rv := make([]*PolicyChange, 0, len(ph.history))
+	for _, p := range ph.history {
+		rv = append(rv, p.Copy())
+	}
+	return rv
+}
+
+// refreshHistory refreshes the policy history from the database. Assumes that
+// the caller holds a write lock.
+func (ph *PolicyHistory) refreshHistory() error {
+	history, err := ph.db.GetPolicyHistory(POLICY_HISTORY_LENGTH)
+	if err != nil {
+		return err
+	}
+
+	// If there's no history, set the initial policy, which doesn't restrict
+	// the roller.
+	if len(history) == 0 {
+		if err := ph.db.SetPolicy(&PolicyChange{
+			Message: "Setting initial policy.",
+			Policy:  &Policy{},
+			Time:    time.Now(),
+			User:    "AutoRoll Bot",
+		}); err != nil {
+			return err
+		}
+		history, err = ph.db.GetPolicyHistory(POLICY_HISTORY_LENGTH)
+		if err != nil {
+			return err
+		}
+	}
+
+	ph.history = history
+	return nil
+}
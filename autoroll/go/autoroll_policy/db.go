@@ -0,0 +1,81 @@
+package autoroll_policy
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.skia.org/infra/go/util"
+)
+
+var (
+	BUCKET_POLICY_HISTORY = []byte("policyHistory")
+)
+
+// db is a struct used for interacting with a database.
+type db struct {
+	db *bolt.DB
+}
+
+// openDB returns a db instance.
+func openDB(filename string) (*db, error) {
+	d, err := bolt.Open(filename, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(BUCKET_POLICY_HISTORY); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &db{d}, nil
+}
+
+// Close closes the db.
+func (d *db) Close() error {
+	return d.db.Close()
+}
+
+// timeToKey returns a BoltDB key for the given time.Time. The keys sort in
+// chronological order.
+func timeToKey(t time.Time) []byte {
+	return []byte(t.UTC().Format(util.RFC3339NanoZeroPad))
+}
+
+// SetPolicy inserts a policy change into the database.
+func (d *db) SetPolicy(p *PolicyChange) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BUCKET_POLICY_HISTORY)
+		serialized, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return b.Put(timeToKey(p.Time), serialized)
+	})
+}
+
+// GetPolicyHistory returns the last N policy changes.
+func (d *db) GetPolicyHistory(N int) ([]*PolicyChange, error) {
+	history := make([]*PolicyChange, 0, N)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BUCKET_POLICY_HISTORY)
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(history) < N; k, v = c.Prev() {
+			var p PolicyChange
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			p.Time = p.Time.UTC()
+			history = append(history, &p)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
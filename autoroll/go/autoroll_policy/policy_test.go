@@ -0,0 +1,216 @@
+package autoroll_policy
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/autoroll"
+	"go.skia.org/infra/go/testutils"
+)
+
+// roll returns a closed roll with the given result, created and last modified
+// at the given time.
+func roll(result string, ts time.Time) *autoroll.AutoRollIssue {
+	return &autoroll.AutoRollIssue{
+		Closed:   true,
+		Created:  ts,
+		Modified: ts,
+		Result:   result,
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	testutils.SmallTest(t)
+
+	assert.NoError(t, (&Policy{}).Validate())
+	assert.NoError(t, (&Policy{
+		MaxRolls:    3,
+		RollPeriod:  "24h",
+		TimeZone:    "America/New_York",
+		Windows:     []*TimeWindow{&TimeWindow{Days: []string{"Mon", "Fri"}, Start: "09:00", End: "17:30"}},
+		BackoffBase: "30m",
+		BackoffMax:  "8h",
+	}).Validate())
+
+	assert.Error(t, (&Policy{MaxRolls: 3}).Validate())
+	assert.Error(t, (&Policy{MaxRolls: -1, RollPeriod: "1h"}).Validate())
+	assert.Error(t, (&Policy{BackoffBase: "soon"}).Validate())
+	assert.Error(t, (&Policy{BackoffBase: "-1h"}).Validate())
+	assert.Error(t, (&Policy{TimeZone: "Nowhere/Special"}).Validate())
+	assert.Error(t, (&Policy{Windows: []*TimeWindow{&TimeWindow{Days: []string{"Monday"}, Start: "09:00", End: "17:00"}}}).Validate())
+	assert.Error(t, (&Policy{Windows: []*TimeWindow{&TimeWindow{Start: "9am", End: "17:00"}}}).Validate())
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	testutils.SmallTest(t)
+
+	now := time.Date(2017, time.May, 10, 12, 0, 0, 0, time.UTC)
+	p := &Policy{BackoffBase: "1h", BackoffMax: "3h"}
+
+	// No failures.
+	th, err := p.Check(now, []*autoroll.AutoRollIssue{roll(autoroll.ROLL_RESULT_SUCCESS, now)})
+	assert.NoError(t, err)
+	assert.Nil(t, th)
+
+	// One failure, 1h back-off.
+	rolls := []*autoroll.AutoRollIssue{
+		roll(autoroll.ROLL_RESULT_FAILURE, now.Add(-30*time.Minute)),
+		roll(autoroll.ROLL_RESULT_SUCCESS, now.Add(-2*time.Hour)),
+	}
+	th, err = p.Check(now, rolls)
+	assert.NoError(t, err)
+	assert.NotNil(t, th)
+	assert.Equal(t, now.Add(30*time.Minute), th.Until)
+	th, err = p.Check(now.Add(30*time.Minute), rolls)
+	assert.NoError(t, err)
+	assert.Nil(t, th)
+
+	// Two failures, 2h back-off.
+	rolls = append([]*autoroll.AutoRollIssue{roll(autoroll.ROLL_RESULT_DRY_RUN_FAILURE, now.Add(-time.Hour))}, rolls...)
+	th, err = p.Check(now, rolls)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), th.Until)
+
+	// Three and four failures, capped at 3h.
+	rolls = append([]*autoroll.AutoRollIssue{roll(autoroll.ROLL_RESULT_FAILURE, now)}, rolls...)
+	th, err = p.Check(now, rolls)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(3*time.Hour), th.Until)
+	rolls = append([]*autoroll.AutoRollIssue{roll(autoroll.ROLL_RESULT_FAILURE, now)}, rolls...)
+	th, err = p.Check(now, rolls)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(3*time.Hour), th.Until)
+}
+
+func TestPolicyMaxRolls(t *testing.T) {
+	testutils.SmallTest(t)
+
+	now := time.Date(2017, time.May, 10, 12, 0, 0, 0, time.UTC)
+	p := &Policy{MaxRolls: 2, RollPeriod: "24h"}
+
+	rolls := []*autoroll.AutoRollIssue{
+		roll(autoroll.ROLL_RESULT_SUCCESS, now.Add(-time.Hour)),
+	}
+	th, err := p.Check(now, rolls)
+	assert.NoError(t, err)
+	assert.Nil(t, th)
+
+	rolls = append(rolls, roll(autoroll.ROLL_RESULT_SUCCESS, now.Add(-20*time.Hour)))
+	th, err = p.Check(now, rolls)
+	assert.NoError(t, err)
+	assert.NotNil(t, th)
+	assert.Equal(t, now.Add(4*time.Hour), th.Until)
+
+	th, err = p.Check(now.Add(4*time.Hour), rolls)
+	assert.NoError(t, err)
+	assert.Nil(t, th)
+}
+
+func TestPolicyWindows(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ny, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	// Business hours on weekdays, and a late night window on Saturdays.
+	p := &Policy{
+		TimeZone: "America/New_York",
+		Windows: []*TimeWindow{
+			&TimeWindow{Days: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}, Start: "09:00", End: "17:00"},
+			&TimeWindow{Days: []string{"Sat"}, Start: "22:00", End: "02:00"},
+		},
+	}
+	check := func(now time.Time, expectUntil time.Time) {
+		th, err := p.Check(now, nil)
+		assert.NoError(t, err)
+		if expectUntil.IsZero() {
+			assert.Nil(t, th)
+		} else {
+			assert.NotNil(t, th)
+			assert.True(t, expectUntil.Equal(th.Until), "Expected %s but got %s", expectUntil, th.Until)
+		}
+	}
+
+	// Wednesday, May 10 2017.
+	check(time.Date(2017, time.May, 10, 12, 0, 0, 0, ny), time.Time{})
+	check(time.Date(2017, time.May, 10, 9, 0, 0, 0, ny), time.Time{})
+	check(time.Date(2017, time.May, 10, 8, 59, 0, 0, ny), time.Date(2017, time.May, 10, 9, 0, 0, 0, ny))
+	check(time.Date(2017, time.May, 10, 17, 0, 0, 0, ny), time.Date(2017, time.May, 11, 9, 0, 0, 0, ny))
+	// The time zone is respected.
+	check(time.Date(2017, time.May, 10, 12, 0, 0, 0, time.UTC), time.Date(2017, time.May, 10, 13, 0, 0, 0, time.UTC))
+
+	// Friday evening until Saturday night.
+	check(time.Date(2017, time.May, 12, 18, 0, 0, 0, ny), time.Date(2017, time.May, 13, 22, 0, 0, 0, ny))
+	check(time.Date(2017, time.May, 13, 23, 0, 0, 0, ny), time.Time{})
+	// The Saturday window extends into Sunday.
+	check(time.Date(2017, time.May, 14, 1, 0, 0, 0, ny), time.Time{})
+	check(time.Date(2017, time.May, 14, 2, 0, 0, 0, ny), time.Date(2017, time.May, 15, 9, 0, 0, 0, ny))
+}
+
+func TestPolicyCheckLongest(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// Outside of the window, and backing off past its start.
+	now := time.Date(2017, time.May, 10, 8, 0, 0, 0, time.UTC)
+	p := &Policy{
+		Windows:     []*TimeWindow{&TimeWindow{Start: "09:00", End: "17:00"}},
+		BackoffBase: "4h",
+	}
+	th, err := p.Check(now, []*autoroll.AutoRollIssue{roll(autoroll.ROLL_RESULT_FAILURE, now)})
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(4*time.Hour), th.Until)
+
+	// Invalid policies result in an error.
+	_, err = (&Policy{MaxRolls: 1}).Check(now, nil)
+	assert.Error(t, err)
+}
+
+// TestPolicyHistory verifies that we correctly track policy history.
+func TestPolicyHistory(t *testing.T) {
+	testutils.MediumTest(t)
+
+	tmpDir, err := ioutil.TempDir("", "test_autoroll_policy_")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmpDir)
+	dbFile := path.Join(tmpDir, "test.db")
+	ph, err := NewPolicyHistory(dbFile)
+	assert.NoError(t, err)
+
+	// The initial policy doesn't restrict the roller.
+	assert.Equal(t, &Policy{}, ph.CurrentPolicy().Policy)
+	assert.Equal(t, 1, len(ph.GetHistory()))
+
+	// Invalid policies are rejected.
+	assert.Error(t, ph.Add(nil, "test@google.com", "Nothing."))
+	assert.Error(t, ph.Add(&Policy{MaxRolls: 1}, "test@google.com", "No period."))
+	assert.Equal(t, 1, len(ph.GetHistory()))
+
+	p := &Policy{
+		MaxRolls:   3,
+		RollPeriod: "24h",
+		Windows:    []*TimeWindow{&TimeWindow{Days: []string{"Mon"}, Start: "09:00", End: "17:00"}},
+	}
+	assert.NoError(t, ph.Add(p, "test@google.com", "Only on Mondays."))
+	current := ph.CurrentPolicy()
+	assert.Equal(t, p, current.Policy)
+	assert.Equal(t, "test@google.com", current.User)
+	assert.Equal(t, "Only on Mondays.", current.Message)
+
+	// Modifying the returned policy doesn't modify the history.
+	current.Policy.Windows[0].Days[0] = "Tue"
+	assert.Equal(t, p, ph.CurrentPolicy().Policy)
+
+	// The history is persisted.
+	assert.NoError(t, ph.Close())
+	ph, err = NewPolicyHistory(dbFile)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, ph.Close())
+	}()
+	history := ph.GetHistory()
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, p, history[0].Policy)
+	assert.Equal(t, &Policy{}, history[1].Policy)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"go.skia.org/infra/autoroll/go/autoroll_modes"
+	"go.skia.org/infra/autoroll/go/autoroll_policy"
 	"go.skia.org/infra/autoroll/go/recent_rolls"
 	"go.skia.org/infra/autoroll/go/repo_manager"
 	"go.skia.org/infra/go/autoroll"
@@ -56,20 +57,53 @@ type AutoRoller struct {
 	modeHistory      *autoroll_modes.ModeHistory
 	modeMtx          sync.Mutex
 	mtx              sync.RWMutex
+	policyHistory    *autoroll_policy.PolicyHistory
+	policyMtx        sync.Mutex
 	recent           *recent_rolls.RecentRolls
 	rm               repo_manager.RepoManager
 	runningMtx       sync.Mutex
 	status           *autoRollStatusCache
 	strategy         string
 	rollIntoAndroid  bool
+	throttle         *autoroll_policy.Throttle
 }
 
-// NewAutoRoller creates and returns a new AutoRoller which runs at the given frequency.
-func NewAutoRoller(workdir, parentRepo, parentBranch, childPath, childBranch, cqExtraTrybots string, emails []string, gerrit *gerrit.Gerrit, tickFrequency, repoFrequency time.Duration, depot_tools string, rollIntoAndroid bool, strategy string) (*AutoRoller, error) {
+// RepoManagerConfig selects a RepoManager other than the DEPS and Android
+// RepoManagers, which roll the single child at childPath.
+type RepoManagerConfig struct {
+	// Additional children which are rolled in the same CL as the child at
+	// childPath.
+	ExtraChildPaths []string
+
+	// If CIPDPackage is set, the instance of that CIPD package which is
+	// pinned in CIPDVersionFile, a path within the parent repo, is rolled to
+	// the instance that CIPDRef points to, eg. "latest".
+	CIPDPackage     string
+	CIPDRef         string
+	CIPDVersionFile string
+}
+
+// NewAutoRoller creates and returns a new AutoRoller which runs at the given
+// frequency. rmConfig may be nil.
+func NewAutoRoller(workdir, parentRepo, parentBranch, childPath, childBranch, cqExtraTrybots string, emails []string, gerrit *gerrit.Gerrit, tickFrequency, repoFrequency time.Duration, depot_tools string, rollIntoAndroid bool, strategy string, rmConfig *RepoManagerConfig) (*AutoRoller, error) {
+	if rmConfig == nil {
+		rmConfig = &RepoManagerConfig{}
+	}
+	if rollIntoAndroid && (len(rmConfig.ExtraChildPaths) > 0 || rmConfig.CIPDPackage != "") {
+		return nil, fmt.Errorf("Multiple children and CIPD packages cannot be rolled into Android.")
+	}
+	if len(rmConfig.ExtraChildPaths) > 0 && rmConfig.CIPDPackage != "" {
+		return nil, fmt.Errorf("Multiple children and CIPD packages cannot be rolled together.")
+	}
+
 	var err error
 	var rm repo_manager.RepoManager
 	if rollIntoAndroid {
 		rm, err = repo_manager.NewAndroidRepoManager(workdir, parentBranch, childPath, childBranch, repoFrequency, gerrit)
+	} else if rmConfig.CIPDPackage != "" {
+		rm, err = repo_manager.NewCIPDRepoManager(workdir, parentRepo, parentBranch, rmConfig.CIPDVersionFile, rmConfig.CIPDPackage, rmConfig.CIPDRef, repoFrequency, depot_tools, gerrit)
+	} else if len(rmConfig.ExtraChildPaths) > 0 {
+		rm, err = repo_manager.NewMultiDEPSRepoManager(workdir, parentRepo, parentBranch, append([]string{childPath}, rmConfig.ExtraChildPaths...), childBranch, repoFrequency, depot_tools, gerrit)
 	} else {
 		rm, err = repo_manager.NewDEPSRepoManager(workdir, parentRepo, parentBranch, childPath, childBranch, repoFrequency, depot_tools, gerrit)
 	}
@@ -87,6 +121,11 @@ func NewAutoRoller(workdir, parentRepo, parentBranch, childPath, childBranch, cq
 		return nil, err
 	}
 
+	ph, err := autoroll_policy.NewPolicyHistory(path.Join(workdir, "autoroll_policy.db"))
+	if err != nil {
+		return nil, err
+	}
+
 	arb := &AutoRoller{
 		attemptCounter:   util.NewAutoDecrementCounter(ROLL_ATTEMPT_THROTTLE_TIME),
 		cqExtraTrybots:   cqExtraTrybots,
@@ -95,6 +134,7 @@ func NewAutoRoller(workdir, parentRepo, parentBranch, childPath, childBranch, cq
 		includeCommitLog: true,
 		liveness:         metrics2.NewLiveness("last-autoroll-landed", map[string]string{"child-path": childPath}),
 		modeHistory:      mh,
+		policyHistory:    ph,
 		recent:           recent,
 		rm:               rm,
 		status:           &autoRollStatusCache{},
@@ -120,27 +160,33 @@ func NewAutoRoller(workdir, parentRepo, parentBranch, childPath, childBranch, cq
 func (r *AutoRoller) Close() error {
 	err1 := r.recent.Close()
 	err2 := r.modeHistory.Close()
+	err3 := r.policyHistory.Close()
 	if err1 != nil {
 		return err1
 	}
 	if err2 != nil {
 		return err2
 	}
+	if err3 != nil {
+		return err3
+	}
 	return nil
 }
 
 // AutoRollStatus is a struct which provides roll-up status information about
 // the AutoRoll Bot.
 type AutoRollStatus struct {
-	CurrentRoll *autoroll.AutoRollIssue    `json:"currentRoll"`
-	Error       string                     `json:"error"`
-	GerritUrl   string                     `json:"gerritUrl"`
-	LastRoll    *autoroll.AutoRollIssue    `json:"lastRoll"`
-	LastRollRev string                     `json:"lastRollRev"`
-	Mode        *autoroll_modes.ModeChange `json:"mode"`
-	Recent      []*autoroll.AutoRollIssue  `json:"recent"`
-	Status      string                     `json:"status"`
-	ValidModes  []string                   `json:"validModes"`
+	CurrentRoll *autoroll.AutoRollIssue       `json:"currentRoll"`
+	Error       string                        `json:"error"`
+	GerritUrl   string                        `json:"gerritUrl"`
+	LastRoll    *autoroll.AutoRollIssue       `json:"lastRoll"`
+	LastRollRev string                        `json:"lastRollRev"`
+	Mode        *autoroll_modes.ModeChange    `json:"mode"`
+	Policy      *autoroll_policy.PolicyChange `json:"policy"`
+	Recent      []*autoroll.AutoRollIssue     `json:"recent"`
+	Status      string                        `json:"status"`
+	Throttle    *autoroll_policy.Throttle     `json:"throttle"`
+	ValidModes  []string                      `json:"validModes"`
 }
 
 // autoRollStatusCache is a struct used for caching roll-up status
@@ -153,8 +199,10 @@ type autoRollStatusCache struct {
 	lastRollRev string
 	mode        *autoroll_modes.ModeChange
 	mtx         sync.RWMutex
+	policy      *autoroll_policy.PolicyChange
 	recent      []*autoroll.AutoRollIssue
 	status      string
+	throttle    *autoroll_policy.Throttle
 }
 
 // Get returns the current status information.
@@ -171,6 +219,7 @@ func (c *autoRollStatusCache) Get(includeError bool) *AutoRollStatus {
 		GerritUrl:   c.gerritUrl,
 		LastRollRev: c.lastRollRev,
 		Mode:        c.mode.Copy(),
+		Policy:      c.policy.Copy(),
 		Recent:      recent,
 		Status:      c.status,
 		ValidModes:  validModes,
@@ -181,6 +230,9 @@ func (c *autoRollStatusCache) Get(includeError bool) *AutoRollStatus {
 	if c.lastRoll != nil {
 		s.LastRoll = c.lastRoll.Copy()
 	}
+	if c.throttle != nil {
+		s.Throttle = c.throttle.Copy()
+	}
 	if includeError && c.lastError != "" {
 		s.Error = c.lastError
 	}
@@ -217,8 +269,13 @@ func (c *autoRollStatusCache) set(s *AutoRollStatus) error {
 	c.gerritUrl = s.GerritUrl
 	c.lastRollRev = s.LastRollRev
 	c.mode = s.Mode.Copy()
+	c.policy = s.Policy.Copy()
 	c.recent = recent
 	c.status = s.Status
+	c.throttle = nil
+	if s.Throttle != nil {
+		c.throttle = s.Throttle.Copy()
+	}
 
 	return nil
 }
@@ -239,6 +296,32 @@ func (r *AutoRoller) SetMode(m, user, message string) error {
 	return r.doAutoRoll()
 }
 
+// SetPolicy sets the policy which controls when the bot may upload new rolls.
+// This forces the bot to run and blocks until it finishes.
+func (r *AutoRoller) SetPolicy(p *autoroll_policy.Policy, user, message string) error {
+	r.policyMtx.Lock()
+	defer r.policyMtx.Unlock()
+	if err := r.policyHistory.Add(p, user, message); err != nil {
+		return err
+	}
+	return r.doAutoRoll()
+}
+
+// getThrottle returns the reason why the bot most recently refrained from
+// uploading a new roll, or nil if it didn't.
+func (r *AutoRoller) getThrottle() *autoroll_policy.Throttle {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.throttle
+}
+
+// setThrottle sets the reason why the bot refrained from uploading a new roll.
+func (r *AutoRoller) setThrottle(t *autoroll_policy.Throttle) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.throttle = t
+}
+
 // isMode determines whether the bot is in the given mode.
 func (r *AutoRoller) isMode(s string) bool {
 	return r.modeHistory.CurrentMode().Mode == s
@@ -400,8 +483,10 @@ func (r *AutoRoller) doAutoRoll() error {
 		LastRoll:    r.recent.LastRoll(),
 		LastRollRev: r.rm.LastRollRev(),
 		Mode:        r.modeHistory.CurrentMode(),
+		Policy:      r.policyHistory.CurrentPolicy(),
 		Recent:      r.recent.GetRecentRolls(),
 		Status:      status,
+		Throttle:    r.getThrottle(),
 	}); err != nil {
 		return err
 	}
@@ -465,6 +550,7 @@ func (r *AutoRoller) isDryRunSuccessful(roll *autoroll.AutoRollIssue) (bool, err
 func (r *AutoRoller) doAutoRollInner() (string, error) {
 	r.runningMtx.Lock()
 	defer r.runningMtx.Unlock()
+	r.setThrottle(nil)
 
 	// Get updated info about the current roll.
 	if err := r.updateCurrentRoll(); err != nil {
@@ -599,8 +685,25 @@ func (r *AutoRoller) doAutoRollInner() (string, error) {
 		return STATUS_UP_TO_DATE, nil
 	}
 
-	// Create a new roll.
+	// Create a new roll, if the attempt counter and the policy allow it.
 	if r.attemptCounter.Get() >= ROLL_ATTEMPT_THROTTLE_NUM {
+		r.setThrottle(&autoroll_policy.Throttle{
+			Reason: fmt.Sprintf("Attempted to upload %d rolls in the last %s.", ROLL_ATTEMPT_THROTTLE_NUM, ROLL_ATTEMPT_THROTTLE_TIME),
+		})
+		return STATUS_THROTTLED, nil
+	}
+	policy := r.policyHistory.CurrentPolicy().Policy
+	rolls, err := r.recent.GetRolls(util.MaxInt(policy.MaxRolls, recent_rolls.RECENT_ROLLS_LENGTH))
+	if err != nil {
+		return STATUS_ERROR, err
+	}
+	throttle, err := policy.Check(time.Now(), rolls)
+	if err != nil {
+		return STATUS_ERROR, err
+	}
+	if throttle != nil {
+		sklog.Infof("Roll policy doesn't allow a new roll: %s", throttle.Reason)
+		r.setThrottle(throttle)
 		return STATUS_THROTTLED, nil
 	}
 	r.attemptCounter.Inc()
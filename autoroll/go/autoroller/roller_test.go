@@ -13,6 +13,7 @@ import (
 
 	assert "github.com/stretchr/testify/require"
 	"go.skia.org/infra/autoroll/go/autoroll_modes"
+	"go.skia.org/infra/autoroll/go/autoroll_policy"
 	"go.skia.org/infra/autoroll/go/repo_manager"
 	"go.skia.org/infra/go/autoroll"
 	"go.skia.org/infra/go/buildbucket"
@@ -439,7 +440,7 @@ func setup(t *testing.T, strategy string) (string, *AutoRoller, *mockRepoManager
 	roll1 := rm.rollerWillUpload(rv, rm.LastRollRev(), rm.ChildHead(), noTrybots, false)
 
 	// Create the roller.
-	roller, err := NewAutoRoller(workdir, "parent.git", "master", "src/third_party/skia", "master", "", []string{}, g, time.Hour, time.Hour, "depot_tools", false, strategy)
+	roller, err := NewAutoRoller(workdir, "parent.git", "master", "src/third_party/skia", "master", "", []string{}, g, time.Hour, time.Hour, "depot_tools", false, strategy, nil)
 	assert.NoError(t, err)
 
 	// Verify that the bot ran successfully.
@@ -723,6 +724,39 @@ func TestAutoRollThrottle(t *testing.T) {
 	checkStatus(t, roller, rv, rm, STATUS_THROTTLED, nil, nil, false, roll3, noTrybots, false)
 }
 
+// TestAutoRollPolicy ensures that the roller respects its policy.
+func TestAutoRollPolicy(t *testing.T) {
+	testutils.MediumTest(t)
+	workdir, roller, rm, rv, roll1 := setup(t, repo_manager.ROLL_STRATEGY_BATCH)
+	defer func() {
+		assert.NoError(t, roller.Close())
+		assert.NoError(t, os.RemoveAll(workdir))
+	}()
+
+	// The roll failed. Verify that we close it and back off.
+	rv.pretendRollFailed(roll1, noTrybots)
+	rv.rollerWillCloseIssue(roll1)
+	u := "test@google.com"
+	assert.NoError(t, roller.SetPolicy(&autoroll_policy.Policy{BackoffBase: "1h"}, u, "Back off."))
+	roll1.Status = gerrit.CHANGE_STATUS_ABANDONED
+	checkStatus(t, roller, rv, rm, STATUS_THROTTLED, nil, nil, false, roll1, noTrybots, false)
+	s := roller.GetStatus(true)
+	assert.Equal(t, "1h", s.Policy.Policy.BackoffBase)
+	assert.Equal(t, u, s.Policy.User)
+	assert.NotNil(t, s.Throttle)
+	assert.True(t, s.Throttle.Until.After(time.Now()))
+
+	// Invalid policies are rejected.
+	assert.Error(t, roller.SetPolicy(&autoroll_policy.Policy{BackoffBase: "forever"}, u, "Invalid."))
+	assert.Equal(t, "1h", roller.GetStatus(true).Policy.Policy.BackoffBase)
+
+	// Remove the back-off. Verify that we upload another roll.
+	roll2 := rm.rollerWillUpload(rv, rm.LastRollRev(), rm.ChildHead(), noTrybots, false)
+	assert.NoError(t, roller.SetPolicy(&autoroll_policy.Policy{}, u, "Stop backing off."))
+	checkStatus(t, roller, rv, rm, STATUS_IN_PROGRESS, roll2, noTrybots, false, roll1, noTrybots, false)
+	assert.Nil(t, roller.GetStatus(true).Throttle)
+}
+
 // TestAutoRollSingle ensures that the one-at-a-time mode works as expected.
 // This is more of a sanity check, since the actual behavior is done in the
 // RepoManager.
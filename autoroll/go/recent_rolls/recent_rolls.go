@@ -88,6 +88,15 @@ func (r *RecentRolls) GetRecentRolls() []*autoroll.AutoRollIssue {
 	return recent
 }
 
+// GetRolls returns the last N DEPS rolls, most recent first. Unlike
+// GetRecentRolls, it reads from the database, so N may exceed
+// RECENT_ROLLS_LENGTH.
+func (r *RecentRolls) GetRolls(N int) ([]*autoroll.AutoRollIssue, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.db.GetRecentRolls(N)
+}
+
 // currentRoll returns the currently-active DEPS roll, or nil if none exists.
 // Does not copy the roll. Expects that the caller holds a lock.
 func (r *RecentRolls) currentRoll() *autoroll.AutoRollIssue {
@@ -0,0 +1,299 @@
+package repo_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/gerrit"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	CIPD = "cipd"
+)
+
+var (
+	// Use this function to instantiate a RepoManager which rolls a CIPD
+	// package. This is able to be overridden for testing.
+	NewCIPDRepoManager func(string, string, string, string, string, string, time.Duration, string, *gerrit.Gerrit) (RepoManager, error) = newCIPDRepoManager
+)
+
+// cipdPin is a package instance in the output of "cipd resolve --json-output".
+type cipdPin struct {
+	Package    string `json:"package"`
+	InstanceID string `json:"instance_id"`
+}
+
+// cipdResolveJson is the structure of "cipd resolve --json-output".
+type cipdResolveJson struct {
+	Result []cipdPin `json:"result"`
+}
+
+// cipdRepoManager is a RepoManager which rolls the instance of a CIPD package
+// pinned in a file in the parent repo. The file contains the instance ID of
+// the package, which is replaced by the instance that the given CIPD ref, eg.
+// "latest", points to.
+//
+// The revisions it deals in are CIPD instance IDs. Instances are not ordered,
+// so there is no history between two instances, and each roll consists of a
+// single "commit".
+type cipdRepoManager struct {
+	*commonRepoManager
+	cipd        string
+	cipdPackage string
+	cipdRef     string
+	depot_tools string
+	parentDir   string
+	parentRepo  string
+	versionFile string
+}
+
+// newCIPDRepoManager returns a RepoManager instance which rolls the given CIPD
+// package pinned in versionFile, a path within the parent repo, to the
+// instance given by cipdRef. It operates in the given working directory and
+// updates at the given frequency.
+func newCIPDRepoManager(workdir, parentRepo, parentBranch, versionFile, cipdPackage, cipdRef string, frequency time.Duration, depot_tools string, g *gerrit.Gerrit) (RepoManager, error) {
+	cipd := CIPD
+	if depot_tools != "" {
+		cipd = path.Join(depot_tools, cipd)
+	}
+
+	wd := path.Join(workdir, "repo_manager")
+	parentBase := strings.TrimSuffix(path.Base(parentRepo), ".git")
+
+	user, err := g.GetUserEmail()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine Gerrit user: %s", err)
+	}
+	sklog.Infof("Repo Manager user: %s", user)
+
+	cr := &cipdRepoManager{
+		commonRepoManager: &commonRepoManager{
+			parentBranch: parentBranch,
+			childPath:    cipdPackage,
+			user:         user,
+			workdir:      wd,
+			g:            g,
+		},
+		cipd:        cipd,
+		cipdPackage: cipdPackage,
+		cipdRef:     cipdRef,
+		depot_tools: depot_tools,
+		parentDir:   path.Join(wd, parentBase),
+		parentRepo:  parentRepo,
+		versionFile: versionFile,
+	}
+	if err := cr.update(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for _ = range time.Tick(frequency) {
+			util.LogErr(cr.update())
+		}
+	}()
+	return cr, nil
+}
+
+// cleanParent forces the parent checkout into a clean state.
+func (cr *cipdRepoManager) cleanParent() error {
+	if _, err := exec.RunCwd(cr.parentDir, "git", "clean", "-d", "-f", "-f"); err != nil {
+		return err
+	}
+	_, _ = exec.RunCwd(cr.parentDir, "git", "rebase", "--abort")
+	if _, err := exec.RunCwd(cr.parentDir, "git", "checkout", fmt.Sprintf("origin/%s", cr.parentBranch), "-f"); err != nil {
+		return err
+	}
+	_, _ = exec.RunCwd(cr.parentDir, "git", "branch", "-D", DEPS_ROLL_BRANCH)
+	return nil
+}
+
+// update syncs the parent repo and resolves the CIPD ref.
+func (cr *cipdRepoManager) update() error {
+	cr.repoMtx.Lock()
+	defer cr.repoMtx.Unlock()
+
+	// Create the working directory if needed.
+	if _, err := os.Stat(cr.workdir); err != nil {
+		if err := os.MkdirAll(cr.workdir, 0755); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(path.Join(cr.parentDir, ".git")); err == nil {
+		if err := cr.cleanParent(); err != nil {
+			return err
+		}
+		// Update the repo.
+		if _, err := exec.RunCwd(cr.parentDir, "git", "fetch"); err != nil {
+			return err
+		}
+		if _, err := exec.RunCwd(cr.parentDir, "git", "reset", "--hard", fmt.Sprintf("origin/%s", cr.parentBranch)); err != nil {
+			return err
+		}
+	} else {
+		if _, err := exec.RunCwd(cr.workdir, "git", "clone", cr.parentRepo, cr.parentDir); err != nil {
+			return err
+		}
+		if err := cr.cleanParent(); err != nil {
+			return err
+		}
+	}
+
+	// Get the last roll revision.
+	lastRollRev, err := cr.getLastRollRev()
+	if err != nil {
+		return err
+	}
+
+	// Resolve the ref to the instance to roll to.
+	childHead, err := cr.resolve(cr.cipdRef)
+	if err != nil {
+		return err
+	}
+
+	cr.infoMtx.Lock()
+	defer cr.infoMtx.Unlock()
+	cr.lastRollRev = lastRollRev
+	cr.childHead = childHead
+	return nil
+}
+
+// ForceUpdate forces the repoManager to update.
+func (cr *cipdRepoManager) ForceUpdate() error {
+	return cr.update()
+}
+
+// getLastRollRev returns the instance ID pinned in the version file.
+func (cr *cipdRepoManager) getLastRollRev() (string, error) {
+	contents, err := ioutil.ReadFile(path.Join(cr.parentDir, cr.versionFile))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read version file: %s", err)
+	}
+	rev := strings.TrimSpace(string(contents))
+	if rev == "" {
+		return "", fmt.Errorf("Version file %s is empty.", cr.versionFile)
+	}
+	return rev, nil
+}
+
+// resolve returns the instance ID of the package for the given version, which
+// is a ref, tag or instance ID.
+func (cr *cipdRepoManager) resolve(version string) (string, error) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		return "", err
+	}
+	defer util.RemoveAll(tmp)
+	jsonFile := path.Join(tmp, "resolve.json")
+	if _, err := exec.RunCommand(&exec.Command{
+		Dir:  cr.workdir,
+		Env:  getEnv(cr.depot_tools),
+		Name: cr.cipd,
+		Args: []string{"resolve", cr.cipdPackage, "--version", version, "--json-output", jsonFile},
+	}); err != nil {
+		return "", err
+	}
+	f, err := os.Open(jsonFile)
+	if err != nil {
+		return "", err
+	}
+	defer util.Close(f)
+	var resolved cipdResolveJson
+	if err := json.NewDecoder(f).Decode(&resolved); err != nil {
+		return "", fmt.Errorf("Failed to decode output of `cipd resolve`: %s", err)
+	}
+	for _, pin := range resolved.Result {
+		if pin.Package == cr.cipdPackage {
+			return pin.InstanceID, nil
+		}
+	}
+	return "", fmt.Errorf("Failed to resolve %s of %s.", version, cr.cipdPackage)
+}
+
+// FullChildHash returns the given instance ID. Rolls always contain the full
+// instance IDs.
+func (cr *cipdRepoManager) FullChildHash(instanceID string) (string, error) {
+	return instanceID, nil
+}
+
+// RolledPast determines whether the repo has rolled past the given instance.
+// Since instances are not ordered, this is only the case if the instance is
+// the one currently pinned.
+func (cr *cipdRepoManager) RolledPast(instanceID string) (bool, error) {
+	cr.infoMtx.RLock()
+	defer cr.infoMtx.RUnlock()
+	return instanceID == cr.lastRollRev, nil
+}
+
+// CreateNewRoll creates and uploads a new roll of the package to the instance
+// given by the CIPD ref. Returns the issue number of the uploaded roll.
+func (cr *cipdRepoManager) CreateNewRoll(strategy string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	cr.repoMtx.Lock()
+	defer cr.repoMtx.Unlock()
+
+	// Clean the checkout, get onto a fresh branch.
+	if err := cr.cleanParent(); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(cr.parentDir, "git", "checkout", "-b", DEPS_ROLL_BRANCH, "-t", fmt.Sprintf("origin/%s", cr.parentBranch), "-f"); err != nil {
+		return 0, err
+	}
+
+	// Defer some more cleanup.
+	defer func() {
+		util.LogErr(cr.cleanParent())
+	}()
+
+	if _, err := exec.RunCwd(cr.parentDir, "git", "config", "user.name", cr.user); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(cr.parentDir, "git", "config", "user.email", cr.user); err != nil {
+		return 0, err
+	}
+
+	// Pin the new instance, keeping the rest of the version file intact.
+	cr.infoMtx.RLock()
+	from := cr.lastRollRev
+	to := cr.childHead
+	cr.infoMtx.RUnlock()
+	if from == to {
+		return 0, fmt.Errorf("%s is already pinned to %s.", cr.cipdPackage, to)
+	}
+	versionFile := path.Join(cr.parentDir, cr.versionFile)
+	contents, err := ioutil.ReadFile(versionFile)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read version file: %s", err)
+	}
+	if err := ioutil.WriteFile(versionFile, []byte(strings.Replace(string(contents), from, to, 1)), 0644); err != nil {
+		return 0, fmt.Errorf("Failed to write version file: %s", err)
+	}
+
+	// The subject has to match autoroll.ROLL_REV_REGEX.
+	commitMsg := fmt.Sprintf(`Roll %s %s..%s (1 commit)
+
+Updates %s to the %q instance of %s.
+
+Documentation for the AutoRoller is here:
+https://skia.googlesource.com/buildbot/+/master/autoroll/README.md
+
+`, cr.cipdPackage, from, to, cr.versionFile, cr.cipdRef, cr.cipdPackage)
+	if _, err := exec.RunCwd(cr.parentDir, "git", "commit", "-a", "-m", commitMsg); err != nil {
+		return 0, err
+	}
+	return uploadRoll(cr.parentDir, cr.depot_tools, commitMsg, emails, cqExtraTrybots, dryRun)
+}
+
+func (cr *cipdRepoManager) SendToGerritCQ(change *gerrit.ChangeInfo, comment string) error {
+	return cr.g.SendToCQ(change, "")
+}
+
+func (cr *cipdRepoManager) SendToGerritDryRun(change *gerrit.ChangeInfo, comment string) error {
+	return cr.g.SendToDryRun(change, "")
+}
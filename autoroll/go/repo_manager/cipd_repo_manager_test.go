@@ -0,0 +1,127 @@
+package repo_manager
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/autoroll"
+	"go.skia.org/infra/go/exec"
+	git_testutils "go.skia.org/infra/go/git/testutils"
+	"go.skia.org/infra/go/testutils"
+)
+
+const (
+	cipdPackage     = "skia/bots/clang_linux"
+	cipdRef         = "latest"
+	cipdVersionFile = "infra/bots/assets/clang_linux/VERSION"
+	cipdInstance1   = "1111111111111111111111111111111111111111"
+	cipdInstance2   = "2222222222222222222222222222222222222222"
+)
+
+// setupCIPD creates a parent repo which pins cipdPackage to cipdInstance1 and
+// mocks out cipd so that cipdRef resolves to the instance in the returned
+// variable.
+func setupCIPD(t *testing.T) (string, *git_testutils.GitBuilder, *string, func()) {
+	wd, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+
+	parent := git_testutils.GitInit(t)
+	parent.Add(cipdVersionFile, cipdInstance1+"\n")
+	parent.Commit()
+
+	latest := cipdInstance2
+	mockRun := exec.CommandCollector{}
+	mockRun.SetDelegateRun(func(cmd *exec.Command) error {
+		if cmd.Name == CIPD && cmd.Args[0] == "resolve" {
+			assert.Equal(t, cipdPackage, cmd.Args[1])
+			assert.Equal(t, cipdRef, cmd.Args[3])
+			testutils.WriteFile(t, cmd.Args[5], testutils.MarshalJSON(t, &cipdResolveJson{
+				Result: []cipdPin{
+					cipdPin{
+						Package:    cipdPackage,
+						InstanceID: latest,
+					},
+				},
+			}))
+			return nil
+		}
+		if cmd.Name == "git" && cmd.Args[0] == "cl" {
+			if cmd.Args[1] == "upload" {
+				return nil
+			} else if cmd.Args[1] == "issue" {
+				json := testutils.MarshalJSON(t, &issueJson{
+					Issue:    issueNum,
+					IssueUrl: "???",
+				})
+				f := strings.Split(cmd.Args[2], "=")[1]
+				testutils.WriteFile(t, f, json)
+				return nil
+			}
+		}
+		return exec.DefaultRun(cmd)
+	})
+	exec.SetRunForTesting(mockRun.Run)
+
+	cleanup := func() {
+		exec.SetRunForTesting(exec.DefaultRun)
+		testutils.RemoveAll(t, wd)
+		parent.Cleanup()
+	}
+	return wd, parent, &latest, cleanup
+}
+
+// TestCIPDRepoManager tests all aspects of the cipdRepoManager.
+func TestCIPDRepoManager(t *testing.T) {
+	testutils.LargeTest(t)
+
+	wd, parent, latest, cleanup := setupCIPD(t)
+	defer cleanup()
+
+	g := setupFakeGerrit(t, wd)
+	rm, err := NewCIPDRepoManager(wd, parent.RepoUrl(), "master", cipdVersionFile, cipdPackage, cipdRef, 24*time.Hour, depotTools, g)
+	assert.NoError(t, err)
+	assert.Equal(t, cipdInstance1, rm.LastRollRev())
+	assert.Equal(t, cipdInstance2, rm.ChildHead())
+	assert.Equal(t, mockUser, rm.User())
+
+	// RolledPast.
+	rp, err := rm.RolledPast(cipdInstance1)
+	assert.NoError(t, err)
+	assert.True(t, rp)
+	rp, err = rm.RolledPast(cipdInstance2)
+	assert.NoError(t, err)
+	assert.False(t, rp)
+
+	// Create a roll.
+	issue, err := rm.CreateNewRoll(ROLL_STRATEGY_BATCH, emails, cqExtraTrybots, false)
+	assert.NoError(t, err)
+	assert.Equal(t, issueNum, issue)
+	msg, err := ioutil.ReadFile(path.Join(rm.(*cipdRepoManager).parentDir, ".git", "COMMIT_EDITMSG"))
+	assert.NoError(t, err)
+	from, to, err := autoroll.RollRev(strings.Split(string(msg), "\n")[0], rm.FullChildHash)
+	assert.NoError(t, err)
+	assert.Equal(t, cipdInstance1, from)
+	assert.Equal(t, cipdInstance2, to)
+
+	// The roll lands.
+	parent.Add(cipdVersionFile, cipdInstance2+"\n")
+	parent.Commit()
+	assert.NoError(t, rm.ForceUpdate())
+	assert.Equal(t, cipdInstance2, rm.LastRollRev())
+	rp, err = rm.RolledPast(cipdInstance2)
+	assert.NoError(t, err)
+	assert.True(t, rp)
+
+	// Nothing to roll.
+	_, err = rm.CreateNewRoll(ROLL_STRATEGY_BATCH, emails, cqExtraTrybots, false)
+	assert.Error(t, err)
+
+	// The ref moves on.
+	*latest = "3333333333333333333333333333333333333333"
+	assert.NoError(t, rm.ForceUpdate())
+	assert.Equal(t, *latest, rm.ChildHead())
+}
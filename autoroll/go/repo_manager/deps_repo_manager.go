@@ -64,6 +64,24 @@ func getEnv(depotTools string) []string {
 // newDEPSRepoManager returns a RepoManager instance which operates in the given
 // working directory and updates at the given frequency.
 func newDEPSRepoManager(workdir, parentRepo, parentBranch, childPath, childBranch string, frequency time.Duration, depot_tools string, g *gerrit.Gerrit) (RepoManager, error) {
+	dr, err := makeDEPSRepoManager(workdir, parentRepo, parentBranch, childPath, childBranch, depot_tools, g)
+	if err != nil {
+		return nil, err
+	}
+	if err := dr.update(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for _ = range time.Tick(frequency) {
+			util.LogErr(dr.update())
+		}
+	}()
+	return dr, nil
+}
+
+// makeDEPSRepoManager returns a depsRepoManager which has not been updated
+// yet.
+func makeDEPSRepoManager(workdir, parentRepo, parentBranch, childPath, childBranch, depot_tools string, g *gerrit.Gerrit) (*depsRepoManager, error) {
 	gclient := GCLIENT
 	rollDep := ROLL_DEP
 	if depot_tools != "" {
@@ -98,14 +116,6 @@ func newDEPSRepoManager(workdir, parentRepo, parentBranch, childPath, childBranc
 		parentRepo:  parentRepo,
 		rollDep:     rollDep,
 	}
-	if err := dr.update(); err != nil {
-		return nil, err
-	}
-	go func() {
-		for _ = range time.Tick(frequency) {
-			util.LogErr(dr.update())
-		}
-	}()
 	return dr, nil
 }
 
@@ -130,12 +140,9 @@ func (dr *depsRepoManager) cleanParent() error {
 	return nil
 }
 
-// update syncs code in the relevant repositories.
-func (dr *depsRepoManager) update() error {
-	// Sync the projects.
-	dr.repoMtx.Lock()
-	defer dr.repoMtx.Unlock()
-
+// syncParent syncs the parent checkout and all of its DEPS. The caller must
+// hold repoMtx.
+func (dr *depsRepoManager) syncParent() error {
 	// Create the working directory if needed.
 	if _, err := os.Stat(dr.workdir); err != nil {
 		if err := os.MkdirAll(dr.workdir, 0755); err != nil {
@@ -172,6 +179,18 @@ func (dr *depsRepoManager) update() error {
 	}); err != nil {
 		return err
 	}
+	return nil
+}
+
+// update syncs code in the relevant repositories.
+func (dr *depsRepoManager) update() error {
+	// Sync the projects.
+	dr.repoMtx.Lock()
+	defer dr.repoMtx.Unlock()
+
+	if err := dr.syncParent(); err != nil {
+		return err
+	}
 
 	// Create the child GitInfo if needed.
 	if dr.childRepo == nil {
@@ -183,7 +202,7 @@ func (dr *depsRepoManager) update() error {
 	}
 
 	// Get the last roll revision.
-	lastRollRev, err := dr.getLastRollRev()
+	lastRollRev, err := dr.getLastRollRev(dr.childPath)
 	if err != nil {
 		return err
 	}
@@ -205,15 +224,16 @@ func (dr *depsRepoManager) ForceUpdate() error {
 	return dr.update()
 }
 
-// getLastRollRev returns the commit hash of the last-completed DEPS roll.
-func (dr *depsRepoManager) getLastRollRev() (string, error) {
+// getLastRollRev returns the commit hash of the last-completed DEPS roll of
+// the child at the given path.
+func (dr *depsRepoManager) getLastRollRev(childPath string) (string, error) {
 	output, err := exec.RunCwd(dr.parentDir, dr.gclient, "revinfo")
 	if err != nil {
 		return "", err
 	}
 	split := strings.Split(output, "\n")
 	for _, s := range split {
-		if strings.HasPrefix(s, dr.childPath) {
+		if strings.HasPrefix(s, childPath) {
 			subs := strings.Split(s, "@")
 			if len(subs) != 2 {
 				return "", fmt.Errorf("Failed to parse output of `gclient revinfo`:\n\n%s\n", output)
@@ -304,12 +324,19 @@ If the roll is causing failures, see:
 http://www.chromium.org/developers/tree-sheriffs/sheriff-details-chromium#TOC-Failures-due-to-DEPS-rolls
 
 `
+	return uploadRoll(dr.parentDir, dr.depot_tools, commitMsg, emails, cqExtraTrybots, dryRun)
+}
+
+// uploadRoll uploads the roll commit in the given checkout to Gerrit with the
+// given commit message, using "git cl upload". Returns the issue number of the
+// uploaded roll.
+func uploadRoll(parentDir, depotTools, commitMsg string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
 	if cqExtraTrybots != "" {
 		commitMsg += "\n" + fmt.Sprintf(TMPL_CQ_INCLUDE_TRYBOTS, cqExtraTrybots)
 	}
 	uploadCmd := &exec.Command{
-		Dir:  dr.parentDir,
-		Env:  getEnv(dr.depot_tools),
+		Dir:  parentDir,
+		Env:  getEnv(depotTools),
 		Name: "git",
 		Args: []string{"cl", "upload", "--bypass-hooks", "-f", "-v", "-v"},
 	}
@@ -342,8 +369,8 @@ http://www.chromium.org/developers/tree-sheriffs/sheriff-details-chromium#TOC-Fa
 	defer util.RemoveAll(tmp)
 	jsonFile := path.Join(tmp, "issue.json")
 	if _, err := exec.RunCommand(&exec.Command{
-		Dir:  dr.parentDir,
-		Env:  getEnv(dr.depot_tools),
+		Dir:  parentDir,
+		Env:  getEnv(depotTools),
 		Name: "git",
 		Args: []string{"cl", "issue", fmt.Sprintf("--json=%s", jsonFile)},
 	}); err != nil {
@@ -0,0 +1,292 @@
+package repo_manager
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/gerrit"
+	"go.skia.org/infra/go/git"
+	"go.skia.org/infra/go/git/gitinfo"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// MULTI_DEPS_SHORT_REV_LEN is the length of the revision of each child
+	// in the subject of a multi-child DEPS roll.
+	MULTI_DEPS_SHORT_REV_LEN = 12
+)
+
+var (
+	// Use this function to instantiate a RepoManager which rolls several
+	// children of the DEPS file in one CL. This is able to be overridden for
+	// testing.
+	NewMultiDEPSRepoManager func(string, string, string, []string, string, time.Duration, string, *gerrit.Gerrit) (RepoManager, error) = newMultiDEPSRepoManager
+)
+
+// multiDEPSChild is a child repo which is rolled by a multiDEPSRepoManager.
+type multiDEPSChild struct {
+	path        string
+	dir         string
+	repo        *gitinfo.GitInfo
+	lastRollRev string
+	head        string
+}
+
+// multiDEPSRepoManager is a RepoManager which rolls several children of the
+// DEPS file in a single CL.
+//
+// The revisions it deals in are the concatenation of the commit hashes of all
+// children, in the order the children were given, so that a roll can still be
+// described by a single revision range in its subject line.
+type multiDEPSRepoManager struct {
+	*depsRepoManager
+	children []*multiDEPSChild
+}
+
+// newMultiDEPSRepoManager returns a RepoManager instance which rolls the
+// children at the given paths, operates in the given working directory and
+// updates at the given frequency.
+func newMultiDEPSRepoManager(workdir, parentRepo, parentBranch string, childPaths []string, childBranch string, frequency time.Duration, depot_tools string, g *gerrit.Gerrit) (RepoManager, error) {
+	if len(childPaths) == 0 {
+		return nil, fmt.Errorf("At least one child path is required.")
+	}
+	dr, err := makeDEPSRepoManager(workdir, parentRepo, parentBranch, strings.Join(childPaths, ", "), childBranch, depot_tools, g)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]*multiDEPSChild, 0, len(childPaths))
+	for _, p := range childPaths {
+		children = append(children, &multiDEPSChild{
+			path: p,
+			dir:  path.Join(dr.workdir, p),
+		})
+	}
+	mr := &multiDEPSRepoManager{
+		depsRepoManager: dr,
+		children:        children,
+	}
+	if err := mr.update(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for _ = range time.Tick(frequency) {
+			util.LogErr(mr.update())
+		}
+	}()
+	return mr, nil
+}
+
+// update syncs code in the relevant repositories.
+func (mr *multiDEPSRepoManager) update() error {
+	mr.repoMtx.Lock()
+	defer mr.repoMtx.Unlock()
+
+	if err := mr.syncParent(); err != nil {
+		return err
+	}
+
+	lastRollRevs := make([]string, 0, len(mr.children))
+	heads := make([]string, 0, len(mr.children))
+	for _, c := range mr.children {
+		// Create the child GitInfo if needed.
+		if c.repo == nil {
+			repo, err := gitinfo.NewGitInfo(c.dir, false, true)
+			if err != nil {
+				return err
+			}
+			c.repo = repo
+		}
+		lastRollRev, err := mr.getLastRollRev(c.path)
+		if err != nil {
+			return err
+		}
+		head, err := c.repo.FullHash(fmt.Sprintf("origin/%s", mr.childBranch))
+		if err != nil {
+			return err
+		}
+		lastRollRevs = append(lastRollRevs, lastRollRev)
+		heads = append(heads, head)
+	}
+
+	mr.infoMtx.Lock()
+	defer mr.infoMtx.Unlock()
+	for i, c := range mr.children {
+		c.lastRollRev = lastRollRevs[i]
+		c.head = heads[i]
+	}
+	mr.lastRollRev = strings.Join(lastRollRevs, "")
+	mr.childHead = strings.Join(heads, "")
+	return nil
+}
+
+// ForceUpdate forces the repoManager to update.
+func (mr *multiDEPSRepoManager) ForceUpdate() error {
+	return mr.update()
+}
+
+// splitRev splits the given revision into the revisions of the children.
+func (mr *multiDEPSRepoManager) splitRev(rev string) ([]string, error) {
+	n := len(mr.children)
+	if len(rev) == 0 || len(rev)%n != 0 {
+		return nil, fmt.Errorf("Invalid revision %q for %d children.", rev, n)
+	}
+	size := len(rev) / n
+	rv := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		rv = append(rv, rev[i*size:(i+1)*size])
+	}
+	return rv, nil
+}
+
+// FullChildHash returns the full revision of the given short revision, which
+// consists of a short hash of each child.
+func (mr *multiDEPSRepoManager) FullChildHash(shortRev string) (string, error) {
+	revs, err := mr.splitRev(shortRev)
+	if err != nil {
+		return "", err
+	}
+	mr.repoMtx.RLock()
+	defer mr.repoMtx.RUnlock()
+	for i, c := range mr.children {
+		h, err := c.repo.FullHash(revs[i])
+		if err != nil {
+			return "", err
+		}
+		revs[i] = h
+	}
+	return strings.Join(revs, ""), nil
+}
+
+// RolledPast determines whether the repo has rolled all children past their
+// commits in the given revision.
+func (mr *multiDEPSRepoManager) RolledPast(rev string) (bool, error) {
+	revs, err := mr.splitRev(rev)
+	if err != nil {
+		return false, err
+	}
+	mr.repoMtx.RLock()
+	defer mr.repoMtx.RUnlock()
+	mr.infoMtx.RLock()
+	defer mr.infoMtx.RUnlock()
+	for i, c := range mr.children {
+		rolledPast, err := git.GitDir(c.dir).IsAncestor(revs[i], c.lastRollRev)
+		if err != nil {
+			return false, err
+		}
+		if !rolledPast {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CreateNewRoll creates and uploads a new DEPS roll of all children which have
+// new commits. Returns the issue number of the uploaded roll.
+func (mr *multiDEPSRepoManager) CreateNewRoll(strategy string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	mr.repoMtx.Lock()
+	defer mr.repoMtx.Unlock()
+
+	// Clean the checkout, get onto a fresh branch.
+	if err := mr.cleanParent(); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(mr.parentDir, "git", "checkout", "-b", DEPS_ROLL_BRANCH, "-t", fmt.Sprintf("origin/%s", mr.parentBranch), "-f"); err != nil {
+		return 0, err
+	}
+
+	// Defer some more cleanup.
+	defer func() {
+		util.LogErr(mr.cleanParent())
+	}()
+
+	if _, err := exec.RunCwd(mr.parentDir, "git", "config", "user.name", mr.user); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(mr.parentDir, "git", "config", "user.email", mr.user); err != nil {
+		return 0, err
+	}
+
+	// Roll each child which has new commits.
+	mr.infoMtx.RLock()
+	defer mr.infoMtx.RUnlock()
+	paths := make([]string, 0, len(mr.children))
+	from := ""
+	to := ""
+	numCommits := 0
+	bugs := []string{}
+	log := ""
+	for _, c := range mr.children {
+		paths = append(paths, c.path)
+		from += c.lastRollRev[:MULTI_DEPS_SHORT_REV_LEN]
+		commits, err := c.repo.RevList(fmt.Sprintf("%s..%s", c.lastRollRev, c.head))
+		if err != nil {
+			return 0, fmt.Errorf("Failed to list revisions: %s", err)
+		}
+		if len(commits) == 0 {
+			to += c.lastRollRev[:MULTI_DEPS_SHORT_REV_LEN]
+			continue
+		}
+		rollTo := c.head
+		if strategy == ROLL_STRATEGY_SINGLE {
+			rollTo = commits[len(commits)-1]
+			commits = commits[len(commits)-1:]
+		}
+		to += rollTo[:MULTI_DEPS_SHORT_REV_LEN]
+		numCommits += len(commits)
+
+		// Find Chromium bugs and build the log of the child.
+		log += fmt.Sprintf("\n%s %s..%s:\n", c.path, c.lastRollRev[:MULTI_DEPS_SHORT_REV_LEN], rollTo[:MULTI_DEPS_SHORT_REV_LEN])
+		for _, commit := range commits {
+			d, err := c.repo.Details(commit, false)
+			if err != nil {
+				return 0, fmt.Errorf("Failed to obtain commit details: %s", err)
+			}
+			log += fmt.Sprintf("  %s %s\n", commit[:MULTI_DEPS_SHORT_REV_LEN], d.Subject)
+			b := util.BugsFromCommitMsg(d.Body)
+			for _, bug := range b[util.PROJECT_CHROMIUM] {
+				bugs = append(bugs, bug)
+			}
+		}
+
+		// Run roll-dep.
+		args := []string{c.path, "--roll-to", rollTo}
+		sklog.Infof("Running command: roll-dep %s", strings.Join(args, " "))
+		if _, err := exec.RunCommand(&exec.Command{
+			Dir:  mr.parentDir,
+			Env:  getEnv(mr.depot_tools),
+			Name: mr.rollDep,
+			Args: args,
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if numCommits == 0 {
+		return 0, fmt.Errorf("None of %s have new commits to roll.", strings.Join(paths, ", "))
+	}
+
+	// roll-dep creates a commit for each child; squash them into a single
+	// commit with a message describing all of the children.
+	commitMsg := fmt.Sprintf("Roll %s %s..%s (%d commits)\n%s", strings.Join(paths, ", "), from, to, numCommits, log)
+	if len(bugs) > 0 {
+		commitMsg += fmt.Sprintf("\nBUG=%s\n", strings.Join(bugs, ","))
+	}
+	commitMsg += `
+Documentation for the AutoRoller is here:
+https://skia.googlesource.com/buildbot/+/master/autoroll/README.md
+
+If the roll is causing failures, see:
+http://www.chromium.org/developers/tree-sheriffs/sheriff-details-chromium#TOC-Failures-due-to-DEPS-rolls
+
+`
+	if _, err := exec.RunCwd(mr.parentDir, "git", "reset", "--soft", fmt.Sprintf("origin/%s", mr.parentBranch)); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(mr.parentDir, "git", "commit", "-m", commitMsg); err != nil {
+		return 0, err
+	}
+	return uploadRoll(mr.parentDir, mr.depot_tools, commitMsg, emails, cqExtraTrybots, dryRun)
+}
@@ -0,0 +1,110 @@
+package repo_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/autoroll"
+	git_testutils "go.skia.org/infra/go/git/testutils"
+	"go.skia.org/infra/go/testutils"
+)
+
+const (
+	otherChildPath = "path/to/other_child"
+)
+
+// setupMulti is like setup, but the parent also depends on a second child,
+// which has a single commit.
+func setupMulti(t *testing.T) (string, []string, *git_testutils.GitBuilder, string, *git_testutils.GitBuilder, func()) {
+	wd, child, childCommits, parent, cleanup := setup(t)
+	other := git_testutils.GitInit(t)
+	otherCommit := other.CommitGen("otherfile.txt")
+	parent.Add("DEPS", fmt.Sprintf(`deps = {
+  "%s": "%s@%s",
+  "%s": "%s@%s",
+}`, childPath, child.RepoUrl(), childCommits[0], otherChildPath, other.RepoUrl(), otherCommit))
+	parent.Commit()
+	return wd, childCommits, other, otherCommit, parent, func() {
+		other.Cleanup()
+		cleanup()
+	}
+}
+
+// TestMultiDEPSRepoManager tests all aspects of the multiDEPSRepoManager
+// except for CreateNewRoll.
+func TestMultiDEPSRepoManager(t *testing.T) {
+	testutils.LargeTest(t)
+
+	wd, childCommits, other, otherCommit, parent, cleanup := setupMulti(t)
+	defer cleanup()
+
+	g := setupFakeGerrit(t, wd)
+	_, err := NewMultiDEPSRepoManager(wd, parent.RepoUrl(), "master", []string{}, "master", 24*time.Hour, depotTools, g)
+	assert.Error(t, err)
+	rm, err := NewMultiDEPSRepoManager(wd, parent.RepoUrl(), "master", []string{childPath, otherChildPath}, "master", 24*time.Hour, depotTools, g)
+	assert.NoError(t, err)
+	assert.Equal(t, childCommits[0]+otherCommit, rm.LastRollRev())
+	assert.Equal(t, childCommits[len(childCommits)-1]+otherCommit, rm.ChildHead())
+
+	// Test FullChildHash.
+	h, err := rm.FullChildHash(childCommits[1][:12] + otherCommit[:12])
+	assert.NoError(t, err)
+	assert.Equal(t, childCommits[1]+otherCommit, h)
+	_, err = rm.FullChildHash(childCommits[1][:12] + otherCommit[:11])
+	assert.Error(t, err)
+
+	// Test update.
+	lastOtherCommit := other.CommitGen("abc.txt")
+	assert.NoError(t, rm.ForceUpdate())
+	assert.Equal(t, childCommits[len(childCommits)-1]+lastOtherCommit, rm.ChildHead())
+
+	// RolledPast.
+	rp, err := rm.RolledPast(childCommits[0] + otherCommit)
+	assert.NoError(t, err)
+	assert.True(t, rp)
+	rp, err = rm.RolledPast(childCommits[0] + lastOtherCommit)
+	assert.NoError(t, err)
+	assert.False(t, rp)
+	rp, err = rm.RolledPast(childCommits[1] + otherCommit)
+	assert.NoError(t, err)
+	assert.False(t, rp)
+}
+
+func testCreateNewMultiDEPSRoll(t *testing.T, strategy string, expectIdx int) {
+	testutils.LargeTest(t)
+
+	wd, childCommits, _, otherCommit, parent, cleanup := setupMulti(t)
+	defer cleanup()
+
+	g := setupFakeGerrit(t, wd)
+	rm, err := NewMultiDEPSRepoManager(wd, parent.RepoUrl(), "master", []string{childPath, otherChildPath}, "master", 24*time.Hour, depotTools, g)
+	assert.NoError(t, err)
+
+	// Only the first child has new commits, the other one stays unchanged.
+	issue, err := rm.CreateNewRoll(strategy, emails, cqExtraTrybots, false)
+	assert.NoError(t, err)
+	assert.Equal(t, issueNum, issue)
+	msg, err := ioutil.ReadFile(path.Join(rm.(*multiDEPSRepoManager).parentDir, ".git", "COMMIT_EDITMSG"))
+	assert.NoError(t, err)
+	subject := strings.Split(string(msg), "\n")[0]
+	assert.True(t, strings.HasPrefix(subject, fmt.Sprintf("Roll %s, %s ", childPath, otherChildPath)))
+	from, to, err := autoroll.RollRev(subject, rm.FullChildHash)
+	assert.NoError(t, err)
+	assert.Equal(t, childCommits[0]+otherCommit, from)
+	assert.Equal(t, childCommits[expectIdx]+otherCommit, to)
+}
+
+// TestMultiDEPSRepoManagerBatch tests the batch roll strategy.
+func TestMultiDEPSRepoManagerBatch(t *testing.T) {
+	testCreateNewMultiDEPSRoll(t, ROLL_STRATEGY_BATCH, numChildCommits-1)
+}
+
+// TestMultiDEPSRepoManagerSingle tests the single-commit roll strategy.
+func TestMultiDEPSRepoManagerSingle(t *testing.T) {
+	testCreateNewMultiDEPSRoll(t, ROLL_STRATEGY_SINGLE, 1)
+}
@@ -76,7 +76,7 @@ func (j *jobEventDB) update() error {
 	}
 	cached := map[string][]*events.Event{}
 	for _, job := range jobs {
-		if !job.Done() {
+		if !job.Done() || job.Status == db.JOB_STATUS_SKIPPED {
 			continue
 		}
 		var buf bytes.Buffer
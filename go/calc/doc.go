@@ -2,9 +2,14 @@
 //
 //   f(g(h("foo"), i(3, "bar")))
 //
-// Note that while it does understand strings and numbers, it doesn't
-// do binary operators. We can do those via functions if needed, ala
-// add(x, y), sub(x, y), etc.
+// Expressions may also be combined with the binary operators +, -, * and /,
+// which have the usual precedence and may be grouped with parentheses, ala
+//
+//   (f("foo") - g("foo")) / 2
+//
+// Operators work point by point, on rows or numbers. If either point is
+// missing, or the result isn't a finite number, then the result is
+// missing.
 //
 // Caveats:
 // * Only handles ASCII.
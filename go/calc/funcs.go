@@ -3,6 +3,7 @@ package calc
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 
 	"go.skia.org/infra/go/vec32"
@@ -14,6 +15,32 @@ const (
 	MIN_STDDEV = 0.001
 )
 
+// numArg returns the value of the i'th argument of node, which must be a
+// number. name is the name of the function, used in error messages.
+func numArg(name string, node *Node, i int) (float64, error) {
+	if node.Args[i].Typ != NodeNum {
+		return 0, fmt.Errorf("%s() takes a number as argument %d.", name, i+1)
+	}
+	f, err := strconv.ParseFloat(node.Args[i].Val, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s() argument not a valid number %s : %s", name, node.Args[i].Val, err)
+	}
+	return f, nil
+}
+
+// intArg returns the value of the i'th argument of node, which must be an
+// integer. name is the name of the function, used in error messages.
+func intArg(name string, node *Node, i int) (int, error) {
+	if node.Args[i].Typ != NodeNum {
+		return 0, fmt.Errorf("%s() takes an integer as argument %d.", name, i+1)
+	}
+	n, err := strconv.ParseInt(node.Args[i].Val, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s() argument not a valid integer %s : %s", name, node.Args[i].Val, err)
+	}
+	return int(n), nil
+}
+
 type FilterFunc struct{}
 
 // filterFunc is a Func that returns a filtered set of Rows in the Context.
@@ -50,7 +77,7 @@ func (NormFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) > 2 || len(node.Args) == 0 {
 		return nil, fmt.Errorf("norm() takes one or two arguments.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("norm() takes a function as its first argument.")
 	}
 	minStdDev := MIN_STDDEV
@@ -100,7 +127,7 @@ func (FillFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("fill() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("fill() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -135,7 +162,7 @@ func (AveFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("ave() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("ave() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -226,7 +253,7 @@ func (CountFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("count() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("count() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -269,7 +296,7 @@ func (SumFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("sum() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("sum() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -316,7 +343,7 @@ func (GeoFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("geo() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("geo() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -363,7 +390,7 @@ func (LogFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("log() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("log() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -404,7 +431,7 @@ func (TraceAveFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("trace_ave() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("trace_ave() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -438,7 +465,7 @@ func (TraceStdDevFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("trace_stddev() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("trace_stddev() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -472,7 +499,7 @@ func (TraceCovFunc) Eval(ctx *Context, node *Node) (Rows, error) {
 	if len(node.Args) != 1 {
 		return nil, fmt.Errorf("trace_cov() takes a single argument.")
 	}
-	if node.Args[0].Typ != NodeFunc {
+	if !node.Args[0].returnsRows() {
 		return nil, fmt.Errorf("trace_cov() takes a function argument.")
 	}
 	rows, err := node.Args[0].Eval(ctx)
@@ -495,3 +522,309 @@ func (TraceCovFunc) Describe() string {
 }
 
 var traceCovFunc = TraceCovFunc{}
+
+// float32Slice is a utility type for sorting float32's.
+type float32Slice []float32
+
+func (p float32Slice) Len() int           { return len(p) }
+func (p float32Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p float32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// present returns a sorted copy of all the non-vec32.MISSING_DATA_SENTINEL
+// values in row.
+func present(row []float32) []float32 {
+	ret := []float32{}
+	for _, v := range row {
+		if v != vec32.MISSING_DATA_SENTINEL {
+			ret = append(ret, v)
+		}
+	}
+	sort.Sort(float32Slice(ret))
+	return ret
+}
+
+// percentile returns the p'th percentile, p in [0, 100], of the sorted values,
+// interpolating linearly between the closest ranks.
+//
+// Returns vec32.MISSING_DATA_SENTINEL if sorted is empty.
+func percentile(sorted []float32, p float64) float32 {
+	if len(sorted) == 0 {
+		return vec32.MISSING_DATA_SENTINEL
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	frac := float32(rank - float64(lower))
+	return sorted[lower] + frac*(sorted[upper]-sorted[lower])
+}
+
+type PercentileFunc struct{}
+
+// percentileFunc implements Func and computes the given percentile of the
+// values of all argument rows at each point, producing a single trace.
+//
+// vec32.MISSING_DATA_SENTINEL values are not included. Note that if all the
+// values at an index are vec32.MISSING_DATA_SENTINEL then the percentile will
+// be vec32.MISSING_DATA_SENTINEL.
+func (PercentileFunc) Eval(ctx *Context, node *Node) (Rows, error) {
+	if len(node.Args) != 2 {
+		return nil, fmt.Errorf("percentile() takes two arguments.")
+	}
+	if !node.Args[0].returnsRows() {
+		return nil, fmt.Errorf("percentile() takes a function as its first argument.")
+	}
+	p, err := numArg("percentile", node, 1)
+	if err != nil {
+		return nil, err
+	}
+	if p < 0 || p > 100 {
+		return nil, fmt.Errorf("percentile() must be between 0 and 100, got %g", p)
+	}
+	rows, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("percentile() argument failed to evaluate: %s", err)
+	}
+
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	ret := newRow(rows)
+	for i, _ := range ret {
+		col := make([]float32, 0, len(rows))
+		for _, r := range rows {
+			col = append(col, r[i])
+		}
+		ret[i] = percentile(present(col), p)
+	}
+	return Rows{ctx.formula: ret}, nil
+}
+
+func (PercentileFunc) Describe() string {
+	return `percentile(rows, p) computes the p'th percentile, 0 <= p <= 100, of the values of all argument rows into a single trace.`
+}
+
+var percentileFunc = PercentileFunc{}
+
+// movingWindow applies f to the trailing window of size n that ends at each
+// point of every row, and returns the results keyed by name(key).
+//
+// f is passed the sorted non-vec32.MISSING_DATA_SENTINEL values in the window,
+// if there are none then the result is vec32.MISSING_DATA_SENTINEL.
+func movingWindow(ctx *Context, node *Node, name string, f func([]float32) float32) (Rows, error) {
+	if len(node.Args) != 2 {
+		return nil, fmt.Errorf("%s() takes two arguments.", name)
+	}
+	if !node.Args[0].returnsRows() {
+		return nil, fmt.Errorf("%s() takes a function as its first argument.", name)
+	}
+	n, err := intArg(name, node, 1)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("%s() window size must be at least 1, got %d", name, n)
+	}
+	rows, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s() failed evaluating argument: %s", name, err)
+	}
+
+	ret := Rows{}
+	for key, r := range rows {
+		row := make([]float32, len(r))
+		for i, _ := range r {
+			begin := i - n + 1
+			if begin < 0 {
+				begin = 0
+			}
+			values := present(r[begin : i+1])
+			if len(values) == 0 {
+				row[i] = vec32.MISSING_DATA_SENTINEL
+			} else {
+				row[i] = f(values)
+			}
+		}
+		ret[name+"("+key+")"] = row
+	}
+	return ret, nil
+}
+
+type MovingAveFunc struct{}
+
+// movingAveFunc implements Func and computes the moving average of each row
+// over a trailing window of the given size.
+//
+// vec32.MISSING_DATA_SENTINEL values are not included in the average.
+func (MovingAveFunc) Eval(ctx *Context, node *Node) (Rows, error) {
+	return movingWindow(ctx, node, "moving_ave", vec32.Mean)
+}
+
+func (MovingAveFunc) Describe() string {
+	return `moving_ave(rows, n) computes the average of each row over a trailing window of n points.`
+}
+
+var movingAveFunc = MovingAveFunc{}
+
+type MovingMedianFunc struct{}
+
+// movingMedianFunc implements Func and computes the moving median of each row
+// over a trailing window of the given size.
+//
+// vec32.MISSING_DATA_SENTINEL values are not included in the median.
+func (MovingMedianFunc) Eval(ctx *Context, node *Node) (Rows, error) {
+	return movingWindow(ctx, node, "moving_median", func(sorted []float32) float32 {
+		return percentile(sorted, 50)
+	})
+}
+
+func (MovingMedianFunc) Describe() string {
+	return `moving_median(rows, n) computes the median of each row over a trailing window of n points.`
+}
+
+var movingMedianFunc = MovingMedianFunc{}
+
+// stepFit returns the trace that best fits r, in the least squares sense, with
+// a single step, i.e. a trace that is constant before the step and constant
+// after it.
+//
+// vec32.MISSING_DATA_SENTINEL values are ignored when fitting. If r doesn't
+// have at least two values then it is fitted with its mean.
+func stepFit(r []float32) []float32 {
+	ret := vec32.Dup(r)
+	vec32.FillMeanMissing(ret)
+
+	bestErr := float32(math.MaxFloat32)
+	for i := 1; i < len(r); i++ {
+		before := vec32.MeanMissing(r[:i])
+		after := vec32.MeanMissing(r[i:])
+		if before == vec32.MISSING_DATA_SENTINEL || after == vec32.MISSING_DATA_SENTINEL {
+			continue
+		}
+		if err := vec32.SSE(r[:i], before) + vec32.SSE(r[i:], after); err < bestErr {
+			bestErr = err
+			for j, _ := range ret {
+				if j < i {
+					ret[j] = before
+				} else {
+					ret[j] = after
+				}
+			}
+		}
+	}
+	return ret
+}
+
+type StepFunc struct{}
+
+// stepFunc implements Func and replaces each row with the single step that
+// best fits it, which makes the location and size of a regression easy to
+// see.
+func (StepFunc) Eval(ctx *Context, node *Node) (Rows, error) {
+	if len(node.Args) != 1 {
+		return nil, fmt.Errorf("step() takes a single argument.")
+	}
+	if !node.Args[0].returnsRows() {
+		return nil, fmt.Errorf("step() takes a function argument.")
+	}
+	rows, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("step() failed evaluating argument: %s", err)
+	}
+
+	ret := Rows{}
+	for key, r := range rows {
+		ret["step("+key+")"] = stepFit(r)
+	}
+	return ret, nil
+}
+
+func (StepFunc) Describe() string {
+	return `step() replaces each row with the single step function that best fits it, in the least squares sense.`
+}
+
+var stepFunc = StepFunc{}
+
+type ScaleByAveFunc struct{}
+
+// scaleByAveFunc implements Func and divides every value in a row by the mean
+// of the row.
+//
+// vec32.MISSING_DATA_SENTINEL values are not taken into account for the mean.
+// If the mean is 0 or all the values are vec32.MISSING_DATA_SENTINEL then the
+// result is all vec32.MISSING_DATA_SENTINEL.
+func (ScaleByAveFunc) Eval(ctx *Context, node *Node) (Rows, error) {
+	if len(node.Args) != 1 {
+		return nil, fmt.Errorf("scale_by_ave() takes a single argument.")
+	}
+	if !node.Args[0].returnsRows() {
+		return nil, fmt.Errorf("scale_by_ave() takes a function argument.")
+	}
+	rows, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("scale_by_ave() failed evaluating argument: %s", err)
+	}
+
+	ret := Rows{}
+	for key, r := range rows {
+		row := vec32.Dup(r)
+		mean := vec32.MeanMissing(r)
+		for i, v := range row {
+			if mean == 0 || mean == vec32.MISSING_DATA_SENTINEL {
+				row[i] = vec32.MISSING_DATA_SENTINEL
+			} else if v != vec32.MISSING_DATA_SENTINEL {
+				row[i] = v / mean
+			}
+		}
+		ret["scale_by_ave("+key+")"] = row
+	}
+	return ret, nil
+}
+
+func (ScaleByAveFunc) Describe() string {
+	return `scale_by_ave() divides every value in a row by the mean of the row.`
+}
+
+var scaleByAveFunc = ScaleByAveFunc{}
+
+type ShiftFunc struct{}
+
+// shiftFunc implements Func and shifts every row by the given number of
+// points, a positive number shifts the values to later commits. Points that
+// are shifted in are vec32.MISSING_DATA_SENTINEL.
+func (ShiftFunc) Eval(ctx *Context, node *Node) (Rows, error) {
+	if len(node.Args) != 2 {
+		return nil, fmt.Errorf("shift() takes two arguments.")
+	}
+	if !node.Args[0].returnsRows() {
+		return nil, fmt.Errorf("shift() takes a function as its first argument.")
+	}
+	n, err := intArg("shift", node, 1)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("shift() failed evaluating argument: %s", err)
+	}
+
+	ret := Rows{}
+	for key, r := range rows {
+		row := make([]float32, len(r))
+		for i, _ := range row {
+			if j := i - n; j >= 0 && j < len(r) {
+				row[i] = r[j]
+			} else {
+				row[i] = vec32.MISSING_DATA_SENTINEL
+			}
+		}
+		ret["shift("+key+")"] = row
+	}
+	return ret, nil
+}
+
+func (ShiftFunc) Describe() string {
+	return `shift(rows, n) shifts every row n points to the right, i.e. to later commits, or to the left if n is negative.`
+}
+
+var shiftFunc = ShiftFunc{}
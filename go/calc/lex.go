@@ -16,6 +16,7 @@ const (
 	itemLParen
 	itemRParen
 	itemComma
+	itemOp
 	itemEOF
 )
 
@@ -35,9 +36,11 @@ type lexer struct {
 	input      string    // The string being parsed.
 	start      int       // The offset of the current lexical item.
 	pos        int       // Current position in input.
+	width      int       // Width of the last char read by next, 0 at eof.
 	items      chan item // Channel by which items are delivered.
 	state      stateFn   // The next lexing function.
 	peekBuffer []item    // A peekBuffer for peek'd items.
+	lastTyp    itemType  // The type of the last emitted item.
 }
 
 // nextItem returns the next item from the input.
@@ -54,6 +57,9 @@ func (l *lexer) nextItem() item {
 // peekItem allows the caller to look ahead and see the next item that
 // nextItem() will return.
 func (l *lexer) peekItem() item {
+	if len(l.peekBuffer) > 0 {
+		return l.peekBuffer[0]
+	}
 	item := <-l.items
 	l.peekBuffer = append(l.peekBuffer, item)
 	return item
@@ -96,6 +102,7 @@ func newLexer(input string) *lexer {
 		items:      make(chan item, 2),
 		state:      lexExp,
 		peekBuffer: []item{},
+		lastTyp:    itemEOF,
 	}
 	go l.run()
 	return l
@@ -104,16 +111,26 @@ func newLexer(input string) *lexer {
 // next returns the next char in the input.
 func (l *lexer) next() byte {
 	if int(l.pos) >= len(l.input) {
+		l.width = 0
 		return eof
 	}
 	ch := l.input[l.pos]
+	l.width = 1
 	l.pos += 1
 	return ch
 }
 
+// peek returns the next char in the input without consuming it.
+func (l *lexer) peek() byte {
+	if int(l.pos) >= len(l.input) {
+		return eof
+	}
+	return l.input[l.pos]
+}
+
 // backUp steps back one rune. Can only be called once per call of next.
 func (l *lexer) backUp() {
-	l.pos -= 1
+	l.pos -= l.width
 }
 
 // run runs the state machine for the lexer.
@@ -129,6 +146,23 @@ func (l *lexer) emit(t itemType) {
 		val: l.input[l.start:l.pos],
 	}
 	l.start = l.pos
+	l.lastTyp = t
+}
+
+// afterOperand returns true if the last emitted item ends an operand, in which
+// case a following '+' or '-' is a binary operator and not the sign of a
+// number.
+func (l *lexer) afterOperand() bool {
+	switch l.lastTyp {
+	case itemIdentifier, itemNum, itemString, itemRParen:
+		return true
+	}
+	return false
+}
+
+// isDigit returns true if r is an ASCII digit.
+func isDigit(r byte) bool {
+	return '0' <= r && r <= '9'
 }
 
 // lexExp parses the input expression.
@@ -153,7 +187,17 @@ func lexExp(l *lexer) stateFn {
 	case unicode.IsSpace(rune(r)):
 		l.ignore()
 		return lexExp
-	case r == '+' || r == '-' || ('0' <= r && r <= '9'):
+	case r == '+' || r == '-':
+		if next := l.peek(); !l.afterOperand() && (isDigit(next) || next == '.') {
+			l.backUp()
+			return lexNumber
+		}
+		l.emit(itemOp)
+		return lexExp
+	case r == '*' || r == '/':
+		l.emit(itemOp)
+		return lexExp
+	case isDigit(r) || r == '.':
 		l.backUp()
 		return lexNumber
 	default:
@@ -62,6 +62,36 @@ func TestLex(t *testing.T) {
 				item{itemEOF, ""},
 			},
 		},
+		{
+			input: "-1 + foo(2)*-3.5/ -bar()",
+			items: []item{
+				item{itemNum, "-1"},
+				item{itemOp, "+"},
+				item{itemIdentifier, "foo"},
+				item{itemLParen, "("},
+				item{itemNum, "2"},
+				item{itemRParen, ")"},
+				item{itemOp, "*"},
+				item{itemNum, "-3.5"},
+				item{itemOp, "/"},
+				item{itemOp, "-"},
+				item{itemIdentifier, "bar"},
+				item{itemLParen, "("},
+				item{itemRParen, ")"},
+				item{itemEOF, ""},
+			},
+		},
+		{
+			input: "(a)-1",
+			items: []item{
+				item{itemLParen, "("},
+				item{itemIdentifier, "a"},
+				item{itemRParen, ")"},
+				item{itemOp, "-"},
+				item{itemNum, "1"},
+				item{itemEOF, ""},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		l := newLexer(tc.input)
@@ -0,0 +1,184 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"go.skia.org/infra/go/vec32"
+)
+
+// operand is the result of evaluating one side of a binary operator, which is
+// either a set of Rows or a single number.
+type operand struct {
+	rows     Rows
+	scalar   float32
+	isScalar bool
+}
+
+// newOpNode creates a new NodeOp Node for the binary operator op.
+func newOpNode(op string, left, right *Node) *Node {
+	n := newNode(op, NodeOp)
+	n.Args = []*Node{left, right}
+	return n
+}
+
+// evalOperand evaluates a Node that appears as an argument to an operator.
+func evalOperand(ctx *Context, n *Node) (*operand, error) {
+	switch n.Typ {
+	case NodeNum:
+		f, err := strconv.ParseFloat(n.Val, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Not a valid number %s: %s", n.Val, err)
+		}
+		return &operand{scalar: float32(f), isScalar: true}, nil
+	case NodeOp:
+		return evalOp(ctx, n)
+	case NodeFunc:
+		rows, err := n.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &operand{rows: rows}, nil
+	}
+	return nil, fmt.Errorf("Operators take functions or numbers, not: %q", n.Val)
+}
+
+// apply applies the binary operator op to a and b.
+//
+// If either a or b is vec32.MISSING_DATA_SENTINEL, or the result isn't a
+// finite number, e.g. after a division by zero, then the result is
+// vec32.MISSING_DATA_SENTINEL.
+func apply(op string, a, b float32) float32 {
+	if a == vec32.MISSING_DATA_SENTINEL || b == vec32.MISSING_DATA_SENTINEL {
+		return vec32.MISSING_DATA_SENTINEL
+	}
+	var ret float32
+	switch op {
+	case "+":
+		ret = a + b
+	case "-":
+		ret = a - b
+	case "*":
+		ret = a * b
+	case "/":
+		ret = a / b
+	}
+	if math.IsInf(float64(ret), 0) || math.IsNaN(float64(ret)) {
+		return vec32.MISSING_DATA_SENTINEL
+	}
+	return ret
+}
+
+// firstRow returns a row from rows, which is only useful if rows has a single
+// row.
+func firstRow(rows Rows) []float32 {
+	for _, v := range rows {
+		return v
+	}
+	return []float32{}
+}
+
+// applyRows applies op point by point to two rows of the same length.
+func applyRows(op string, a, b []float32) ([]float32, error) {
+	if len(a) != len(b) {
+		return nil, fmt.Errorf("Operator %s applied to rows of different lengths: %d != %d", op, len(a), len(b))
+	}
+	ret := make([]float32, len(a))
+	for i, _ := range a {
+		ret[i] = apply(op, a[i], b[i])
+	}
+	return ret, nil
+}
+
+// evalOp evaluates a NodeOp.
+//
+// A number combined with Rows is applied to every row. Two sets of Rows are
+// combined point by point: if one side has a single row then it is combined
+// with every row of the other side and the keys of the other side are kept,
+// otherwise rows with matching keys are combined and all other rows are
+// dropped.
+func evalOp(ctx *Context, n *Node) (*operand, error) {
+	if len(n.Args) != 2 {
+		return nil, fmt.Errorf("Operator %s takes two arguments.", n.Val)
+	}
+	left, err := evalOperand(ctx, n.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("Operator %s failed evaluating left argument: %s", n.Val, err)
+	}
+	right, err := evalOperand(ctx, n.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("Operator %s failed evaluating right argument: %s", n.Val, err)
+	}
+
+	if left.isScalar && right.isScalar {
+		return &operand{scalar: apply(n.Val, left.scalar, right.scalar), isScalar: true}, nil
+	}
+	ret := Rows{}
+	if left.isScalar {
+		for key, r := range right.rows {
+			row := make([]float32, len(r))
+			for i, v := range r {
+				row[i] = apply(n.Val, left.scalar, v)
+			}
+			ret[key] = row
+		}
+		return &operand{rows: ret}, nil
+	}
+	if right.isScalar {
+		for key, r := range left.rows {
+			row := make([]float32, len(r))
+			for i, v := range r {
+				row[i] = apply(n.Val, v, right.scalar)
+			}
+			ret[key] = row
+		}
+		return &operand{rows: ret}, nil
+	}
+
+	if len(left.rows) == 0 || len(right.rows) == 0 {
+		return &operand{rows: ret}, nil
+	}
+	if len(left.rows) == 1 && len(right.rows) == 1 {
+		row, err := applyRows(n.Val, firstRow(left.rows), firstRow(right.rows))
+		if err != nil {
+			return nil, err
+		}
+		ret[ctx.formula] = row
+		return &operand{rows: ret}, nil
+	}
+	if len(left.rows) == 1 {
+		a := firstRow(left.rows)
+		for key, b := range right.rows {
+			row, err := applyRows(n.Val, a, b)
+			if err != nil {
+				return nil, err
+			}
+			ret[key] = row
+		}
+		return &operand{rows: ret}, nil
+	}
+	if len(right.rows) == 1 {
+		b := firstRow(right.rows)
+		for key, a := range left.rows {
+			row, err := applyRows(n.Val, a, b)
+			if err != nil {
+				return nil, err
+			}
+			ret[key] = row
+		}
+		return &operand{rows: ret}, nil
+	}
+	for key, a := range left.rows {
+		b, ok := right.rows[key]
+		if !ok {
+			continue
+		}
+		row, err := applyRows(n.Val, a, b)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = row
+	}
+	return &operand{rows: ret}, nil
+}
@@ -11,6 +11,7 @@ const (
 	NodeFunc
 	NodeNum
 	NodeString
+	NodeOp
 )
 
 type (
@@ -52,8 +53,24 @@ func newNode(val string, typ NodeType) *Node {
 	}
 }
 
-// Evaluates a node. Only valid to call on Nodes of type NodeFunc.
+// returnsRows returns true if evaluating the Node may return Rows, i.e. it is
+// a function call or an operator.
+func (n *Node) returnsRows() bool {
+	return n.Typ == NodeFunc || n.Typ == NodeOp
+}
+
+// Evaluates a node. Only valid to call on Nodes of type NodeFunc or NodeOp.
 func (n *Node) Eval(ctx *Context) (Rows, error) {
+	if n.Typ == NodeOp {
+		op, err := evalOp(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		if op.isScalar {
+			return nil, fmt.Errorf("Expression evaluates to a number, not rows: %s", n.Val)
+		}
+		return op.rows, nil
+	}
 	if n.Typ != NodeFunc {
 		return nil, fmt.Errorf("Tried to call eval on a non-Func node: %s", n.Val)
 	}
@@ -87,20 +104,28 @@ func NewContext(rowsFromQuery RowsFromQuery) *Context {
 	return &Context{
 		RowsFromQuery: rowsFromQuery,
 		Funcs: map[string]Func{
-			"filter":       filterFunc,
-			"norm":         normFunc,
-			"fill":         fillFunc,
-			"ave":          aveFunc,
-			"avg":          aveFunc,
-			"count":        countFunc,
-			"ratio":        ratioFunc,
-			"sum":          sumFunc,
-			"geo":          geoFunc,
-			"log":          logFunc,
-			"trace_ave":    traceAveFunc,
-			"trace_avg":    traceAveFunc,
-			"trace_stddev": traceStdDevFunc,
-			"trace_cov":    traceCovFunc,
+			"filter":        filterFunc,
+			"norm":          normFunc,
+			"fill":          fillFunc,
+			"ave":           aveFunc,
+			"avg":           aveFunc,
+			"count":         countFunc,
+			"ratio":         ratioFunc,
+			"sum":           sumFunc,
+			"geo":           geoFunc,
+			"log":           logFunc,
+			"trace_ave":     traceAveFunc,
+			"trace_avg":     traceAveFunc,
+			"trace_stddev":  traceStdDevFunc,
+			"trace_cov":     traceCovFunc,
+			"percentile":    percentileFunc,
+			"moving_ave":    movingAveFunc,
+			"moving_avg":    movingAveFunc,
+			"moving_median": movingMedianFunc,
+			"step":          stepFunc,
+			"scale_by_ave":  scaleByAveFunc,
+			"scale_by_avg":  scaleByAveFunc,
+			"shift":         shiftFunc,
 		},
 	}
 }
@@ -119,22 +144,113 @@ func (ctx *Context) Eval(exp string) (Rows, error) {
 // parse starts the parsing.
 func parse(input string) (*Node, error) {
 	l := newLexer(input)
-	return parseExp(l)
+	n, err := parseExp(l)
+	if err != nil {
+		return nil, err
+	}
+	if it := l.nextItem(); it.typ != itemEOF {
+		return nil, fmt.Errorf("Expression: unexpected %q after the expression.", it.val)
+	}
+	return n, nil
 }
 
-// parseExp parses an expression.
+// parseExp parses an expression, i.e. a sum of terms.
 //
 // Something of the form:
 //
-//    fn(arg1, args2)
+//    term + term - term
 //
 func parseExp(l *lexer) (*Node, error) {
+	n, err := parseTerm(l)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		it := l.peekItem()
+		if it.typ != itemOp || (it.val != "+" && it.val != "-") {
+			return n, nil
+		}
+		l.nextItem()
+		right, err := parseTerm(l)
+		if err != nil {
+			return nil, err
+		}
+		n = newOpNode(it.val, n, right)
+	}
+}
+
+// parseTerm parses a product of factors.
+//
+// Something of the form:
+//
+//    factor * factor / factor
+//
+func parseTerm(l *lexer) (*Node, error) {
+	n, err := parseFactor(l)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		it := l.peekItem()
+		if it.typ != itemOp || (it.val != "*" && it.val != "/") {
+			return n, nil
+		}
+		l.nextItem()
+		right, err := parseFactor(l)
+		if err != nil {
+			return nil, err
+		}
+		n = newOpNode(it.val, n, right)
+	}
+}
+
+// parseFactor parses a function call, a number, a string, a parenthesized
+// expression, or a factor preceded by a unary '+' or '-'.
+func parseFactor(l *lexer) (*Node, error) {
 	it := l.nextItem()
-	if it.typ != itemIdentifier {
-		return nil, fmt.Errorf("Expression: must begin with an identifier")
+	switch it.typ {
+	case itemIdentifier:
+		return parseFunc(l, it)
+	case itemNum:
+		return newNode(it.val, NodeNum), nil
+	case itemString:
+		return newNode(it.val, NodeString), nil
+	case itemLParen:
+		n, err := parseExp(l)
+		if err != nil {
+			return nil, err
+		}
+		if it := l.nextItem(); it.typ != itemRParen {
+			return nil, fmt.Errorf("Expression: didn't find ')' after a parenthesized expression.")
+		}
+		return n, nil
+	case itemOp:
+		if it.val != "+" && it.val != "-" {
+			return nil, fmt.Errorf("Expression: unexpected operator %q.", it.val)
+		}
+		n, err := parseFactor(l)
+		if err != nil {
+			return nil, err
+		}
+		if it.val == "-" {
+			n = newOpNode("*", newNode("-1", NodeNum), n)
+		}
+		return n, nil
+	case itemError:
+		return nil, fmt.Errorf("Expression: %s", it.val)
 	}
-	n := newNode(it.val, NodeFunc)
-	it = l.nextItem()
+	return nil, fmt.Errorf("Expression: unexpected token %q.", it.val)
+}
+
+// parseFunc parses a function call, the identifier has already been consumed.
+//
+// Something of the form:
+//
+//    fn(arg1, args2)
+//
+func parseFunc(l *lexer, ident item) (*Node, error) {
+	n := newNode(ident.val, NodeFunc)
+	it := l.nextItem()
 	if it.typ != itemLParen {
 		return nil, fmt.Errorf("Expression: didn't find '(' after an identifier.")
 	}
@@ -156,32 +272,23 @@ func parseExp(l *lexer) (*Node, error) {
 //
 // It terminates when it sees a closing paren, or an invalid token.
 func parseArgs(l *lexer, p *Node) error {
-Loop:
+	if l.peekItem().typ == itemRParen {
+		return nil
+	}
 	for {
+		arg, err := parseExp(l)
+		if err != nil {
+			return fmt.Errorf("Failed parsing args: %s", err)
+		}
+		p.Args = append(p.Args, arg)
 		it := l.peekItem()
 		switch it.typ {
-		case itemIdentifier:
-			next, err := parseExp(l)
-			if err != nil {
-				return fmt.Errorf("Failed parsing args: %s", err)
-			}
-			p.Args = append(p.Args, next)
-		case itemString:
-			l.nextItem()
-			node := newNode(it.val, NodeString)
-			p.Args = append(p.Args, node)
-		case itemNum:
-			l.nextItem()
-			node := newNode(it.val, NodeNum)
-			p.Args = append(p.Args, node)
 		case itemComma:
 			l.nextItem()
-			continue
 		case itemRParen:
-			break Loop
+			return nil
 		default:
-			return fmt.Errorf("Invalid token in args: %d", it.typ)
+			return fmt.Errorf("Invalid token in args: %q", it.val)
 		}
 	}
-	return nil
 }
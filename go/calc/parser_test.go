@@ -110,6 +110,22 @@ func TestEvalErrors(t *testing.T) {
 		`ave()`,
 		`avg()`,
 		`fill()`,
+		// Operators.
+		`filter("") +`,
+		`* filter("")`,
+		`(filter("")`,
+		`filter("") filter("")`,
+		`1 + 2`,
+		`filter("") + "foo"`,
+		// New Funcs.
+		`percentile(filter(""))`,
+		`percentile(filter(""), 101)`,
+		`percentile(2, 50)`,
+		`moving_ave(filter(""), 0)`,
+		`moving_median(filter(""), "3")`,
+		`step(2)`,
+		`scale_by_ave()`,
+		`shift(filter(""), 1.5)`,
 	}
 	for _, tc := range testCases {
 		_, err := ctx.Eval(tc)
@@ -312,3 +328,183 @@ func TestLog(t *testing.T) {
 		}
 	}
 }
+
+func TestOperators(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{1, 2, 3, e},
+		",name=t2,": []float32{4, 0, 6, 8},
+	})
+
+	testCases := []struct {
+		formula string
+		key     string
+		want    []float32
+	}{
+		{`filter("name=t1") + filter("name=t2")`, ``, []float32{5, 2, 9, e}},
+		{`filter("name=t1") / filter("name=t2")`, ``, []float32{0.25, e, 0.5, e}},
+		{`filter("name=t2") - 2 * filter("name=t1")`, ``, []float32{2, -4, 0, e}},
+		{`(filter("name=t2") - 2) * filter("name=t1")`, ``, []float32{2, -4, 12, e}},
+		{`-filter("name=t1") + 1`, `,name=t1,`, []float32{0, -1, -2, e}},
+		{`filter("name=t2") / -2`, `,name=t2,`, []float32{-2, 0, -3, -4}},
+		{`2*(3-1)*filter("name=t1")`, `,name=t1,`, []float32{4, 8, 12, e}},
+		{`1 / filter("name=t2")`, `,name=t2,`, []float32{0.25, e, 1.0 / 6, 0.125}},
+	}
+	for _, tc := range testCases {
+		rows, err := ctx.Eval(tc.formula)
+		if err != nil {
+			t.Fatalf("Failed to eval %q: %s", tc.formula, err)
+		}
+		key := tc.key
+		if key == "" {
+			key = tc.formula
+		}
+		assert.Equal(t, 1, len(rows), tc.formula)
+		for i, want := range tc.want {
+			if got := rows[key][i]; !near(got, want) {
+				t.Errorf("%q mismatch at %d: Got %v Want %v Full %#v", tc.formula, i, got, want, rows)
+			}
+		}
+	}
+}
+
+func TestOperatorsManyRows(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{1, 2},
+		",name=t2,": []float32{4, 8},
+		",name=t3,": []float32{1, 2, 3},
+	})
+
+	// A single row is applied to each row.
+	rows, err := ctx.Eval(`filter("name=t1&name=t2") / filter("name=t1")`)
+	assert.NoError(t, err)
+	assert.Equal(t, Rows{
+		",name=t1,": []float32{1, 1},
+		",name=t2,": []float32{4, 4},
+	}, rows)
+
+	// Rows are matched by key.
+	rows, err = ctx.Eval(`filter("name=t1&name=t2") + filter("name=t2&name=t3")`)
+	assert.NoError(t, err)
+	assert.Equal(t, Rows{",name=t2,": []float32{8, 16}}, rows)
+	rows, err = ctx.Eval(`trace_ave(filter("")) - filter("")`)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rows))
+
+	// Nothing to operate on.
+	rows, err = ctx.Eval(`filter("name=t9") * filter("name=t1")`)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rows))
+
+	// Rows must be the same length.
+	_, err = ctx.Eval(`filter("name=t1") + filter("name=t3")`)
+	assert.Error(t, err)
+}
+
+func TestPercentile(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{1, 10, e, e},
+		",name=t2,": []float32{2, 20, 5, e},
+		",name=t3,": []float32{3, 30, e, e},
+		",name=t4,": []float32{4, 40, e, e},
+		",name=t5,": []float32{5, 50, e, e},
+	})
+	testCases := []struct {
+		formula string
+		want    []float32
+	}{
+		{`percentile(filter(""), 50)`, []float32{3, 30, 5, e}},
+		{`percentile(filter(""), 0)`, []float32{1, 10, 5, e}},
+		{`percentile(filter(""), 100)`, []float32{5, 50, 5, e}},
+		{`percentile(filter(""), 90)`, []float32{4.6, 46, 5, e}},
+	}
+	for _, tc := range testCases {
+		rows, err := ctx.Eval(tc.formula)
+		if err != nil {
+			t.Fatalf("Failed to eval %q: %s", tc.formula, err)
+		}
+		for i, want := range tc.want {
+			if got := rows[tc.formula][i]; !near(got, want) {
+				t.Errorf("%q mismatch at %d: Got %v Want %v", tc.formula, i, got, want)
+			}
+		}
+	}
+}
+
+func TestMovingAveAndMedian(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{e, 1, 2, 9, e, e, e, 3},
+	})
+	rows, err := ctx.Eval(`moving_ave(filter(""), 3)`)
+	assert.NoError(t, err)
+	for i, want := range []float32{e, 1, 1.5, 4, 5.5, 9, e, 3} {
+		if got := rows["moving_ave(,name=t1,)"][i]; !near(got, want) {
+			t.Errorf("moving_ave() mismatch at %d: Got %v Want %v", i, got, want)
+		}
+	}
+
+	rows, err = ctx.Eval(`moving_median(filter(""), 3)`)
+	assert.NoError(t, err)
+	for i, want := range []float32{e, 1, 1.5, 2, 5.5, 9, e, 3} {
+		if got := rows["moving_median(,name=t1,)"][i]; !near(got, want) {
+			t.Errorf("moving_median() mismatch at %d: Got %v Want %v", i, got, want)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{1, 1.1, e, 0.9, 5, 5.2, 4.8},
+		",name=t2,": []float32{e, 3, e},
+		",name=t3,": []float32{e, e},
+	})
+	rows, err := ctx.Eval(`step(filter(""))`)
+	assert.NoError(t, err)
+	for i, want := range []float32{1, 1, 1, 1, 5, 5, 5} {
+		if got := rows["step(,name=t1,)"][i]; !near(got, want) {
+			t.Errorf("step() mismatch at %d: Got %v Want %v", i, got, want)
+		}
+	}
+	assert.Equal(t, []float32{3, 3, 3}, rows["step(,name=t2,)"])
+	assert.Equal(t, []float32{e, e}, rows["step(,name=t3,)"])
+}
+
+func TestScaleByAve(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{1, 3, e, 4},
+		",name=t2,": []float32{1, -1, e},
+	})
+	rows, err := ctx.Eval(`scale_by_ave(filter(""))`)
+	assert.NoError(t, err)
+	for i, want := range []float32{0.375, 1.125, e, 1.5} {
+		if got := rows["scale_by_ave(,name=t1,)"][i]; !near(got, want) {
+			t.Errorf("scale_by_ave() mismatch at %d: Got %v Want %v", i, got, want)
+		}
+	}
+	assert.Equal(t, []float32{e, e, e}, rows["scale_by_ave(,name=t2,)"])
+}
+
+func TestShift(t *testing.T) {
+	testutils.SmallTest(t)
+	ctx := newTestContext(Rows{
+		",name=t1,": []float32{1, 2, 3, 4},
+	})
+	rows, err := ctx.Eval(`shift(filter(""), 1)`)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{e, 1, 2, 3}, rows["shift(,name=t1,)"])
+
+	rows, err = ctx.Eval(`shift(filter(""), -2)`)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{3, 4, e, e}, rows["shift(,name=t1,)"])
+
+	// Compare each point to the previous one.
+	formula := `filter("") - shift(filter(""), 1)`
+	rows, err = ctx.Eval(formula)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{e, 1, 1, 1}, rows[formula])
+}
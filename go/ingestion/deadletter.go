@@ -0,0 +1,289 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+// BoltDB bucket where the files that failed to be processed are stored,
+// keyed by the name of the file.
+const FAILED_FILES_BUCKET = "failed_files"
+
+// FailedFile is an entry in the dead-letter store of an Ingester, i.e. a
+// result file whose processing failed.
+type FailedFile struct {
+	Name      string `json:"name"`      // See ResultFileLocation.Name().
+	MD5       string `json:"md5"`       // See ResultFileLocation.MD5().
+	TimeStamp int64  `json:"timestamp"` // See ResultFileLocation.TimeStamp().
+	Failed    int64  `json:"failed"`    // When processing last failed, in seconds since the epoch.
+	Attempts  int    `json:"attempts"`  // The number of times processing failed.
+	Error     string `json:"error"`     // The error returned by the last attempt.
+}
+
+// failedFileSlice is a utility type for sorting FailedFiles by the time they
+// failed.
+type failedFileSlice []*FailedFile
+
+func (p failedFileSlice) Len() int { return len(p) }
+func (p failedFileSlice) Less(i, j int) bool {
+	if p[i].Failed == p[j].Failed {
+		return p[i].Name < p[j].Name
+	}
+	return p[i].Failed < p[j].Failed
+}
+func (p failedFileSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// addToFailedFiles records the given failures in the dead-letter store. The
+// number of attempts is carried over from an existing entry for the same file.
+func (i *Ingester) addToFailedFiles(failed []*FailedFile) {
+	if len(failed) == 0 {
+		return
+	}
+	updateFn := func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(FAILED_FILES_BUCKET))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range failed {
+			if b := bucket.Get([]byte(f.Name)); b != nil {
+				old := &FailedFile{}
+				if err := json.Unmarshal(b, old); err == nil {
+					f.Attempts += old.Attempts
+				}
+			}
+			b, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(f.Name), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := i.statusDB.Update(updateFn); err != nil {
+		sklog.Errorf("Error writing to bucket %s: %s", FAILED_FILES_BUCKET, err)
+	}
+}
+
+// removeFromFailedFiles removes the files with the given names from the
+// dead-letter store.
+func (i *Ingester) removeFromFailedFiles(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	updateFn := func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(FAILED_FILES_BUCKET))
+		if bucket == nil {
+			return nil
+		}
+
+		for _, name := range names {
+			if err := bucket.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := i.statusDB.Update(updateFn); err != nil {
+		sklog.Errorf("Error deleting from bucket %s: %s", FAILED_FILES_BUCKET, err)
+	}
+}
+
+// FailedFiles returns the contents of the dead-letter store, i.e. all the
+// files whose processing failed and that haven't been processed successfully
+// since, ordered by the time they failed.
+func (i *Ingester) FailedFiles() ([]*FailedFile, error) {
+	ret := []*FailedFile{}
+	viewFn := func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(FAILED_FILES_BUCKET))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			f := &FailedFile{}
+			if err := json.Unmarshal(v, f); err != nil {
+				return fmt.Errorf("Invalid entry for %s: %s", string(k), err)
+			}
+			ret = append(ret, f)
+			return nil
+		})
+	}
+
+	if err := i.statusDB.View(viewFn); err != nil {
+		return nil, fmt.Errorf("Error reading from bucket %s: %s", FAILED_FILES_BUCKET, err)
+	}
+	sort.Sort(failedFileSlice(ret))
+	return ret, nil
+}
+
+// Reprocess re-ingests files from the dead-letter store, e.g. after a bug in
+// the Processor has been fixed. If names is not empty then the files with the
+// given names are re-ingested, otherwise all the files that failed in the time
+// range [begin, end), in seconds since the epoch, are re-ingested.
+//
+// The files are retrieved again by polling the sources of the Ingester over
+// the time range of the files. Returns the number of files that were
+// re-ingested successfully. Files that fail again stay in the dead-letter
+// store with the new error.
+func (i *Ingester) Reprocess(names []string, begin, end int64) (int, error) {
+	failed, err := i.FailedFiles()
+	if err != nil {
+		return 0, err
+	}
+	selected := map[string]*FailedFile{}
+	nameSet := util.NewStringSet(names)
+	for _, f := range failed {
+		if len(names) > 0 {
+			if nameSet[f.Name] {
+				selected[f.Name] = f
+			}
+		} else if f.Failed >= begin && f.Failed < end {
+			selected[f.Name] = f
+		}
+	}
+	if len(selected) == 0 {
+		return 0, nil
+	}
+
+	// Find the time range that covers all the selected files.
+	var minTS, maxTS int64 = -1, -1
+	for _, f := range selected {
+		if minTS == -1 || f.TimeStamp < minTS {
+			minTS = f.TimeStamp
+		}
+		if f.TimeStamp > maxTS {
+			maxTS = f.TimeStamp
+		}
+	}
+
+	resultFiles := []ResultFileLocation{}
+	for _, source := range i.sources {
+		// Poll only returns files that were updated after the start time.
+		polled, err := source.Poll(minTS-1, maxTS)
+		if err != nil {
+			return 0, fmt.Errorf("Error polling data source '%s': %s", source.ID(), err)
+		}
+		for _, rf := range polled {
+			if _, ok := selected[rf.Name()]; ok {
+				resultFiles = append(resultFiles, rf)
+				delete(selected, rf.Name())
+			}
+		}
+	}
+	for name, _ := range selected {
+		sklog.Warningf("Unable to find failed file %s in any source.", name)
+	}
+	sklog.Infof("Reprocessing %d failed files.", len(resultFiles))
+	i.processResults(resultFiles, i.reprocessMetrics)
+
+	// Count the files that are no longer in the dead-letter store.
+	remaining, err := i.FailedFiles()
+	if err != nil {
+		return 0, err
+	}
+	stillFailed := util.StringSet{}
+	for _, f := range remaining {
+		stillFailed[f.Name] = true
+	}
+	ret := 0
+	for _, rf := range resultFiles {
+		if !stillFailed[rf.Name()] {
+			ret++
+		}
+	}
+	return ret, nil
+}
+
+// findIngester returns the ingester with the given id, or nil if there is no
+// such ingester.
+func findIngester(ingesters []*Ingester, id string) *Ingester {
+	for _, i := range ingesters {
+		if i.id == id {
+			return i
+		}
+	}
+	return nil
+}
+
+// FailedFilesHandler returns an http.HandlerFunc that serves the dead-letter
+// stores of the given ingesters as JSON, a map from ingester id to a list of
+// FailedFile. The 'id' query parameter restricts the response to a single
+// ingester.
+func FailedFilesHandler(ingesters []*Ingester) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id := r.FormValue("id")
+		resp := map[string][]*FailedFile{}
+		for _, i := range ingesters {
+			if id != "" && i.id != id {
+				continue
+			}
+			failed, err := i.FailedFiles()
+			if err != nil {
+				httputils.ReportError(w, r, err, "Failed to load failed files.")
+				return
+			}
+			resp[i.id] = failed
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			sklog.Errorf("Failed to write or encode output: %s", err)
+		}
+	}
+}
+
+// ReprocessRequest is the JSON body accepted by ReprocessHandler. See
+// Ingester.Reprocess.
+type ReprocessRequest struct {
+	ID    string   `json:"id"`    // The id of the ingester.
+	Names []string `json:"names"` // The names of the files to re-ingest.
+	Begin int64    `json:"begin"` // Re-ingest all files that failed in [Begin, End) if Names is empty.
+	End   int64    `json:"end"`
+}
+
+// ReprocessResponse is the JSON response of ReprocessHandler.
+type ReprocessResponse struct {
+	Reprocessed int `json:"reprocessed"` // The number of files re-ingested successfully.
+}
+
+// ReprocessHandler returns an http.HandlerFunc that re-ingests files from the
+// dead-letter store of one of the given ingesters, as described by a
+// ReprocessRequest.
+func ReprocessHandler(ingesters []*Ingester) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		req := ReprocessRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputils.ReportError(w, r, err, "Failed to decode JSON.")
+			return
+		}
+		ingester := findIngester(ingesters, req.ID)
+		if ingester == nil {
+			httputils.ReportError(w, r, fmt.Errorf("Unknown ingester: %q", req.ID), "Unknown ingester.")
+			return
+		}
+		if len(req.Names) == 0 && req.End == 0 {
+			req.End = time.Now().Unix() + 1
+		}
+		n, err := ingester.Reprocess(req.Names, req.Begin, req.End)
+		if err != nil {
+			httputils.ReportError(w, r, err, "Failed to reprocess files.")
+			return
+		}
+		if err := json.NewEncoder(w).Encode(ReprocessResponse{Reprocessed: n}); err != nil {
+			sklog.Errorf("Failed to write or encode output: %s", err)
+		}
+	}
+}
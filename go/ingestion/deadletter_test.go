@@ -0,0 +1,141 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/sharedconfig"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+)
+
+const LOCAL_DEAD_LETTER_DIR = "./ingestion_deadletter"
+
+func TestDeadLetter(t *testing.T) {
+	testutils.MediumTest(t)
+	defer util.RemoveAll(LOCAL_DEAD_LETTER_DIR)
+	rootDir := filepath.Join(LOCAL_DEAD_LETTER_DIR, "data")
+	statusDir := filepath.Join(LOCAL_DEAD_LETTER_DIR, "status")
+
+	// Write the result files into the directory of the current hour.
+	now := time.Now()
+	dataDir := filepath.Join(rootDir, now.UTC().Format("2006/01/02/15"))
+	assert.NoError(t, os.MkdirAll(dataDir, 0755))
+	for _, name := range []string{"good.json", "bad-1.json", "bad-2.json"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644))
+	}
+	source, err := NewFileSystemSource("test-fs-source", rootDir)
+	assert.NoError(t, err)
+
+	// The processor fails on the bad files until it's fixed.
+	var mutex sync.Mutex
+	fixed := false
+	processFn := func(result ResultFileLocation) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !fixed && strings.Contains(string(result.Content()), "bad") {
+			return fmt.Errorf("Processor bug.")
+		}
+		return nil
+	}
+	processor := MockProcessor(processFn, func() error { return nil })
+
+	conf := &sharedconfig.IngesterConfig{
+		RunEvery:  sharedconfig.TomlDuration{Duration: 1 * time.Second},
+		NCommits:  10,
+		MinDays:   3,
+		StatusDir: statusDir,
+	}
+	vcs := getVCS(now.Add(-time.Hour*24).Unix(), now.Unix(), 10)
+	ingester, err := NewIngester("dead-letter-ingester", conf, vcs, []Source{source}, processor)
+	assert.NoError(t, err)
+
+	resultFiles, err := source.Poll(now.Add(-time.Hour).Unix(), now.Unix())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(resultFiles))
+	ingester.processResults(resultFiles, ingester.pollProcessMetrics)
+
+	failed, err := ingester.FailedFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(failed))
+	names := []string{}
+	for _, f := range failed {
+		assert.Equal(t, "Processor bug.", f.Error)
+		assert.Equal(t, 1, f.Attempts)
+		assert.NotEqual(t, "", f.MD5)
+		names = append(names, f.Name)
+	}
+	assert.True(t, util.In(filepath.Join(now.UTC().Format("2006/01/02/15"), "bad-1.json"), names))
+
+	// Reprocessing before the bug is fixed fails again.
+	n, err := ingester.Reprocess(names[:1], 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	failed, err = ingester.FailedFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(failed))
+	for _, f := range failed {
+		if f.Name == names[0] {
+			assert.Equal(t, 2, f.Attempts)
+		} else {
+			assert.Equal(t, 1, f.Attempts)
+		}
+	}
+
+	// Nothing failed in this time range.
+	n, err = ingester.Reprocess(nil, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// List the failed files via HTTP.
+	ingesters := []*Ingester{ingester}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/failed?id=dead-letter-ingester", nil)
+	FailedFilesHandler(ingesters)(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	listed := map[string][]*FailedFile{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	assert.Equal(t, 2, len(listed["dead-letter-ingester"]))
+
+	// Fix the bug and reprocess everything via HTTP.
+	mutex.Lock()
+	fixed = true
+	mutex.Unlock()
+
+	body, err := json.Marshal(ReprocessRequest{ID: "unknown-ingester"})
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/reprocess", bytes.NewReader(body))
+	ReprocessHandler(ingesters)(w, r)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	body, err = json.Marshal(ReprocessRequest{ID: "dead-letter-ingester"})
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/reprocess", bytes.NewReader(body))
+	ReprocessHandler(ingesters)(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := ReprocessResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, 2, resp.Reprocessed)
+
+	failed, err = ingester.FailedFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(failed))
+
+	// All the files are now processed and are ignored when polled again.
+	for _, rf := range resultFiles {
+		assert.True(t, ingester.inProcessedFiles(rf.MD5()))
+	}
+}
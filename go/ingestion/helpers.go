@@ -108,15 +108,22 @@ func IngestersFromConfig(config *sharedconfig.Config, client *http.Client, evt *
 }
 
 // getSource returns an instance of source that is either getting data from
-// Google storage or the local fileystem.
+// Google storage or the local fileystem. If the data source is watched then
+// the source also delivers new files via its EventChan.
 func getSource(id string, dataSource *sharedconfig.DataSource, client *http.Client, evt *eventbus.EventBus) (Source, error) {
 	if dataSource.Dir == "" {
 		return nil, fmt.Errorf("Datasource for %s is missing a directory.", id)
 	}
 
 	if dataSource.Bucket != "" {
+		if dataSource.Watch {
+			return NewGoogleStoragePushSource(id, dataSource.Bucket, dataSource.Dir, client, evt)
+		}
 		return NewGoogleStorageSource(id, dataSource.Bucket, dataSource.Dir, client, evt)
 	}
+	if dataSource.Watch {
+		return NewFileSystemWatchSource(id, dataSource.Dir)
+	}
 	return NewFileSystemSource(id, dataSource.Dir)
 }
 
@@ -139,6 +146,10 @@ type GoogleStorageSource struct {
 // on the bucket and directory provided. The id is used to identify the Source
 // and is generally the same id as the ingester.
 func NewGoogleStorageSource(baseName, bucket, rootDir string, client *http.Client, evt *eventbus.EventBus) (Source, error) {
+	return newGoogleStorageSource(baseName, bucket, rootDir, client, evt)
+}
+
+func newGoogleStorageSource(baseName, bucket, rootDir string, client *http.Client, evt *eventbus.EventBus) (*GoogleStorageSource, error) {
 	storageClient, err := storage.NewClient(context.Background(), option.WithHTTPClient(client))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create a Google Storage API client: %s", err)
@@ -270,10 +281,14 @@ type FileSystemSource struct {
 }
 
 func NewFileSystemSource(baseName, rootDir string) (Source, error) {
+	return newFileSystemSource(baseName, rootDir), nil
+}
+
+func newFileSystemSource(baseName, rootDir string) *FileSystemSource {
 	return &FileSystemSource{
 		rootDir: rootDir,
 		id:      fmt.Sprintf("%s:fs:%s", baseName, rootDir),
-	}, nil
+	}
 }
 
 // See Source interface.
@@ -45,6 +45,10 @@ type Source interface {
 	// timestamps in milliseconds.
 	Poll(startTime, endTime int64) ([]ResultFileLocation, error)
 
+	// EventChan returns a channel that delivers result files as soon as they
+	// become available, or nil if the source only supports polling.
+	EventChan() <-chan []ResultFileLocation
+
 	// ID returns a unique identifier for this source.
 	ID() string
 }
@@ -106,6 +110,13 @@ type Ingester struct {
 	// eventProcessMetrics capture metrics from processing result files delivered by events from sources.
 	eventProcessMetrics *processMetrics
 
+	// reprocessMetrics capture metrics from reprocessing files from the dead-letter store.
+	reprocessMetrics *processMetrics
+
+	// processMutex serializes calls to processResults, since the processor
+	// handles one batch at a time.
+	processMutex sync.Mutex
+
 	// processTimer measure the overall time it takes to process a set of files.
 	processTimer metrics2.Timer
 
@@ -144,6 +155,7 @@ func NewIngester(ingesterID string, ingesterConf *sharedconfig.IngesterConfig, v
 func (i *Ingester) setupMetrics() {
 	i.pollProcessMetrics = newProcessMetrics(i.id, "poll")
 	i.eventProcessMetrics = newProcessMetrics(i.id, "event")
+	i.reprocessMetrics = newProcessMetrics(i.id, "reprocess")
 	i.srcMetrics = newSourceMetrics(i.id, i.sources)
 	i.processTimer = metrics2.NewTimer("ingestion.process", map[string]string{"id": i.id})
 }
@@ -225,6 +237,25 @@ func (i *Ingester) getInputChannels() (<-chan []ResultFileLocation, <-chan []Res
 				srcMetrics.pollTimer.Stop()
 			})
 		}(source, i.srcMetrics[idx], i.doneCh)
+
+		// Forward the result files of event driven sources.
+		if ch := source.EventChan(); ch != nil {
+			go func(source Source, ch <-chan []ResultFileLocation, doneCh <-chan bool) {
+				for {
+					select {
+					case resultFiles := <-ch:
+						sklog.Infof("Sending eventChan from %s for %d files.", source.ID(), len(resultFiles))
+						select {
+						case eventChan <- resultFiles:
+						case <-doneCh:
+							return
+						}
+					case <-doneCh:
+						return
+					}
+				}
+			}(source, ch, i.doneCh)
+		}
 	}
 	return pollChan, eventChan
 }
@@ -271,11 +302,16 @@ func (i *Ingester) addToProcessedFiles(md5s []string) {
 	}
 }
 
-// processResults ingests a set of result files.
+// processResults ingests a set of result files. Files that fail to be
+// processed are added to the dead-letter store, see FailedFiles.
 func (i *Ingester) processResults(resultFiles []ResultFileLocation, targetMetrics *processMetrics) {
+	i.processMutex.Lock()
+	defer i.processMutex.Unlock()
 
 	var mutex sync.Mutex // Protects access to the following vars.
 	processedMD5s := make([]string, 0, len(resultFiles))
+	processedNames := make([]string, 0, len(resultFiles))
+	failed := []*FailedFile{}
 	var processedCounter int64 = 0
 	var ignoredCounter int64 = 0
 	var errorCounter int64 = 0
@@ -305,6 +341,14 @@ func (i *Ingester) processResults(resultFiles []ResultFileLocation, targetMetric
 				} else {
 					errorCounter++
 					sklog.Errorf("Failed to ingest %s: %s", resultLocation.Name(), err)
+					failed = append(failed, &FailedFile{
+						Name:      resultLocation.Name(),
+						MD5:       resultLocation.MD5(),
+						TimeStamp: resultLocation.TimeStamp(),
+						Failed:    time.Now().Unix(),
+						Attempts:  1,
+						Error:     err.Error(),
+					})
 				}
 				return
 			}
@@ -317,6 +361,7 @@ func (i *Ingester) processResults(resultFiles []ResultFileLocation, targetMetric
 			// Gather all successfully processed MD5s
 			processedCounter++
 			processedMD5s = append(processedMD5s, resultLocation.MD5())
+			processedNames = append(processedNames, resultLocation.Name())
 		}(resultLocation)
 	}
 	wg.Wait()
@@ -329,6 +374,7 @@ func (i *Ingester) processResults(resultFiles []ResultFileLocation, targetMetric
 	targetMetrics.processedGauge.Update(processedCounter + targetMetrics.processedGauge.Get())
 	targetMetrics.ignoredGauge.Update(ignoredCounter + targetMetrics.ignoredGauge.Get())
 	targetMetrics.errorGauge.Update(errorCounter + targetMetrics.errorGauge.Get())
+	i.addToFailedFiles(failed)
 
 	// Notify the ingester that the batch has finished and cause it to reset its
 	// state and do any pending ingestion.
@@ -336,6 +382,7 @@ func (i *Ingester) processResults(resultFiles []ResultFileLocation, targetMetric
 		sklog.Errorf("Batchfinished failed: %s", err)
 	} else {
 		i.addToProcessedFiles(processedMD5s)
+		i.removeFromFailedFiles(processedNames)
 	}
 }
 
@@ -171,6 +171,10 @@ func (m *mockSource) Poll(startTime, endTime int64) ([]ResultFileLocation, error
 	return m.data[startIdx:endIdx], nil
 }
 
+func (m *mockSource) EventChan() <-chan []ResultFileLocation {
+	return nil
+}
+
 func (m mockSource) ID() string {
 	return "test-source"
 }
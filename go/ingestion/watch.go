@@ -0,0 +1,367 @@
+package ingestion
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsnotify/fsnotify"
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/fileutil"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// EVENT_BATCH_INTERVAL is how often watched sources send the new result
+	// files they have collected to their EventChan.
+	EVENT_BATCH_INTERVAL = time.Second
+
+	// MAX_PENDING_EVENTS is the maximum number of result files a push source
+	// buffers before it rejects notifications, which are then redelivered.
+	MAX_PENDING_EVENTS = 10 * POLL_CHUNK_SIZE
+
+	// WATCH_SETTLE_TIME is how long a file on the local file system has to
+	// remain unchanged before it is ingested, so that partially written files
+	// aren't ingested.
+	WATCH_SETTLE_TIME = 2 * time.Second
+
+	// OBJECT_FINALIZE is the Cloud Pub/Sub event type of a new or overwritten
+	// object in Google storage.
+	OBJECT_FINALIZE = "OBJECT_FINALIZE"
+
+	// RESOURCE_STATE_EXISTS is the state of a new or overwritten object in an
+	// object change notification.
+	RESOURCE_STATE_EXISTS = "exists"
+
+	// PUSH_TOKEN_PARAM is the query parameter which contains the secret token
+	// in Cloud Pub/Sub push requests.
+	PUSH_TOKEN_PARAM = "token"
+
+	// CHANNEL_TOKEN_HEADER is the header which contains the secret token in
+	// object change notifications.
+	CHANNEL_TOKEN_HEADER = "X-Goog-Channel-Token"
+)
+
+// objectResource contains the fields of a Google storage object resource that
+// identify the object. Everything else is read from Google storage, since the
+// notification can't be trusted beyond that.
+type objectResource struct {
+	Bucket string `json:"bucket"`
+	Name   string `json:"name"`
+}
+
+// pushRequest is the body of a push request from Cloud Pub/Sub.
+type pushRequest struct {
+	Message struct {
+		Attributes map[string]string `json:"attributes"`
+		Data       []byte            `json:"data"` // The JSON encoded objectResource.
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// parseNotification parses a notification about a change of an object in
+// Google storage. It accepts both the push requests of Cloud Pub/Sub and
+// object change notifications, which are identified by the
+// X-Goog-Resource-State header. Returns nil if the notification isn't about
+// a new or overwritten object.
+func parseNotification(r *http.Request) (*objectResource, error) {
+	obj := &objectResource{}
+	if state := r.Header.Get("X-Goog-Resource-State"); state != "" {
+		if state != RESOURCE_STATE_EXISTS {
+			return nil, nil
+		}
+		if err := json.NewDecoder(r.Body).Decode(obj); err != nil {
+			return nil, fmt.Errorf("Invalid object change notification: %s", err)
+		}
+		return obj, nil
+	}
+
+	req := &pushRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, fmt.Errorf("Invalid push request: %s", err)
+	}
+	if req.Message.Attributes["eventType"] != OBJECT_FINALIZE {
+		return nil, nil
+	}
+	if err := json.Unmarshal(req.Message.Data, obj); err != nil {
+		return nil, fmt.Errorf("Invalid object in message %s: %s", req.Message.MessageID, err)
+	}
+	return obj, nil
+}
+
+// validToken returns true iff the request carries the given secret token,
+// either in the query parameter used by Cloud Pub/Sub push subscriptions or in
+// the header used by object change notifications. An empty token is never
+// valid.
+func validToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	got := r.URL.Query().Get(PUSH_TOKEN_PARAM)
+	if r.Header.Get("X-Goog-Resource-State") != "" {
+		got = r.Header.Get(CHANNEL_TOKEN_HEADER)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// eventBatcher collects result files and periodically sends them to its
+// EventChan in chunks of at most POLL_CHUNK_SIZE. Sending happens in its own
+// goroutine, so adding result files never blocks on the consumer.
+type eventBatcher struct {
+	eventCh chan []ResultFileLocation
+
+	mutex   sync.Mutex
+	pending []ResultFileLocation
+}
+
+// newEventBatcher returns a new eventBatcher and starts sending its events.
+func newEventBatcher() *eventBatcher {
+	ret := &eventBatcher{
+		eventCh: make(chan []ResultFileLocation),
+		pending: []ResultFileLocation{},
+	}
+	go ret.sendEvents()
+	return ret
+}
+
+// add adds the given result files to the pending result files. If max is
+// positive and there are already max or more pending result files, the result
+// files aren't added and false is returned.
+func (b *eventBatcher) add(max int, resultFiles ...ResultFileLocation) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if max > 0 && len(b.pending) >= max {
+		return false
+	}
+	b.pending = append(b.pending, resultFiles...)
+	return true
+}
+
+// sendEvents periodically sends the pending result files to the EventChan.
+func (b *eventBatcher) sendEvents() {
+	for range time.Tick(EVENT_BATCH_INTERVAL) {
+		b.mutex.Lock()
+		resultFiles := b.pending
+		b.pending = []ResultFileLocation{}
+		b.mutex.Unlock()
+
+		for len(resultFiles) > 0 {
+			chunkSize := util.MinInt(POLL_CHUNK_SIZE, len(resultFiles))
+			b.eventCh <- resultFiles[:chunkSize]
+			resultFiles = resultFiles[chunkSize:]
+		}
+	}
+}
+
+// GoogleStoragePushSource implements the Source interface for Google storage.
+// In addition to polling, it delivers result files via its EventChan as soon
+// as it is notified about them by PushHandler.
+type GoogleStoragePushSource struct {
+	*GoogleStorageSource
+	events *eventBatcher
+
+	// attrs returns the attributes of the given object in Google storage.
+	attrs func(bucket, name string) (*storage.ObjectAttrs, error)
+}
+
+// NewGoogleStoragePushSource returns a new instance of
+// GoogleStoragePushSource, see NewGoogleStorageSource. Notifications for the
+// bucket have to be pushed to a PushHandler.
+func NewGoogleStoragePushSource(baseName, bucket, rootDir string, client *http.Client, evt *eventbus.EventBus) (Source, error) {
+	gsSource, err := newGoogleStorageSource(baseName, bucket, rootDir, client, evt)
+	if err != nil {
+		return nil, err
+	}
+	return newGoogleStoragePushSource(gsSource), nil
+}
+
+func newGoogleStoragePushSource(gsSource *GoogleStorageSource) *GoogleStoragePushSource {
+	ret := &GoogleStoragePushSource{
+		GoogleStorageSource: gsSource,
+		events:              newEventBatcher(),
+	}
+	ret.attrs = func(bucket, name string) (*storage.ObjectAttrs, error) {
+		return ret.storageClient.Bucket(bucket).Object(name).Attrs(context.Background())
+	}
+	return ret
+}
+
+// add adds the given object to the result files that are sent to the
+// EventChan, unless it isn't a result file of this source. The attributes of
+// the object, eg. its MD5 hash, are read from Google storage.
+func (g *GoogleStoragePushSource) add(obj *objectResource) error {
+	if obj.Bucket != g.bucket || !strings.HasPrefix(obj.Name, g.rootDir+"/") || !validIngestionFile(obj.Name) {
+		return nil
+	}
+	if strings.Contains(filepath.Base(obj.Name), "uploading") {
+		sklog.Warningf("Received notification for temporary file from GS: %s", obj.Name)
+		return nil
+	}
+	attrs, err := g.attrs(obj.Bucket, obj.Name)
+	if err == storage.ErrObjectNotExist {
+		sklog.Warningf("Received notification for missing file from GS: %s", obj.Name)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to retrieve attributes of %s/%s: %s", obj.Bucket, obj.Name, err)
+	}
+	if !g.events.add(MAX_PENDING_EVENTS, newGCSResultFileLocation(attrs, g.rootDir, g.storageClient)) {
+		return fmt.Errorf("Too many pending result files in %s.", g.id)
+	}
+	return nil
+}
+
+// See Source interface.
+func (g *GoogleStoragePushSource) EventChan() <-chan []ResultFileLocation {
+	return g.events.eventCh
+}
+
+// PushHandler returns an http.HandlerFunc that receives notifications about
+// new objects in Google storage, see parseNotification, and forwards them to
+// the GoogleStoragePushSources of the given ingesters. A notification is
+// acknowledged with a 200 response once it has been accepted by all sources,
+// otherwise it is redelivered later.
+//
+// Notifications have to carry the given secret token, see validToken, so that
+// the push endpoint of the subscription or notification channel has to be
+// configured as eg. https://<host>/push?token=<token>. Notifications without
+// the token are rejected, as are all notifications if the token is empty.
+func PushHandler(ingesters []*Ingester, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(r, token) {
+			http.Error(w, "Invalid push token.", http.StatusForbidden)
+			return
+		}
+		obj, err := parseNotification(r)
+		if err != nil {
+			httputils.ReportError(w, r, err, "Failed to parse notification.")
+			return
+		}
+		if obj == nil {
+			return
+		}
+		for _, i := range ingesters {
+			for _, source := range i.sources {
+				if pushSource, ok := source.(*GoogleStoragePushSource); ok {
+					if err := pushSource.add(obj); err != nil {
+						httputils.ReportError(w, r, err, "Failed to add result file.")
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// FileSystemWatchSource implements the Source interface to read from the local
+// file system. In addition to polling, it watches the root directory and all
+// its subdirectories with inotify and delivers new or changed result files via
+// its EventChan.
+type FileSystemWatchSource struct {
+	*FileSystemSource
+	watcher *fsnotify.Watcher
+	events  *eventBatcher
+}
+
+// NewFileSystemWatchSource returns a new instance of FileSystemWatchSource,
+// see NewFileSystemSource. The root directory is created if it doesn't exist.
+func NewFileSystemWatchSource(baseName, rootDir string) (Source, error) {
+	if _, err := fileutil.EnsureDirExists(rootDir); err != nil {
+		return nil, fmt.Errorf("Unable to create %s: %s", rootDir, err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create file system watcher: %s", err)
+	}
+	ret := &FileSystemWatchSource{
+		FileSystemSource: newFileSystemSource(baseName, rootDir),
+		watcher:          watcher,
+		events:           newEventBatcher(),
+	}
+	if err := ret.watchTree(rootDir, nil); err != nil {
+		return nil, err
+	}
+	go ret.watch()
+	return ret, nil
+}
+
+// watchTree adds the directory dir and all its subdirectories to the watcher.
+// If pending is not nil, the result files found in the directories are added
+// to it, since they might have been written before the directories were
+// watched.
+func (f *FileSystemWatchSource) watchTree(dir string, pending map[string]time.Time) error {
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			sklog.Errorf("Error walking %s: %s", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			if err := f.watcher.Add(path); err != nil {
+				return fmt.Errorf("Unable to watch %s: %s", path, err)
+			}
+		} else if pending != nil && validIngestionFile(path) {
+			pending[path] = time.Now()
+		}
+		return nil
+	}
+	return filepath.Walk(dir, walkFn)
+}
+
+// watch processes the events of the watcher and periodically hands the result
+// files that have settled to the eventBatcher, which sends them to the
+// EventChan without blocking the processing of events.
+func (f *FileSystemWatchSource) watch() {
+	// Maps the paths of changed result files to the time of the last change.
+	pending := map[string]time.Time{}
+	ticker := time.NewTicker(EVENT_BATCH_INTERVAL)
+	for {
+		select {
+		case event := <-f.watcher.Events:
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := f.watchTree(event.Name, pending); err != nil {
+						sklog.Errorf("Unable to watch new directory: %s", err)
+					}
+					continue
+				}
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 && validIngestionFile(event.Name) {
+				pending[event.Name] = time.Now()
+			}
+		case err := <-f.watcher.Errors:
+			sklog.Errorf("Error watching %s: %s", f.rootDir, err)
+		case <-ticker.C:
+			resultFiles := []ResultFileLocation{}
+			for path, lastChange := range pending {
+				if time.Since(lastChange) < WATCH_SETTLE_TIME {
+					continue
+				}
+				delete(pending, path)
+				rf, err := FileSystemResult(path, f.rootDir)
+				if err != nil {
+					sklog.Errorf("Unable to create file system result: %s", err)
+					continue
+				}
+				resultFiles = append(resultFiles, rf)
+			}
+			if len(resultFiles) > 0 {
+				f.events.add(0, resultFiles...)
+			}
+		}
+	}
+}
+
+// See Source interface.
+func (f *FileSystemWatchSource) EventChan() <-chan []ResultFileLocation {
+	return f.events.eventCh
+}
@@ -0,0 +1,165 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+)
+
+const LOCAL_WATCH_DIR = "./ingestion_watch"
+
+// pushBody returns the body of a Cloud Pub/Sub push request for the given
+// event type and object.
+func pushBody(t *testing.T, eventType string, obj *objectResource) []byte {
+	data, err := json.Marshal(obj)
+	assert.NoError(t, err)
+	req := &pushRequest{}
+	req.Message.Attributes = map[string]string{"eventType": eventType}
+	req.Message.Data = data
+	req.Message.MessageID = "1234"
+	body, err := json.Marshal(req)
+	assert.NoError(t, err)
+	return body
+}
+
+// nextEvent returns the next result files sent to ch, or nil if there are
+// none within the given time.
+func nextEvent(ch <-chan []ResultFileLocation, timeout time.Duration) []ResultFileLocation {
+	select {
+	case resultFiles := <-ch:
+		return resultFiles
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestPushSource(t *testing.T) {
+	testutils.SmallTest(t)
+	source := newGoogleStoragePushSource(&GoogleStorageSource{
+		bucket:  "test-bucket",
+		rootDir: "dm-json-v1",
+		id:      "test-push-source",
+	})
+	ingesters := []*Ingester{&Ingester{sources: []Source{source}}}
+	md5 := []byte("0123456789abcdef")
+	updated := time.Unix(1494000000, 0).UTC()
+	// The attributes of the objects are read from Google storage, not taken
+	// from the notifications.
+	source.attrs = func(bucket, name string) (*storage.ObjectAttrs, error) {
+		if name == "dm-json-v1/2017/05/05/16/missing.json" {
+			return nil, storage.ErrObjectNotExist
+		} else if name == "dm-json-v1/2017/05/05/16/error.json" {
+			return nil, fmt.Errorf("Backend error")
+		}
+		return &storage.ObjectAttrs{
+			Bucket:  bucket,
+			Name:    name,
+			MD5:     md5,
+			Updated: updated,
+		}, nil
+	}
+	newObj := func(bucket, name string) *objectResource {
+		return &objectResource{
+			Bucket: bucket,
+			Name:   name,
+		}
+	}
+
+	const token = "s3cr3t"
+	// Pub/Sub push requests carry the token in the URL, object change
+	// notifications in a header.
+	pushWithToken := func(got, header string, body []byte) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/push?token="+got, bytes.NewReader(body))
+		if header != "" {
+			r.Header.Set("X-Goog-Resource-State", header)
+			r.Header.Set(CHANNEL_TOKEN_HEADER, got)
+			r.URL.RawQuery = ""
+		}
+		PushHandler(ingesters, token)(w, r)
+		return w.Code
+	}
+	push := func(header string, body []byte) int {
+		return pushWithToken(token, header, body)
+	}
+
+	// Notifications without the correct token are rejected.
+	valid := pushBody(t, OBJECT_FINALIZE, newObj("test-bucket", "dm-json-v1/2017/05/05/16/a.json"))
+	assert.Equal(t, http.StatusForbidden, pushWithToken("", "", valid))
+	assert.Equal(t, http.StatusForbidden, pushWithToken("wrong", "", valid))
+	assert.Equal(t, http.StatusForbidden, pushWithToken("wrong", RESOURCE_STATE_EXISTS, valid))
+	w := httptest.NewRecorder()
+	PushHandler(ingesters, "")(w, httptest.NewRequest("POST", "/push?token=", bytes.NewReader(valid)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Notifications that aren't about new result files of the source.
+	assert.Equal(t, http.StatusOK, push("", pushBody(t, "OBJECT_DELETE", newObj("test-bucket", "dm-json-v1/2017/05/05/16/a.json"))))
+	assert.Equal(t, http.StatusOK, push("", pushBody(t, OBJECT_FINALIZE, newObj("other-bucket", "dm-json-v1/2017/05/05/16/a.json"))))
+	assert.Equal(t, http.StatusOK, push("", pushBody(t, OBJECT_FINALIZE, newObj("test-bucket", "other-dir/2017/05/05/16/a.json"))))
+	assert.Equal(t, http.StatusOK, push("", pushBody(t, OBJECT_FINALIZE, newObj("test-bucket", "dm-json-v1/2017/05/05/16/a.txt"))))
+	assert.Equal(t, http.StatusOK, push("", pushBody(t, OBJECT_FINALIZE, newObj("test-bucket", "dm-json-v1/2017/05/05/16/missing.json"))))
+	assert.Nil(t, nextEvent(source.EventChan(), 2*EVENT_BATCH_INTERVAL))
+
+	// Invalid notifications are rejected, and so are notifications whose
+	// object can't be looked up, so that they are redelivered.
+	assert.NotEqual(t, http.StatusOK, push("", []byte("{")))
+	assert.NotEqual(t, http.StatusOK, push("", pushBody(t, OBJECT_FINALIZE, newObj("test-bucket", "dm-json-v1/2017/05/05/16/error.json"))))
+
+	// Pub/Sub push requests and object change notifications.
+	assert.Equal(t, http.StatusOK, push("", pushBody(t, OBJECT_FINALIZE, newObj("test-bucket", "dm-json-v1/2017/05/05/16/a.json"))))
+	body, err := json.Marshal(newObj("test-bucket", "dm-json-v1/2017/05/05/16/b.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, push(RESOURCE_STATE_EXISTS, body))
+	assert.Equal(t, http.StatusOK, push("not_exists", body))
+
+	// The notifications might be delivered in separate batches.
+	resultFiles := nextEvent(source.EventChan(), 5*EVENT_BATCH_INTERVAL)
+	if len(resultFiles) == 1 {
+		resultFiles = append(resultFiles, nextEvent(source.EventChan(), 5*EVENT_BATCH_INTERVAL)...)
+	}
+	assert.Equal(t, 2, len(resultFiles))
+	assert.Equal(t, "gs://test-bucket/dm-json-v1/2017/05/05/16/a.json", resultFiles[0].Name())
+	assert.Equal(t, "gs://test-bucket/dm-json-v1/2017/05/05/16/b.json", resultFiles[1].Name())
+	for _, rf := range resultFiles {
+		assert.Equal(t, "30313233343536373839616263646566", rf.MD5())
+		assert.Equal(t, updated.Unix(), rf.TimeStamp())
+	}
+}
+
+func TestFileSystemWatchSource(t *testing.T) {
+	testutils.MediumTest(t)
+	defer util.RemoveAll(LOCAL_WATCH_DIR)
+
+	source, err := NewFileSystemWatchSource("test-watch-source", LOCAL_WATCH_DIR)
+	assert.NoError(t, err)
+
+	// Files in new directories are found, and files that aren't result files
+	// are ignored.
+	dir := filepath.Join(LOCAL_WATCH_DIR, "2017", "05", "05", "16")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("a"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+
+	resultFiles := nextEvent(source.EventChan(), 5*WATCH_SETTLE_TIME)
+	assert.Equal(t, 1, len(resultFiles))
+	assert.Equal(t, "2017/05/05/16/a.json", resultFiles[0].Name())
+	assert.Equal(t, []byte("a"), resultFiles[0].Content())
+
+	// Changed files are delivered again.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("b"), 0644))
+	resultFiles = nextEvent(source.EventChan(), 5*WATCH_SETTLE_TIME)
+	assert.Equal(t, 1, len(resultFiles))
+	assert.Equal(t, []byte("b"), resultFiles[0].Content())
+}
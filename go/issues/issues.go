@@ -23,8 +23,9 @@ type IssueTracker interface {
 	FromQuery(q string) ([]Issue, error)
 	// AddComment adds a comment to the issue with the given id
 	AddComment(id string, comment CommentRequest) error
-	// AddIssue creates an issue with the passed in params.
-	AddIssue(issue IssueRequest) error
+	// AddIssue creates an issue with the passed in params and returns the
+	// created issue.
+	AddIssue(issue IssueRequest) (*Issue, error)
 }
 
 // Issue is an individual issue returned from the project hosting response.
@@ -85,12 +86,17 @@ func (m *MonorailIssueTracker) FromQuery(q string) ([]Issue, error) {
 // AddComment adds a comment to the issue with the given id
 func (m *MonorailIssueTracker) AddComment(id string, comment CommentRequest) error {
 	u := fmt.Sprintf("%s/%s/comments", MONORAIL_BASE_URL, id)
-	return post(m.client, u, comment)
+	return post(m.client, u, comment, nil)
 }
 
-// AddIssue creates an issue with the passed in params.
-func (m *MonorailIssueTracker) AddIssue(issue IssueRequest) error {
-	return post(m.client, MONORAIL_BASE_URL, issue)
+// AddIssue creates an issue with the passed in params and returns the created
+// issue.
+func (m *MonorailIssueTracker) AddIssue(issue IssueRequest) (*Issue, error) {
+	ret := &Issue{}
+	if err := post(m.client, MONORAIL_BASE_URL, issue, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
 }
 
 func get(client *http.Client, u string) ([]Issue, error) {
@@ -110,7 +116,9 @@ func get(client *http.Client, u string) ([]Issue, error) {
 	return issueResponse.Items, nil
 }
 
-func post(client *http.Client, dst string, request interface{}) error {
+// post sends the request as JSON to dst. If response is not nil the JSON
+// response is decoded into it, otherwise the response is logged.
+func post(client *http.Client, dst string, request interface{}, response interface{}) error {
 	b := new(bytes.Buffer)
 	e := json.NewEncoder(b)
 	if err := e.Encode(request); err != nil {
@@ -123,6 +131,12 @@ func post(client *http.Client, dst string, request interface{}) error {
 		return fmt.Errorf("Failed to retrieve issue tracker response: %s", err)
 	}
 	defer util.Close(resp.Body)
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return fmt.Errorf("Failed to decode issue tracker response: %s", err)
+		}
+		return nil
+	}
 	msg, err := ioutil.ReadAll(resp.Body)
 	sklog.Infof("%s\n\nErr: %v", string(msg), err)
 	return nil
@@ -105,9 +105,12 @@ func handleCreate() {
 		Summary:     *summary,
 		Description: *description,
 	}
-	if err := tracker.AddIssue(req); err != nil {
+	issue, err := tracker.AddIssue(req)
+	if err != nil {
 		sklog.Errorf("Failed to add issue: %s", err)
+		return
 	}
+	sklog.Infof("Created issue %d", issue.ID)
 }
 
 func checkCreateFlags() bool {
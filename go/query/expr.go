@@ -0,0 +1,280 @@
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// boolOp is the boolean operator that combines the children of a Query.
+type boolOp int
+
+const (
+	opNone boolOp = iota
+	opAnd
+	opOr
+	opNot
+)
+
+// Keywords of the boolean query language.
+const (
+	AND = "AND"
+	OR  = "OR"
+	NOT = "NOT"
+)
+
+// eval evaluates the boolean expression q, calling leaf to match the simple
+// queries at its leaves.
+func (q *Query) eval(leaf func(*Query) bool) bool {
+	switch q.op {
+	case opAnd:
+		for _, c := range q.children {
+			if !c.eval(leaf) {
+				return false
+			}
+		}
+		return true
+	case opOr:
+		for _, c := range q.children {
+			if c.eval(leaf) {
+				return true
+			}
+		}
+		return false
+	case opNot:
+		return !q.children[0].eval(leaf)
+	}
+	return leaf(q)
+}
+
+// queryParamSlice is a utility type for sorting queryParams by key.
+type queryParamSlice []queryParam
+
+func (p queryParamSlice) Len() int           { return len(p) }
+func (p queryParamSlice) Less(i, j int) bool { return p[i].key < p[j].key }
+func (p queryParamSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// newBool returns a Query that combines the children with op, which is
+// either opAnd or opOr.
+//
+// Nested operations of the same kind are flattened, and for opAnd all the
+// simple queries that don't share a parameter name are merged into a single
+// simple query, so they can be matched in a single pass over a key.
+func newBool(op boolOp, children []*Query) *Query {
+	flat := []*Query{}
+	for _, c := range children {
+		if c.op == op {
+			flat = append(flat, c.children...)
+		} else {
+			flat = append(flat, c)
+		}
+	}
+	if op == opAnd {
+		merged := &Query{params: []queryParam{}}
+		seen := map[string]bool{}
+		rest := []*Query{}
+	Outer:
+		for _, c := range flat {
+			if c.op != opNone {
+				rest = append(rest, c)
+				continue
+			}
+			for _, p := range c.params {
+				if seen[p.key] {
+					rest = append(rest, c)
+					continue Outer
+				}
+			}
+			for _, p := range c.params {
+				seen[p.key] = true
+			}
+			merged.params = append(merged.params, c.params...)
+		}
+		sort.Sort(queryParamSlice(merged.params))
+		if len(rest) == 0 {
+			return merged
+		}
+		flat = rest
+		if len(merged.params) > 0 {
+			flat = append([]*Query{merged}, rest...)
+		}
+	}
+	if len(flat) == 1 {
+		return flat[0]
+	}
+	return &Query{op: op, children: flat}
+}
+
+// tokenize splits a boolean query expression into tokens. Tokens are
+// separated by whitespace. Parentheses are split off from the beginning of a
+// token, since parameter names can't contain them, and from the end of a token
+// when they aren't balanced within the token, so that regular expressions such
+// as "~^(a|b)" are left intact.
+func tokenize(s string) []string {
+	ret := []string{}
+	for _, field := range strings.FieldsFunc(s, unicode.IsSpace) {
+		for strings.HasPrefix(field, "(") {
+			ret = append(ret, "(")
+			field = field[1:]
+		}
+		closing := 0
+		for strings.HasSuffix(field, ")") && strings.Count(field, ")") > strings.Count(field, "(") {
+			closing += 1
+			field = field[:len(field)-1]
+		}
+		if field != "" {
+			ret = append(ret, field)
+		}
+		for i := 0; i < closing; i++ {
+			ret = append(ret, ")")
+		}
+	}
+	return ret
+}
+
+// IsExpression returns true if s is a boolean query expression, i.e. it uses
+// AND, OR, NOT or parentheses, as opposed to a plain URL encoded query.
+func IsExpression(s string) bool {
+	tokens := tokenize(s)
+	if len(tokens) == 1 {
+		switch tokens[0] {
+		case AND, OR, NOT, "(", ")":
+			return true
+		}
+	}
+	return len(tokens) > 1
+}
+
+// parser parses a tokenized boolean query expression.
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token, or "" at the end of the tokens.
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// next returns and consumes the next token, or "" at the end of the tokens.
+func (p *parser) next() string {
+	ret := p.peek()
+	if ret != "" {
+		p.pos += 1
+	}
+	return ret
+}
+
+// parseOr parses terms separated by OR.
+func (p *parser) parseOr() (*Query, error) {
+	children := []*Query{}
+	for {
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, q)
+		if p.peek() != OR {
+			return newBool(opOr, children), nil
+		}
+		p.next()
+	}
+}
+
+// parseAnd parses terms separated by AND.
+func (p *parser) parseAnd() (*Query, error) {
+	children := []*Query{}
+	for {
+		q, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, q)
+		if p.peek() != AND {
+			return newBool(opAnd, children), nil
+		}
+		p.next()
+	}
+}
+
+// parseNot parses a NOT, a parenthesized expression, or a URL encoded query.
+func (p *parser) parseNot() (*Query, error) {
+	switch tok := p.next(); tok {
+	case "":
+		return nil, fmt.Errorf("Unexpected end of query.")
+	case NOT:
+		q, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if q.op == opNot {
+			return q.children[0], nil
+		}
+		return &Query{op: opNot, children: []*Query{q}}, nil
+	case "(":
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("Missing ')' in query.")
+		}
+		return q, nil
+	case ")", AND, OR:
+		return nil, fmt.Errorf("Unexpected %q in query.", tok)
+	default:
+		return newTerm(tok)
+	}
+}
+
+// newTerm parses a single URL encoded query from a boolean query expression.
+func newTerm(s string) (*Query, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query %q: %s", s, err)
+	}
+	for key, vals := range values {
+		for _, v := range vals {
+			if v == "" {
+				return nil, fmt.Errorf("Invalid query %q: missing value for %q.", s, key)
+			}
+		}
+	}
+	return New(values)
+}
+
+// NewFromString creates a Query from the given string, which is either a URL
+// encoded query, as accepted by New, or a boolean query expression.
+//
+// A boolean query expression combines URL encoded queries with AND, OR, NOT
+// and parentheses. NOT binds tighter than AND, which binds tighter than OR.
+// For example, this matches all the 565 and 8888 traces on x86, and all the
+// traces that aren't on Android:
+//
+//	config=565&config=8888 AND arch=x86 OR NOT os=Android
+//
+// Whitespace separates the parts of an expression, so whitespace and
+// unbalanced parentheses within a value must be URL encoded.
+func NewFromString(s string) (*Query, error) {
+	if !IsExpression(s) {
+		values, err := url.ParseQuery(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid query %q: %s", s, err)
+		}
+		return New(values)
+	}
+	p := &parser{tokens: tokenize(s)}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok != "" {
+		return nil, fmt.Errorf("Unexpected %q in query.", tok)
+	}
+	return q, nil
+}
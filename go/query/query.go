@@ -138,6 +138,7 @@ func ParseKey(key string) (map[string]string, error) {
 
 // queryParam represents a query on a particular parameter in a key.
 type queryParam struct {
+	key         string         // The param key.
 	keyMatch    string         // The param key, including the leading "," and trailing "=".
 	keyMatchLen int            // The length of keyMatch.
 	isWildCard  bool           // True if this is a wildcard value match.
@@ -185,9 +186,15 @@ type queryParam struct {
 //        "config": []string{"!565", "8888"},
 //        "extra_config": []string{"*"}})
 //
+// Queries can also be combined with AND, OR and NOT, see NewFromString.
 type Query struct {
 	// These are in alphabetical order of parameter name.
 	params []queryParam
+
+	// op combines the children. If op is opNone then the Query is a simple
+	// query that is matched using params.
+	op       boolOp
+	children []*Query
 }
 
 // New creates a Query from the given url.Values. It represents a query to be
@@ -213,7 +220,7 @@ func New(q url.Values) (*Query, error) {
 			if q[key][0] == "*" {
 				isWildCard = true
 			}
-			if q[key][0][:1] == "~" {
+			if strings.HasPrefix(q[key][0], "~") {
 				isRegex = true
 				reg, err = regexp.Compile(q[key][0][1:])
 				if err != nil {
@@ -236,6 +243,7 @@ func New(q url.Values) (*Query, error) {
 			}
 		}
 		params = append(params, queryParam{
+			key:         key,
 			keyMatch:    keyMatch,
 			keyMatchLen: len(keyMatch),
 			isWildCard:  isWildCard,
@@ -251,6 +259,28 @@ func New(q url.Values) (*Query, error) {
 
 // Matches returns true if the given structured key matches the query.
 func (q *Query) Matches(s string) bool {
+	if q.op == opNone {
+		return q.matchesKey(s)
+	}
+	return q.eval(func(leaf *Query) bool {
+		return leaf.matchesKey(s)
+	})
+}
+
+// MatchesParams returns true if the given params, i.e. the parsed form of a
+// structured key, match the query.
+func (q *Query) MatchesParams(params map[string]string) bool {
+	if q.op == opNone {
+		return q.matchesParams(params)
+	}
+	return q.eval(func(leaf *Query) bool {
+		return leaf.matchesParams(params)
+	})
+}
+
+// matchesKey returns true if the given structured key matches the params of
+// the query.
+func (q *Query) matchesKey(s string) bool {
 	// Search forward in the given structured key. Since q.params are in
 	// alphabetical order and structured keys have their params in alphabetical
 	// order we can always search forward in the structured key, i.e. once
@@ -282,3 +312,25 @@ func (q *Query) Matches(s string) bool {
 	}
 	return true
 }
+
+// matchesParams returns true if the given params match the params of the
+// query.
+func (q *Query) matchesParams(params map[string]string) bool {
+	for _, part := range q.params {
+		value, ok := params[part.key]
+		if !ok {
+			return false
+		}
+		if part.isWildCard {
+			continue
+		}
+		if part.isRegex {
+			if !part.reg.MatchString(value) {
+				return false
+			}
+		} else if part.isNegative == util.In(value, part.values) {
+			return false
+		}
+	}
+	return true
+}
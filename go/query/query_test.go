@@ -308,6 +308,112 @@ func TestMatches(t *testing.T) {
 		if got, want := q.Matches(tc.key), tc.matches; got != want {
 			t.Errorf("Failed matching %q to %#v. Got %v Want %v. %s", tc.key, tc.query, got, want, tc.reason)
 		}
+		params, err := ParseKey(tc.key)
+		assert.NoError(t, err)
+		if got, want := q.MatchesParams(params), tc.matches; got != want {
+			t.Errorf("Failed matching params %q to %#v. Got %v Want %v. %s", tc.key, tc.query, got, want, tc.reason)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, []string{}, tokenize(""))
+	assert.Equal(t, []string{"config=565&arch=x86"}, tokenize(" config=565&arch=x86 "))
+	assert.Equal(t, []string{"(", "(", "a=b", "OR", "c=d", ")", "AND", "NOT", "e=f", ")"}, tokenize("((a=b OR c=d) AND NOT e=f)"))
+	assert.Equal(t, []string{"(", "a=~^(x|y)$", ")"}, tokenize("(a=~^(x|y)$)"))
+	assert.Equal(t, []string{"a=~(x|y)"}, tokenize("a=~(x|y)"))
+
+	assert.False(t, IsExpression(""))
+	assert.False(t, IsExpression("config=565&config=8888&arch=x86"))
+	assert.True(t, IsExpression("config=565 OR arch=x86"))
+	assert.True(t, IsExpression("(config=565)"))
+	assert.True(t, IsExpression("NOT"))
+}
+
+func TestNewFromString(t *testing.T) {
+	testutils.SmallTest(t)
+	keys := []string{
+		",arch=x86,config=565,debug=true,",
+		",arch=x86,config=8888,debug=false,",
+		",arch=arm,config=565,debug=false,os=Android,",
+		",arch=arm,config=gpu,os=Android,",
+	}
+	testCases := []struct {
+		query   string
+		matches []bool
+	}{
+		{"", []bool{true, true, true, true}},
+		{"config=565&config=8888", []bool{true, true, true, false}},
+		{"config=565 OR os=Android", []bool{true, false, true, true}},
+		{"config=565 AND arch=x86", []bool{true, false, false, false}},
+		{"NOT config=565", []bool{false, true, false, true}},
+		{"NOT NOT config=565", []bool{true, false, true, false}},
+		{"arch=x86 AND NOT debug=true OR config=gpu", []bool{false, true, false, true}},
+		{"arch=x86 AND (NOT debug=true OR config=gpu)", []bool{false, true, false, false}},
+		{"NOT (arch=arm OR debug=false)", []bool{true, false, false, false}},
+		{"(config=~^(5|8)) AND (debug=* OR os=*)", []bool{true, true, true, false}},
+		{"config=565 AND config=8888", []bool{false, false, false, false}},
+		{"config=!565 AND NOT os=*", []bool{false, true, false, false}},
+		{"  ( config=gpu )  ", []bool{false, false, false, true}},
+	}
+	for _, tc := range testCases {
+		q, err := NewFromString(tc.query)
+		assert.NoError(t, err, tc.query)
+		for i, key := range keys {
+			if got, want := q.Matches(key), tc.matches[i]; got != want {
+				t.Errorf("Failed matching %q to %q. Got %v Want %v.", key, tc.query, got, want)
+			}
+			params, err := ParseKey(key)
+			assert.NoError(t, err)
+			if got, want := q.MatchesParams(params), tc.matches[i]; got != want {
+				t.Errorf("Failed matching params %q to %q. Got %v Want %v.", key, tc.query, got, want)
+			}
+		}
+	}
+}
+
+func TestNewFromStringMerges(t *testing.T) {
+	testutils.SmallTest(t)
+	// Simple queries ANDed together with distinct params are matched in a
+	// single pass.
+	q, err := NewFromString("debug=true AND (config=565 AND arch=x86)")
+	assert.NoError(t, err)
+	assert.Equal(t, opNone, q.op)
+	assert.Equal(t, 3, len(q.params))
+	assert.Equal(t, ",arch=", q.params[0].keyMatch)
+	assert.Equal(t, ",config=", q.params[1].keyMatch)
+	assert.Equal(t, ",debug=", q.params[2].keyMatch)
+
+	q, err = NewFromString("config=565 AND config=8888 AND NOT arch=x86")
+	assert.NoError(t, err)
+	assert.Equal(t, opAnd, q.op)
+	assert.Equal(t, 3, len(q.children))
+
+	q, err = NewFromString("config=565 OR (config=8888 OR arch=x86)")
+	assert.NoError(t, err)
+	assert.Equal(t, opOr, q.op)
+	assert.Equal(t, 3, len(q.children))
+}
+
+func TestNewFromStringErrors(t *testing.T) {
+	testutils.SmallTest(t)
+	testCases := []string{
+		"config=565 AND",
+		"OR config=565",
+		"NOT",
+		"(config=565",
+		"config=565)",
+		"(config=565) arch=x86",
+		"config=565 AND ()",
+		"config AND arch=x86",
+		"config=~( AND arch=x86",
+		"config=%zz OR arch=x86",
+		"config=%zz",
+	}
+	for _, tc := range testCases {
+		_, err := NewFromString(tc)
+		assert.Error(t, err, tc)
 	}
 }
 
@@ -20,9 +20,12 @@ func (d *TomlDuration) UnmarshalText(text []byte) error {
 
 // DataSource is a single ingestion source. Currently we use the convention
 // that if 'bucket' is empty, we assume a source on the local file system.
+// Watched sources in Google storage receive push notifications from Cloud
+// Pub/Sub, watched sources on the local file system use inotify.
 type DataSource struct {
 	Bucket string // Bucket in Google storage. If empty local storage is assumed.
 	Dir    string // Root directory of the data to ingest.
+	Watch  bool   // Ingest new files as soon as they appear instead of waiting for the next poll.
 }
 
 type IngesterConfig struct {
@@ -16,8 +16,8 @@ func TestIngesterConfig(t *testing.T) {
 	assert.Equal(t, "./skia", conf.GitRepoDir)
 	assert.Equal(t, 4, len(conf.Ingesters))
 	assert.Equal(t, 100, conf.Ingesters["gold"].NCommits)
-	assert.Equal(t, []*DataSource{&DataSource{"chromium-skia-gm", "dm-json-v1"},
-		&DataSource{"skia-infra-gm", "dm-json-v1"}}, conf.Ingesters["gold"].Sources)
+	assert.Equal(t, []*DataSource{&DataSource{"chromium-skia-gm", "dm-json-v1", false},
+		&DataSource{"skia-infra-gm", "dm-json-v1", true}}, conf.Ingesters["gold"].Sources)
 
 	assert.Equal(t, "", conf.Ingesters["gold-trybot"].Sources[0].Bucket)
 }
@@ -16,6 +16,7 @@ import (
 	"github.com/golang/groupcache/lru"
 	"github.com/golang/protobuf/proto"
 	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/query"
 	"golang.org/x/net/context"
 )
 
@@ -30,19 +31,20 @@ const (
 )
 
 var (
-	tags               = map[string]string{"module": "tracedb"}
-	missingParamsCalls = metrics2.GetCounter("missing-params-calls", tags)
-	addParamsCalls     = metrics2.GetCounter("add-params-calls", tags)
-	addCalls           = metrics2.GetCounter("add-calls", tags)
-	addCount           = metrics2.GetCounter("added-count", tags)
-	removeCalls        = metrics2.GetCounter("remove-calls", tags)
-	listCalls          = metrics2.GetCounter("list-calls", tags)
-	listMD5Calls       = metrics2.GetCounter("list-md5-calls", tags)
-	getParamsCalls     = metrics2.GetCounter("get-params-calls", tags)
-	getValuesCalls     = metrics2.GetCounter("get-values-calls", tags)
-	getValuesRawCalls  = metrics2.GetCounter("get-values-raw-calls", tags)
-	getTraceIDsCalls   = metrics2.GetCounter("get-traceids-calls", tags)
-	pingCalls          = metrics2.GetCounter("ping-calls", tags)
+	tags                = map[string]string{"module": "tracedb"}
+	missingParamsCalls  = metrics2.GetCounter("missing-params-calls", tags)
+	addParamsCalls      = metrics2.GetCounter("add-params-calls", tags)
+	addCalls            = metrics2.GetCounter("add-calls", tags)
+	addCount            = metrics2.GetCounter("added-count", tags)
+	removeCalls         = metrics2.GetCounter("remove-calls", tags)
+	listCalls           = metrics2.GetCounter("list-calls", tags)
+	listMD5Calls        = metrics2.GetCounter("list-md5-calls", tags)
+	getParamsCalls      = metrics2.GetCounter("get-params-calls", tags)
+	getValuesCalls      = metrics2.GetCounter("get-values-calls", tags)
+	getValuesRawCalls   = metrics2.GetCounter("get-values-raw-calls", tags)
+	getTraceIDsCalls    = metrics2.GetCounter("get-traceids-calls", tags)
+	getValuesRangeCalls = metrics2.GetCounter("get-values-range-calls", tags)
+	pingCalls           = metrics2.GetCounter("ping-calls", tags)
 )
 
 // bytesFromUint64 converts a uint64 to a []byte.
@@ -87,6 +89,9 @@ type TraceServiceImpl struct {
 	// db is the BoltDB datastore we actually store the data in.
 	db *bolt.DB
 
+	// dbMutex controls access to db, which is replaced during compaction.
+	dbMutex sync.RWMutex
+
 	// cache is an in-memory LRU cache for traceids <-> trace64ids and commitid -> md5.
 	cache *lru.Cache
 
@@ -125,6 +130,20 @@ func NewTraceServiceServer(filename string) (*TraceServiceImpl, error) {
 	}, nil
 }
 
+// view runs fn in a read-only transaction of the datastore.
+func (ts *TraceServiceImpl) view(fn func(*bolt.Tx) error) error {
+	ts.dbMutex.RLock()
+	defer ts.dbMutex.RUnlock()
+	return ts.db.View(fn)
+}
+
+// update runs fn in a read-write transaction of the datastore.
+func (ts *TraceServiceImpl) update(fn func(*bolt.Tx) error) error {
+	ts.dbMutex.RLock()
+	defer ts.dbMutex.RUnlock()
+	return ts.db.Update(fn)
+}
+
 // addMD5 adds the md5 of the raw bytes for the given key, which should
 // be a CommitID as a byte slice.
 //
@@ -216,7 +235,7 @@ func (ts *TraceServiceImpl) atomize(ids []string) (map[string]uint64, error) {
 		return nil
 	}
 
-	if err := ts.db.View(get); err != nil {
+	if err := ts.view(get); err != nil {
 		return nil, fmt.Errorf("Error while reading trace ids: %s", err)
 	}
 
@@ -265,7 +284,7 @@ func (ts *TraceServiceImpl) atomize(ids []string) (map[string]uint64, error) {
 		return nil
 	}
 
-	if err := ts.db.Update(add); err != nil {
+	if err := ts.update(add); err != nil {
 		return nil, fmt.Errorf("Error while writing new trace ids: %s", err)
 	}
 
@@ -359,7 +378,7 @@ func (ts *TraceServiceImpl) MissingParams(ctx context.Context, in *MissingParams
 		}
 		return nil
 	}
-	if err := ts.db.View(get); err != nil {
+	if err := ts.view(get); err != nil {
 		return nil, fmt.Errorf("Failed to add values to tracedb: %s", err)
 	}
 	return resp, nil
@@ -394,7 +413,7 @@ func (ts *TraceServiceImpl) AddParams(ctx context.Context, in *AddParamsRequest)
 		}
 		return nil
 	}
-	if err := ts.db.Update(add); err != nil {
+	if err := ts.update(add); err != nil {
 		return nil, fmt.Errorf("Failed to add values to tracedb: %s", err)
 	}
 	return &Empty{}, nil
@@ -460,7 +479,7 @@ func (ts *TraceServiceImpl) Add(ctx context.Context, in *AddRequest) (*Empty, er
 		return nil
 	}
 
-	if err := ts.db.Update(add); err != nil {
+	if err := ts.update(add); err != nil {
 		return nil, fmt.Errorf("Failed to add values to tracedb: %s", err)
 	}
 	return &Empty{}, nil
@@ -479,7 +498,7 @@ func (ts *TraceServiceImpl) Remove(ctx context.Context, in *RemoveRequest) (*Emp
 		}
 		return c.Delete(key)
 	}
-	if err := ts.db.Update(remove); err != nil {
+	if err := ts.update(remove); err != nil {
 		return nil, fmt.Errorf("Failed to remove values from tracedb: %s", err)
 	}
 	ret := &Empty{}
@@ -510,7 +529,7 @@ func (ts *TraceServiceImpl) List(ctx context.Context, listRequest *ListRequest)
 		return nil
 	}
 
-	if err := ts.db.View(scan); err != nil {
+	if err := ts.view(scan); err != nil {
 		return nil, fmt.Errorf("Failed to scan for commits: %s", err)
 	}
 
@@ -568,7 +587,7 @@ func (ts *TraceServiceImpl) GetValues(ctx context.Context, getValuesRequest *Get
 
 		return nil
 	}
-	if err := ts.db.View(load); err != nil {
+	if err := ts.view(load); err != nil {
 		return nil, fmt.Errorf("Failed to load data for commitid: %#v, %s", *(getValuesRequest.Commitid), err)
 	}
 
@@ -596,7 +615,7 @@ func (ts *TraceServiceImpl) GetValuesRaw(ctx context.Context, getValuesRequest *
 		ret.Md5 = ts.getMD5(key, ret.Value)
 		return nil
 	}
-	if err := ts.db.View(load); err != nil {
+	if err := ts.view(load); err != nil {
 		return nil, fmt.Errorf("Failed to load data for commitid: %#v, %s", *(getValuesRequest.Commitid), err)
 	}
 
@@ -622,7 +641,7 @@ func (ts *TraceServiceImpl) GetTraceIDs(ctx context.Context, getTraceIDsRequest
 		}
 		return nil
 	}
-	if err := ts.db.View(load); err != nil {
+	if err := ts.view(load); err != nil {
 		return nil, fmt.Errorf("Failed to load traceids: %s", err)
 	}
 
@@ -662,7 +681,7 @@ func (ts *TraceServiceImpl) GetParams(ctx context.Context, getParamsRequest *Get
 
 		return nil
 	}
-	if err := ts.db.View(load); err != nil {
+	if err := ts.view(load); err != nil {
 		return nil, fmt.Errorf("GetParams: Failed to load data: %s", err)
 	}
 
@@ -691,7 +710,7 @@ func (ts *TraceServiceImpl) ListMD5(ctx context.Context, listMD5Request *ListMD5
 				hash = ts.getMD5(key, c.Get(key))
 				return nil
 			}
-			if err := ts.db.View(load); err != nil {
+			if err := ts.view(load); err != nil {
 				return nil, fmt.Errorf("Failed to load data for commitid: %#v, %s", *commitid, err)
 			}
 		}
@@ -704,6 +723,139 @@ func (ts *TraceServiceImpl) ListMD5(ctx context.Context, listMD5Request *ListMD5
 	return ret, nil
 }
 
+// traceFilter returns the trace64ids of the traces that match the traceids
+// and the query of the request, mapped to their traceids. Returns nil if the
+// request doesn't restrict the traces.
+func (ts *TraceServiceImpl) traceFilter(in *GetValuesRangeRequest) (map[uint64]string, error) {
+	if len(in.Traceids) == 0 && in.Query == "" {
+		return nil, nil
+	}
+	var q *query.Query
+	if in.Query != "" {
+		var err error
+		if q, err = query.NewFromString(in.Query); err != nil {
+			return nil, fmt.Errorf("Invalid query %q: %s", in.Query, err)
+		}
+	}
+
+	ret := map[uint64]string{}
+	load := func(tx *bolt.Tx) error {
+		t := tx.Bucket([]byte(TRACE_BUCKET_NAME))
+		tid := tx.Bucket([]byte(TRACEID_BUCKET_NAME))
+
+		// match adds the trace64id of the traceid to ret if the Params of the
+		// trace match the query.
+		match := func(traceid string, b []byte) error {
+			if q != nil {
+				entry := &StoredEntry{}
+				if err := proto.Unmarshal(b, entry); err != nil {
+					return fmt.Errorf("Failed to unmarshal StoredEntry proto for %s: %s", traceid, err)
+				}
+				if entry.Params == nil || !q.MatchesParams(entry.Params.Params) {
+					return nil
+				}
+			}
+			if bid64 := tid.Get([]byte(traceid)); bid64 != nil {
+				ret[binary.LittleEndian.Uint64(bid64)] = traceid
+			}
+			return nil
+		}
+
+		if len(in.Traceids) > 0 {
+			for _, traceid := range in.Traceids {
+				if err := match(traceid, t.Get([]byte(traceid))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return t.ForEach(func(k, v []byte) error {
+			return match(string(k), v)
+		})
+	}
+	if err := ts.view(load); err != nil {
+		return nil, fmt.Errorf("Failed to find matching traces: %s", err)
+	}
+	return ret, nil
+}
+
+func (ts *TraceServiceImpl) GetValuesRange(in *GetValuesRangeRequest, stream TraceService_GetValuesRangeServer) error {
+	getValuesRangeCalls.Inc(1)
+	if in == nil {
+		return fmt.Errorf("Received nil request.")
+	}
+	filter, err := ts.traceFilter(in)
+	if err != nil {
+		return err
+	}
+	if filter != nil && len(filter) == 0 {
+		return nil
+	}
+	listResp, err := ts.List(stream.Context(), &ListRequest{Begin: in.Begin, End: in.End})
+	if err != nil {
+		return err
+	}
+
+	// Maps trace64ids to traceids. If the traces aren't filtered then it caches
+	// the traceids looked up so far.
+	traceids := filter
+	if traceids == nil {
+		traceids = map[uint64]string{}
+	}
+	for _, commitid := range listResp.Commitids {
+		resp := &GetValuesRangeResponse{
+			Commitid: commitid,
+			Values:   []*ValuePair{},
+		}
+		// Each commit is loaded in its own transaction, so that slow clients
+		// don't keep a transaction open.
+		load := func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(COMMIT_BUCKET_NAME))
+			tid := tx.Bucket([]byte(TRACEID_BUCKET_NAME))
+
+			key, err := CommitIDToBytes(commitid)
+			if err != nil {
+				return err
+			}
+			data, err := NewCommitInfo(c.Get(key))
+			if err != nil {
+				return fmt.Errorf("Unable to decode stored values: %s", err)
+			}
+			for id64, value := range data.Values {
+				traceid, ok := traceids[id64]
+				if !ok {
+					if filter != nil {
+						continue
+					}
+					b := tid.Get(bytesFromUint64(id64))
+					if b == nil {
+						return fmt.Errorf("Failed to get traceid for trace64id %d", id64)
+					}
+					traceid = string(b)
+					traceids[id64] = traceid
+				}
+				resp.Values = append(resp.Values, &ValuePair{
+					Key:   traceid,
+					Value: value,
+				})
+			}
+			return nil
+		}
+		if err := ts.view(load); err != nil {
+			return fmt.Errorf("Failed to load data for commitid: %#v, %s", *commitid, err)
+		}
+
+		// The commit might have been removed since it was listed.
+		if len(resp.Values) == 0 {
+			continue
+		}
+		if err := stream.Send(resp); err != nil {
+			return fmt.Errorf("Failed to send values: %s", err)
+		}
+	}
+	return nil
+}
+
 func (ts *TraceServiceImpl) Ping(ctx context.Context, empty *Empty) (*Empty, error) {
 	pingCalls.Inc(1)
 
@@ -712,5 +864,7 @@ func (ts *TraceServiceImpl) Ping(ctx context.Context, empty *Empty) (*Empty, err
 
 // Close closes the underlying datastore. Not part of the TraceServiceServer interface.
 func (ts *TraceServiceImpl) Close() error {
+	ts.dbMutex.Lock()
+	defer ts.dbMutex.Unlock()
 	return ts.db.Close()
 }
@@ -0,0 +1,384 @@
+package traceservice
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/boltdb/bolt"
+	"go.skia.org/infra/go/config"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// The number of commits removed, or keys copied during compaction, in a
+	// single transaction.
+	RETENTION_TX_SIZE = 1000
+
+	// The number of attempts to reopen the datastore after compaction.
+	COMPACT_REOPEN_ATTEMPTS = 5
+)
+
+var (
+	retentionRemovedCommits = metrics2.GetCounter("retention-removed-commits", tags)
+	retentionRemovedBytes   = metrics2.GetCounter("retention-removed-bytes", tags)
+	compactionSavedBytes    = metrics2.GetInt64Metric("compaction-saved-bytes", tags)
+	dbSizeBytes             = metrics2.GetInt64Metric("db-size-bytes", tags)
+)
+
+// RetentionRule is the retention policy for the commits whose Source matches
+// a regular expression.
+type RetentionRule struct {
+	Source             string              // Regular expression that matches CommitID.Source of the commits the rule applies to.
+	MaxAge             config.TomlDuration // Commits older than this are removed. Zero keeps commits forever.
+	DownsampleAge      config.TomlDuration // Commits older than this are downsampled. Zero disables downsampling.
+	DownsampleInterval config.TomlDuration // When downsampling only the most recent commit of a source in each interval of this length is kept.
+
+	regex *regexp.Regexp
+}
+
+// RetentionPolicy controls how long the data of commits is kept, e.g. trybot
+// data for 30 days and the data of the master branch forever.
+type RetentionPolicy struct {
+	RunEvery     config.TomlDuration // How often the policy is applied.
+	Compact      bool                // Compact the datastore after commits were removed, which reclaims their disk space.
+	CompactHours []int               // Hours of the day, in UTC, during which compaction may start. Empty means any time. Compaction blocks all requests, so this should be off-peak.
+	Rules        []*RetentionRule    // The first rule that matches a commit applies. Commits that don't match any rule are kept forever.
+}
+
+// RetentionPolicyFromTomlFile parses a RetentionPolicy from a TOML file, e.g.
+//
+//	RunEvery     = "1h"
+//	Compact      = true
+//	CompactHours = [2, 3, 4]
+//
+//	[[Rules]]
+//	Source             = "^master$"
+//	DownsampleAge      = "8760h"
+//	DownsampleInterval = "24h"
+//
+//	[[Rules]]
+//	Source = ".*"
+//	MaxAge = "720h"
+func RetentionPolicyFromTomlFile(path string) (*RetentionPolicy, error) {
+	ret := &RetentionPolicy{}
+	if _, err := toml.DecodeFile(path, ret); err != nil {
+		return nil, fmt.Errorf("Failed to parse retention policy %s: %s", path, err)
+	}
+	if err := ret.Validate(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate checks the policy and compiles the regular expressions of its
+// rules. It must be called before the policy is applied.
+func (p *RetentionPolicy) Validate() error {
+	if p.RunEvery.Duration <= 0 {
+		return fmt.Errorf("RunEvery must be positive.")
+	}
+	for _, h := range p.CompactHours {
+		if h < 0 || h > 23 {
+			return fmt.Errorf("Invalid hour in CompactHours: %d", h)
+		}
+	}
+	for _, r := range p.Rules {
+		var err error
+		if r.regex, err = regexp.Compile(r.Source); err != nil {
+			return fmt.Errorf("Invalid Source regex %q: %s", r.Source, err)
+		}
+		if r.DownsampleAge.Duration > 0 && r.DownsampleInterval.Duration < time.Second {
+			return fmt.Errorf("Rule for %q downsamples but has no DownsampleInterval.", r.Source)
+		}
+	}
+	return nil
+}
+
+// ruleFor returns the rule that applies to commits with the given source, or
+// nil if the commits are kept forever.
+func (p *RetentionPolicy) ruleFor(source string) *RetentionRule {
+	for _, r := range p.Rules {
+		if r.regex.MatchString(source) {
+			return r
+		}
+	}
+	return nil
+}
+
+// compactAllowed returns true if compaction may start at the given time.
+func (p *RetentionPolicy) compactAllowed(now time.Time) bool {
+	if len(p.CompactHours) == 0 {
+		return true
+	}
+	for _, h := range p.CompactHours {
+		if now.UTC().Hour() == h {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionResult is the result of applying a RetentionPolicy.
+type RetentionResult struct {
+	Removed int   // The number of commits removed.
+	Bytes   int64 // The total size of the values of the removed commits.
+}
+
+// sample is the most recent commit found in a downsampling interval.
+type sample struct {
+	key       []byte
+	timestamp int64
+	size      int
+}
+
+// ApplyRetention removes the commits that the policy doesn't keep at the
+// given time.
+//
+// Removing commits doesn't shrink the datastore file, the space is reused for
+// new data. Call Compact to reclaim the disk space.
+func (ts *TraceServiceImpl) ApplyRetention(policy *RetentionPolicy, now time.Time) (*RetentionResult, error) {
+	ret := &RetentionResult{}
+	toRemove := [][]byte{}
+	remove := func(key []byte, size int) {
+		toRemove = append(toRemove, key)
+		ret.Bytes += int64(size)
+	}
+
+	// Maps a source and downsampling interval to the most recent commit in it.
+	latest := map[string]*sample{}
+	scan := func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(COMMIT_BUCKET_NAME)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			cid, err := CommitIDFromBytes(k)
+			if err != nil {
+				return fmt.Errorf("scan: Failed to deserialize a commit id: %s", err)
+			}
+			rule := policy.ruleFor(cid.Source)
+			if rule == nil {
+				continue
+			}
+			// The key is only valid during the transaction.
+			key := make([]byte, len(k))
+			copy(key, k)
+			age := now.Sub(time.Unix(cid.Timestamp, 0))
+			if rule.MaxAge.Duration > 0 && age > rule.MaxAge.Duration {
+				remove(key, len(v))
+				continue
+			}
+			if rule.DownsampleAge.Duration > 0 && age > rule.DownsampleAge.Duration {
+				interval := fmt.Sprintf("%s!%d", cid.Source, cid.Timestamp/int64(rule.DownsampleInterval.Seconds()))
+				current := &sample{key: key, timestamp: cid.Timestamp, size: len(v)}
+				if prev, ok := latest[interval]; !ok {
+					latest[interval] = current
+				} else if current.timestamp >= prev.timestamp {
+					remove(prev.key, prev.size)
+					latest[interval] = current
+				} else {
+					remove(current.key, current.size)
+				}
+			}
+		}
+		return nil
+	}
+	if err := ts.view(scan); err != nil {
+		return nil, fmt.Errorf("Failed to scan for expired commits: %s", err)
+	}
+
+	for len(toRemove) > 0 {
+		batch := toRemove[:util.MinInt(RETENTION_TX_SIZE, len(toRemove))]
+		toRemove = toRemove[len(batch):]
+		del := func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(COMMIT_BUCKET_NAME))
+			for _, key := range batch {
+				if err := c.Delete(key); err != nil {
+					return fmt.Errorf("Failed to remove %s: %s", string(key), err)
+				}
+			}
+			return nil
+		}
+		if err := ts.update(del); err != nil {
+			return nil, fmt.Errorf("Failed to remove expired commits: %s", err)
+		}
+		ts.mutex.Lock()
+		for _, key := range batch {
+			ts.cache.Remove(string(key))
+		}
+		ts.mutex.Unlock()
+		ret.Removed += len(batch)
+	}
+	retentionRemovedCommits.Inc(int64(ret.Removed))
+	retentionRemovedBytes.Inc(ret.Bytes)
+	return ret, nil
+}
+
+// fileSize returns the size of the file at the given path.
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// copyBuckets copies all the buckets of src into dst.
+func copyBuckets(dst, src *bolt.DB) error {
+	return src.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// begin starts a new transaction of dst and returns the bucket to copy to.
+			begin := func() (*bolt.Tx, *bolt.Bucket, error) {
+				tx, err := dst.Begin(true)
+				if err != nil {
+					return nil, nil, err
+				}
+				bucket, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					util.LogErr(tx.Rollback())
+					return nil, nil, err
+				}
+				// The keys are added in order, so the pages can be filled completely.
+				bucket.FillPercent = 1.0
+				return tx, bucket, nil
+			}
+
+			dstTx, dstBucket, err := begin()
+			if err != nil {
+				return err
+			}
+			count := 0
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if v == nil {
+					util.LogErr(dstTx.Rollback())
+					return fmt.Errorf("Nested bucket %s in %s isn't supported.", string(k), string(name))
+				}
+				if err := dstBucket.Put(k, v); err != nil {
+					util.LogErr(dstTx.Rollback())
+					return err
+				}
+				count += 1
+				if count%RETENTION_TX_SIZE == 0 {
+					if err := dstTx.Commit(); err != nil {
+						return err
+					}
+					if dstTx, dstBucket, err = begin(); err != nil {
+						return err
+					}
+				}
+			}
+			return dstTx.Commit()
+		})
+	})
+}
+
+// reopenDB opens the datastore at the given path after compaction. The
+// traceserver can't serve any requests without its datastore, so the process
+// exits if it can't be reopened.
+func reopenDB(path string) *bolt.DB {
+	var err error
+	for i := 0; i < COMPACT_REOPEN_ATTEMPTS; i++ {
+		var d *bolt.DB
+		if d, err = bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second}); err == nil {
+			return d
+		}
+		sklog.Errorf("Failed to reopen BoltDB at %s: %s", path, err)
+		time.Sleep(time.Second)
+	}
+	sklog.Fatalf("Unable to reopen BoltDB at %s after compaction: %s", path, err)
+	return nil
+}
+
+// Compact rewrites the datastore into a new file, which reclaims the space of
+// removed data, and returns the number of bytes saved.
+//
+// All other requests are blocked while the datastore is compacted, which takes
+// minutes for a datastore of several GB, so compaction should only run
+// off-peak, see RetentionPolicy.CompactHours. If the datastore can't be closed
+// or reopened after compaction the process exits, since it can't serve
+// requests without it.
+func (ts *TraceServiceImpl) Compact() (int64, error) {
+	ts.dbMutex.Lock()
+	defer ts.dbMutex.Unlock()
+
+	path := ts.db.Path()
+	before, err := fileSize(path)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get the size of %s: %s", path, err)
+	}
+	compactPath := path + ".compact"
+	if err := os.RemoveAll(compactPath); err != nil {
+		return 0, fmt.Errorf("Failed to remove %s: %s", compactPath, err)
+	}
+	dst, err := bolt.Open(compactPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to open BoltDB at %s: %s", compactPath, err)
+	}
+	if err := copyBuckets(dst, ts.db); err != nil {
+		util.LogErr(dst.Close())
+		util.RemoveAll(compactPath)
+		return 0, fmt.Errorf("Failed to compact %s: %s", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		util.RemoveAll(compactPath)
+		return 0, fmt.Errorf("Failed to close %s: %s", compactPath, err)
+	}
+
+	// Swap in the compacted file. If the rename fails the original file is
+	// reopened.
+	if err := ts.db.Close(); err != nil {
+		sklog.Fatalf("Failed to close BoltDB at %s for compaction, its state is unknown: %s", path, err)
+	}
+	renameErr := os.Rename(compactPath, path)
+	ts.db = reopenDB(path)
+	if renameErr != nil {
+		util.RemoveAll(compactPath)
+		return 0, fmt.Errorf("Failed to replace %s with the compacted file: %s", path, renameErr)
+	}
+
+	after, err := fileSize(path)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get the size of %s: %s", path, err)
+	}
+	dbSizeBytes.Update(after)
+	compactionSavedBytes.Update(before - after)
+	return before - after, nil
+}
+
+// StartRetention applies the policy in the background every policy.RunEvery,
+// compacting the datastore afterwards if the policy asks for it. Compaction
+// is postponed until one of policy.CompactHours.
+func (ts *TraceServiceImpl) StartRetention(policy *RetentionPolicy) {
+	compactPending := false
+	go util.Repeat(policy.RunEvery.Duration, nil, func() {
+		now := time.Now()
+		res, err := ts.ApplyRetention(policy, now)
+		if err != nil {
+			sklog.Errorf("Failed to apply retention policy: %s", err)
+			return
+		}
+		sklog.Infof("Retention policy removed %d commits with %d bytes of values.", res.Removed, res.Bytes)
+		if !policy.Compact {
+			return
+		}
+		if res.Removed > 0 {
+			compactPending = true
+		}
+		if !compactPending {
+			return
+		}
+		if !policy.compactAllowed(now) {
+			sklog.Infof("Postponing compaction until one of the hours %v.", policy.CompactHours)
+			return
+		}
+		saved, err := ts.Compact()
+		if err != nil {
+			sklog.Errorf("Failed to compact the datastore: %s", err)
+			return
+		}
+		compactPending = false
+		sklog.Infof("Compaction saved %d bytes.", saved)
+	})
+}
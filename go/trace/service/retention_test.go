@@ -0,0 +1,188 @@
+package traceservice
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/config"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+	"golang.org/x/net/context"
+)
+
+const (
+	RETENTION_FILENAME = "/tmp/tracestore_retention_test.db"
+)
+
+func TestRetentionPolicyFromTomlFile(t *testing.T) {
+	testutils.SmallTest(t)
+	dir, err := ioutil.TempDir("", "retention")
+	assert.NoError(t, err)
+	defer util.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "retention.toml")
+	content := `
+RunEvery     = "1h"
+Compact      = true
+CompactHours = [2, 3]
+
+[[Rules]]
+Source             = "^master$"
+DownsampleAge      = "8760h"
+DownsampleInterval = "24h"
+
+[[Rules]]
+Source = ".*"
+MaxAge = "720h"
+`
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	policy, err := RetentionPolicyFromTomlFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, policy.RunEvery.Duration)
+	assert.True(t, policy.Compact)
+	assert.Equal(t, []int{2, 3}, policy.CompactHours)
+	assert.True(t, policy.compactAllowed(time.Date(2017, time.May, 10, 3, 30, 0, 0, time.UTC)))
+	assert.False(t, policy.compactAllowed(time.Date(2017, time.May, 10, 4, 0, 0, 0, time.UTC)))
+	assert.True(t, (&RetentionPolicy{}).compactAllowed(time.Now()))
+	assert.Equal(t, 2, len(policy.Rules))
+	assert.Equal(t, policy.Rules[0], policy.ruleFor("master"))
+	assert.Equal(t, policy.Rules[1], policy.ruleFor("12345"))
+	assert.Equal(t, 720*time.Hour, policy.ruleFor("12345").MaxAge.Duration)
+
+	// Invalid policies.
+	policy = &RetentionPolicy{}
+	assert.Error(t, policy.Validate())
+	policy = &RetentionPolicy{
+		RunEvery: config.TomlDuration{Duration: time.Hour},
+		Rules:    []*RetentionRule{&RetentionRule{Source: "("}},
+	}
+	assert.Error(t, policy.Validate())
+	policy = &RetentionPolicy{
+		RunEvery: config.TomlDuration{Duration: time.Hour},
+		Rules:    []*RetentionRule{&RetentionRule{Source: ".*", DownsampleAge: config.TomlDuration{Duration: time.Hour}}},
+	}
+	assert.Error(t, policy.Validate())
+	policy = &RetentionPolicy{
+		RunEvery:     config.TomlDuration{Duration: time.Hour},
+		CompactHours: []int{24},
+	}
+	assert.Error(t, policy.Validate())
+}
+
+func TestRetention(t *testing.T) {
+	testutils.MediumTest(t)
+	ts, err := NewTraceServiceServer(RETENTION_FILENAME)
+	assert.NoError(t, err)
+	defer util.Close(ts)
+	defer util.RemoveAll(RETENTION_FILENAME)
+
+	ctx := context.Background()
+	now := time.Unix(1494000000, 0)
+	day := 24 * time.Hour
+	value := bytes.Repeat([]byte("x"), 200)
+	add := func(id, source string, age time.Duration) {
+		req := &AddRequest{
+			Commitid: &CommitID{
+				Timestamp: now.Add(-age).Unix(),
+				Id:        id,
+				Source:    source,
+			},
+			Values: []*ValuePair{
+				&ValuePair{
+					Key:   "key:gpu:win8",
+					Value: value,
+				},
+			},
+		}
+		_, err := ts.Add(ctx, req)
+		assert.NoError(t, err)
+	}
+
+	// Recent master commits are all kept, old ones are downsampled to one per
+	// year. Trybot data is kept for 30 days.
+	add("m1", "master", time.Hour)
+	add("m2", "master", 2*time.Hour)
+	add("m3", "master", 10*day+time.Hour)
+	add("m4", "master", 10*day+2*time.Hour)
+	add("m5", "master", 400*day)
+	add("t1", "12345", 29*day)
+	add("t2", "12345", 31*day)
+	add("t3", "12346", 40*day)
+	for i := 0; i < RETENTION_TX_SIZE+1; i++ {
+		add("old", "99999", 50*day+time.Duration(i)*time.Second)
+	}
+
+	policy := &RetentionPolicy{
+		RunEvery: config.TomlDuration{Duration: time.Hour},
+		Compact:  true,
+		Rules: []*RetentionRule{
+			&RetentionRule{
+				Source:             "^master$",
+				DownsampleAge:      config.TomlDuration{Duration: 7 * day},
+				DownsampleInterval: config.TomlDuration{Duration: 365 * day},
+			},
+			&RetentionRule{
+				Source: ".*",
+				MaxAge: config.TomlDuration{Duration: 30 * day},
+			},
+		},
+	}
+	assert.NoError(t, policy.Validate())
+
+	res, err := ts.ApplyRetention(policy, now)
+	assert.NoError(t, err)
+	// m4, t2, t3 and all the old trybot commits.
+	assert.Equal(t, RETENTION_TX_SIZE+4, res.Removed)
+	assert.True(t, res.Bytes > int64(res.Removed*len(value)))
+
+	listResp, err := ts.List(ctx, &ListRequest{Begin: 0, End: now.Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, cid := range listResp.Commitids {
+		ids = append(ids, cid.Id)
+	}
+	assert.True(t, util.In("m1", ids))
+	assert.True(t, util.In("m2", ids))
+	assert.True(t, util.In("m3", ids))
+	assert.True(t, util.In("m5", ids))
+	assert.True(t, util.In("t1", ids))
+	assert.False(t, util.In("m4", ids))
+	assert.False(t, util.In("t2", ids))
+	assert.False(t, util.In("t3", ids))
+	assert.False(t, util.In("old", ids))
+
+	// Applying the policy again doesn't remove anything.
+	res, err = ts.ApplyRetention(policy, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Removed)
+
+	// Compaction reclaims the space and keeps the data.
+	before, err := fileSize(RETENTION_FILENAME)
+	assert.NoError(t, err)
+	saved, err := ts.Compact()
+	assert.NoError(t, err)
+	assert.True(t, saved > 0)
+	after, err := fileSize(RETENTION_FILENAME)
+	assert.NoError(t, err)
+	assert.Equal(t, before-saved, after)
+	_, err = os.Stat(RETENTION_FILENAME + ".compact")
+	assert.True(t, os.IsNotExist(err))
+
+	compactedResp, err := ts.List(ctx, &ListRequest{Begin: 0, End: now.Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+	assert.Equal(t, listResp.Commitids, compactedResp.Commitids)
+	valuesResp, err := ts.GetValues(ctx, &GetValuesRequest{Commitid: listResp.Commitids[0]})
+	assert.NoError(t, err)
+	assert.Equal(t, []*ValuePair{&ValuePair{Key: "key:gpu:win8", Value: value}}, valuesResp.Values)
+
+	// New data can be added after compaction.
+	add("m6", "master", 0)
+	listResp, err = ts.List(ctx, &ListRequest{Begin: 0, End: now.Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+	assert.Equal(t, len(compactedResp.Commitids)+1, len(listResp.Commitids))
+}
@@ -33,6 +33,8 @@ It has these top-level messages:
 	ListMD5Request
 	CommitMD5
 	ListMD5Response
+	GetValuesRangeRequest
+	GetValuesRangeResponse
 */
 package traceservice
 
@@ -529,6 +531,76 @@ func (m *ListMD5Response) GetCommitmd5() []*CommitMD5 {
 	return nil
 }
 
+type GetValuesRangeRequest struct {
+	// begin is the unix timestamp to start searching from.
+	Begin int64 `protobuf:"varint,1,opt,name=begin" json:"begin,omitempty"`
+	// end is the unix timestamp to search to (inclusive).
+	End int64 `protobuf:"varint,2,opt,name=end" json:"end,omitempty"`
+	// If not empty only the values of these traceids are returned.
+	Traceids []string `protobuf:"bytes,3,rep,name=traceids" json:"traceids,omitempty"`
+	// If not empty only the values of the traces whose Params match this query
+	// are returned. See go/query for the format of the query.
+	Query string `protobuf:"bytes,4,opt,name=query" json:"query,omitempty"`
+}
+
+func (m *GetValuesRangeRequest) Reset()                    { *m = GetValuesRangeRequest{} }
+func (m *GetValuesRangeRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetValuesRangeRequest) ProtoMessage()               {}
+func (*GetValuesRangeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+func (m *GetValuesRangeRequest) GetBegin() int64 {
+	if m != nil {
+		return m.Begin
+	}
+	return 0
+}
+
+func (m *GetValuesRangeRequest) GetEnd() int64 {
+	if m != nil {
+		return m.End
+	}
+	return 0
+}
+
+func (m *GetValuesRangeRequest) GetTraceids() []string {
+	if m != nil {
+		return m.Traceids
+	}
+	return nil
+}
+
+func (m *GetValuesRangeRequest) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+type GetValuesRangeResponse struct {
+	Commitid *CommitID `protobuf:"bytes,1,opt,name=commitid" json:"commitid,omitempty"`
+	// The values of the matching traces in the commit.
+	Values []*ValuePair `protobuf:"bytes,2,rep,name=values" json:"values,omitempty"`
+}
+
+func (m *GetValuesRangeResponse) Reset()                    { *m = GetValuesRangeResponse{} }
+func (m *GetValuesRangeResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetValuesRangeResponse) ProtoMessage()               {}
+func (*GetValuesRangeResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+func (m *GetValuesRangeResponse) GetCommitid() *CommitID {
+	if m != nil {
+		return m.Commitid
+	}
+	return nil
+}
+
+func (m *GetValuesRangeResponse) GetValues() []*ValuePair {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Empty)(nil), "traceservice.Empty")
 	proto.RegisterType((*CommitID)(nil), "traceservice.CommitID")
@@ -554,6 +626,8 @@ func init() {
 	proto.RegisterType((*ListMD5Request)(nil), "traceservice.ListMD5Request")
 	proto.RegisterType((*CommitMD5)(nil), "traceservice.CommitMD5")
 	proto.RegisterType((*ListMD5Response)(nil), "traceservice.ListMD5Response")
+	proto.RegisterType((*GetValuesRangeRequest)(nil), "traceservice.GetValuesRangeRequest")
+	proto.RegisterType((*GetValuesRangeResponse)(nil), "traceservice.GetValuesRangeResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -590,6 +664,10 @@ type TraceServiceClient interface {
 	GetTraceIDs(ctx context.Context, in *GetTraceIDsRequest, opts ...grpc.CallOption) (*GetTraceIDsResponse, error)
 	// ListMD5 returns the MD5 hashes for the given CommitIDs.
 	ListMD5(ctx context.Context, in *ListMD5Request, opts ...grpc.CallOption) (*ListMD5Response, error)
+	// GetValuesRange streams the values of the matching traces for all the
+	// commits in the given time range, one response per commit. This avoids
+	// calling List followed by GetValues for every commit.
+	GetValuesRange(ctx context.Context, in *GetValuesRangeRequest, opts ...grpc.CallOption) (TraceService_GetValuesRangeClient, error)
 	// Ping should always succeed. Used to test if the service is up and
 	// running.
 	Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
@@ -693,6 +771,38 @@ func (c *traceServiceClient) ListMD5(ctx context.Context, in *ListMD5Request, op
 	return out, nil
 }
 
+func (c *traceServiceClient) GetValuesRange(ctx context.Context, in *GetValuesRangeRequest, opts ...grpc.CallOption) (TraceService_GetValuesRangeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_TraceService_serviceDesc.Streams[0], c.cc, "/traceservice.TraceService/GetValuesRange", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &traceServiceGetValuesRangeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TraceService_GetValuesRangeClient interface {
+	Recv() (*GetValuesRangeResponse, error)
+	grpc.ClientStream
+}
+
+type traceServiceGetValuesRangeClient struct {
+	grpc.ClientStream
+}
+
+func (x *traceServiceGetValuesRangeClient) Recv() (*GetValuesRangeResponse, error) {
+	m := new(GetValuesRangeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *traceServiceClient) Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
 	out := new(Empty)
 	err := grpc.Invoke(ctx, "/traceservice.TraceService/Ping", in, out, c.cc, opts...)
@@ -728,6 +838,10 @@ type TraceServiceServer interface {
 	GetTraceIDs(context.Context, *GetTraceIDsRequest) (*GetTraceIDsResponse, error)
 	// ListMD5 returns the MD5 hashes for the given CommitIDs.
 	ListMD5(context.Context, *ListMD5Request) (*ListMD5Response, error)
+	// GetValuesRange streams the values of the matching traces for all the
+	// commits in the given time range, one response per commit. This avoids
+	// calling List followed by GetValues for every commit.
+	GetValuesRange(*GetValuesRangeRequest, TraceService_GetValuesRangeServer) error
 	// Ping should always succeed. Used to test if the service is up and
 	// running.
 	Ping(context.Context, *Empty) (*Empty, error)
@@ -917,6 +1031,27 @@ func _TraceService_ListMD5_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TraceService_GetValuesRange_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetValuesRangeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TraceServiceServer).GetValuesRange(m, &traceServiceGetValuesRangeServer{stream})
+}
+
+type TraceService_GetValuesRangeServer interface {
+	Send(*GetValuesRangeResponse) error
+	grpc.ServerStream
+}
+
+type traceServiceGetValuesRangeServer struct {
+	grpc.ServerStream
+}
+
+func (x *traceServiceGetValuesRangeServer) Send(m *GetValuesRangeResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _TraceService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -984,61 +1119,70 @@ var _TraceService_serviceDesc = grpc.ServiceDesc{
 			Handler:    _TraceService_Ping_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetValuesRange",
+			Handler:       _TraceService_GetValuesRange_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "traceservice.proto",
 }
 
 func init() { proto.RegisterFile("traceservice.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 780 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xac, 0x56, 0xdf, 0x53, 0xd3, 0x4a,
-	0x14, 0x26, 0x4d, 0x5b, 0xc8, 0x49, 0xe1, 0x72, 0x97, 0x5e, 0x6e, 0x8c, 0xa8, 0x75, 0xe5, 0xa1,
-	0x33, 0x3a, 0xa8, 0x81, 0x32, 0xa8, 0x8c, 0x0e, 0x50, 0x04, 0x66, 0xc4, 0xa9, 0x81, 0xe1, 0xc1,
-	0xb7, 0xd0, 0xdd, 0x61, 0x32, 0x9a, 0xa6, 0x24, 0x01, 0x87, 0x07, 0xff, 0x0e, 0xff, 0x0c, 0xff,
-	0x45, 0x27, 0xbb, 0x9b, 0xcd, 0x8f, 0x26, 0x80, 0xe2, 0x13, 0xd9, 0xdd, 0x73, 0xbe, 0xfd, 0xbe,
-	0xb3, 0xdf, 0x39, 0x14, 0x50, 0x14, 0x38, 0x43, 0x1a, 0xd2, 0xe0, 0xd2, 0x1d, 0xd2, 0x95, 0x71,
-	0xe0, 0x47, 0x3e, 0x6a, 0x65, 0xf7, 0xf0, 0x34, 0x34, 0x76, 0xbd, 0x71, 0x74, 0x85, 0x07, 0x30,
-	0xb3, 0xe3, 0x7b, 0x9e, 0x1b, 0x1d, 0xf4, 0xd1, 0x1c, 0xd4, 0x5c, 0x62, 0x28, 0x1d, 0xa5, 0xab,
-	0xd9, 0x35, 0x97, 0xa0, 0x45, 0x68, 0x86, 0xfe, 0x45, 0x30, 0xa4, 0x46, 0x8d, 0xed, 0x89, 0x15,
-	0x5a, 0x02, 0x2d, 0x72, 0x3d, 0x1a, 0x46, 0x8e, 0x37, 0x36, 0xd4, 0x8e, 0xd2, 0x55, 0xed, 0x74,
-	0x03, 0x7f, 0x87, 0xe6, 0xc0, 0x09, 0x1c, 0x2f, 0x44, 0x1b, 0xd0, 0x1c, 0xb3, 0x2f, 0x43, 0xe9,
-	0xa8, 0x5d, 0xdd, 0xea, 0xac, 0xe4, 0x78, 0xf1, 0x28, 0xf1, 0x67, 0x77, 0x14, 0x05, 0x57, 0xb6,
-	0x88, 0x37, 0x5f, 0x81, 0x9e, 0xd9, 0x46, 0xf3, 0xa0, 0x7e, 0xa1, 0x57, 0x82, 0x59, 0xfc, 0x89,
-	0xda, 0xd0, 0xb8, 0x74, 0xbe, 0x5e, 0x24, 0xcc, 0xf8, 0xe2, 0x75, 0x6d, 0x43, 0xc1, 0x16, 0xb4,
-	0x0f, 0xdd, 0x30, 0x74, 0x47, 0x67, 0x1c, 0xc1, 0xa6, 0xe7, 0x17, 0x34, 0x8c, 0x90, 0x09, 0x33,
-	0xec, 0x76, 0x97, 0x70, 0x3a, 0x9a, 0x2d, 0xd7, 0x78, 0x15, 0xfe, 0x2b, 0xe4, 0x84, 0x63, 0x7f,
-	0x14, 0xd2, 0x6b, 0x93, 0x7e, 0x28, 0x00, 0x3c, 0x7c, 0xe0, 0xb8, 0x41, 0x09, 0xc7, 0x4d, 0x29,
-	0xbf, 0xc6, 0xe4, 0x2f, 0x97, 0xc9, 0x8f, 0x73, 0xff, 0x76, 0x09, 0xfa, 0x30, 0xbf, 0x45, 0x48,
-	0x5e, 0xfe, 0x0b, 0x49, 0xa6, 0xce, 0xc8, 0x18, 0x55, 0x64, 0x12, 0x02, 0xf8, 0x0d, 0xe8, 0x47,
-	0x91, 0x1f, 0x50, 0xc2, 0x09, 0x3c, 0xcb, 0xa8, 0x51, 0xba, 0xba, 0xd5, 0x2e, 0x03, 0x90, 0xc9,
-	0xab, 0xa0, 0x9d, 0xc4, 0x7c, 0x2a, 0x4a, 0x93, 0xe3, 0xde, 0x12, 0xdc, 0xf1, 0x39, 0xc0, 0x16,
-	0x21, 0x09, 0x63, 0x0b, 0x66, 0x86, 0xcc, 0x99, 0xc2, 0x93, 0xba, 0xb5, 0x98, 0xbf, 0x32, 0xf1,
-	0xad, 0x2d, 0xe3, 0xd0, 0x73, 0x68, 0x32, 0xa8, 0xd0, 0x50, 0x99, 0xca, 0xff, 0xf3, 0x19, 0x92,
-	0x92, 0x2d, 0xc2, 0xf0, 0x0e, 0xcc, 0xda, 0xd4, 0xf3, 0x2f, 0xe9, 0x1d, 0x6e, 0xc5, 0x3d, 0xd0,
-	0x3f, 0xb8, 0x61, 0x94, 0x40, 0xb4, 0xa1, 0x71, 0x4a, 0xcf, 0xdc, 0x11, 0xcb, 0x57, 0x6d, 0xbe,
-	0x88, 0x8b, 0x40, 0x47, 0x84, 0x09, 0x56, 0xed, 0xf8, 0x13, 0xf7, 0xa1, 0xc5, 0xd3, 0x84, 0xd9,
-	0xd6, 0x40, 0x4b, 0x20, 0x13, 0xfe, 0x55, 0x77, 0xa7, 0x81, 0xf8, 0x3d, 0xcc, 0xef, 0xd1, 0x88,
-	0x29, 0x0b, 0xef, 0x22, 0xe2, 0x04, 0xfe, 0xcd, 0xe0, 0x08, 0x4a, 0x69, 0x3d, 0xeb, 0xb7, 0xaa,
-	0x67, 0xac, 0xd2, 0x23, 0x3d, 0xa3, 0xc1, 0x9f, 0xda, 0x23, 0x3d, 0xbc, 0xc2, 0xf8, 0xdd, 0xbe,
-	0x17, 0x77, 0x19, 0x8f, 0x42, 0x1f, 0xfe, 0xbe, 0x7b, 0xdf, 0x42, 0x3b, 0x95, 0xe3, 0x7c, 0x93,
-	0x48, 0xd2, 0x79, 0x4a, 0xc6, 0x79, 0x09, 0xed, 0x5a, 0x4a, 0x7b, 0x19, 0xd0, 0x1e, 0x8d, 0x8e,
-	0xe3, 0x5b, 0x0e, 0xfa, 0x92, 0x78, 0x32, 0x21, 0xd5, 0x6e, 0x3d, 0x9e, 0x90, 0xf8, 0x25, 0xe8,
-	0x22, 0x84, 0x19, 0x1d, 0x41, 0xdd, 0x25, 0xeb, 0x6b, 0x0c, 0xbb, 0x6e, 0xb3, 0x6f, 0x91, 0x52,
-	0x4b, 0x86, 0x2a, 0xde, 0x86, 0x85, 0x1c, 0xb0, 0xe0, 0xf5, 0x14, 0xd4, 0xa4, 0x1a, 0xba, 0x75,
-	0x2f, 0x2f, 0x2f, 0x73, 0x85, 0x1d, 0x47, 0xe1, 0x3e, 0xcc, 0xc5, 0xce, 0x39, 0xec, 0xf7, 0xca,
-	0x5f, 0x5c, 0xbd, 0xd5, 0x8b, 0x7f, 0x02, 0x8d, 0xef, 0x1e, 0xf6, 0x7b, 0x7f, 0xd4, 0x6d, 0x93,
-	0x55, 0xdb, 0x87, 0x7f, 0x24, 0x31, 0x21, 0xac, 0x97, 0xb8, 0x3a, 0x0e, 0x55, 0xca, 0x5c, 0x24,
-	0x49, 0xd8, 0x69, 0xa4, 0xf5, 0xb3, 0x09, 0x2d, 0xa6, 0xfb, 0x88, 0x47, 0xa1, 0xcf, 0x30, 0x9b,
-	0x9b, 0xd1, 0x08, 0xe7, 0x51, 0xca, 0x86, 0xbe, 0xf9, 0xe4, 0xda, 0x18, 0xce, 0x10, 0x4f, 0xa1,
-	0x6d, 0xd0, 0xe4, 0xc0, 0x44, 0x0f, 0xf3, 0x39, 0xc5, 0x49, 0x6a, 0x2e, 0xe4, 0xcf, 0xf9, 0xbf,
-	0xd1, 0x29, 0xb4, 0x0e, 0xea, 0x16, 0x21, 0xc8, 0x98, 0xc8, 0xbe, 0x21, 0x6f, 0x13, 0x9a, 0x7c,
-	0x02, 0xa1, 0xfb, 0xf9, 0x80, 0xdc, 0x5c, 0xaa, 0xca, 0x7e, 0x07, 0xf5, 0xb8, 0xe0, 0xa8, 0xe0,
-	0x98, 0xcc, 0x38, 0x32, 0xcd, 0xb2, 0x23, 0x29, 0xfd, 0x23, 0x68, 0xb2, 0x4f, 0x8a, 0xd2, 0x8b,
-	0x73, 0xc5, 0x7c, 0x54, 0x79, 0x5e, 0xc0, 0x2b, 0x2f, 0x65, 0x71, 0x0e, 0x94, 0xe0, 0x4d, 0x3c,
-	0xcd, 0x31, 0xb4, 0xb2, 0x7d, 0x7c, 0x23, 0x45, 0x5c, 0x75, 0x9e, 0xce, 0x00, 0x86, 0xaa, 0x67,
-	0x9a, 0x10, 0x75, 0x26, 0x92, 0x0a, 0x8d, 0x6f, 0x3e, 0xbe, 0x26, 0x42, 0xa2, 0xee, 0xc3, 0xb4,
-	0x70, 0x3f, 0x5a, 0x9a, 0x2c, 0x7a, 0xda, 0xad, 0xe6, 0x83, 0x8a, 0x53, 0x89, 0x64, 0x41, 0x7d,
-	0xe0, 0x8e, 0xce, 0x50, 0xd9, 0xab, 0x57, 0x58, 0xe1, 0xb4, 0xc9, 0x7e, 0xe7, 0xad, 0xfe, 0x0a,
-	0x00, 0x00, 0xff, 0xff, 0xcf, 0x97, 0x2e, 0x23, 0xfd, 0x09, 0x00, 0x00,
+	// 832 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xad, 0x56, 0x5d, 0x53, 0xd3, 0x40,
+	0x14, 0x25, 0x4d, 0x28, 0xe4, 0xa6, 0x20, 0x2e, 0x05, 0x6b, 0x44, 0xc5, 0x85, 0x07, 0x66, 0x74,
+	0x10, 0x0b, 0x65, 0xf0, 0x63, 0x74, 0x84, 0xa2, 0x30, 0x23, 0x4e, 0x0d, 0x0c, 0x0f, 0xbe, 0x38,
+	0xa5, 0xd9, 0x61, 0x32, 0x9a, 0xb6, 0x24, 0x01, 0xa7, 0x0f, 0xfe, 0x07, 0xdf, 0xfc, 0xbb, 0x6e,
+	0x36, 0x9b, 0x4d, 0x36, 0x4d, 0x4a, 0x11, 0x9f, 0xba, 0xd9, 0xbd, 0xf7, 0xec, 0x39, 0xbb, 0xe7,
+	0xde, 0x2d, 0xa0, 0xc0, 0x6b, 0x77, 0x88, 0x4f, 0xbc, 0x2b, 0xa7, 0x43, 0xd6, 0xfb, 0x5e, 0x2f,
+	0xe8, 0xa1, 0x4a, 0x7a, 0x0e, 0x4f, 0xc1, 0xe4, 0xbe, 0xdb, 0x0f, 0x06, 0xb8, 0x05, 0xd3, 0x7b,
+	0x3d, 0xd7, 0x75, 0x82, 0xc3, 0x26, 0x9a, 0x85, 0x92, 0x63, 0xd7, 0x94, 0x65, 0x65, 0x4d, 0xb7,
+	0xe8, 0x08, 0x2d, 0x42, 0xd9, 0xef, 0x5d, 0x7a, 0x1d, 0x52, 0x2b, 0xb1, 0x39, 0xfe, 0x85, 0x96,
+	0x40, 0x0f, 0x1c, 0x97, 0xf8, 0x41, 0xdb, 0xed, 0xd7, 0x54, 0xba, 0xa4, 0x5a, 0xc9, 0x04, 0xfe,
+	0x05, 0xe5, 0x56, 0xdb, 0x6b, 0xbb, 0x3e, 0xda, 0x81, 0x72, 0x9f, 0x8d, 0x28, 0xa6, 0xba, 0x66,
+	0xd4, 0x97, 0xd7, 0x25, 0x5e, 0x51, 0x14, 0xff, 0xd9, 0xef, 0x06, 0xde, 0xc0, 0xe2, 0xf1, 0xe6,
+	0x4b, 0x30, 0x52, 0xd3, 0x68, 0x0e, 0xd4, 0xef, 0x64, 0xc0, 0x99, 0x85, 0x43, 0x54, 0x85, 0xc9,
+	0xab, 0xf6, 0x8f, 0xcb, 0x98, 0x59, 0xf4, 0xf1, 0xaa, 0xb4, 0xa3, 0xe0, 0x3a, 0x54, 0x8f, 0x1c,
+	0xdf, 0x77, 0xba, 0xe7, 0x11, 0x82, 0x45, 0x2e, 0x2e, 0x29, 0x33, 0x64, 0xc2, 0x34, 0xdb, 0xdd,
+	0xb1, 0x23, 0x3a, 0xba, 0x25, 0xbe, 0xf1, 0x26, 0x2c, 0x64, 0x72, 0xfc, 0x7e, 0xaf, 0xeb, 0x93,
+	0x91, 0x49, 0x7f, 0x14, 0x80, 0x28, 0xbc, 0xd5, 0x76, 0xbc, 0x1c, 0x8e, 0x6f, 0x84, 0xfc, 0x12,
+	0x93, 0xbf, 0x9a, 0x27, 0x3f, 0xcc, 0xfd, 0xdf, 0x47, 0xd0, 0x84, 0xb9, 0xf7, 0xb6, 0x2d, 0xcb,
+	0xdf, 0x10, 0x64, 0x34, 0x46, 0xa6, 0x56, 0x44, 0x26, 0x26, 0x80, 0x5f, 0x83, 0x71, 0x1c, 0xf4,
+	0x3c, 0x62, 0x47, 0x04, 0x9e, 0xa5, 0xd4, 0x28, 0x14, 0xa0, 0x9a, 0x07, 0x20, 0x92, 0x37, 0x41,
+	0x3f, 0x0d, 0xf9, 0x14, 0x1c, 0x8d, 0xc4, 0xbd, 0xc2, 0xb9, 0xe3, 0x0b, 0x00, 0xca, 0x3b, 0x66,
+	0x5c, 0x87, 0xe9, 0x0e, 0x73, 0x26, 0xf7, 0xa4, 0x51, 0x5f, 0x94, 0xb7, 0x8c, 0x7d, 0x6b, 0x89,
+	0x38, 0xf4, 0x1c, 0xca, 0x0c, 0xca, 0xa7, 0xb6, 0x0c, 0x55, 0xde, 0x93, 0x33, 0x04, 0x25, 0x8b,
+	0x87, 0xe1, 0x3d, 0x98, 0xb1, 0x88, 0xdb, 0xbb, 0x22, 0xb7, 0xd8, 0x15, 0x37, 0xc0, 0xf8, 0xe4,
+	0xf8, 0x41, 0x0c, 0x41, 0xc5, 0x9d, 0x91, 0x73, 0xa7, 0xcb, 0xf2, 0x55, 0x2b, 0xfa, 0x08, 0x0f,
+	0x81, 0x74, 0x6d, 0x26, 0x58, 0xb5, 0xc2, 0x21, 0xbd, 0xa6, 0x4a, 0x94, 0xc6, 0xcd, 0xb6, 0x05,
+	0x7a, 0x0c, 0x19, 0xf3, 0x2f, 0xda, 0x3b, 0x09, 0xc4, 0x1f, 0x60, 0xee, 0x23, 0x09, 0x98, 0x32,
+	0xff, 0x36, 0x22, 0x4e, 0xe1, 0x6e, 0x0a, 0x87, 0x53, 0x4a, 0xce, 0x53, 0x1b, 0xeb, 0x3c, 0x43,
+	0x95, 0xae, 0xdd, 0xa8, 0x4d, 0x46, 0x57, 0x4d, 0x87, 0x78, 0x9d, 0xf1, 0x1b, 0xbf, 0x16, 0xf7,
+	0x19, 0x8f, 0x4c, 0x1d, 0xde, 0xdc, 0xbd, 0x6f, 0xa1, 0x9a, 0xc8, 0x69, 0xff, 0x14, 0x48, 0xc2,
+	0x79, 0x4a, 0xca, 0x79, 0x31, 0xed, 0x52, 0x42, 0x7b, 0x15, 0x10, 0xcd, 0x3f, 0x09, 0x77, 0x39,
+	0x6c, 0x0a, 0xe2, 0x71, 0x87, 0x54, 0xd7, 0xb4, 0xb0, 0x43, 0xe2, 0x17, 0x60, 0xf0, 0x10, 0x66,
+	0x74, 0x04, 0x9a, 0x63, 0x6f, 0x6f, 0x31, 0x6c, 0xcd, 0x62, 0x63, 0x9e, 0x52, 0x8a, 0x9b, 0x2a,
+	0xde, 0x85, 0x79, 0x09, 0x98, 0xf3, 0x7a, 0x0a, 0x6a, 0x7c, 0x1a, 0x46, 0xfd, 0xbe, 0x2c, 0x2f,
+	0xb5, 0x85, 0x15, 0x46, 0x51, 0xe7, 0xcc, 0x86, 0xce, 0x39, 0x6a, 0x36, 0xf2, 0x6f, 0x5c, 0x1d,
+	0xeb, 0xc6, 0xbf, 0x80, 0x1e, 0xcd, 0x52, 0x9c, 0x7f, 0xaa, 0xb6, 0xe1, 0x53, 0x3b, 0x80, 0x3b,
+	0x82, 0x18, 0x17, 0xd6, 0x88, 0x5d, 0x1d, 0x86, 0x2a, 0x79, 0x2e, 0x12, 0x24, 0xac, 0x24, 0x92,
+	0xf6, 0x82, 0x85, 0xd4, 0xfd, 0x75, 0xcf, 0xc9, 0x0d, 0xab, 0x4b, 0xf2, 0x98, 0x2a, 0x7b, 0x2c,
+	0xc4, 0xa0, 0x60, 0xde, 0x80, 0xba, 0x89, 0xb5, 0x4e, 0xf6, 0x41, 0x1f, 0xae, 0xc5, 0xec, 0x96,
+	0x5c, 0xc3, 0xed, 0x5a, 0x51, 0x69, 0xac, 0xd2, 0xa9, 0xff, 0x9e, 0x82, 0x0a, 0xbb, 0xe9, 0xe3,
+	0x28, 0x04, 0x7d, 0x85, 0x19, 0xe9, 0x55, 0x42, 0x58, 0x86, 0xc8, 0x7b, 0xe6, 0xcc, 0x95, 0x91,
+	0x31, 0x91, 0x1e, 0x3c, 0x81, 0x76, 0x41, 0x17, 0x4f, 0x04, 0x7a, 0x24, 0xe7, 0x64, 0xdf, 0x0e,
+	0x73, 0x5e, 0x5e, 0x8f, 0xfe, 0x38, 0x4c, 0xa0, 0x6d, 0x50, 0x69, 0x28, 0xaa, 0x0d, 0x65, 0x5f,
+	0x93, 0x47, 0xdf, 0xc5, 0xa8, 0xe7, 0xa2, 0x07, 0x72, 0x80, 0xd4, 0x89, 0x8b, 0xb2, 0xdf, 0x81,
+	0x16, 0x5a, 0x0c, 0x65, 0x6a, 0x24, 0xd5, 0x80, 0x4d, 0x33, 0x6f, 0x49, 0x48, 0xff, 0x0c, 0xba,
+	0xb8, 0xe6, 0xac, 0xf4, 0x6c, 0x27, 0x35, 0x1f, 0x17, 0xae, 0x67, 0xf0, 0xf2, 0x8f, 0x32, 0xdb,
+	0xf9, 0x72, 0xf0, 0x86, 0xae, 0xe6, 0x04, 0x2a, 0xe9, 0xce, 0x75, 0x2d, 0x45, 0x5c, 0xb4, 0x9e,
+	0x74, 0x3d, 0x86, 0x6a, 0xa4, 0xda, 0x0e, 0x5a, 0x1e, 0x4a, 0xca, 0xb4, 0x3a, 0xf3, 0xc9, 0x88,
+	0x08, 0x81, 0x7a, 0x00, 0x53, 0xbc, 0xde, 0xd1, 0xd2, 0xf0, 0xa1, 0x27, 0xfd, 0xc9, 0x7c, 0x58,
+	0xb0, 0x2a, 0x90, 0xbe, 0xc1, 0xac, 0x5c, 0x7c, 0x68, 0xa5, 0x50, 0x57, 0xd2, 0x0d, 0xcc, 0xd5,
+	0xd1, 0x41, 0x31, 0xfc, 0x86, 0x42, 0x6b, 0x58, 0x6b, 0xd1, 0x4a, 0x40, 0x79, 0xb6, 0x2a, 0xf0,
+	0xda, 0x59, 0x99, 0xfd, 0x75, 0xde, 0xfc, 0x0b, 0x57, 0x65, 0x3b, 0x17, 0x50, 0x0b, 0x00, 0x00,
 }
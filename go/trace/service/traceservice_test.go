@@ -3,8 +3,11 @@ package traceservice
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
+	"net"
 	"os"
+	"sort"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"go.skia.org/infra/go/trace/db/perftypes"
 	"go.skia.org/infra/go/util"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -294,3 +298,120 @@ func TestCommitInfo(t *testing.T) {
 	_, err = NewCommitInfo(b[:len(b)-1])
 	assert.Error(t, err)
 }
+
+// valuePairSlice sorts ValuePairs by key.
+type valuePairSlice []*ValuePair
+
+func (p valuePairSlice) Len() int           { return len(p) }
+func (p valuePairSlice) Less(i, j int) bool { return p[i].Key < p[j].Key }
+func (p valuePairSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func TestGetValuesRange(t *testing.T) {
+	testutils.MediumTest(t)
+	ts, err := NewTraceServiceServer(FILENAME)
+	assert.NoError(t, err)
+	defer util.Close(ts)
+	defer cleanup()
+
+	// GetValuesRange is a streaming RPC, so talk to the server via gRPC.
+	lis, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	s := grpc.NewServer()
+	RegisterTraceServiceServer(s, ts)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	defer s.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer util.Close(conn)
+	client := NewTraceServiceClient(conn)
+
+	ctx := context.Background()
+	_, err = ts.AddParams(ctx, &AddParamsRequest{
+		Params: []*ParamsPair{
+			&ParamsPair{
+				Key:    "key:8888:android",
+				Params: map[string]string{"config": "8888", "platform": "android"},
+			},
+			&ParamsPair{
+				Key:    "key:gpu:win8",
+				Params: map[string]string{"config": "gpu", "platform": "win8"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	commitIDs := []*CommitID{
+		&CommitID{Timestamp: 100, Id: "abc123", Source: "master"},
+		&CommitID{Timestamp: 200, Id: "def456", Source: "master"},
+		&CommitID{Timestamp: 300, Id: "xyz789", Source: "master"},
+	}
+	for i, cid := range commitIDs {
+		values := []*ValuePair{
+			&ValuePair{Key: "key:gpu:win8", Value: []byte(fmt.Sprintf("gpu%d", i))},
+		}
+		// The android trace has no value for the middle commit.
+		if i != 1 {
+			values = append(values, &ValuePair{Key: "key:8888:android", Value: []byte(fmt.Sprintf("8888%d", i))})
+		}
+		_, err := ts.Add(ctx, &AddRequest{Commitid: cid, Values: values})
+		assert.NoError(t, err)
+	}
+
+	// getValuesRange returns all the responses of a GetValuesRange call.
+	getValuesRange := func(req *GetValuesRangeRequest) ([]*GetValuesRangeResponse, error) {
+		stream, err := client.GetValuesRange(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		ret := []*GetValuesRangeResponse{}
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return ret, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			sort.Sort(valuePairSlice(resp.Values))
+			ret = append(ret, resp)
+		}
+	}
+
+	// All the traces of the commits in the range.
+	resps, err := getValuesRange(&GetValuesRangeRequest{Begin: 100, End: 250})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(resps))
+	assert.Equal(t, commitIDs[0], resps[0].Commitid)
+	assert.Equal(t, []*ValuePair{
+		&ValuePair{Key: "key:8888:android", Value: []byte("88880")},
+		&ValuePair{Key: "key:gpu:win8", Value: []byte("gpu0")},
+	}, resps[0].Values)
+	assert.Equal(t, commitIDs[1], resps[1].Commitid)
+	assert.Equal(t, []*ValuePair{
+		&ValuePair{Key: "key:gpu:win8", Value: []byte("gpu1")},
+	}, resps[1].Values)
+
+	// Only the matching traces, commits without values are skipped.
+	resps, err = getValuesRange(&GetValuesRangeRequest{Begin: 0, End: 1000, Query: "config=8888"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(resps))
+	assert.Equal(t, commitIDs[0], resps[0].Commitid)
+	assert.Equal(t, commitIDs[2], resps[1].Commitid)
+	assert.Equal(t, []*ValuePair{
+		&ValuePair{Key: "key:8888:android", Value: []byte("88882")},
+	}, resps[1].Values)
+
+	// The traceids and the query are combined.
+	resps, err = getValuesRange(&GetValuesRangeRequest{Begin: 0, End: 1000, Traceids: []string{"key:gpu:win8"}, Query: "platform=win8"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(resps))
+	resps, err = getValuesRange(&GetValuesRangeRequest{Begin: 0, End: 1000, Traceids: []string{"key:gpu:win8", "unknown"}, Query: "platform=android"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(resps))
+
+	// Invalid queries are rejected.
+	_, err = getValuesRange(&GetValuesRangeRequest{Begin: 0, End: 1000, Query: "%%"})
+	assert.Error(t, err)
+}
@@ -146,13 +146,28 @@ var gold = gold || {};
   // Returns the query string to pass to the diff page or to the diff endpoint.
   // Input is the name of the test and the two digests to compare.
   gold.diffQuery = function(test, left, right) {
-    return '?test=' + test + '&left=' + left + '&right=' + right;
+    return '?test=' + test + '&left=' + left + '&right=' + right + gold.branchQuery();
   };
 
   // Returns the query string to usee for the detail page or the call to the
   // diff endpoint.
   gold.detailQuery = function(test, digest) {
-    return '?test=' + test + '&digest=' + digest;
+    return '?test=' + test + '&digest=' + digest + gold.branchQuery();
+  };
+
+  // branchParams returns the branch and issue of the current page, i.e. the
+  // values that select whose expectations are shown and changed. Fields that
+  // are not set in the URL are omitted.
+  gold.branchParams = function() {
+    var params = sk.query.toObject(window.location.search.slice(1), {branch: '', issue: ''});
+    return gold.filterEmpty({branch: params.branch, issue: params.issue});
+  };
+
+  // branchQuery returns the branch and issue of the current page as a
+  // query string fragment that can be appended to another query string.
+  gold.branchQuery = function() {
+    var q = sk.query.fromObject(gold.branchParams());
+    return (q === '') ? '' : '&' + q;
   };
 
   // stateFromQuery returns a state object based on the query portion of the URL.
@@ -223,6 +238,8 @@ var gold = gold || {};
   //    makeTriageQuery(testName, digests, status)
   // or an array containing triples (as arrays) with the same information.
   // Note: 'digests' can either be a single string or an array of strings.
+  // The branch and issue of the current page are included so that triaging
+  // on a branch or trybot issue doesn't change the master expectations.
   gold.makeTriageQuery = function(triageList) {
     if (arguments.length === 3) {
       triageList = [[arguments[0], arguments[1], arguments[2]]];
@@ -245,9 +262,9 @@ var gold = gold || {};
         found[digests[i]] = status;
       }
     });
-    return {
-      testDigestStatus: ret
-    };
+    var query = gold.branchParams();
+    query.testDigestStatus = ret;
+    return query;
   };
 
   // flattenTriageQuery is the inverse operation of makeTriageQuery.
@@ -169,6 +169,26 @@ var migrationSteps = []database.MigrationStep{
 		},
 	},
 
+	// Add a table to store the expectations of branches and trybot issues
+	// as deltas over master.
+	// version 11
+	{
+		MySQLUp: []string{
+			`CREATE TABLE exp_branch_change (
+				branch        VARCHAR(255)  NOT NULL,
+				name          VARCHAR(255)  NOT NULL,
+				digest        VARCHAR(255)  NOT NULL,
+				label         VARCHAR(255)  NOT NULL,
+				userid        VARCHAR(255)  NOT NULL,
+				ts            BIGINT        NOT NULL,
+				PRIMARY KEY (branch, name, digest)
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS exp_branch_change`,
+		},
+	},
+
 	// Use this is a template for more migration steps.
 	// version x
 	// {
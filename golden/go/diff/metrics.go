@@ -1,55 +1,276 @@
 package diff
 
 import (
+	"fmt"
 	"image"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
-	METRIC_COMBINED = "combined"
-	METRIC_PERCENT  = "percent"
-	METRIC_PIXEL    = "pixel"
+	METRIC_COMBINED     = "combined"
+	METRIC_IGNORE_ALPHA = "ignorealpha"
+	METRIC_PERCENT      = "percent"
+	METRIC_PIXEL        = "pixel"
+	METRIC_SSIM         = "ssim"
+
+	// METRIC_MASK_PREFIX is the prefix of the ids of the masked diff metrics
+	// registered by SetCorpusMask.
+	METRIC_MASK_PREFIX = "mask-"
+
+	// SSIM_WINDOW_SIZE is the width and height of the windows over which the
+	// structural similarity of two images is computed.
+	SSIM_WINDOW_SIZE = 8
 )
 
-// MetricsFn is the signature a custom diff metric has to implmente.
+// MetricsFn is the signature a custom diff metric has to implmente. Smaller
+// values indicate more similar images, so that all metrics can be sorted the
+// same way.
 type MetricFn func(*DiffMetrics, *image.NRGBA, *image.NRGBA) float32
 
-// metrics contains the custom diff metrics.
-var metrics = map[string]MetricFn{
-	METRIC_COMBINED: combinedDiffMetric,
-	METRIC_PERCENT:  percentDiffMetric,
-	METRIC_PIXEL:    pixelDiffMetric,
-}
+var (
+	// metrics contains the default diff metrics. They are computed by CalcDiff
+	// and stored together with the basic diff metrics.
+	metrics = map[string]MetricFn{
+		METRIC_COMBINED: combinedDiffMetric,
+		METRIC_PERCENT:  percentDiffMetric,
+		METRIC_PIXEL:    pixelDiffMetric,
+	}
+
+	// customMetrics contains the diff metrics added by RegisterMetric,
+	// EnableMetric and SetCorpusMask. They are computed by CalcCustomMetrics
+	// and stored separately from the default diff metrics, so that adding a
+	// metric doesn't require recomputing the diffs.
+	customMetrics = map[string]MetricFn{}
+
+	// optionalMetrics contains the built-in diff metrics which are only
+	// registered by EnableMetric. Every registered metric is computed for
+	// every diff, so they are opt-in per instance.
+	optionalMetrics = map[string]MetricFn{
+		METRIC_IGNORE_ALPHA: ignoreAlphaDiffMetric,
+		METRIC_SSIM:         ssimDiffMetric,
+	}
+
+	// diffMetricIds contains the sorted ids of all diff metrics, default
+	// and custom.
+	diffMetricIds []string
+
+	// customMetricIds contains the sorted ids of the custom diff metrics.
+	customMetricIds []string
 
-// diffMetricIds contains the ids of all diff metrics.
-var diffMetricIds []string
+	// corpusMetrics maps corpora to the diff metric used by default when
+	// searching them.
+	corpusMetrics = map[string]string{}
+
+	// metricsMutex protects customMetrics, diffMetricIds, customMetricIds and
+	// corpusMetrics.
+	metricsMutex sync.RWMutex
+)
 
 func init() {
 	// Extract the ids of the diffmetrics once.
-	diffMetricIds = make([]string, 0, len(metrics))
+	updateDiffMetricIds()
+}
+
+// updateDiffMetricIds updates diffMetricIds and customMetricIds after the set
+// of metrics has changed. Assumes the caller holds metricsMutex, if necessary.
+func updateDiffMetricIds() {
+	custom := make([]string, 0, len(customMetrics))
+	for k := range customMetrics {
+		custom = append(custom, k)
+	}
+	sort.Strings(custom)
+	ids := make([]string, 0, len(metrics)+len(customMetrics))
 	for k := range metrics {
-		diffMetricIds = append(diffMetricIds, k)
+		ids = append(ids, k)
+	}
+	ids = append(ids, custom...)
+	sort.Strings(ids)
+	diffMetricIds = ids
+	customMetricIds = custom
+}
+
+// registerMetric adds the given custom diff metric. Assumes the caller holds
+// metricsMutex.
+func registerMetric(id string, fn MetricFn) error {
+	if id == "" {
+		return fmt.Errorf("Diff metric id must not be empty.")
+	}
+	if _, ok := metrics[id]; ok {
+		return fmt.Errorf("Diff metric %q is already registered.", id)
+	}
+	if _, ok := customMetrics[id]; ok {
+		return fmt.Errorf("Diff metric %q is already registered.", id)
+	}
+	customMetrics[id] = fn
+	updateDiffMetricIds()
+	return nil
+}
+
+// RegisterMetric adds a custom diff metric with the given id. It is computed
+// by a DiffStore when a diff is next requested, without recomputing the
+// default metrics of diffs that were calculated before. Custom metrics should
+// be registered at startup. Returns an error if a metric with the same id was
+// already registered.
+func RegisterMetric(id string, fn MetricFn) error {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if _, ok := optionalMetrics[id]; ok {
+		return fmt.Errorf("Diff metric %q is built in, use EnableMetric.", id)
+	}
+	return registerMetric(id, fn)
+}
+
+// EnableMetric registers the optional built-in diff metric with the given id,
+// eg. METRIC_SSIM. See RegisterMetric. Enabling a metric which is already
+// enabled has no effect.
+func EnableMetric(id string) error {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	fn, ok := optionalMetrics[id]
+	if !ok {
+		return fmt.Errorf("Unknown optional diff metric %q.", id)
+	}
+	if _, ok := customMetrics[id]; ok {
+		return nil
+	}
+	return registerMetric(id, fn)
+}
+
+// MaskMetricID returns the id of the masked diff metric of the given corpus,
+// see SetCorpusMask.
+func MaskMetricID(corpus string) string {
+	return METRIC_MASK_PREFIX + corpus
+}
+
+// SetCorpusMask registers a diff metric which ignores the pixels within the
+// given regions, eg. a clock or other content which is expected to change,
+// and makes it the default diff metric of the given corpus. The id of the
+// metric is MaskMetricID(corpus).
+func SetCorpusMask(corpus string, masks []image.Rectangle) error {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	id := MaskMetricID(corpus)
+	if err := registerMetric(id, NewMaskedMetric(masks)); err != nil {
+		return err
+	}
+	corpusMetrics[corpus] = id
+	return nil
+}
+
+// ParseMasks parses a list of regions in the format "x0,y0,x1,y1 x0,y0,x1,y1",
+// where (x0, y0) is the inclusive top left and (x1, y1) the exclusive bottom
+// right corner of a region.
+func ParseMasks(s string) ([]image.Rectangle, error) {
+	ret := []image.Rectangle{}
+	for _, r := range strings.Fields(s) {
+		parts := strings.Split(r, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("Invalid mask %q: expected x0,y0,x1,y1.", r)
+		}
+		coords := make([]int, 4)
+		for i, p := range parts {
+			c, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid mask %q: %s", r, err)
+			}
+			coords[i] = c
+		}
+		rect := image.Rect(coords[0], coords[1], coords[2], coords[3])
+		if rect.Empty() {
+			return nil, fmt.Errorf("Invalid mask %q: the region is empty.", r)
+		}
+		ret = append(ret, rect)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("No masks given.")
 	}
+	return ret, nil
 }
 
 // GetDiffMetricIDs returns the ids of the available diff metrics.
 func GetDiffMetricIDs() []string {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
 	return diffMetricIds
 }
 
-// CalcDiff calculates the basic difference and then then custom diff metrics.
+// GetCustomMetricIDs returns the ids of the registered custom diff metrics.
+func GetCustomMetricIDs() []string {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	return customMetricIds
+}
+
+// SetCorpusMetric sets the diff metric used by default when searching the
+// given corpus, eg. a perceptual metric for a corpus of text-rendering tests.
+// The metric has to be registered or enabled first.
+func SetCorpusMetric(corpus, id string) error {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	_, isDefault := metrics[id]
+	_, isCustom := customMetrics[id]
+	if !isDefault && !isCustom {
+		return fmt.Errorf("Unknown diff metric %q for corpus %q.", id, corpus)
+	}
+	corpusMetrics[corpus] = id
+	return nil
+}
+
+// GetCorpusMetric returns the diff metric used by default when searching the
+// given corpus, or dflt if none was set.
+func GetCorpusMetric(corpus, dflt string) string {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	if id, ok := corpusMetrics[corpus]; ok {
+		return id
+	}
+	return dflt
+}
+
+// CalcDiff calculates the basic difference and then the default diff metrics.
+// The custom diff metrics are calculated separately by CalcCustomMetrics.
 func CalcDiff(leftImg *image.NRGBA, rightImg *image.NRGBA) (*DiffMetrics, *image.NRGBA) {
 	ret, diffImg := Diff(leftImg, rightImg)
-
-	// Calcluate the metrics.
-	diffs := make(map[string]float32, len(diffMetricIds))
-	for _, id := range diffMetricIds {
-		diffs[id] = metrics[id](ret, leftImg, rightImg)
+	ret.Diffs = make(map[string]float32, len(metrics))
+	for id, fn := range metrics {
+		ret.Diffs[id] = fn(ret, leftImg, rightImg)
 	}
-	ret.Diffs = diffs
 	return ret, diffImg
 }
 
+// MissingCustomMetrics returns true if any of the registered custom diff
+// metrics is not contained in values, eg. because values were loaded from a
+// cache before the metric was registered.
+func MissingCustomMetrics(values map[string]float32) bool {
+	for _, id := range GetCustomMetricIDs() {
+		if _, ok := values[id]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CalcCustomMetrics calculates the registered custom diff metrics which are
+// not yet contained in values for the given basic diff and images. Existing
+// values are left unchanged. Returns values, which is allocated if it is nil.
+func CalcCustomMetrics(values map[string]float32, dm *DiffMetrics, leftImg *image.NRGBA, rightImg *image.NRGBA) map[string]float32 {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	if values == nil {
+		values = make(map[string]float32, len(customMetricIds))
+	}
+	for _, id := range customMetricIds {
+		if _, ok := values[id]; !ok {
+			values[id] = customMetrics[id](dm, leftImg, rightImg)
+		}
+	}
+	return values
+}
+
 // combinedDiffMetric returns a value in [0, 1] that represents how large
 // the diff is between two images. Implements the MetricFn signature.
 func combinedDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
@@ -80,3 +301,135 @@ func percentDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) f
 func pixelDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
 	return float32(basic.NumDiffPixels)
 }
+
+// ignoreAlphaDiffMetric returns the percentage of pixels whose color channels
+// differ, ignoring differences in the alpha channel. If the images have
+// different dimensions all pixels are considered different. Implements the
+// MetricFn signature.
+func ignoreAlphaDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.DimDiffer || !one.Bounds().Eq(two.Bounds()) {
+		return 100
+	}
+	if basic.NumDiffPixels == 0 {
+		return 0
+	}
+	bounds := one.Bounds()
+	numDiff := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := one.PixOffset(x, y)
+			j := two.PixOffset(x, y)
+			p1 := one.Pix[i : i+4]
+			p2 := two.Pix[j : j+4]
+			if p1[0] != p2[0] || p1[1] != p2[1] || p1[2] != p2[2] {
+				numDiff++
+			}
+		}
+	}
+	return getPixelDiffPercent(numDiff, bounds.Dx()*bounds.Dy())
+}
+
+// NewMaskedMetric returns a MetricFn which computes the percentage of the
+// pixels outside of the given regions that differ between two images. If the
+// images have different dimensions all pixels are considered different.
+func NewMaskedMetric(masks []image.Rectangle) MetricFn {
+	return func(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
+		if basic.DimDiffer || !one.Bounds().Eq(two.Bounds()) {
+			return 100
+		}
+		bounds := one.Bounds()
+		total := 0
+		numDiff := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if inMasks(x, y, masks) {
+					continue
+				}
+				total++
+				i := one.PixOffset(x, y)
+				j := two.PixOffset(x, y)
+				p1 := one.Pix[i : i+4]
+				p2 := two.Pix[j : j+4]
+				if p1[0] != p2[0] || p1[1] != p2[1] || p1[2] != p2[2] || p1[3] != p2[3] {
+					numDiff++
+				}
+			}
+		}
+		if total == 0 {
+			return 0
+		}
+		return getPixelDiffPercent(numDiff, total)
+	}
+}
+
+// inMasks returns true if the given point is inside any of the masks.
+func inMasks(x, y int, masks []image.Rectangle) bool {
+	pt := image.Pt(x, y)
+	for _, m := range masks {
+		if pt.In(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ssimDiffMetric returns a perceptual dissimilarity in [0, 1] based on the
+// mean structural similarity (SSIM) of the luminance of the two images over
+// SSIM_WINDOW_SIZE x SSIM_WINDOW_SIZE windows. Unlike the pixel-based
+// metrics it is insensitive to small differences in anti-aliasing, which
+// makes it suitable for text-rendering tests. Implements the MetricFn
+// signature.
+func ssimDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.DimDiffer || !one.Bounds().Eq(two.Bounds()) {
+		return 1
+	}
+	if basic.NumDiffPixels == 0 {
+		return 0
+	}
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	bounds := one.Bounds()
+	sum := 0.0
+	windows := 0
+	for y0 := bounds.Min.Y; y0 < bounds.Max.Y; y0 += SSIM_WINDOW_SIZE {
+		for x0 := bounds.Min.X; x0 < bounds.Max.X; x0 += SSIM_WINDOW_SIZE {
+			var sum1, sum2, sumSq1, sumSq2, sumProd float64
+			n := 0
+			for y := y0; y < y0+SSIM_WINDOW_SIZE && y < bounds.Max.Y; y++ {
+				for x := x0; x < x0+SSIM_WINDOW_SIZE && x < bounds.Max.X; x++ {
+					l1 := luminance(one, x, y)
+					l2 := luminance(two, x, y)
+					sum1 += l1
+					sum2 += l2
+					sumSq1 += l1 * l1
+					sumSq2 += l2 * l2
+					sumProd += l1 * l2
+					n++
+				}
+			}
+			fn := float64(n)
+			mean1 := sum1 / fn
+			mean2 := sum2 / fn
+			var1 := sumSq1/fn - mean1*mean1
+			var2 := sumSq2/fn - mean2*mean2
+			cov := sumProd/fn - mean1*mean2
+			sum += ((2*mean1*mean2 + c1) * (2*cov + c2)) / ((mean1*mean1 + mean2*mean2 + c1) * (var1 + var2 + c2))
+			windows++
+		}
+	}
+	ssim := sum / float64(windows)
+	// SSIM is in [-1, 1] where 1 means identical. Map it to [0, 1] where 0
+	// means identical.
+	return float32(math.Max(0, math.Min(1, (1-ssim)/2)))
+}
+
+// luminance returns the luminance of the given pixel, premultiplied by its
+// alpha value, in [0, 255].
+func luminance(img *image.NRGBA, x, y int) float64 {
+	i := img.PixOffset(x, y)
+	p := img.Pix[i : i+4]
+	l := 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+	return l * float64(p[3]) / 255.0
+}
@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
+)
+
+// solidImage returns a w x h image filled with the given color.
+func solidImage(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// unregisterMetric removes the given custom metric and the corpus metric of
+// the given corpus, if any. Used to reset the registry after a test.
+func unregisterMetric(id, corpus string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	delete(customMetrics, id)
+	delete(corpusMetrics, corpus)
+	updateDiffMetricIds()
+}
+
+func TestRegisterMetric(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Error(t, RegisterMetric(METRIC_PERCENT, percentDiffMetric))
+	assert.Error(t, RegisterMetric("", percentDiffMetric))
+
+	id := "test-constant"
+	assert.NoError(t, RegisterMetric(id, func(*DiffMetrics, *image.NRGBA, *image.NRGBA) float32 { return 42 }))
+	defer unregisterMetric(id, "")
+	assert.Error(t, RegisterMetric(id, percentDiffMetric))
+	assert.Contains(t, GetDiffMetricIDs(), id)
+	assert.Equal(t, []string{id}, GetCustomMetricIDs())
+
+	// CalcDiff only calculates the default metrics.
+	one := solidImage(4, 4, color.NRGBA{0, 0, 0, 255})
+	two := solidImage(4, 4, color.NRGBA{255, 0, 0, 255})
+	dm, _ := CalcDiff(one, two)
+	assert.Equal(t, float32(100), dm.Diffs[METRIC_PERCENT])
+	_, ok := dm.Diffs[id]
+	assert.False(t, ok)
+
+	values := CalcCustomMetrics(nil, dm, one, two)
+	assert.Equal(t, map[string]float32{id: 42}, values)
+	assert.False(t, MissingCustomMetrics(values))
+
+	// Only missing metrics are calculated.
+	assert.True(t, MissingCustomMetrics(map[string]float32{}))
+	values = map[string]float32{"other": 7}
+	CalcCustomMetrics(values, dm, one, two)
+	assert.Equal(t, map[string]float32{id: 42, "other": 7}, values)
+	values[id] = 3
+	CalcCustomMetrics(values, dm, one, two)
+	assert.Equal(t, float32(3), values[id])
+}
+
+func TestEnableMetric(t *testing.T) {
+	testutils.SmallTest(t)
+	// Only the default metrics are computed initially.
+	assert.Equal(t, []string{METRIC_COMBINED, METRIC_PERCENT, METRIC_PIXEL}, GetDiffMetricIDs())
+	assert.Equal(t, []string{}, GetCustomMetricIDs())
+	assert.False(t, MissingCustomMetrics(nil))
+
+	assert.Error(t, EnableMetric("bogus"))
+	assert.Error(t, EnableMetric(METRIC_PERCENT))
+	assert.Error(t, RegisterMetric(METRIC_SSIM, ssimDiffMetric))
+	assert.NoError(t, EnableMetric(METRIC_SSIM))
+	defer unregisterMetric(METRIC_SSIM, "")
+	assert.NoError(t, EnableMetric(METRIC_SSIM))
+	assert.Equal(t, []string{METRIC_COMBINED, METRIC_PERCENT, METRIC_PIXEL, METRIC_SSIM}, GetDiffMetricIDs())
+	assert.Equal(t, []string{METRIC_SSIM}, GetCustomMetricIDs())
+	assert.True(t, MissingCustomMetrics(nil))
+}
+
+func TestCorpusMetric(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, METRIC_COMBINED, GetCorpusMetric("text", METRIC_COMBINED))
+	assert.Error(t, SetCorpusMetric("text", "bogus"))
+	// Optional metrics have to be enabled first.
+	assert.Error(t, SetCorpusMetric("text", METRIC_SSIM))
+	assert.NoError(t, EnableMetric(METRIC_SSIM))
+	defer unregisterMetric(METRIC_SSIM, "text")
+	assert.NoError(t, SetCorpusMetric("text", METRIC_SSIM))
+	assert.Equal(t, METRIC_SSIM, GetCorpusMetric("text", METRIC_COMBINED))
+	assert.Equal(t, METRIC_COMBINED, GetCorpusMetric("gm", METRIC_COMBINED))
+}
+
+func TestCorpusMask(t *testing.T) {
+	testutils.SmallTest(t)
+	_, err := ParseMasks("")
+	assert.Error(t, err)
+	_, err = ParseMasks("0,0,10")
+	assert.Error(t, err)
+	_, err = ParseMasks("0,0,a,10")
+	assert.Error(t, err)
+	_, err = ParseMasks("5,5,5,10")
+	assert.Error(t, err)
+	masks, err := ParseMasks("9,9,10,10  0,0,2,3")
+	assert.NoError(t, err)
+	assert.Equal(t, []image.Rectangle{image.Rect(9, 9, 10, 10), image.Rect(0, 0, 2, 3)}, masks)
+
+	id := MaskMetricID("clock")
+	assert.NoError(t, SetCorpusMask("clock", masks[:1]))
+	defer unregisterMetric(id, "clock")
+	assert.Error(t, SetCorpusMask("clock", masks))
+	assert.Equal(t, id, GetCorpusMetric("clock", METRIC_COMBINED))
+	assert.Equal(t, []string{id}, GetCustomMetricIDs())
+
+	// The pixel with the color difference is masked.
+	one := solidImage(10, 10, color.NRGBA{10, 20, 30, 255})
+	two := solidImage(10, 10, color.NRGBA{10, 20, 30, 255})
+	two.SetNRGBA(9, 9, color.NRGBA{11, 20, 30, 255})
+	dm, _ := CalcDiff(one, two)
+	assert.Equal(t, float32(1), dm.Diffs[METRIC_PERCENT])
+	assert.Equal(t, map[string]float32{id: 0}, CalcCustomMetrics(nil, dm, one, two))
+}
+
+func TestMaskedMetric(t *testing.T) {
+	testutils.SmallTest(t)
+	one := solidImage(10, 10, color.NRGBA{10, 20, 30, 255})
+	two := solidImage(10, 10, color.NRGBA{10, 20, 30, 255})
+	// One pixel differs in alpha only, one in color.
+	two.SetNRGBA(0, 0, color.NRGBA{10, 20, 30, 128})
+	two.SetNRGBA(9, 9, color.NRGBA{11, 20, 30, 255})
+	dm, _ := Diff(one, two)
+
+	assert.Equal(t, float32(2), NewMaskedMetric(nil)(dm, one, two))
+	// Masking the pixel with the color difference leaves only the alpha
+	// difference, out of 99 pixels.
+	masked := NewMaskedMetric([]image.Rectangle{image.Rect(9, 9, 10, 10)})
+	assert.InDelta(t, 100.0/99.0, float64(masked(dm, one, two)), 0.0001)
+	masked = NewMaskedMetric([]image.Rectangle{image.Rect(0, 0, 10, 10)})
+	assert.Equal(t, float32(0), masked(dm, one, two))
+
+	// Different dimensions.
+	three := solidImage(5, 5, color.NRGBA{10, 20, 30, 255})
+	dm, _ = Diff(one, three)
+	assert.Equal(t, float32(100), masked(dm, one, three))
+}
+
+func TestIgnoreAlphaDiffMetric(t *testing.T) {
+	testutils.SmallTest(t)
+	one := solidImage(10, 10, color.NRGBA{10, 20, 30, 255})
+	two := solidImage(10, 10, color.NRGBA{10, 20, 30, 255})
+	// One pixel differs in alpha only, one in color.
+	two.SetNRGBA(0, 0, color.NRGBA{10, 20, 30, 128})
+	two.SetNRGBA(9, 9, color.NRGBA{11, 20, 30, 255})
+	dm, _ := Diff(one, two)
+	assert.Equal(t, 2, dm.NumDiffPixels)
+
+	assert.Equal(t, float32(1), ignoreAlphaDiffMetric(dm, one, two))
+
+	// Different dimensions.
+	three := solidImage(5, 5, color.NRGBA{10, 20, 30, 255})
+	dm, _ = Diff(one, three)
+	assert.Equal(t, float32(100), ignoreAlphaDiffMetric(dm, one, three))
+}
+
+func TestSSIMDiffMetric(t *testing.T) {
+	testutils.SmallTest(t)
+	black := solidImage(16, 16, color.NRGBA{0, 0, 0, 255})
+	white := solidImage(16, 16, color.NRGBA{255, 255, 255, 255})
+
+	// Identical images.
+	dm, _ := Diff(black, black)
+	assert.Equal(t, float32(0), ssimDiffMetric(dm, black, black))
+
+	// Completely different images.
+	dm, _ = Diff(black, white)
+	opposite := ssimDiffMetric(dm, black, white)
+	assert.True(t, opposite > 0.4)
+
+	// A slight change in a single pixel is perceptually small, even though
+	// its channel difference is large.
+	slight := solidImage(16, 16, color.NRGBA{0, 0, 0, 255})
+	slight.SetNRGBA(3, 3, color.NRGBA{40, 40, 40, 255})
+	dm, _ = Diff(black, slight)
+	small := ssimDiffMetric(dm, black, slight)
+	assert.True(t, small > 0)
+	assert.True(t, small < opposite)
+
+	// Different dimensions.
+	dm, _ = Diff(black, solidImage(8, 8, color.NRGBA{0, 0, 0, 255}))
+	assert.Equal(t, float32(1), ssimDiffMetric(dm, black, solidImage(8, 8, color.NRGBA{0, 0, 0, 255})))
+}
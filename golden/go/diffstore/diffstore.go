@@ -52,6 +52,11 @@ type MemDiffStore struct {
 	// diffMetricsCache caches and calculates diff metrics and images.
 	diffMetricsCache rtcache.ReadThroughCache
 
+	// customMetricsCache caches and calculates the values of the custom diff
+	// metrics, see diff.RegisterMetric. They are cached separately from the
+	// diff metrics, so that registering a metric doesn't invalidate the diffs.
+	customMetricsCache rtcache.ReadThroughCache
+
 	// imgLoader fetches and caches images.
 	imgLoader *ImageLoader
 
@@ -92,6 +97,10 @@ func New(client *http.Client, baseDir string, gsBucketNames []string, gsImageBas
 		return nil, err
 	}
 
+	if ret.customMetricsCache, err = rtcache.New(ret.customMetricsWorker, diffCacheCount, runtime.NumCPU()); err != nil {
+		return nil, err
+	}
+
 	return ret, nil
 }
 
@@ -116,12 +125,19 @@ func (d *MemDiffStore) WarmDiffs(priority int64, leftDigests []string, rightDige
 	priority = rtcache.PriorityTimeCombined(priority)
 	diffIDs := getDiffIds(leftDigests, rightDigests)
 	sklog.Infof("Warming %d diffs", len(diffIDs))
+	hasCustomMetrics := len(diff.GetCustomMetricIDs()) > 0
 	d.wg.Add(len(diffIDs))
 	for _, id := range diffIDs {
 		go func(id string) {
 			defer d.wg.Done()
 			if err := d.diffMetricsCache.Warm(priority, id); err != nil {
 				sklog.Errorf("Unable to warm diff %s. Got error: %s", id, err)
+				return
+			}
+			if hasCustomMetrics {
+				if err := d.customMetricsCache.Warm(priority, id); err != nil {
+					sklog.Errorf("Unable to warm custom diff metrics %s. Got error: %s", id, err)
+				}
 			}
 		}(id)
 	}
@@ -138,6 +154,7 @@ func (d *MemDiffStore) Get(priority int64, mainDigest string, rightDigests []str
 	}
 
 	diffMap := make(map[string]*diff.DiffMetrics, len(rightDigests))
+	hasCustomMetrics := len(diff.GetCustomMetricIDs()) > 0
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
 	for _, right := range rightDigests {
@@ -152,9 +169,18 @@ func (d *MemDiffStore) Get(priority int64, mainDigest string, rightDigests []str
 					sklog.Errorf("Unable to calculate diff for %s. Got error: %s", id, err)
 					return
 				}
+				dm := ret.(*diff.DiffMetrics)
+				if hasCustomMetrics {
+					values, err := d.customMetricsCache.Get(priority, id)
+					if err != nil {
+						sklog.Errorf("Unable to calculate custom diff metrics for %s. Got error: %s", id, err)
+					} else {
+						dm = withCustomMetrics(dm, values.(map[string]float32))
+					}
+				}
 				mutex.Lock()
 				defer mutex.Unlock()
-				diffMap[right] = ret.(*diff.DiffMetrics)
+				diffMap[right] = dm
 			}(right)
 		}
 	}
@@ -191,6 +217,7 @@ func (m *MemDiffStore) PurgeDigests(digests []string, purgeGCS bool) error {
 		}
 	}
 	m.diffMetricsCache.Remove(removeKeys)
+	m.customMetricsCache.Remove(removeKeys)
 
 	if err := m.metricsStore.purgeMetrics(digests); err != nil {
 		return err
@@ -265,7 +292,8 @@ func (d *MemDiffStore) diffMetricsWorker(priority int64, id string) (interface{}
 	leftDigest, rightDigest := splitDigests(id)
 
 	// Load it from disk cache if necessary.
-	if dm, err := d.metricsStore.loadDiffMetric(id); err != nil {
+	dm, err := d.metricsStore.loadDiffMetric(id)
+	if err != nil {
 		sklog.Errorf("Error trying to load diff metric: %s", err)
 	} else if dm != nil {
 		return dm, nil
@@ -292,21 +320,83 @@ func (d *MemDiffStore) diffMetricsWorker(priority int64, id string) (interface{}
 	return diffRec, nil
 }
 
+// customMetricsWorker calculates the values of the custom diff metrics if
+// they are not in the cache. Only the metrics which are missing from the disk
+// cache, eg. because they were registered after the values were saved, are
+// calculated.
+func (d *MemDiffStore) customMetricsWorker(priority int64, id string) (interface{}, error) {
+	// Load it from disk cache if necessary.
+	values, err := d.metricsStore.loadCustomMetrics(id)
+	if err != nil {
+		sklog.Errorf("Error trying to load custom diff metrics: %s", err)
+	} else if values != nil && !diff.MissingCustomMetrics(values) {
+		return values, nil
+	}
+
+	// The custom metrics are based on the diff metrics and the images.
+	dm, err := d.diffMetricsCache.Get(priority, id)
+	if err != nil {
+		return nil, err
+	}
+	leftDigest, rightDigest := splitDigests(id)
+	imgs, err := d.imgLoader.Get(priority, []string{leftDigest, rightDigest})
+	if err != nil {
+		return nil, err
+	}
+
+	values = diff.CalcCustomMetrics(values, dm.(*diff.DiffMetrics), imgs[0], imgs[1])
+	d.saveCustomMetricsAsync(id, values)
+	return values, nil
+}
+
+// withCustomMetrics returns a copy of the given diff metrics which also
+// contains the given values of the custom diff metrics. The cached diff
+// metrics are not modified.
+func withCustomMetrics(dm *diff.DiffMetrics, values map[string]float32) *diff.DiffMetrics {
+	ret := *dm
+	ret.Diffs = make(map[string]float32, len(dm.Diffs)+len(values))
+	for id, val := range dm.Diffs {
+		ret.Diffs[id] = val
+	}
+	for id, val := range values {
+		ret.Diffs[id] = val
+	}
+	return &ret
+}
+
 // saveDiffInfoAsync saves the given diff information to disk asynchronously.
 func (d *MemDiffStore) saveDiffInfoAsync(diffID, leftDigest, rightDigest string, dr *diff.DiffMetrics, imgBytes []byte) {
-	d.wg.Add(2)
+	d.saveDiffMetricAsync(diffID, dr)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		imageFileName := getDiffImgFileName(leftDigest, rightDigest)
+		if err := saveFileRadixPath(d.localDiffDir, imageFileName, bytes.NewBuffer(imgBytes)); err != nil {
+			sklog.Error(err)
+		}
+	}()
+}
+
+// saveDiffMetricAsync saves the given diff metrics to disk asynchronously.
+func (d *MemDiffStore) saveDiffMetricAsync(diffID string, dr *diff.DiffMetrics) {
+	d.wg.Add(1)
 	go func() {
 		defer d.wg.Done()
 		if err := d.metricsStore.saveDiffMetric(diffID, dr); err != nil {
 			sklog.Errorf("Error saving diff metric: %s", err)
 		}
 	}()
+}
 
+// saveCustomMetricsAsync saves the given values of the custom diff metrics to
+// disk asynchronously.
+func (d *MemDiffStore) saveCustomMetricsAsync(diffID string, values map[string]float32) {
+	d.wg.Add(1)
 	go func() {
 		defer d.wg.Done()
-		imageFileName := getDiffImgFileName(leftDigest, rightDigest)
-		if err := saveFileRadixPath(d.localDiffDir, imageFileName, bytes.NewBuffer(imgBytes)); err != nil {
-			sklog.Error(err)
+		if err := d.metricsStore.saveCustomMetrics(diffID, values); err != nil {
+			sklog.Errorf("Error saving custom diff metrics: %s", err)
 		}
 	}()
 }
@@ -11,19 +11,36 @@ const (
 	// METRICSDB_NAME is the name of the boltdb caching diff metrics.
 	METRICSDB_NAME = "diffstore_metrics"
 
+	// CUSTOM_METRICS_BUCKET is the name of the bucket in the metrics db which
+	// caches the values of the custom diff metrics, see diff.RegisterMetric.
+	CUSTOM_METRICS_BUCKET = "diffstore_custom_metrics"
+
 	// METRICS_DIGEST_INDEX is the index name to keep track of digests in the metrics db.
 	METRICS_DIGEST_INDEX = "metric_digest_index"
+
+	// CUSTOM_METRICS_DIGEST_INDEX is the index name to keep track of digests in
+	// the custom metrics bucket. Index buckets are shared by all buckets of a
+	// database, so it has to differ from METRICS_DIGEST_INDEX.
+	CUSTOM_METRICS_DIGEST_INDEX = "custom_metric_digest_index"
 )
 
 // metricsStore stores diff metrics on disk.
 type metricsStore struct {
 	// store stores the diff metrics in a boltdb database.
 	store *boltutil.IndexedBucket
+
+	// customStore stores the values of the custom diff metrics in the same
+	// database. They are kept apart from the diff metrics, so that adding a
+	// custom metric doesn't require rewriting the diff metrics.
+	customStore *boltutil.IndexedBucket
 }
 
 // metricsRecIndices are  the indices supported by the metricsRec type.
 var metricsRecIndices = []string{METRICS_DIGEST_INDEX}
 
+// customMetricsRecIndices are the indices supported by the customMetricsRec type.
+var customMetricsRecIndices = []string{CUSTOM_METRICS_DIGEST_INDEX}
+
 // metricsRec implements the boltutil.Record interface.
 type metricsRec struct {
 	ID string `json:"id"`
@@ -41,6 +58,23 @@ func (m *metricsRec) IndexValues() map[string][]string {
 	return map[string][]string{METRICS_DIGEST_INDEX: {d1, d2}}
 }
 
+// customMetricsRec implements the boltutil.Record interface.
+type customMetricsRec struct {
+	ID     string             `json:"id"`
+	Values map[string]float32 `json:"values"`
+}
+
+// Key see the boltutil.Record interface.
+func (m *customMetricsRec) Key() string {
+	return m.ID
+}
+
+// IndexValues see the boltutil.Record interface.
+func (m *customMetricsRec) IndexValues() map[string][]string {
+	d1, d2 := splitDigests(m.ID)
+	return map[string][]string{CUSTOM_METRICS_DIGEST_INDEX: {d1, d2}}
+}
+
 // newMetricsStore returns a new instance of metricsStore.
 func newMetricStore(baseDir string) (*metricsStore, error) {
 	db, err := openBoltDB(baseDir, METRICSDB_NAME+".db")
@@ -59,8 +93,20 @@ func newMetricStore(baseDir string) (*metricsStore, error) {
 		return nil, err
 	}
 
+	customConfig := &boltutil.Config{
+		DB:      db,
+		Name:    CUSTOM_METRICS_BUCKET,
+		Indices: customMetricsRecIndices,
+		Codec:   util.JSONCodec(&customMetricsRec{}),
+	}
+	customStore, err := boltutil.NewIndexedBucket(customConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &metricsStore{
-		store: store,
+		store:       store,
+		customStore: customStore,
 	}, nil
 }
 
@@ -83,6 +129,25 @@ func (m *metricsStore) saveDiffMetric(id string, dr *diff.DiffMetrics) error {
 	return m.store.Insert([]boltutil.Record{rec})
 }
 
+// loadCustomMetrics loads the values of the custom diff metrics from disk.
+func (m *metricsStore) loadCustomMetrics(id string) (map[string]float32, error) {
+	recs, err := m.customStore.Read([]string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	if recs[0] == nil {
+		return nil, nil
+	}
+	return recs[0].(*customMetricsRec).Values, nil
+}
+
+// saveCustomMetrics stores the values of the custom diff metrics to disk.
+func (m *metricsStore) saveCustomMetrics(id string, values map[string]float32) error {
+	rec := &customMetricsRec{ID: id, Values: values}
+	return m.customStore.Insert([]boltutil.Record{rec})
+}
+
 // purgeMetrics removes all metrics that based on a specific digest.
 func (m *metricsStore) purgeMetrics(digests []string) error {
 	updateFn := func(tx *bolt.Tx) error {
@@ -96,7 +161,21 @@ func (m *metricsStore) purgeMetrics(digests []string) error {
 			metricIds.AddLists(ids)
 		}
 
-		return m.store.DeleteTx(tx, metricIds.Keys())
+		if err := m.store.DeleteTx(tx, metricIds.Keys()); err != nil {
+			return err
+		}
+
+		customIDMap, err := m.customStore.ReadIndexTx(tx, CUSTOM_METRICS_DIGEST_INDEX, digests)
+		if err != nil {
+			return err
+		}
+
+		customIds := util.StringSet{}
+		for _, ids := range customIDMap {
+			customIds.AddLists(ids)
+		}
+
+		return m.customStore.DeleteTx(tx, customIds.Keys())
 	}
 
 	return m.store.DB.Update(updateFn)
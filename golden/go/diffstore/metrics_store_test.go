@@ -0,0 +1,72 @@
+package diffstore
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/diff"
+)
+
+func TestMetricsStore(t *testing.T) {
+	testutils.MediumTest(t)
+
+	baseDir := TEST_DATA_BASE_DIR + "-metricsstore"
+	defer testutils.RemoveAll(t, baseDir)
+
+	mStore, err := newMetricStore(baseDir)
+	assert.NoError(t, err)
+
+	id1 := combineDigests("aaaa", "bbbb")
+	id2 := combineDigests("cccc", "dddd")
+	dm := &diff.DiffMetrics{
+		NumDiffPixels:    5,
+		PixelDiffPercent: 0.5,
+		MaxRGBADiffs:     []int{1, 2, 3, 4},
+		Diffs:            map[string]float32{diff.METRIC_PIXEL: 5},
+	}
+	assert.NoError(t, mStore.saveDiffMetric(id1, dm))
+	assert.NoError(t, mStore.saveDiffMetric(id2, dm))
+
+	// The custom metrics are stored apart from the diff metrics.
+	values, err := mStore.loadCustomMetrics(id1)
+	assert.NoError(t, err)
+	assert.Nil(t, values)
+	assert.NoError(t, mStore.saveCustomMetrics(id1, map[string]float32{diff.METRIC_SSIM: 0.25}))
+	assert.NoError(t, mStore.saveCustomMetrics(id2, map[string]float32{diff.METRIC_SSIM: 0.5}))
+	values, err = mStore.loadCustomMetrics(id1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float32{diff.METRIC_SSIM: 0.25}, values)
+
+	found, err := mStore.loadDiffMetric(id1)
+	assert.NoError(t, err)
+	assert.Equal(t, dm, found)
+
+	// Purging a digest removes both.
+	assert.NoError(t, mStore.purgeMetrics([]string{"aaaa"}))
+	found, err = mStore.loadDiffMetric(id1)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+	values, err = mStore.loadCustomMetrics(id1)
+	assert.NoError(t, err)
+	assert.Nil(t, values)
+	values, err = mStore.loadCustomMetrics(id2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float32{diff.METRIC_SSIM: 0.5}, values)
+}
+
+func TestWithCustomMetrics(t *testing.T) {
+	testutils.SmallTest(t)
+
+	dm := &diff.DiffMetrics{
+		NumDiffPixels: 5,
+		Diffs:         map[string]float32{diff.METRIC_PIXEL: 5},
+	}
+	ret := withCustomMetrics(dm, map[string]float32{diff.METRIC_SSIM: 0.25})
+	assert.Equal(t, map[string]float32{diff.METRIC_PIXEL: 5, diff.METRIC_SSIM: 0.25}, ret.Diffs)
+	assert.Equal(t, 5, ret.NumDiffPixels)
+
+	// The cached diff metrics are unchanged.
+	assert.Equal(t, map[string]float32{diff.METRIC_PIXEL: 5}, dm.Diffs)
+}
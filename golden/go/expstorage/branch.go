@@ -0,0 +1,307 @@
+package expstorage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// ISSUE_BRANCH_PREFIX is the prefix of the branch names used for the
+	// expectations of trybot issues.
+	ISSUE_BRANCH_PREFIX = "issue:"
+
+	// MAX_BRANCH_NAME_LENGTH is the maximum length of a branch name.
+	MAX_BRANCH_NAME_LENGTH = 255
+)
+
+// IssueBranch returns the name of the branch which holds the expectations of
+// the given trybot issue.
+func IssueBranch(issueID string) string {
+	return ISSUE_BRANCH_PREFIX + issueID
+}
+
+// ValidateBranch returns an error if the given branch name is not valid.
+func ValidateBranch(branch string) error {
+	if branch == "" {
+		return fmt.Errorf("Branch name must not be empty.")
+	}
+	if len(branch) > MAX_BRANCH_NAME_LENGTH {
+		return fmt.Errorf("Branch name must be at most %d characters.", MAX_BRANCH_NAME_LENGTH)
+	}
+	return nil
+}
+
+// Merge returns a copy of the expectations with the given delta applied.
+func (e *Expectations) Merge(delta *Expectations) *Expectations {
+	ret := e.DeepCopy()
+	ret.AddDigests(delta.Tests)
+	return ret
+}
+
+// BranchExpectationsStore layers the expectations of branches, e.g. release
+// branches or trybot issues, over the master expectations, so that triaging on
+// a branch does not change master. Each branch holds a delta over master.
+type BranchExpectationsStore interface {
+	// Get returns the master expectations merged with the delta of the given
+	// branch.
+	Get(branch string) (*Expectations, error)
+
+	// GetDelta returns only the delta of the given branch.
+	GetDelta(branch string) (*Expectations, error)
+
+	// AddChange adds the given classified digests to the delta of the given
+	// branch.
+	AddChange(branch string, changes map[string]types.TestClassification, userId string) error
+
+	// Promote applies the delta of the given branch to master as a single
+	// change recorded under the given user and removes the branch. Returns
+	// the changes applied to master, i.e. the part of the delta which differed
+	// from master.
+	Promote(branch string, userId string) (map[string]types.TestClassification, error)
+
+	// RemoveBranch discards the delta of the given branch.
+	RemoveBranch(branch string) error
+
+	// Branches returns the sorted names of the branches which have a delta.
+	Branches() ([]string, error)
+}
+
+// deltaStore stores the deltas of branches. It is used by
+// branchExpectationsStore.
+type deltaStore interface {
+	get(branch string) (map[string]types.TestClassification, error)
+	add(branch string, changes map[string]types.TestClassification, userId string) error
+	remove(branch string) error
+	branches() ([]string, error)
+}
+
+// branchExpectationsStore implements BranchExpectationsStore on top of an
+// ExpectationsStore for master and a deltaStore for the branches.
+type branchExpectationsStore struct {
+	master ExpectationsStore
+	deltas deltaStore
+
+	// Serializes promotions.
+	mutex sync.Mutex
+}
+
+// NewMemBranchExpectationsStore returns a BranchExpectationsStore which keeps
+// the branch deltas in memory, for testing.
+func NewMemBranchExpectationsStore(master ExpectationsStore) BranchExpectationsStore {
+	return &branchExpectationsStore{
+		master: master,
+		deltas: &memDeltaStore{
+			deltas: map[string]*Expectations{},
+		},
+	}
+}
+
+// NewSQLBranchExpectationsStore returns a BranchExpectationsStore which keeps
+// the branch deltas in an SQL database.
+func NewSQLBranchExpectationsStore(vdb *database.VersionedDB, master ExpectationsStore) BranchExpectationsStore {
+	return &branchExpectationsStore{
+		master: master,
+		deltas: &sqlDeltaStore{vdb: vdb},
+	}
+}
+
+// See BranchExpectationsStore interface.
+func (b *branchExpectationsStore) Get(branch string) (*Expectations, error) {
+	delta, err := b.GetDelta(branch)
+	if err != nil {
+		return nil, err
+	}
+	master, err := b.master.Get()
+	if err != nil {
+		return nil, err
+	}
+	return master.Merge(delta), nil
+}
+
+// See BranchExpectationsStore interface.
+func (b *branchExpectationsStore) GetDelta(branch string) (*Expectations, error) {
+	if err := ValidateBranch(branch); err != nil {
+		return nil, err
+	}
+	tests, err := b.deltas.get(branch)
+	if err != nil {
+		return nil, err
+	}
+	return &Expectations{Tests: tests}, nil
+}
+
+// See BranchExpectationsStore interface.
+func (b *branchExpectationsStore) AddChange(branch string, changes map[string]types.TestClassification, userId string) error {
+	if err := ValidateBranch(branch); err != nil {
+		return err
+	}
+	return b.deltas.add(branch, changes, userId)
+}
+
+// See BranchExpectationsStore interface.
+func (b *branchExpectationsStore) Promote(branch string, userId string) (map[string]types.TestClassification, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	merged, err := b.Get(branch)
+	if err != nil {
+		return nil, err
+	}
+	master, err := b.master.Get()
+	if err != nil {
+		return nil, err
+	}
+	// Only apply the part of the delta which differs from master. Since the
+	// delta only adds labels, nothing is removed from master.
+	changes, _ := master.Delta(merged)
+	if len(changes.Tests) > 0 {
+		if err := b.master.AddChange(changes.Tests, userId); err != nil {
+			return nil, err
+		}
+	}
+	return changes.Tests, b.deltas.remove(branch)
+}
+
+// See BranchExpectationsStore interface.
+func (b *branchExpectationsStore) RemoveBranch(branch string) error {
+	if err := ValidateBranch(branch); err != nil {
+		return err
+	}
+	return b.deltas.remove(branch)
+}
+
+// See BranchExpectationsStore interface.
+func (b *branchExpectationsStore) Branches() ([]string, error) {
+	return b.deltas.branches()
+}
+
+// memDeltaStore implements deltaStore in memory.
+type memDeltaStore struct {
+	deltas map[string]*Expectations
+	mutex  sync.RWMutex
+}
+
+// See deltaStore interface.
+func (m *memDeltaStore) get(branch string) (map[string]types.TestClassification, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if delta, ok := m.deltas[branch]; ok {
+		return delta.DeepCopy().Tests, nil
+	}
+	return map[string]types.TestClassification{}, nil
+}
+
+// See deltaStore interface.
+func (m *memDeltaStore) add(branch string, changes map[string]types.TestClassification, userId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delta, ok := m.deltas[branch]
+	if !ok {
+		delta = NewExpectations()
+		m.deltas[branch] = delta
+	}
+	delta.AddDigests(changes)
+	return nil
+}
+
+// See deltaStore interface.
+func (m *memDeltaStore) remove(branch string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.deltas, branch)
+	return nil
+}
+
+// See deltaStore interface.
+func (m *memDeltaStore) branches() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	ret := make([]string, 0, len(m.deltas))
+	for branch := range m.deltas {
+		ret = append(ret, branch)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// sqlDeltaStore implements deltaStore in an SQL database. Only the current
+// label of each digest is stored per branch; the history of a branch is not
+// kept.
+type sqlDeltaStore struct {
+	vdb *database.VersionedDB
+}
+
+// See deltaStore interface.
+func (s *sqlDeltaStore) get(branch string) (map[string]types.TestClassification, error) {
+	const stmt = `SELECT name, digest, label FROM exp_branch_change WHERE branch=?`
+	rows, err := s.vdb.DB.Query(stmt, branch)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	ret := map[string]types.TestClassification{}
+	for rows.Next() {
+		var testName, digest, label string
+		if err := rows.Scan(&testName, &digest, &label); err != nil {
+			return nil, err
+		}
+		if _, ok := ret[testName]; !ok {
+			ret[testName] = types.TestClassification{}
+		}
+		ret[testName][digest] = types.LabelFromString(label)
+	}
+	return ret, nil
+}
+
+// See deltaStore interface.
+func (s *sqlDeltaStore) add(branch string, changes map[string]types.TestClassification, userId string) error {
+	const insertStmt = `INSERT INTO exp_branch_change (branch, name, digest, label, userid, ts) VALUES %s
+	                    ON DUPLICATE KEY UPDATE label=VALUES(label), userid=VALUES(userid), ts=VALUES(ts)`
+
+	placeHolders := []string{}
+	vals := []interface{}{}
+	now := util.TimeStampMs()
+	for testName, digests := range changes {
+		for d, label := range digests {
+			placeHolders = append(placeHolders, "(?, ?, ?, ?, ?, ?)")
+			vals = append(vals, branch, testName, d, label.String(), userId, now)
+		}
+	}
+	if len(vals) == 0 {
+		return nil
+	}
+	_, err := s.vdb.DB.Exec(fmt.Sprintf(insertStmt, strings.Join(placeHolders, ",")), vals...)
+	return err
+}
+
+// See deltaStore interface.
+func (s *sqlDeltaStore) remove(branch string) error {
+	_, err := s.vdb.DB.Exec(`DELETE FROM exp_branch_change WHERE branch=?`, branch)
+	return err
+}
+
+// See deltaStore interface.
+func (s *sqlDeltaStore) branches() ([]string, error) {
+	rows, err := s.vdb.DB.Query(`SELECT DISTINCT branch FROM exp_branch_change ORDER BY branch`)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	ret := []string{}
+	for rows.Next() {
+		var branch string
+		if err := rows.Scan(&branch); err != nil {
+			return nil, err
+		}
+		ret = append(ret, branch)
+	}
+	return ret, nil
+}
@@ -0,0 +1,147 @@
+package expstorage
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/database/testutil"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestMemBranchExpectationsStore(t *testing.T) {
+	testutils.SmallTest(t)
+	master := NewMemExpectationsStore(nil)
+	testBranchExpectationsStore(t, NewMemBranchExpectationsStore(master), master)
+}
+
+func TestMySQLBranchExpectationsStore(t *testing.T) {
+	testutils.LargeTest(t)
+	testDb := testutil.SetupMySQLTestDatabase(t, db.MigrationSteps())
+	defer testDb.Close(t)
+
+	conf := testutil.LocalTestDatabaseConfig(db.MigrationSteps())
+	vdb, err := conf.NewVersionedDB()
+	assert.NoError(t, err)
+
+	master := NewSQLExpectationStore(vdb)
+	testBranchExpectationsStore(t, NewSQLBranchExpectationsStore(vdb, master), master)
+}
+
+func testBranchExpectationsStore(t *testing.T, store BranchExpectationsStore, master ExpectationsStore) {
+	assert.NoError(t, master.AddChange(map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+			"b": types.NEGATIVE,
+		},
+	}, "user@example.com"))
+
+	branch := "release-1"
+	issue := IssueBranch("1234")
+	_, err := store.Get("")
+	assert.Error(t, err)
+
+	// A branch without a delta has the master expectations.
+	exp, err := store.Get(branch)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+			"b": types.NEGATIVE,
+		},
+	}, exp.Tests)
+
+	// Triaging on the branch does not change master or other branches.
+	assert.NoError(t, store.AddChange(branch, map[string]types.TestClassification{
+		"foo": {
+			"b": types.POSITIVE,
+			"c": types.POSITIVE,
+		},
+		"bar": {
+			"d": types.NEGATIVE,
+		},
+	}, "user@example.com"))
+	assert.NoError(t, store.AddChange(issue, map[string]types.TestClassification{
+		"foo": {
+			"a": types.NEGATIVE,
+		},
+	}, "user@example.com"))
+	exp, err = store.Get(branch)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+			"b": types.POSITIVE,
+			"c": types.POSITIVE,
+		},
+		"bar": {
+			"d": types.NEGATIVE,
+		},
+	}, exp.Tests)
+	exp, err = store.Get(issue)
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, exp.Classification("foo", "a"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("foo", "c"))
+	exp, err = master.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, exp.Classification("foo", "b"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("foo", "c"))
+
+	// Triaging a digest again on the branch overwrites the delta.
+	assert.NoError(t, store.AddChange(branch, map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+		},
+		"bar": {
+			"d": types.POSITIVE,
+		},
+	}, "user@example.com"))
+	delta, err := store.GetDelta(branch)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+			"b": types.POSITIVE,
+			"c": types.POSITIVE,
+		},
+		"bar": {
+			"d": types.POSITIVE,
+		},
+	}, delta.Tests)
+
+	branches, err := store.Branches()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{issue, branch}, branches)
+
+	// Promote the branch. Only the differences are applied to master.
+	changes, err := store.Promote(branch, "user@example.com")
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, map[string]types.TestClassification{
+		"foo": {
+			"b": types.POSITIVE,
+			"c": types.POSITIVE,
+		},
+		"bar": {
+			"d": types.POSITIVE,
+		},
+	}, changes)
+	exp, err = master.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE, exp.Classification("foo", "b"))
+	assert.Equal(t, types.POSITIVE, exp.Classification("bar", "d"))
+	branches, err = store.Branches()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{issue}, branches)
+
+	// The other branch sees the promoted expectations.
+	exp, err = store.Get(issue)
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, exp.Classification("foo", "a"))
+	assert.Equal(t, types.POSITIVE, exp.Classification("foo", "c"))
+
+	assert.NoError(t, store.RemoveBranch(issue))
+	branches, err = store.Branches()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(branches))
+}
@@ -0,0 +1,119 @@
+package expstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// EXPORT_FORMAT_VERSION is the version of the format written by
+	// WriteExport. It is incremented whenever the format changes in an
+	// incompatible way.
+	EXPORT_FORMAT_VERSION = 1
+)
+
+// ExportedExpectations is a stable, serializable representation of the
+// positive and negative expectations of a Gold instance. Untriaged digests are
+// not included. It is used to seed a new instance from an existing one and to
+// snapshot baselines.
+type ExportedExpectations struct {
+	Version int `json:"version"`
+
+	// Tests maps test names to digests to "positive" or "negative".
+	Tests map[string]map[string]string `json:"tests"`
+}
+
+// Export returns the positive and negative expectations for the given tests,
+// or for all tests if tests is nil.
+func Export(exp *Expectations, tests util.StringSet) *ExportedExpectations {
+	ret := &ExportedExpectations{
+		Version: EXPORT_FORMAT_VERSION,
+		Tests:   map[string]map[string]string{},
+	}
+	for testName, digests := range exp.Tests {
+		if tests != nil && !tests[testName] {
+			continue
+		}
+		for digest, label := range digests {
+			if label == types.UNTRIAGED {
+				continue
+			}
+			if _, ok := ret.Tests[testName]; !ok {
+				ret.Tests[testName] = map[string]string{}
+			}
+			ret.Tests[testName][digest] = label.String()
+		}
+	}
+	return ret
+}
+
+// WriteExport writes the given ExportedExpectations as JSON. Since map keys
+// are sorted the output is identical for identical expectations, which makes
+// the files easy to diff.
+func WriteExport(w io.Writer, e *ExportedExpectations) error {
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("\n"))
+	return err
+}
+
+// ReadExport reads and validates ExportedExpectations written by WriteExport.
+func ReadExport(r io.Reader) (*ExportedExpectations, error) {
+	var ret ExportedExpectations
+	if err := json.NewDecoder(r).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("Unable to decode exported expectations: %s", err)
+	}
+	if ret.Version != EXPORT_FORMAT_VERSION {
+		return nil, fmt.Errorf("Unsupported export format version %d; expected %d.", ret.Version, EXPORT_FORMAT_VERSION)
+	}
+	for testName, digests := range ret.Tests {
+		for digest, label := range digests {
+			if label != types.POSITIVE.String() && label != types.NEGATIVE.String() {
+				return nil, fmt.Errorf("Invalid label %q for digest %s of test %s.", label, digest, testName)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// Import applies the given ExportedExpectations to the store in a single
+// change, recorded in the triage log under the given user, so the import can
+// be undone as a whole. Only expectations which differ from the current ones
+// are changed. Returns the number of changed digests.
+func Import(store ExpectationsStore, e *ExportedExpectations, userId string) (int, error) {
+	exp, err := store.Get()
+	if err != nil {
+		return 0, err
+	}
+	changes := map[string]types.TestClassification{}
+	n := 0
+	for testName, digests := range e.Tests {
+		for digest, labelStr := range digests {
+			label := types.LabelFromString(labelStr)
+			if current, ok := exp.Tests[testName][digest]; ok && current == label {
+				continue
+			}
+			if _, ok := changes[testName]; !ok {
+				changes[testName] = types.TestClassification{}
+			}
+			changes[testName][digest] = label
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := store.AddChange(changes, userId); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,96 @@
+package expstorage
+
+import (
+	"bytes"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestExportImport(t *testing.T) {
+	testutils.SmallTest(t)
+	src := NewMemExpectationsStore(nil)
+	assert.NoError(t, src.AddChange(map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+			"b": types.NEGATIVE,
+			"c": types.UNTRIAGED,
+		},
+		"bar": {
+			"d": types.POSITIVE,
+		},
+		"baz": {
+			"e": types.UNTRIAGED,
+		},
+	}, "user@example.com"))
+	exp, err := src.Get()
+	assert.NoError(t, err)
+
+	// Untriaged digests and tests without triaged digests are omitted.
+	e := Export(exp, nil)
+	testutils.AssertDeepEqual(t, &ExportedExpectations{
+		Version: EXPORT_FORMAT_VERSION,
+		Tests: map[string]map[string]string{
+			"foo": {
+				"a": "positive",
+				"b": "negative",
+			},
+			"bar": {
+				"d": "positive",
+			},
+		},
+	}, e)
+
+	// Filter by test.
+	filtered := Export(exp, util.NewStringSet([]string{"bar"}))
+	testutils.AssertDeepEqual(t, map[string]map[string]string{
+		"bar": {
+			"d": "positive",
+		},
+	}, filtered.Tests)
+
+	// The output is stable.
+	var buf1, buf2 bytes.Buffer
+	assert.NoError(t, WriteExport(&buf1, e))
+	assert.NoError(t, WriteExport(&buf2, Export(exp, nil)))
+	assert.Equal(t, buf1.String(), buf2.String())
+
+	read, err := ReadExport(&buf1)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, e, read)
+
+	// Import into a store with a conflicting expectation. Only the
+	// differing expectations are changed.
+	dst := NewMemExpectationsStore(nil)
+	assert.NoError(t, dst.AddChange(map[string]types.TestClassification{
+		"foo": {
+			"a": types.POSITIVE,
+			"b": types.POSITIVE,
+		},
+	}, "user@example.com"))
+	n, err := Import(dst, read, "importer@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	got, err := dst.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, got.Classification("foo", "b"))
+	assert.Equal(t, types.POSITIVE, got.Classification("bar", "d"))
+
+	// Importing again is a no-op.
+	n, err = Import(dst, read, "importer@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestReadExportErrors(t *testing.T) {
+	testutils.SmallTest(t)
+	_, err := ReadExport(bytes.NewBufferString(`{"version": 2, "tests": {}}`))
+	assert.EqualError(t, err, "Unsupported export format version 2; expected 1.")
+	_, err = ReadExport(bytes.NewBufferString(`{"version": 1, "tests": {"foo": {"a": "untriaged"}}}`))
+	assert.EqualError(t, err, "Invalid label \"untriaged\" for digest a of test foo.")
+	_, err = ReadExport(bytes.NewBufferString(`not json`))
+	assert.Error(t, err)
+}
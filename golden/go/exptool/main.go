@@ -0,0 +1,130 @@
+package main
+
+// Exports the expectations of a Gold instance to a file or imports them into
+// the database of a Gold instance, e.g. to seed a new instance or to snapshot
+// the baselines before a risky change.
+//
+// Export the expectations of the gm corpus:
+//   exptool --export --gold_url=https://gold.skia.org --corpus=gm --file=gm.json
+//
+// Import them into the database of another instance. This should be done
+// while the instance is not running, since it caches the expectations:
+//   exptool --import --file=gm.json --user=me@example.com --db_host=...
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/expstorage"
+)
+
+var (
+	corpus         = flag.String("corpus", "", "If set, only export the expectations of tests in this corpus.")
+	doExport       = flag.Bool("export", false, "Export the expectations of the instance at --gold_url to --file.")
+	doImport       = flag.Bool("import", false, "Import the expectations in --file into the database.")
+	file           = flag.String("file", "", "File to export to or import from. If empty, stdout or stdin is used.")
+	goldURL        = flag.String("gold_url", "https://gold.skia.org", "URL of the Gold instance to export from.")
+	promptPassword = flag.Bool("password", false, "Prompt for the database password.")
+	tests          = common.NewMultiStringFlag("test", nil, "If set, only export the expectations of these tests.")
+	user           = flag.String("user", "", "User recorded in the triage log for imported expectations.")
+)
+
+func main() {
+	defer common.LogPanic()
+	dbConf := database.ConfigFromFlags(db.PROD_DB_HOST, db.PROD_DB_PORT, database.USER_RW, db.PROD_DB_NAME, db.MigrationSteps())
+	common.Init()
+
+	if *doExport == *doImport {
+		sklog.Fatal("Exactly one of --export and --import is required.")
+	}
+	if *doExport {
+		if err := export(); err != nil {
+			sklog.Fatal(err)
+		}
+		return
+	}
+
+	if *user == "" {
+		sklog.Fatal("--user is required for --import.")
+	}
+	if *promptPassword {
+		if err := dbConf.PromptForPassword(); err != nil {
+			sklog.Fatal(err)
+		}
+	}
+	vdb, err := dbConf.NewVersionedDB()
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	n, err := importExpectations(expstorage.NewSQLExpectationStore(vdb))
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	sklog.Infof("Imported %d expectations.", n)
+}
+
+// export writes the expectations returned by the export endpoint of the Gold
+// instance to --file.
+func export() error {
+	q := url.Values{}
+	if *corpus != "" {
+		q.Set("corpus", *corpus)
+	}
+	for _, t := range *tests {
+		q.Add("test", t)
+	}
+	u := strings.TrimRight(*goldURL, "/") + "/json/expectations/export?" + q.Encode()
+	resp, err := httputils.NewTimeoutClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to export expectations from %s: %s", u, resp.Status)
+	}
+
+	// Decode and re-encode the expectations to validate them.
+	e, err := expstorage.ReadExport(resp.Body)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer util.Close(f)
+		w = f
+	}
+	return expstorage.WriteExport(w, e)
+}
+
+// importExpectations applies the expectations in --file to the given store.
+func importExpectations(store expstorage.ExpectationsStore) (int, error) {
+	var r io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return 0, err
+		}
+		defer util.Close(f)
+		r = f
+	}
+	e, err := expstorage.ReadExport(r)
+	if err != nil {
+		return 0, err
+	}
+	return expstorage.Import(store, e, *user)
+}
@@ -0,0 +1,206 @@
+// Package fuzzy automatically triages new digests as positive if they are
+// within a per-test tolerance of an existing positive digest. This prevents
+// tiny differences, eg. anti-aliasing differences between GPU drivers, from
+// producing large numbers of untriaged digests.
+package fuzzy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/tally"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// AUTO_TRIAGE_USER is the user recorded in the triage log for changes
+	// made by the Matcher, so that they can be audited and undone.
+	AUTO_TRIAGE_USER = "fuzzy-matcher"
+)
+
+// Rule describes how much a digest may differ from a positive digest of the
+// same test and still be considered positive.
+type Rule struct {
+	// MaxPixelDiffPercent is the maximum percentage of pixels which may
+	// differ, in [0, 100].
+	MaxPixelDiffPercent float32 `json:"maxPixelDiffPercent"`
+
+	// MaxRGBADiffs is the maximum difference in each of the R, G, B and A
+	// channels of any pixel. See diff.DiffMetrics.MaxRGBADiffs.
+	MaxRGBADiffs []int `json:"maxRGBADiffs"`
+}
+
+// Validate returns an error if the Rule is not valid.
+func (r *Rule) Validate() error {
+	if r.MaxPixelDiffPercent < 0 || r.MaxPixelDiffPercent > 100 {
+		return fmt.Errorf("maxPixelDiffPercent must be in [0, 100]; got %f", r.MaxPixelDiffPercent)
+	}
+	if len(r.MaxRGBADiffs) != 4 {
+		return fmt.Errorf("maxRGBADiffs must contain exactly 4 values; got %v", r.MaxRGBADiffs)
+	}
+	for _, d := range r.MaxRGBADiffs {
+		if d < 0 || d > 255 {
+			return fmt.Errorf("maxRGBADiffs must be in [0, 255]; got %v", r.MaxRGBADiffs)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if two images with the given DiffMetrics are within the
+// tolerance of the Rule.
+func (r *Rule) Matches(dm *diff.DiffMetrics) bool {
+	if dm.DimDiffer || dm.PixelDiffPercent > r.MaxPixelDiffPercent {
+		return false
+	}
+	if len(dm.MaxRGBADiffs) != len(r.MaxRGBADiffs) {
+		return false
+	}
+	for i, d := range dm.MaxRGBADiffs {
+		if d > r.MaxRGBADiffs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Rules maps test names to the Rule used to match digests of that test. Tests
+// without a Rule are never auto-triaged.
+type Rules map[string]*Rule
+
+// Validate returns an error if any of the Rules is not valid.
+func (r Rules) Validate() error {
+	for testName, rule := range r {
+		if rule == nil {
+			return fmt.Errorf("Missing rule for test %q", testName)
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("Invalid rule for test %q: %s", testName, err)
+		}
+	}
+	return nil
+}
+
+// ReadRules reads Rules from the given JSON file, which maps test names to
+// Rules, eg. {"blurcircles": {"maxPixelDiffPercent": 0.5, "maxRGBADiffs":
+// [2, 2, 2, 0]}}.
+func ReadRules(path string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(f)
+	ret := Rules{}
+	if err := json.NewDecoder(f).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("Unable to decode fuzzy matching rules: %s", err)
+	}
+	if err := ret.Validate(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Match returns the digests in the given tallies which should be triaged as
+// positive because they are within the tolerance of their test's Rule of a
+// positive digest in the tallies. Only digests which have never been triaged
+// are considered; a digest which was explicitly marked untriaged, eg. by
+// undoing an earlier automatic triage, is left alone.
+func Match(rules Rules, exp *expstorage.Expectations, talliesByTest map[string]tally.Tally, diffStore diff.DiffStore) map[string]types.TestClassification {
+	ret := map[string]types.TestClassification{}
+	unavailable := diffStore.UnavailableDigests()
+	for testName, rule := range rules {
+		digests, ok := talliesByTest[testName]
+		if !ok {
+			continue
+		}
+		positives := []string{}
+		untriaged := []string{}
+		for digest, _ := range digests {
+			if _, ok := unavailable[digest]; ok {
+				continue
+			}
+			if label, ok := exp.Tests[testName][digest]; !ok {
+				untriaged = append(untriaged, digest)
+			} else if label == types.POSITIVE {
+				positives = append(positives, digest)
+			}
+		}
+		if len(positives) == 0 || len(untriaged) == 0 {
+			continue
+		}
+		sort.Strings(untriaged)
+		for _, digest := range untriaged {
+			diffs, err := diffStore.Get(diff.PRIORITY_BACKGROUND, digest, positives)
+			if err != nil {
+				sklog.Errorf("Unable to compare %s to positive digests of %s: %s", digest, testName, err)
+				continue
+			}
+			for _, dm := range diffs {
+				if rule.Matches(dm) {
+					if _, ok := ret[testName]; !ok {
+						ret[testName] = types.TestClassification{}
+					}
+					ret[testName][digest] = types.POSITIVE
+					break
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Matcher auto-triages digests whenever the search index is updated.
+type Matcher struct {
+	rules    Rules
+	storages *storage.Storage
+
+	// Serializes runs, since index updates are delivered asynchronously.
+	mutex sync.Mutex
+}
+
+// New returns a new Matcher which uses the given Rules.
+func New(storages *storage.Storage, rules Rules) *Matcher {
+	return &Matcher{
+		rules:    rules,
+		storages: storages,
+	}
+}
+
+// Start subscribes the Matcher to updates of the search index.
+func (m *Matcher) Start() {
+	m.storages.EventBus.SubscribeAsync(indexer.EV_INDEX_UPDATED, func(state interface{}) {
+		if err := m.run(state.(*indexer.SearchIndex)); err != nil {
+			sklog.Errorf("Fuzzy matching failed: %s", err)
+		}
+	})
+}
+
+// run auto-triages the untriaged digests in the given index. The changes are
+// recorded in the triage log under AUTO_TRIAGE_USER.
+func (m *Matcher) run(idx *indexer.SearchIndex) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	exp, err := m.storages.ExpectationsStore.Get()
+	if err != nil {
+		return err
+	}
+	changes := Match(m.rules, exp, idx.TalliesByTest(), m.storages.DiffStore)
+	if len(changes) == 0 {
+		return nil
+	}
+	n := 0
+	for _, digests := range changes {
+		n += len(digests)
+	}
+	sklog.Infof("Auto-triaging %d digests in %d tests as positive.", n, len(changes))
+	return m.storages.ExpectationsStore.AddChange(changes, AUTO_TRIAGE_USER)
+}
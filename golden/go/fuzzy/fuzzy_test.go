@@ -0,0 +1,147 @@
+package fuzzy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/tally"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// mockDiffStore returns the DiffMetrics in its map for each pair of digests.
+type mockDiffStore map[string]*diff.DiffMetrics
+
+func (m mockDiffStore) ImageHandler(urlPrefix string) (http.Handler, error)                   { return nil, nil }
+func (m mockDiffStore) WarmDigests(priority int64, digests []string)                          {}
+func (m mockDiffStore) WarmDiffs(priority int64, leftDigests []string, rightDigests []string) {}
+func (m mockDiffStore) PurgeDigests(digests []string, purgeGCS bool) error                    { return nil }
+func (m mockDiffStore) UnavailableDigests() map[string]*diff.DigestFailure {
+	return map[string]*diff.DigestFailure{"broken": {Digest: "broken"}}
+}
+
+func (m mockDiffStore) Get(priority int64, dMain string, dRest []string) (map[string]*diff.DiffMetrics, error) {
+	result := map[string]*diff.DiffMetrics{}
+	for _, d := range dRest {
+		if dm, ok := m[dMain+":"+d]; ok {
+			result[d] = dm
+		} else {
+			result[d] = &diff.DiffMetrics{
+				PixelDiffPercent: 100,
+				MaxRGBADiffs:     []int{255, 255, 255, 255},
+			}
+		}
+	}
+	return result, nil
+}
+
+func TestRule(t *testing.T) {
+	testutils.SmallTest(t)
+	r := &Rule{
+		MaxPixelDiffPercent: 1.0,
+		MaxRGBADiffs:        []int{2, 2, 2, 0},
+	}
+	assert.NoError(t, r.Validate())
+
+	assert.True(t, r.Matches(&diff.DiffMetrics{PixelDiffPercent: 0.5, MaxRGBADiffs: []int{1, 2, 0, 0}}))
+	assert.True(t, r.Matches(&diff.DiffMetrics{PixelDiffPercent: 1.0, MaxRGBADiffs: []int{2, 2, 2, 0}}))
+	assert.False(t, r.Matches(&diff.DiffMetrics{PixelDiffPercent: 1.5, MaxRGBADiffs: []int{1, 1, 1, 0}}))
+	assert.False(t, r.Matches(&diff.DiffMetrics{PixelDiffPercent: 0.5, MaxRGBADiffs: []int{1, 1, 1, 1}}))
+	assert.False(t, r.Matches(&diff.DiffMetrics{PixelDiffPercent: 0.5, MaxRGBADiffs: []int{1, 1, 1, 0}, DimDiffer: true}))
+
+	r.MaxPixelDiffPercent = 101
+	assert.Error(t, r.Validate())
+	r.MaxPixelDiffPercent = 1.0
+	r.MaxRGBADiffs = []int{1, 1, 1}
+	assert.Error(t, r.Validate())
+	r.MaxRGBADiffs = []int{1, 1, -1, 0}
+	assert.Error(t, r.Validate())
+}
+
+func TestReadRules(t *testing.T) {
+	testutils.SmallTest(t)
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+
+	file := path.Join(tmp, "rules.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"foo": {"maxPixelDiffPercent": 0.5, "maxRGBADiffs": [2, 2, 2, 0]}}`), 0644))
+	rules, err := ReadRules(file)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, Rules{
+		"foo": {
+			MaxPixelDiffPercent: 0.5,
+			MaxRGBADiffs:        []int{2, 2, 2, 0},
+		},
+	}, rules)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"foo": {"maxPixelDiffPercent": 0.5}}`), 0644))
+	_, err = ReadRules(file)
+	assert.Error(t, err)
+}
+
+func TestMatch(t *testing.T) {
+	testutils.SmallTest(t)
+	rules := Rules{
+		"foo": {
+			MaxPixelDiffPercent: 1.0,
+			MaxRGBADiffs:        []int{2, 2, 2, 0},
+		},
+	}
+	exp := &expstorage.Expectations{
+		Tests: map[string]types.TestClassification{
+			"foo": {
+				"pos":    types.POSITIVE,
+				"neg":    types.NEGATIVE,
+				"undone": types.UNTRIAGED,
+			},
+			"bar": {
+				"pos": types.POSITIVE,
+			},
+		},
+	}
+	near := &diff.DiffMetrics{
+		PixelDiffPercent: 0.1,
+		MaxRGBADiffs:     []int{1, 1, 1, 0},
+	}
+	diffStore := mockDiffStore{
+		"close:pos":   near,
+		"close:neg":   near,
+		"nearneg:neg": near,
+		"undone:pos":  near,
+		"broken:pos":  near,
+	}
+	tallies := map[string]tally.Tally{
+		"foo": {
+			"pos":     1,
+			"neg":     1,
+			"close":   1,
+			"far":     1,
+			"nearneg": 1,
+			"undone":  1,
+			"broken":  1,
+		},
+		// No rule for this test.
+		"bar": {
+			"pos":   1,
+			"close": 1,
+		},
+	}
+
+	// Only "close" is within tolerance of a positive digest. "undone" was
+	// explicitly marked untriaged, so it is left alone.
+	testutils.AssertDeepEqual(t, map[string]types.TestClassification{
+		"foo": {
+			"close": types.POSITIVE,
+		},
+	}, Match(rules, exp, tallies, diffStore))
+
+	// Nothing to do once it has been triaged.
+	exp.Tests["foo"]["close"] = types.POSITIVE
+	assert.Equal(t, 0, len(Match(rules, exp, tallies, diffStore)))
+}
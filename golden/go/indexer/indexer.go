@@ -10,6 +10,7 @@ import (
 	"go.skia.org/infra/go/sklog"
 
 	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/golden/go/blame"
@@ -95,6 +96,25 @@ func (idx *SearchIndex) CalcSummaries(testNames []string, query url.Values, incl
 	return idx.summaries.CalcSummaries(idx.tilePair.Tile, testNames, query, head)
 }
 
+// CalcSummariesByExpr is like CalcSummaries, but only the traces that match
+// the given boolean query expression are considered, see query.NewFromString.
+func (idx *SearchIndex) CalcSummariesByExpr(testNames []string, expr *query.Query, includeIgnores, head bool) (map[string]*summary.Summary, error) {
+	tile := idx.GetTile(includeIgnores)
+	filtered := &tiling.Tile{
+		Traces:    map[string]tiling.Trace{},
+		ParamSet:  tile.ParamSet,
+		Commits:   tile.Commits,
+		Scale:     tile.Scale,
+		TileIndex: tile.TileIndex,
+	}
+	for id, tr := range tile.Traces {
+		if expr.MatchesParams(tr.Params()) {
+			filtered.Traces[id] = tr
+		}
+	}
+	return idx.summaries.CalcSummaries(filtered, testNames, nil, head)
+}
+
 // Proxy to paramsets.Get
 func (idx *SearchIndex) GetParamsetSummary(test, digest string, includeIgnores bool) paramtools.ParamSet {
 	return idx.paramsetSummary.Get(test, digest, includeIgnores)
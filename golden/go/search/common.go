@@ -3,8 +3,8 @@ package search
 
 import (
 	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/indexer"
-	"go.skia.org/infra/golden/go/storage"
 	"go.skia.org/infra/golden/go/types"
 )
 
@@ -26,13 +26,9 @@ type AddFn func(test, digest, traceID string, trace tiling.Trace, acceptResult i
 // It iterates over the tile and filters against the given query. If calls
 // acceptFn to determine whether to keep a trace (after it has already been
 // tested against the query) and calls addFn to add a digest and its trace.
-// acceptFn == nil equals unconditional acceptance.
-func iterTile(query *Query, addFn AddFn, acceptFn AcceptFn, storages *storage.Storage, idx *indexer.SearchIndex) error {
-	exp, err := storages.ExpectationsStore.Get()
-	if err != nil {
-		return err
-	}
-
+// acceptFn == nil equals unconditional acceptance. exp are the expectations
+// used to classify the digests, i.e. the expectations of the query's branch.
+func iterTile(query *Query, addFn AddFn, acceptFn AcceptFn, exp *expstorage.Expectations, idx *indexer.SearchIndex) error {
 	tile := idx.GetTile(query.IncludeIgnores)
 
 	if acceptFn == nil {
@@ -45,7 +41,7 @@ func iterTile(query *Query, addFn AddFn, acceptFn AcceptFn, storages *storage.St
 	// Iterate through the tile.
 	for id, tr := range tile.Traces {
 		// Check if the query matches.
-		if tiling.Matches(tr, query.Query) {
+		if tiling.Matches(tr, query.Query) && query.matchesExpr(tr.Params()) {
 			// Check if we should accept this trace.
 			if ok, acceptRet := acceptFn(tr); ok {
 				test := tr.Params()[types.PRIMARY_KEY_FIELD]
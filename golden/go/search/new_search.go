@@ -91,7 +91,7 @@ func NewSearchAPI(storages *storage.Storage, ixr *indexer.Indexer) (*SearchAPI,
 func (s *SearchAPI) Search(q *Query) (*NewSearchResponse, error) {
 	// Get the expectations and the current index, which we assume constant
 	// for the duration of this query.
-	exp, err := s.storages.ExpectationsStore.Get()
+	exp, err := s.storages.GetExpectations(q.ExpectationsBranch())
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +99,7 @@ func (s *SearchAPI) Search(q *Query) (*NewSearchResponse, error) {
 
 	// Unconditional query stage. Iterate through the tile and get an intermediate
 	// representation that contains all the traces matching the queries.
-	inter, err := s.filterTile(q, idx)
+	inter, err := s.filterTile(q, exp, idx)
 
 	// Pre-diff filtering: Filter out everything that does not involve any diffs.
 	s.beforeDiffResultFilter(q, inter, idx)
@@ -167,7 +167,7 @@ func (s *srIntermediate) Add(traceID string, trace tiling.Trace) {
 
 // filterTile iterates over the tile and accumulates the traces
 // that match the given query creating the initial search result.
-func (s *SearchAPI) filterTile(q *Query, idx *indexer.SearchIndex) (map[string]map[string]*srIntermediate, error) {
+func (s *SearchAPI) filterTile(q *Query, exp *expstorage.Expectations, idx *indexer.SearchIndex) (map[string]map[string]*srIntermediate, error) {
 	// Add digest/trace to the result.
 	ret := map[string]map[string]*srIntermediate{}
 	addFn := func(test, digest, traceID string, trace tiling.Trace, accptRet interface{}) {
@@ -180,7 +180,7 @@ func (s *SearchAPI) filterTile(q *Query, idx *indexer.SearchIndex) (map[string]m
 		}
 	}
 
-	if err := iterTile(q, addFn, nil, s.storages, idx); err != nil {
+	if err := iterTile(q, addFn, nil, exp, idx); err != nil {
 		return nil, err
 	}
 	return ret, nil
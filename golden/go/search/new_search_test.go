@@ -10,8 +10,10 @@ import (
 	"go.skia.org/infra/go/testutils"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/indexer"
 	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/types"
 )
 
 func TestSearchAPI(t *testing.T) {
@@ -42,6 +44,74 @@ func TestSearchAPI(t *testing.T) {
 	// add more calls to testGivenQuery to test for a range of different queries.
 }
 
+func TestSearchAPIBranch(t *testing.T) {
+	testutils.MediumTest(t)
+
+	storages, _, _, ixr := getStoragesIndexTile(t, gcs.TEST_DATA_BUCKET, TEST_DATA_STORAGE_PATH, TEST_DATA_PATH)
+	storages.BranchExpStore = expstorage.NewMemBranchExpectationsStore(storages.ExpectationsStore)
+	api, err := NewSearchAPI(storages, ixr)
+	assert.NoError(t, err)
+	idx := ixr.GetIndex()
+
+	q := &Query{
+		Unt:      true,
+		Head:     true,
+		Metric:   diff.METRIC_PIXEL,
+		FRGBAMax: -1,
+		FDiffMax: -1,
+	}
+	resp, err := api.Search(q)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, len(resp.Digests))
+	untriaged := resp.Digests[0]
+
+	// Triage the digest on a branch.
+	const branch = "release"
+	assert.NoError(t, storages.BranchExpStore.AddChange(branch, map[string]types.TestClassification{
+		untriaged.Test: {untriaged.Digest: types.POSITIVE},
+	}, "testuser"))
+
+	// On the branch the digest is no longer untriaged.
+	q.Branch = branch
+	resp, err = api.Search(q)
+	assert.NoError(t, err)
+	for _, d := range resp.Digests {
+		assert.False(t, d.Test == untriaged.Test && d.Digest == untriaged.Digest)
+	}
+	q.Unt = false
+	q.Pos = true
+	resp, err = api.Search(q)
+	assert.NoError(t, err)
+	found := false
+	for _, d := range resp.Digests {
+		if d.Test == untriaged.Test && d.Digest == untriaged.Digest {
+			assert.Equal(t, types.POSITIVE.String(), d.Status)
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	// The details are labeled with the expectations of the branch.
+	details, err := GetDigestDetails(untriaged.Test, untriaged.Digest, branch, storages, idx)
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE.String(), details.Digest.Status)
+	details, err = GetDigestDetails(untriaged.Test, untriaged.Digest, "", storages, idx)
+	assert.NoError(t, err)
+	assert.Equal(t, types.UNTRIAGED.String(), details.Digest.Status)
+
+	// Master is unchanged.
+	q.Branch = ""
+	q.Unt = true
+	q.Pos = false
+	resp, err = api.Search(q)
+	assert.NoError(t, err)
+	found = false
+	for _, d := range resp.Digests {
+		found = found || (d.Test == untriaged.Test && d.Digest == untriaged.Digest)
+	}
+	assert.True(t, found)
+}
+
 func testGivenQuery(t *testing.T, api *SearchAPI, q *Query, storages *storage.Storage, idx *indexer.SearchIndex) {
 	tile := idx.GetTile(q.IncludeIgnores)
 	testNameSet, total := findTests(tile, q.Head)
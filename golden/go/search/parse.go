@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 
@@ -56,14 +55,19 @@ func ParseCTQuery(r io.ReadCloser, limitDefault int, ctQuery *CTQuery) error {
 	ctQuery.ColumnQuery.Patchsets = strings.Split(ctQuery.ColumnQuery.PatchsetsStr, ",")
 	ctQuery.RowQuery.Patchsets = strings.Split(ctQuery.RowQuery.PatchsetsStr, ",")
 
-	// Parse the query string into a url.Values instance.
-	if ctQuery.RowQuery.Query, err = url.ParseQuery(ctQuery.RowQuery.QueryStr); err != nil {
+	// Parse the query strings.
+	if err = ctQuery.RowQuery.SetQuery(ctQuery.RowQuery.QueryStr); err != nil {
 		return err
 	}
-	if ctQuery.ColumnQuery.Query, err = url.ParseQuery(ctQuery.ColumnQuery.QueryStr); err != nil {
+	if err = ctQuery.ColumnQuery.SetQuery(ctQuery.ColumnQuery.QueryStr); err != nil {
 		return err
 	}
 
+	// The corpus is taken from the queries, so expressions are not supported.
+	if (ctQuery.RowQuery.Expr != nil) || (ctQuery.ColumnQuery.Expr != nil) {
+		return fmt.Errorf("Query expressions are not supported when comparing tests.")
+	}
+
 	rowCorpus := ctQuery.RowQuery.Query.Get(types.CORPUS_FIELD)
 	colCorpus := ctQuery.ColumnQuery.Query.Get(types.CORPUS_FIELD)
 	if (rowCorpus != colCorpus) || (rowCorpus == "") {
@@ -94,7 +98,7 @@ func ParseCTQuery(r io.ReadCloser, limitDefault int, ctQuery *CTQuery) error {
 	validate.StrValue("rowsDir", &ctQuery.RowsDir, sortDirections, SORT_DESC)
 	validate.StrValue("sortColumns", &ctQuery.SortColumns, columnSortFields, SORT_FIELD_DIFF)
 	validate.StrValue("columnsDir", &ctQuery.ColumnsDir, sortDirections, SORT_ASC)
-	validate.StrValue("metrics", &ctQuery.Metric, diff.GetDiffMetricIDs(), diff.METRIC_PERCENT)
+	validate.StrValue("metrics", &ctQuery.Metric, diff.GetDiffMetricIDs(), diff.GetCorpusMetric(rowCorpus, diff.METRIC_PERCENT))
 	return validate.Errors()
 }
 
@@ -53,4 +53,31 @@ func TestParseCTQuery(t *testing.T) {
 	jsonBytes, err = json.Marshal(&testQuery)
 	assert.NoError(t, err)
 	assert.Error(t, ParseCTQuery(ioutil.NopCloser(bytes.NewBuffer(jsonBytes)), 10, &ctQuery))
+
+	// Query expressions are rejected.
+	testQuery.RowQuery.QueryStr = "source_type=gm AND NOT param=value"
+	jsonBytes, err = json.Marshal(&testQuery)
+	assert.NoError(t, err)
+	assert.Error(t, ParseCTQuery(ioutil.NopCloser(bytes.NewBuffer(jsonBytes)), 10, &ctQuery))
+}
+
+func TestSetQuery(t *testing.T) {
+	testutils.SmallTest(t)
+	q := &Query{}
+	assert.NoError(t, q.SetQuery("source_type=gm&param=value"))
+	assert.Equal(t, url.Values{"source_type": []string{"gm"}, "param": []string{"value"}}, q.Query)
+	assert.Nil(t, q.Expr)
+	assert.True(t, q.matchesExpr(map[string]string{"source_type": "svg"}))
+
+	assert.NoError(t, q.SetQuery("source_type=gm OR (source_type=svg AND NOT param=value)"))
+	assert.Equal(t, url.Values{}, q.Query)
+	assert.NotNil(t, q.Expr)
+	assert.True(t, q.matchesExpr(map[string]string{"source_type": "gm", "param": "value"}))
+	assert.True(t, q.matchesExpr(map[string]string{"source_type": "svg", "param": "other"}))
+	assert.False(t, q.matchesExpr(map[string]string{"source_type": "svg", "param": "value"}))
+
+	assert.Error(t, q.SetQuery("source_type=gm OR"))
+	assert.NoError(t, q.SetQuery(""))
+	assert.Equal(t, url.Values{}, q.Query)
+	assert.Nil(t, q.Expr)
 }
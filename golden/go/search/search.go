@@ -12,6 +12,7 @@ import (
 	"go.skia.org/infra/go/sklog"
 
 	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/blame"
@@ -132,12 +133,21 @@ type Query struct {
 	QueryStr string     `json:"query"`
 	Query    url.Values `json:"-"`
 
+	// Expr is set instead of Query if the query string is a boolean query
+	// expression, see query.NewFromString.
+	Expr *query.Query `json:"-"`
+
 	// Trybot support.
 	Issue         string   `json:"issue"`
 	PatchsetsStr  string   `json:"patchsets"` // Comma-separated list of patchsets.
 	Patchsets     []string `json:"-"`
 	IncludeMaster bool     `json:"master"` // Include digests also contained in master when searching Rietveld issues.
 
+	// Branch whose expectations to use. If empty and Issue is set, the
+	// expectations of the issue are used. Otherwise the master expectations
+	// are used.
+	Branch string `json:"branch"`
+
 	// Filtering.
 	FCommitBegin string  `json:"fbegin"`     // Start commit
 	FCommitEnd   string  `json:"fend"`       // End commit
@@ -151,6 +161,41 @@ type Query struct {
 	Limit  int `json:"limit"`
 }
 
+// SetQuery parses s, which is either a URL encoded query or a boolean query
+// expression, into q.Query or q.Expr.
+func (q *Query) SetQuery(s string) error {
+	q.Query = url.Values{}
+	q.Expr = nil
+	if query.IsExpression(s) {
+		expr, err := query.NewFromString(s)
+		if err != nil {
+			return err
+		}
+		q.Expr = expr
+		return nil
+	}
+	parsed, err := url.ParseQuery(s)
+	if err != nil {
+		return err
+	}
+	q.Query = parsed
+	return nil
+}
+
+// matchesExpr returns true if q.Expr isn't set or if params match it.
+func (q *Query) matchesExpr(params map[string]string) bool {
+	return q.Expr == nil || q.Expr.MatchesParams(params)
+}
+
+// ExpectationsBranch returns the branch whose expectations should be used for
+// the query. See Query.Branch.
+func (q *Query) ExpectationsBranch() string {
+	if q.Branch == "" && q.Issue != "" {
+		return expstorage.IssueBranch(q.Issue)
+	}
+	return q.Branch
+}
+
 // SearchResponse is the standard search response. Depending on the query some fields
 // might be empty, i.e. IssueDetails only makes sense if a trybot isssue was given in the query.
 type SearchResponse struct {
@@ -212,7 +257,7 @@ func (p DigestSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func Search(q *Query, storages *storage.Storage, idx *indexer.SearchIndex) (*SearchResponse, error) {
 	tile := idx.GetTile(q.IncludeIgnores)
 
-	e, err := storages.ExpectationsStore.Get()
+	e, err := storages.GetExpectations(q.ExpectationsBranch())
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get expectations: %s", err)
 	}
@@ -305,7 +350,7 @@ func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parse
 			}
 
 			// Does it match a given query.
-			if (queryRule == nil) || queryRule.IsMatch(params) {
+			if ((queryRule == nil) || queryRule.IsMatch(params)) && q.matchesExpr(params) {
 				if !q.IncludeMaster {
 					if _, ok := talliesByTest[testName][digest]; ok {
 						continue
@@ -360,7 +405,7 @@ func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, st
 	// map [test:digest] *intermediate
 	inter := map[string]*intermediate{}
 	for id, tr := range tile.Traces {
-		if tiling.Matches(tr, parsedQuery) {
+		if tiling.Matches(tr, parsedQuery) && q.matchesExpr(tr.Params()) {
 			test := tr.Params()[types.PRIMARY_KEY_FIELD]
 			// Get all the digests
 			digests := digestsFromTrace(id, tr, q.Head, lastCommitIndex, traceTally)
@@ -597,15 +642,16 @@ type DigestDiff struct {
 }
 
 // CompareDigests compares two digests that were generated by the given test. It returns
-// an instance of DigestDiff.
-func CompareDigests(test, left, right string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDiff, error) {
+// an instance of DigestDiff. The digests are labeled with the expectations of
+// the given branch, see storage.Storage.GetExpectations.
+func CompareDigests(test, left, right, branch string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDiff, error) {
 	// Get the diff between the two digests
 	diff, err := storages.DiffStore.Get(diff.PRIORITY_NOW, left, []string{right})
 	if err != nil {
 		return nil, err
 	}
 
-	exp, err := storages.ExpectationsStore.Get()
+	exp, err := storages.GetExpectations(branch)
 	if err != nil {
 		return nil, err
 	}
@@ -634,10 +680,12 @@ type DigestDetails struct {
 }
 
 // GetDigestDetails returns details about a digest as an instance of DigestDetails.
-func GetDigestDetails(test, digest string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDetails, error) {
+// The digests are labeled with the expectations of the given branch, see
+// storage.Storage.GetExpectations.
+func GetDigestDetails(test, digest, branch string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDetails, error) {
 	tile := idx.GetTile(true)
 
-	exp, err := storages.ExpectationsStore.Get()
+	exp, err := storages.GetExpectations(branch)
 	if err != nil {
 		return nil, err
 	}
@@ -761,8 +809,13 @@ func ctSummaryFromSummary(sum *summary.Summary) *CTSummary {
 // the provided instance of CTQuery is consistent in that the row query and
 // column query contain the same test names and the same corpus field.
 func CompareTest(ctq *CTQuery, storages *storage.Storage, idx *indexer.SearchIndex) (*CTResponse, error) {
+	exp, err := storages.GetExpectations(ctq.RowQuery.ExpectationsBranch())
+	if err != nil {
+		return nil, err
+	}
+
 	// Retrieve the row digests.
-	rowDigests, err := filterTile(ctq.RowQuery, storages, idx)
+	rowDigests, err := filterTile(ctq.RowQuery, exp, idx)
 	if err != nil {
 		return nil, err
 	}
@@ -785,7 +838,7 @@ func CompareTest(ctq *CTQuery, storages *storage.Storage, idx *indexer.SearchInd
 	}
 
 	// Get the column digests conditioned on the result of the row digests.
-	columnDigests, err := filterTileWithMatch(ctq.ColumnQuery, ctq.Match, rowDigests, storages, idx)
+	columnDigests, err := filterTileWithMatch(ctq.ColumnQuery, ctq.Match, rowDigests, exp, idx)
 	if err != nil {
 		return nil, err
 	}
@@ -850,7 +903,7 @@ func CompareTest(ctq *CTQuery, storages *storage.Storage, idx *indexer.SearchInd
 // filterTile iterates over the tile and finds digests that match the given query.
 // It returns a map[digest]ParamSet which contains all the found digests and
 // the paramsets that generated them.
-func filterTile(query *Query, storages *storage.Storage, idx *indexer.SearchIndex) (map[string]paramtools.ParamSet, error) {
+func filterTile(query *Query, exp *expstorage.Expectations, idx *indexer.SearchIndex) (map[string]paramtools.ParamSet, error) {
 	ret := map[string]paramtools.ParamSet{}
 
 	// Add digest/trace to the result.
@@ -862,7 +915,7 @@ func filterTile(query *Query, storages *storage.Storage, idx *indexer.SearchInde
 		}
 	}
 
-	if err := iterTile(query, addFn, nil, storages, idx); err != nil {
+	if err := iterTile(query, addFn, nil, exp, idx); err != nil {
 		return nil, err
 	}
 	return ret, nil
@@ -923,7 +976,7 @@ func getFilterByTileFunctions(matchFields []string, condDigests map[string]param
 // fields listed in matchFields. condDigests contains the digests their
 // parameter sets for which we would like to find a set of digests for
 // comparison. It returns a set of digests for each digest in condDigests.
-func filterTileWithMatch(query *Query, matchFields []string, condDigests map[string]paramtools.ParamSet, storages *storage.Storage, idx *indexer.SearchIndex) (map[string]util.StringSet, error) {
+func filterTileWithMatch(query *Query, matchFields []string, condDigests map[string]paramtools.ParamSet, exp *expstorage.Expectations, idx *indexer.SearchIndex) (map[string]util.StringSet, error) {
 	if len(condDigests) == 0 {
 		return map[string]util.StringSet{}, nil
 	}
@@ -961,7 +1014,7 @@ func filterTileWithMatch(query *Query, matchFields []string, condDigests map[str
 		}
 	}
 
-	if err := iterTile(query, addFn, acceptFn, storages, idx); err != nil {
+	if err := iterTile(query, addFn, acceptFn, exp, idx); err != nil {
 		return nil, err
 	}
 	return ret, nil
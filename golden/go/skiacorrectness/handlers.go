@@ -308,7 +308,7 @@ func jsonDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ret, err := search.GetDigestDetails(test, digest, storages, ixr.GetIndex())
+	ret, err := search.GetDigestDetails(test, digest, formBranch(r), storages, ixr.GetIndex())
 	if err != nil {
 		httputils.ReportError(w, r, err, "Unable to get digest details.")
 		return
@@ -331,7 +331,7 @@ func jsonDiffHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ret, err := search.CompareDigests(test, left, right, storages, ixr.GetIndex())
+	ret, err := search.CompareDigests(test, left, right, formBranch(r), storages, ixr.GetIndex())
 	if err != nil {
 		httputils.ReportError(w, r, err, "Unable to compare digests")
 		return
@@ -479,6 +479,29 @@ type TriageRequest struct {
 	Filter           string                       `json:"filter"`
 	Include          bool                         `json:"include"` // Include ignored digests.
 	Head             bool                         `json:"head"`    // Only include digests at head if true.
+
+	// Branch and Issue select the branch or trybot issue whose expectations
+	// are changed. If both are empty the master expectations are changed.
+	Branch string `json:"branch"`
+	Issue  string `json:"issue"`
+}
+
+// branch returns the branch whose expectations are changed by the request.
+func (t *TriageRequest) branch() string {
+	if t.Branch == "" && t.Issue != "" {
+		return expstorage.IssueBranch(t.Issue)
+	}
+	return t.Branch
+}
+
+// formBranch returns the branch selected by the "branch" and "issue" form
+// values of the given request. See TriageRequest.branch.
+func formBranch(r *http.Request) string {
+	req := &TriageRequest{
+		Branch: r.Form.Get("branch"),
+		Issue:  r.Form.Get("issue"),
+	}
+	return req.branch()
 }
 
 // jsonTriageHandler handles a request to change the triage status of one or more
@@ -501,10 +524,11 @@ func jsonTriageHandler(w http.ResponseWriter, r *http.Request) {
 	sklog.Infof("Triage request: %#v", req)
 
 	var tc map[string]types.TestClassification
+	branch := req.branch()
 
 	// Build the expectations change request from filter, query, and include.
 	if req.All {
-		exp, err := storages.ExpectationsStore.Get()
+		exp, err := storages.GetExpectations(branch)
 		if err != nil {
 			httputils.ReportError(w, r, err, "Failed to load expectations.")
 			return
@@ -541,7 +565,13 @@ func jsonTriageHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := storages.ExpectationsStore.AddChange(tc, user); err != nil {
+	var err error
+	if branch != "" {
+		err = storages.BranchExpStore.AddChange(branch, tc, user)
+	} else {
+		err = storages.ExpectationsStore.AddChange(tc, user)
+	}
+	if err != nil {
 		httputils.ReportError(w, r, err, "Failed to store the updated expectations.")
 		return
 	}
@@ -620,6 +650,10 @@ func jsonClusterDiffHandler(w http.ResponseWriter, r *http.Request) {
 		httputils.ReportError(w, r, err, "Unable to parse query parameter.")
 		return
 	}
+	if q.Expr != nil {
+		httputils.ReportError(w, r, fmt.Errorf("Query expressions are not supported: %q", r.FormValue("query")), "Clustering requires a URL encoded query with a test name.")
+		return
+	}
 	testName := q.Query.Get(types.PRIMARY_KEY_FIELD)
 	if testName == "" {
 		httputils.ReportError(w, r, fmt.Errorf("test name parameter missing"), "No test name provided.")
@@ -728,7 +762,8 @@ type ClusterDiffResult struct {
 //
 // It takes these parameters:
 //  include - If true ignored digests should be included. (true, false)
-//  query   - A query to restrict the responses to, encoded as a URL encoded paramset.
+//  query   - A query to restrict the responses to, encoded as a URL encoded paramset
+//            or as a boolean query expression, see query.NewFromString.
 //  head    - if only digest that appear at head should be included.
 //  unt     - If true include tests that have untriaged digests. (true, false)
 //  pos     - If true include tests that have positive digests. (true, false)
@@ -754,44 +789,58 @@ func jsonListTestsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If the query only includes source_type parameters, and include==false, then we can just
-	// filter the response from summaries.Get(). If the query is broader than that, or
-	// include==true, then we need to call summaries.CalcSummaries().
 	if err := r.ParseForm(); err != nil {
 		httputils.ReportError(w, r, err, "Invalid request.")
 		return
 	}
 
-	idx := ixr.GetIndex()
+	sumSlice, err := listTests(ixr.GetIndex(), &query)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to calculate summaries.")
+		return
+	}
+
+	sort.Sort(SummarySlice(sumSlice))
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(sumSlice); err != nil {
+		sklog.Errorf("Failed to write or encode result: %s", err)
+	}
+}
+
+// listTests returns the summaries of the tests that match the given query.
+func listTests(idx *indexer.SearchIndex, query *search.Query) ([]*summary.Summary, error) {
+	// If the query only includes source_type parameters, and include==false, then we can just
+	// filter the response from summaries.Get(). If the query is broader than that, or
+	// include==true, then we need to call summaries.CalcSummaries().
 	corpus, hasSourceType := query.Query[types.CORPUS_FIELD]
 	sumSlice := []*summary.Summary{}
-	if !query.IncludeIgnores && query.Head && len(query.Query) == 1 && hasSourceType {
+	if !query.IncludeIgnores && query.Head && len(query.Query) == 1 && hasSourceType && query.Expr == nil {
 		sumMap := idx.GetSummaries()
 		for _, s := range sumMap {
-			if util.In(s.Corpus, corpus) && includeSummary(s, &query) {
-				sumSlice = append(sumSlice, s)
-			}
-		}
-	} else {
-		sklog.Infof("%q %q %q", r.FormValue("query"), r.FormValue("include"), r.FormValue("head"))
-		sumMap, err := idx.CalcSummaries(nil, query.Query, query.IncludeIgnores, query.Head)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Failed to calculate summaries.")
-			return
-		}
-		for _, s := range sumMap {
-			if includeSummary(s, &query) {
+			if util.In(s.Corpus, corpus) && includeSummary(s, query) {
 				sumSlice = append(sumSlice, s)
 			}
 		}
+		return sumSlice, nil
 	}
 
-	sort.Sort(SummarySlice(sumSlice))
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(sumSlice); err != nil {
-		sklog.Errorf("Failed to write or encode result: %s", err)
+	var sumMap map[string]*summary.Summary
+	var err error
+	if query.Expr != nil {
+		sumMap, err = idx.CalcSummariesByExpr(nil, query.Expr, query.IncludeIgnores, query.Head)
+	} else {
+		sumMap, err = idx.CalcSummaries(nil, query.Query, query.IncludeIgnores, query.Head)
+	}
+	if err != nil {
+		return nil, err
 	}
+	for _, s := range sumMap {
+		if includeSummary(s, query) {
+			sumSlice = append(sumSlice, s)
+		}
+	}
+	return sumSlice, nil
 }
 
 // includeSummary returns true if the given summary matches the query flags.
@@ -823,13 +872,9 @@ func parseQuery(r *http.Request, query *search.Query) error {
 	}
 
 	// Parse the query
-	var err error
-	query.Query = url.Values{}
-	if q := r.FormValue("query"); q != "" {
-		query.Query, err = url.ParseQuery(q)
-		if err != nil {
-			return fmt.Errorf("Unable to parse query: %s. Error: %s", q, err)
-		}
+	q := r.FormValue("query")
+	if err := query.SetQuery(q); err != nil {
+		return fmt.Errorf("Unable to parse query: %s. Error: %s", q, err)
 	}
 
 	// Parse out the patchsets.
@@ -849,7 +894,8 @@ func parseQuery(r *http.Request, query *search.Query) error {
 	}
 
 	validate := search.Validation{}
-	validate.StrFormValue(r, "metric", &query.Metric, diff.GetDiffMetricIDs(), diff.METRIC_COMBINED)
+	defaultMetric := diff.GetCorpusMetric(query.Query.Get(types.CORPUS_FIELD), diff.METRIC_COMBINED)
+	validate.StrFormValue(r, "metric", &query.Metric, diff.GetDiffMetricIDs(), defaultMetric)
 	validate.StrFormValue(r, "sort", &query.Sort, []string{search.SORT_DESC, search.SORT_ASC}, search.SORT_DESC)
 
 	// Parse and validate the filter values.
@@ -866,6 +912,7 @@ func parseQuery(r *http.Request, query *search.Query) error {
 	query.Head = r.FormValue("head") == "true"
 	query.IncludeIgnores = r.FormValue("include") == "true"
 	query.Issue = r.FormValue("issue")
+	query.Branch = r.FormValue("branch")
 	query.IncludeMaster = r.FormValue("master") == "true"
 
 	// Extract the filter values.
@@ -1090,3 +1137,117 @@ func jsonCompareTestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	sendJsonResponse(w, compareResult)
 }
+
+// jsonExportExpectationsHandler returns the positive and negative
+// expectations in the stable format of expstorage.ExportedExpectations.
+// The optional query parameters 'corpus' and 'test' (which may be repeated)
+// restrict the export to the tests in the given corpus and to the given tests.
+func jsonExportExpectationsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse form values.")
+		return
+	}
+	var tests util.StringSet = nil
+	if names, ok := r.Form["test"]; ok {
+		tests = util.NewStringSet(names)
+	}
+	if corpus := r.FormValue("corpus"); corpus != "" {
+		corpusTests := util.StringSet{}
+		for _, trace := range ixr.GetIndex().GetTile(true).Traces {
+			params := trace.Params()
+			if params[types.CORPUS_FIELD] == corpus {
+				corpusTests[params[types.PRIMARY_KEY_FIELD]] = true
+			}
+		}
+		if tests == nil {
+			tests = corpusTests
+		} else {
+			tests = tests.Intersect(corpusTests)
+		}
+	}
+
+	exp, err := storages.ExpectationsStore.Get()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to load expectations.")
+		return
+	}
+	setJSONHeaders(w)
+	if err := expstorage.WriteExport(w, expstorage.Export(exp, tests)); err != nil {
+		sklog.Errorf("Failed to write exported expectations: %s", err)
+	}
+}
+
+// jsonImportExpectationsHandler applies expectations in the format returned
+// by jsonExportExpectationsHandler. All changes are recorded in a single
+// triage log entry for the logged in user, so the import can be undone.
+func jsonImportExpectationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to import expectations.")
+		return
+	}
+
+	defer util.Close(r.Body)
+	e, err := expstorage.ReadExport(r.Body)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse exported expectations.")
+		return
+	}
+	n, err := expstorage.Import(storages.ExpectationsStore, e, user)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to import expectations.")
+		return
+	}
+	sklog.Infof("%s imported %d expectations.", user, n)
+	sendJsonResponse(w, map[string]int{"changed": n})
+}
+
+// PromoteRequest is the POST'd request body of jsonPromoteBranchHandler.
+type PromoteRequest struct {
+	Branch string `json:"branch"`
+	Issue  string `json:"issue"`
+}
+
+// jsonBranchesHandler returns the names of the branches and trybot issues
+// which have expectations that differ from master.
+func jsonBranchesHandler(w http.ResponseWriter, r *http.Request) {
+	branches, err := storages.BranchExpStore.Branches()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to list branches.")
+		return
+	}
+	sendJsonResponse(w, branches)
+}
+
+// jsonPromoteBranchHandler applies the expectations of a branch or trybot
+// issue to master in a single triage log entry and removes the branch. It
+// returns the expectations that were changed in master.
+func jsonPromoteBranchHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to change expectations.")
+		return
+	}
+
+	req := &PromoteRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse JSON request.")
+		return
+	}
+	branch := req.Branch
+	if branch == "" && req.Issue != "" {
+		branch = expstorage.IssueBranch(req.Issue)
+	}
+	if err := expstorage.ValidateBranch(branch); err != nil {
+		httputils.ReportError(w, r, err, "Invalid branch.")
+		return
+	}
+
+	changes, err := storages.BranchExpStore.Promote(branch, user)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to promote the expectations of the branch.")
+		return
+	}
+	sklog.Infof("%s promoted the expectations of %s to master.", user, branch)
+	sendJsonResponse(w, changes)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/gcs"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/mocks"
+	"go.skia.org/infra/golden/go/search"
+	"go.skia.org/infra/golden/go/serialize"
+	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/summary"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// Directory with testdata.
+	TEST_DATA_DIR = "./testdata"
+
+	// Local file location of the test data.
+	TEST_DATA_PATH = TEST_DATA_DIR + "/10-test-sample.tile"
+
+	// Folder in the testdata bucket. See go/testutils for details.
+	TEST_DATA_STORAGE_PATH = "gold-testdata/10-test-sample.tile"
+)
+
+func TestListTests(t *testing.T) {
+	testutils.MediumTest(t)
+
+	idx := getTestIndex(t)
+	testNames := util.StringSet{}
+	for _, trace := range idx.GetTile(false).Traces {
+		testNames[trace.Params()[types.PRIMARY_KEY_FIELD]] = true
+	}
+	names := testNames.Keys()
+	sort.Strings(names)
+	assert.True(t, len(names) > 2)
+
+	listNames := func(q string) []string {
+		query := &search.Query{Pos: true, Neg: true, Unt: true}
+		assert.NoError(t, query.SetQuery(q))
+		sums, err := listTests(idx, query)
+		assert.NoError(t, err)
+		ret := make([]string, 0, len(sums))
+		for _, s := range sums {
+			ret = append(ret, s.Name)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	// Expressions restrict the tests like URL encoded queries do.
+	assert.Equal(t, names, listNames(""))
+	assert.Equal(t, names[:1], listNames("name="+names[0]))
+	assert.Equal(t, names[:2], listNames("name="+names[0]+" OR name="+names[1]))
+	assert.Equal(t, names[1:], listNames("NOT name="+names[0]))
+	assert.Equal(t, []string{}, listNames("name="+names[0]+" AND name="+names[1]))
+
+	// The summary of an expression only counts the matching traces.
+	query := &search.Query{Pos: true, Neg: true, Unt: true, Head: true}
+	assert.NoError(t, query.SetQuery("source_type=gm&name="+names[0]))
+	expected, err := listTests(idx, query)
+	assert.NoError(t, err)
+	assert.NoError(t, query.SetQuery("source_type=gm AND name="+names[0]))
+	actual, err := listTests(idx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, summaryNames(expected), summaryNames(actual))
+	assert.Equal(t, expected[0].Untriaged, actual[0].Untriaged)
+	assert.Equal(t, expected[0].Num, actual[0].Num)
+}
+
+func TestJsonClusterDiffHandlerRejectsExpr(t *testing.T) {
+	testutils.SmallTest(t)
+
+	q := url.Values{"query": {"name=foo OR name=bar"}}
+	r := httptest.NewRequest("GET", "/json/clusterdiff?"+q.Encode(), nil)
+	w := httptest.NewRecorder()
+	jsonClusterDiffHandler(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func summaryNames(sums []*summary.Summary) []string {
+	ret := make([]string, 0, len(sums))
+	for _, s := range sums {
+		ret = append(ret, s.Name)
+	}
+	return ret
+}
+
+func getTestIndex(t *testing.T) *indexer.SearchIndex {
+	err := gcs.DownloadTestDataFile(t, gcs.TEST_DATA_BUCKET, TEST_DATA_STORAGE_PATH, TEST_DATA_PATH)
+	assert.NoError(t, err, "Unable to download testdata.")
+	defer testutils.RemoveAll(t, TEST_DATA_DIR)
+
+	file, err := os.Open(TEST_DATA_PATH)
+	assert.NoError(t, err)
+	defer util.Close(file)
+	sample, err := serialize.DeserializeSample(file)
+	assert.NoError(t, err)
+
+	eventBus := eventbus.New(nil)
+	expStore := expstorage.NewMemExpectationsStore(eventBus)
+	assert.NoError(t, expStore.AddChange(sample.Expectations.Tests, "testuser"))
+
+	storages := &storage.Storage{
+		ExpectationsStore: expStore,
+		MasterTileBuilder: mocks.NewMockTileBuilderFromTile(t, sample.Tile),
+		DigestStore: &mocks.MockDigestStore{
+			FirstSeen: time.Now().Unix(),
+			OkValue:   true,
+		},
+		DiffStore: mocks.NewMockDiffStore(),
+		EventBus:  eventBus,
+	}
+
+	ixr, err := indexer.New(storages, 10*time.Minute)
+	assert.NoError(t, err)
+	return ixr.GetIndex()
+}
@@ -31,9 +31,11 @@ import (
 	tracedb "go.skia.org/infra/go/trace/db"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/diff"
 	"go.skia.org/infra/golden/go/diffstore"
 	"go.skia.org/infra/golden/go/digeststore"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/fuzzy"
 	"go.skia.org/infra/golden/go/goldingestion"
 	"go.skia.org/infra/golden/go/history"
 	"go.skia.org/infra/golden/go/ignore"
@@ -51,9 +53,13 @@ var (
 	appTitle           = flag.String("app_title", "Skia Gold", "Title of the deployed up on the front end.")
 	authWhiteList      = flag.String("auth_whitelist", login.DEFAULT_DOMAIN_WHITELIST, "White space separated list of domains and email addresses that are allowed to login.")
 	cacheSize          = flag.Int("cache_size", 1, "Approximate cachesize used to cache images and diff metrics in GiB. This is just a way to limit caching. 0 means no caching at all. Use default for testing.")
+	corpusMasks        = flag.String("corpus_masks", "", "Semicolon-separated list of corpus:masks pairs. For each pair a diff metric which ignores the pixels in the given regions, e.g. a clock, becomes the default diff metric of the corpus. masks is a space-separated list of x0,y0,x1,y1 rectangles, e.g. 'clock:0,0,100,20 0,80,10,100'.")
+	corpusMetrics      = flag.String("corpus_metrics", "", "Comma-separated list of corpus:metric pairs which set the diff metric used by default when searching a corpus, e.g. 'text:ssim'. Optional metrics have to be enabled with --diff_metrics.")
 	cpuProfile         = flag.Duration("cpu_profile", 0, "Duration for which to profile the CPU usage. After this duration the program writes the CPU profile and exits.")
 	doOauth            = flag.Bool("oauth", true, "Run through the OAuth 2.0 flow on startup, otherwise use a GCE service account.")
 	defaultCorpus      = flag.String("default_corpus", "gm", "The corpus identifier shown by default on the frontend.")
+	diffMetrics        = flag.String("diff_metrics", "", "Comma-separated list of optional diff metrics to compute in addition to the default ones, e.g. 'ssim,ignorealpha'. Enabled metrics are cached separately from the diffs and computed for cached diffs when they are next used.")
+	fuzzyRules         = flag.String("fuzzy_rules", "", "JSON file containing per-test rules for automatically triaging digests which are within tolerance of a positive digest. If blank, no digests are auto-triaged.")
 	forceLogin         = flag.Bool("force_login", false, "Force the user to be authenticated for all requests.")
 	gsBucketNames      = flag.String("gs_buckets", "skia-infra-gm,chromium-skia-gm", "Comma-separated list of google storage bucket that hold uploaded images.")
 	imageDir           = flag.String("image_dir", "/tmp/imagedir", "What directory to store test and diff images in.")
@@ -166,6 +172,42 @@ func main() {
 		sklog.Fatalf("Failed to authenticate service account: %s", err)
 	}
 
+	// Enable the optional diff metrics and set the default diff metrics per
+	// corpus.
+	if *diffMetrics != "" {
+		for _, id := range strings.Split(*diffMetrics, ",") {
+			if err := diff.EnableMetric(id); err != nil {
+				sklog.Fatal(err)
+			}
+		}
+	}
+	if *corpusMetrics != "" {
+		for _, pair := range strings.Split(*corpusMetrics, ",") {
+			parts := strings.Split(pair, ":")
+			if len(parts) != 2 {
+				sklog.Fatalf("Invalid corpus:metric pair %q", pair)
+			}
+			if err := diff.SetCorpusMetric(parts[0], parts[1]); err != nil {
+				sklog.Fatal(err)
+			}
+		}
+	}
+	if *corpusMasks != "" {
+		for _, pair := range strings.Split(*corpusMasks, ";") {
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 {
+				sklog.Fatalf("Invalid corpus:masks pair %q", pair)
+			}
+			masks, err := diff.ParseMasks(parts[1])
+			if err != nil {
+				sklog.Fatal(err)
+			}
+			if err := diff.SetCorpusMask(parts[0], masks); err != nil {
+				sklog.Fatal(err)
+			}
+		}
+	}
+
 	// Get the expecations storage, the filediff storage and the tilestore.
 	diffStore, err := diffstore.New(client, *imageDir, strings.Split(*gsBucketNames, ","), diffstore.DEFAULT_GCS_IMG_DIR_NAME, *cacheSize)
 	if err != nil {
@@ -233,6 +275,8 @@ func main() {
 		GerritAPI:         gerritAPI,
 	}
 
+	storages.BranchExpStore = expstorage.NewSQLBranchExpectationsStore(vdb, storages.ExpectationsStore)
+
 	// TODO(stephana): Remove this workaround to avoid circular dependencies once the 'storage' module is cleaned up.
 	storages.IgnoreStore = ignore.NewSQLIgnoreStore(vdb, storages.ExpectationsStore, storages.GetTileStreamNow(time.Minute))
 
@@ -250,6 +294,14 @@ func main() {
 		sklog.Fatalf("Failed to create indexer: %s", err)
 	}
 
+	if *fuzzyRules != "" {
+		rules, err := fuzzy.ReadRules(*fuzzyRules)
+		if err != nil {
+			sklog.Fatalf("Failed to read fuzzy matching rules: %s", err)
+		}
+		fuzzy.New(storages, rules).Start()
+	}
+
 	searchAPI, err = search.NewSearchAPI(storages, ixr)
 	if err != nil {
 		sklog.Fatalf("Failed to create instance of search API: %s", err)
@@ -302,6 +354,10 @@ func main() {
 	router.HandleFunc("/json/cmp", jsonCompareTestHandler).Methods("POST")
 	router.HandleFunc("/json/triagelog", jsonTriageLogHandler).Methods("GET")
 	router.HandleFunc("/json/triagelog/undo", jsonTriageUndoHandler).Methods("POST")
+	router.HandleFunc("/json/expectations/export", jsonExportExpectationsHandler).Methods("GET")
+	router.HandleFunc("/json/expectations/import", jsonImportExpectationsHandler).Methods("POST")
+	router.HandleFunc("/json/branches", jsonBranchesHandler).Methods("GET")
+	router.HandleFunc("/json/branches/promote", jsonPromoteBranchHandler).Methods("POST")
 	router.HandleFunc("/json/trybot", jsonListTrybotsHandler).Methods("GET")
 	router.HandleFunc("/json/failure", jsonListFailureHandler).Methods("GET")
 	router.HandleFunc("/json/failure/clear", jsonClearFailureHandler).Methods("POST")
@@ -24,6 +24,7 @@ import (
 type Storage struct {
 	DiffStore         diff.DiffStore
 	ExpectationsStore expstorage.ExpectationsStore
+	BranchExpStore    expstorage.BranchExpectationsStore
 	IgnoreStore       ignore.IgnoreStore
 	MasterTileBuilder tracedb.MasterTileBuilder
 	BranchTileBuilder tracedb.BranchTileBuilder
@@ -44,6 +45,16 @@ type Storage struct {
 	mutex                  sync.Mutex
 }
 
+// GetExpectations returns the expectations of the given branch, i.e. the
+// master expectations merged with the delta of the branch. If branch is empty
+// or branches are not supported, the master expectations are returned.
+func (s *Storage) GetExpectations(branch string) (*expstorage.Expectations, error) {
+	if branch == "" || s.BranchExpStore == nil {
+		return s.ExpectationsStore.Get()
+	}
+	return s.BranchExpStore.Get(branch)
+}
+
 // GetTileStreamNow is a utility function that reads tiles in the given
 // interval and sends them on the returned channel.
 // The first tile is send immediately.
@@ -0,0 +1,99 @@
+// Package alerts provides the configurations of the alerts that continuous
+// clustering uses to look for regressions.
+package alerts
+
+import (
+	"fmt"
+	"strings"
+
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/perf/go/clustering2"
+)
+
+// Direction is the direction of the steps an alert reports.
+type Direction string
+
+// Direction constants.
+const (
+	BOTH Direction = "BOTH" // Report steps up and steps down.
+	UP   Direction = "UP"   // Only report steps up, i.e. HIGH clusters.
+	DOWN Direction = "DOWN" // Only report steps down, i.e. LOW clusters.
+)
+
+const (
+	// INVALID_ID is the ID of a Config that hasn't been stored yet.
+	INVALID_ID = -1
+)
+
+// Config is the configuration of a single alert.
+type Config struct {
+	ID          int64                   `json:"id"`
+	DisplayName string                  `json:"display_name"`
+	Query       string                  `json:"query"`       // The URL query encoded query that selects the traces to cluster.
+	Algo        clustering2.ClusterAlgo `json:"algo"`        // The algorithm used to find regressions.
+	Radius      int                     `json:"radius"`      // The number of commits on either side of a commit to cluster over. 0 means the default.
+	Interesting float32                 `json:"interesting"` // The StepFit.Regression threshhold for k-means clusters. 0 means the default.
+	Owner       string                  `json:"owner"`       // The email address of the owner of the alert.
+	Direction   Direction               `json:"direction"`
+
+	// Emails are the addresses notified when the alert finds a new regression.
+	Emails []string `json:"emails"`
+
+	// FileBug is true if a bug should be filed, owned by Owner, when the alert
+	// finds a new regression.
+	FileBug bool `json:"file_bug"`
+}
+
+// NewConfig returns a new Config with default values.
+func NewConfig() *Config {
+	return &Config{
+		ID:        INVALID_ID,
+		Algo:      clustering2.KMEANS_ALGO,
+		Direction: BOTH,
+	}
+}
+
+// Validate returns an error if the Config isn't valid.
+func (c *Config) Validate() error {
+	if c.Query == "" {
+		return fmt.Errorf("An alert must have a query.")
+	}
+	if _, err := query.NewFromString(c.Query); err != nil {
+		return fmt.Errorf("Invalid query %q: %s", c.Query, err)
+	}
+	if err := clustering2.ValidateClusterAlgo(c.Algo); err != nil {
+		return err
+	}
+	if c.Radius < 0 || c.Radius > clustering2.MAX_RADIUS {
+		return fmt.Errorf("Radius must be between 0 and %d.", clustering2.MAX_RADIUS)
+	}
+	if c.Interesting < 0 {
+		return fmt.Errorf("Interesting must not be negative.")
+	}
+	switch c.Direction {
+	case BOTH, UP, DOWN:
+	default:
+		return fmt.Errorf("Unknown direction: %q", c.Direction)
+	}
+	for _, e := range c.Emails {
+		if !strings.Contains(e, "@") {
+			return fmt.Errorf("Invalid email address: %q", e)
+		}
+	}
+	if c.FileBug && c.Owner == "" {
+		return fmt.Errorf("An alert that files bugs must have an owner.")
+	}
+	return nil
+}
+
+// Reports returns true if the alert reports clusters with the given
+// clustering2 status, i.e. clustering2.LOW or clustering2.HIGH.
+func (c *Config) Reports(status string) bool {
+	switch status {
+	case clustering2.HIGH:
+		return c.Direction != DOWN
+	case clustering2.LOW:
+		return c.Direction != UP
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package alerts
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/perf/go/clustering2"
+	"go.skia.org/infra/perf/go/db"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestValidate(t *testing.T) {
+	testutils.SmallTest(t)
+	cfg := NewConfig()
+	assert.Error(t, cfg.Validate(), "A query is required.")
+
+	cfg.Query = "source_type=skp&sub_result=min_ms"
+	assert.NoError(t, cfg.Validate())
+	cfg.Query = "source_type=skp AND (sub_result=min_ms OR"
+	assert.Error(t, cfg.Validate())
+	cfg.Query = "source_type=skp AND (sub_result=min_ms OR sub_result=max_ms)"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Algo = "dbscan"
+	assert.Error(t, cfg.Validate())
+	cfg.Algo = clustering2.CHANGEPOINT_ALGO
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Radius = clustering2.MAX_RADIUS + 1
+	assert.Error(t, cfg.Validate())
+	cfg.Radius = 7
+
+	cfg.Interesting = -1
+	assert.Error(t, cfg.Validate())
+	cfg.Interesting = 0
+
+	cfg.Direction = "SIDEWAYS"
+	assert.Error(t, cfg.Validate())
+	cfg.Direction = UP
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Emails = []string{"someone@example.com", "nobody"}
+	assert.Error(t, cfg.Validate())
+	cfg.Emails = []string{"someone@example.com"}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.FileBug = true
+	assert.Error(t, cfg.Validate(), "Filing bugs requires an owner.")
+	cfg.Owner = "someone@example.com"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestReports(t *testing.T) {
+	testutils.SmallTest(t)
+	cfg := NewConfig()
+	assert.True(t, cfg.Reports(clustering2.HIGH))
+	assert.True(t, cfg.Reports(clustering2.LOW))
+	assert.False(t, cfg.Reports(clustering2.UNINTERESTING))
+
+	cfg.Direction = UP
+	assert.True(t, cfg.Reports(clustering2.HIGH))
+	assert.False(t, cfg.Reports(clustering2.LOW))
+
+	cfg.Direction = DOWN
+	assert.False(t, cfg.Reports(clustering2.HIGH))
+	assert.True(t, cfg.Reports(clustering2.LOW))
+}
+
+func TestSave(t *testing.T) {
+	testutils.SmallTest(t)
+	// Set up mock db.
+	mdb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mdb.Close()
+
+	cfg := NewConfig()
+	cfg.Query = "source_type=skp"
+	body, err := json.Marshal(cfg)
+	assert.NoError(t, err)
+
+	// Set expectations.
+	mock.ExpectExec("INSERT INTO alerts").WithArgs(string(body)).WillReturnResult(sqlmock.NewResult(12, 1))
+
+	// Put mock db into place.
+	db.DB = mdb
+
+	st := NewStore()
+	err = st.Save(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), cfg.ID)
+
+	// Now update the stored Config.
+	cfg.Direction = DOWN
+	body, err = json.Marshal(cfg)
+	assert.NoError(t, err)
+	mock.ExpectExec("UPDATE alerts SET body=(.+) WHERE id=(.+)").WithArgs(string(body), int64(12)).WillReturnResult(sqlmock.NewResult(0, 1))
+	err = st.Save(cfg)
+	assert.NoError(t, err)
+
+	// Invalid Config's are not stored.
+	cfg.Query = ""
+	assert.Error(t, st.Save(cfg))
+
+	// Make sure that all expectations were met.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	testutils.SmallTest(t)
+	// Set up mock db.
+	mdb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mdb.Close()
+
+	// The first body was stored before its ID was known.
+	rows := sqlmock.NewRows([]string{"id", "body"}).
+		AddRow(1, `{"id":-1,"query":"source_type=skp","algo":"kmeans","direction":"BOTH"}`).
+		AddRow(2, `{"id":2,"query":"source_type=svg","algo":"changepoint","radius":10,"direction":"UP"}`)
+
+	// Set expectations.
+	mock.ExpectQuery("SELECT id, body FROM alerts ORDER BY id").WillReturnRows(rows)
+	mock.ExpectExec("DELETE FROM alerts WHERE id=(.+)").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Put mock db into place.
+	db.DB = mdb
+
+	st := NewStore()
+	configs, err := st.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(configs))
+	assert.Equal(t, int64(1), configs[0].ID)
+	assert.Equal(t, "source_type=skp", configs[0].Query)
+	assert.Equal(t, int64(2), configs[1].ID)
+	assert.Equal(t, clustering2.CHANGEPOINT_ALGO, configs[1].Algo)
+	assert.Equal(t, 10, configs[1].Radius)
+	assert.Equal(t, UP, configs[1].Direction)
+
+	assert.NoError(t, st.Delete(2))
+
+	// Make sure that all expectations were met.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,84 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/perf/go/db"
+)
+
+// Store persists Config's to/from an SQL database.
+type Store struct {
+}
+
+// NewStore returns a new Store.
+func NewStore() *Store {
+	return &Store{}
+}
+
+// Save validates and stores the given Config. If the Config hasn't been
+// stored before, i.e. its ID is INVALID_ID, then its ID is set to the ID of
+// the newly stored Config.
+func (s *Store) Save(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("Failed to encode Config to JSON: %s", err)
+	}
+	if cfg.ID == INVALID_ID {
+		result, err := db.DB.Exec("INSERT INTO alerts (body) VALUES (?)", string(body))
+		if err != nil {
+			return fmt.Errorf("Failed to insert alert: %s", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("Failed to retrieve ID of new alert: %s", err)
+		}
+		cfg.ID = id
+		// The stored body has INVALID_ID as its id, which List replaces with the
+		// row id.
+		return nil
+	}
+	if _, err := db.DB.Exec("UPDATE alerts SET body=? WHERE id=?", string(body), cfg.ID); err != nil {
+		return fmt.Errorf("Failed to update alert: %s", err)
+	}
+	return nil
+}
+
+// Delete removes the Config with the given ID.
+func (s *Store) Delete(id int64) error {
+	if _, err := db.DB.Exec("DELETE FROM alerts WHERE id=?", id); err != nil {
+		return fmt.Errorf("Failed to delete alert: %s", err)
+	}
+	return nil
+}
+
+// List returns all the stored Config's, ordered by ID.
+func (s *Store) List() ([]*Config, error) {
+	rows, err := db.DB.Query("SELECT id, body FROM alerts ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query from database: %s", err)
+	}
+	defer util.Close(rows)
+	ret := []*Config{}
+	for rows.Next() {
+		var id int64
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			return nil, fmt.Errorf("Failed to read from database: %s", err)
+		}
+		cfg := NewConfig()
+		if err := json.Unmarshal([]byte(body), cfg); err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON body: %s", err)
+		}
+		cfg.ID = id
+		ret = append(ret, cfg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error while iterating rows: %s", err)
+	}
+	return ret, nil
+}
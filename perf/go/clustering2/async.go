@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"net/url"
 	"sync"
 	"time"
 
@@ -48,7 +47,7 @@ var (
 	errorNotFound = errors.New("Process not found.")
 )
 
-// ClusterRequest is all the info needed to start a k-means clustering run.
+// ClusterRequest is all the info needed to start a clustering run.
 type ClusterRequest struct {
 	Source string `json:"source"`
 	Offset int    `json:"offset"`
@@ -56,6 +55,13 @@ type ClusterRequest struct {
 	Query  string `json:"query"`
 	K      int    `json:"k"`
 	TZ     string `json:"tz"`
+
+	// Algo is the algorithm used to find regressions. Defaults to KMEANS_ALGO.
+	Algo ClusterAlgo `json:"algo"`
+
+	// Interesting is the threshhold beyond which StepFit.Regression values
+	// are interesting. Defaults to INTERESTING_THRESHHOLD.
+	Interesting float32 `json:"interesting"`
 }
 
 func (c *ClusterRequest) Id() string {
@@ -260,12 +266,11 @@ func (p *ClusterRequestProcess) Run() {
 			Offset: i,
 		})
 	}
-	parsedQuery, err := url.ParseQuery(p.request.Query)
-	if err != nil {
-		p.reportError(err, "Invalid URL query.")
+	if err := ValidateClusterAlgo(p.request.Algo); err != nil {
+		p.reportError(err, "Invalid clustering algorithm.")
 		return
 	}
-	q, err := query.New(parsedQuery)
+	q, err := query.NewFromString(p.request.Query)
 	if err != nil {
 		p.reportError(err, "Invalid Query.")
 		return
@@ -283,20 +288,12 @@ func (p *ClusterRequestProcess) Run() {
 	after := len(df.TraceSet)
 	sklog.Infof("Filtered Traces: %d %d", before, after)
 
-	k := p.request.K
-	if k <= 0 || k > MAX_K {
-		n := len(df.TraceSet)
-		// We want K to be around 50 when n = 30000, which has been determined via
-		// trial and error to be a good value for the Perf data we are working in. We
-		// want K to decrease from  there as n gets smaller, but don't want K to go
-		// below 10, so we use a simple linear relation:
-		//
-		//  k = 40/30000 * n + 10
-		//
-		k = int(math.Floor((40.0/30000.0)*float64(n) + 10))
+	var summary *ClusterSummaries
+	if p.request.Algo == CHANGEPOINT_ALGO {
+		summary, err = CalculateChangePointSummaries(df, config.MIN_STDDEV, p.request.Interesting)
+	} else {
+		summary, err = p.kmeans(df)
 	}
-	sklog.Infof("Clustering with K=%d", k)
-	summary, err := CalculateClusterSummaries(df, k, config.MIN_STDDEV, p.clusterProgress)
 	if err != nil {
 		p.reportError(err, "Invalid clustering.")
 		return
@@ -318,3 +315,30 @@ func (p *ClusterRequestProcess) Run() {
 		Frame:   frame,
 	}
 }
+
+// kmeans runs k-means clustering over the traces in the DataFrame.
+func (p *ClusterRequestProcess) kmeans(df *dataframe.DataFrame) (*ClusterSummaries, error) {
+	k := p.request.K
+	if k <= 0 || k > MAX_K {
+		n := len(df.TraceSet)
+		// We want K to be around 50 when n = 30000, which has been determined via
+		// trial and error to be a good value for the Perf data we are working in. We
+		// want K to decrease from  there as n gets smaller, but don't want K to go
+		// below 10, so we use a simple linear relation:
+		//
+		//  k = 40/30000 * n + 10
+		//
+		k = int(math.Floor((40.0/30000.0)*float64(n) + 10))
+	}
+	sklog.Infof("Clustering with K=%d", k)
+	summary, err := CalculateClusterSummaries(df, k, config.MIN_STDDEV, p.clusterProgress)
+	if err != nil {
+		return nil, err
+	}
+	if p.request.Interesting > 0 {
+		for _, cl := range summary.Clusters {
+			cl.StepFit.Status = getStatus(cl.StepFit.Regression, p.request.Interesting)
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,279 @@
+package clustering2
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"go.skia.org/infra/go/vec32"
+	"go.skia.org/infra/perf/go/config"
+	"go.skia.org/infra/perf/go/ctrace2"
+	"go.skia.org/infra/perf/go/dataframe"
+	"go.skia.org/infra/perf/go/kmeans"
+)
+
+// ClusterAlgo is the algorithm used to find regressions in a ClusterRequest.
+type ClusterAlgo string
+
+const (
+	// KMEANS_ALGO clusters the traces with k-means and fits a step function
+	// to the centroid of each cluster. This is the default.
+	KMEANS_ALGO ClusterAlgo = "kmeans"
+
+	// CHANGEPOINT_ALGO looks for a change point in each trace individually
+	// using the Mann-Whitney U test, and then groups the traces that change
+	// at the same commit in the same direction.
+	CHANGEPOINT_ALGO ClusterAlgo = "changepoint"
+
+	// MIN_CHANGEPOINT_SEGMENT is the minimum number of points required on
+	// either side of a change point.
+	MIN_CHANGEPOINT_SEGMENT = 5
+
+	// CHANGEPOINT_WINDOW is the maximum number of points on either side of a
+	// candidate change point that are compared, so that other changes further
+	// away in the trace don't mask or fake a change.
+	CHANGEPOINT_WINDOW = 10
+
+	// CHANGEPOINT_ALPHA is the significance level of the two-sided
+	// Mann-Whitney U test. Since every candidate change point of a trace is
+	// tested, it is divided by the number of candidates (Bonferroni
+	// correction).
+	CHANGEPOINT_ALPHA = 0.01
+)
+
+// ValidateClusterAlgo returns an error if algo isn't a known ClusterAlgo. The
+// empty string is valid and means KMEANS_ALGO.
+func ValidateClusterAlgo(algo ClusterAlgo) error {
+	switch algo {
+	case "", KMEANS_ALGO, CHANGEPOINT_ALGO:
+		return nil
+	}
+	return fmt.Errorf("Unknown clustering algorithm: %q", algo)
+}
+
+// changePoint is the most significant change point found in a single trace.
+type changePoint struct {
+	trace *ctrace2.ClusterableTrace
+
+	// index is the index of the first point after the change.
+	index int
+
+	// z is the Mann-Whitney z statistic of the change. Positive values
+	// indicate a step down, negative values a step up, matching the sign
+	// convention of StepFit.StepSize.
+	z float64
+}
+
+// rankedValue is a value in a trace along with its index.
+type rankedValue struct {
+	value float32
+	index int
+}
+
+type rankedValueSlice []rankedValue
+
+func (p rankedValueSlice) Len() int           { return len(p) }
+func (p rankedValueSlice) Less(i, j int) bool { return p[i].value < p[j].value }
+func (p rankedValueSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// ranks returns the rank of each value in the trace, with ties given the
+// average of the ranks they span. Ranks start at 1.
+func ranks(trace []float32) []float64 {
+	sorted := make(rankedValueSlice, len(trace))
+	for i, x := range trace {
+		sorted[i] = rankedValue{value: x, index: i}
+	}
+	sort.Sort(sorted)
+	ret := make([]float64, len(trace))
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].value == sorted[i].value {
+			j++
+		}
+		// Positions i..j-1 are tied, so they all get the average rank.
+		rank := float64(i+j+1) / 2.0
+		for k := i; k < j; k++ {
+			ret[sorted[k].index] = rank
+		}
+		i = j
+	}
+	return ret
+}
+
+// mannWhitneyZ returns the z statistic of the Mann-Whitney U test comparing
+// the points before index i to the points at and after index i, given the
+// ranks of all the points. Returns 0 if the points don't vary.
+func mannWhitneyZ(r []float64, i int) float64 {
+	n1 := float64(i)
+	n2 := float64(len(r) - i)
+	rankSum := 0.0
+	for _, x := range r[:i] {
+		rankSum += x
+	}
+	u := rankSum - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 * (n1 + n2 + 1) / 12)
+	if sigma == 0 {
+		return 0
+	}
+	return (u - mean) / sigma
+}
+
+// pValue returns the two-sided p-value of the z statistic.
+func pValue(z float64) float64 {
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// getChangePoint finds the most significant change point in the trace, or
+// returns nil if the trace has no significant change point. Each candidate
+// change point is tested over a window of at most CHANGEPOINT_WINDOW points
+// on either side of it. The step fit over that window must also have a
+// Regression of at least interesting, so that statistically significant but
+// tiny changes aren't reported.
+func getChangePoint(trace *ctrace2.ClusterableTrace, interesting float32) *changePoint {
+	values := trace.Values
+	if len(values) < 2*MIN_CHANGEPOINT_SEGMENT {
+		return nil
+	}
+	numCandidates := len(values) - 2*MIN_CHANGEPOINT_SEGMENT + 1
+	alpha := CHANGEPOINT_ALPHA / float64(numCandidates)
+	var ret *changePoint
+	for i := MIN_CHANGEPOINT_SEGMENT; i <= len(values)-MIN_CHANGEPOINT_SEGMENT; i++ {
+		begin := i - CHANGEPOINT_WINDOW
+		if begin < 0 {
+			begin = 0
+		}
+		end := i + CHANGEPOINT_WINDOW
+		if end > len(values) {
+			end = len(values)
+		}
+		z := mannWhitneyZ(ranks(values[begin:end]), i-begin)
+		if pValue(z) >= alpha {
+			continue
+		}
+		// The step fit must be large enough, and in the same direction
+		// as the change found by the test.
+		if getStatus(stepFitAt(values[begin:end], i-begin, "").Regression, interesting) != statusOf(z) {
+			continue
+		}
+		if ret == nil || math.Abs(z) > math.Abs(ret.z) {
+			ret = &changePoint{
+				trace: trace,
+				index: i,
+				z:     z,
+			}
+		}
+	}
+	return ret
+}
+
+// statusOf returns the status of a change with the given Mann-Whitney z
+// statistic.
+func statusOf(z float64) string {
+	if z < 0 {
+		return HIGH
+	}
+	return LOW
+}
+
+// stepFitAt returns the fit of a step function to the trace with the step at
+// index i, with the given status.
+func stepFitAt(trace []float32, i int, status string) *StepFit {
+	y0 := vec32.Mean(trace[:i])
+	y1 := vec32.Mean(trace[i:])
+	lse := vec32.SSE(trace[:i], y0) + vec32.SSE(trace[i:], y1)
+	lse = float32(math.Sqrt(float64(lse))) / float32(len(trace))
+	stepSize := y0 - y1
+	regression := float32(0.0)
+	if lse > 0 {
+		regression = stepSize / lse
+	} else if stepSize > 0 {
+		regression = math.MaxFloat32
+	} else if stepSize < 0 {
+		regression = -math.MaxFloat32
+	}
+	return &StepFit{
+		LeastSquares: lse,
+		StepSize:     stepSize,
+		TurningPoint: i,
+		Regression:   regression,
+		Status:       status,
+	}
+}
+
+// sortableChangePointSlice sorts changePoints by descending significance.
+type sortableChangePointSlice []*changePoint
+
+func (p sortableChangePointSlice) Len() int { return len(p) }
+func (p sortableChangePointSlice) Less(i, j int) bool {
+	return math.Abs(p[i].z) > math.Abs(p[j].z)
+}
+func (p sortableChangePointSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// changePointGroup identifies the traces that change at the same index in the
+// same direction.
+type changePointGroup struct {
+	index int
+	up    bool
+}
+
+// CalculateChangePointSummaries looks for a change point in each trace of the
+// DataFrame individually, which finds regressions in noisy traces that
+// k-means clustering can average away. Traces which change at the same commit
+// in the same direction are reported together as a single ClusterSummary, so
+// the results can be triaged and stored just like k-means clusters. Changes
+// are only reported if the Regression of their step fit is at least
+// interesting, which defaults to INTERESTING_THRESHHOLD.
+func CalculateChangePointSummaries(df *dataframe.DataFrame, stddevThreshhold, interesting float32) (*ClusterSummaries, error) {
+	if len(df.TraceSet) == 0 {
+		return nil, fmt.Errorf("Zero traces in the DataFrame.")
+	}
+	if interesting <= 0 {
+		interesting = INTERESTING_THRESHHOLD
+	}
+	groups := map[changePointGroup][]*changePoint{}
+	for key, trace := range df.TraceSet {
+		cp := getChangePoint(ctrace2.NewFullTrace(key, trace, stddevThreshhold), interesting)
+		if cp == nil {
+			continue
+		}
+		g := changePointGroup{index: cp.index, up: cp.z < 0}
+		groups[g] = append(groups[g], cp)
+	}
+
+	ret := &ClusterSummaries{
+		Clusters:         []*ClusterSummary{},
+		StdDevThreshhold: stddevThreshhold,
+	}
+	for g, cps := range groups {
+		sort.Sort(sortableChangePointSlice(cps))
+		members := make([]kmeans.Clusterable, len(cps))
+		for i, cp := range cps {
+			members[i] = cp.trace
+		}
+		numSampleKeys := len(cps)
+		if numSampleKeys > config.MAX_SAMPLE_TRACES_PER_CLUSTER {
+			numSampleKeys = config.MAX_SAMPLE_TRACES_PER_CLUSTER
+		}
+
+		centroid := ctrace2.CalculateCentroid(members).(*ctrace2.ClusterableTrace).Values
+		summary := newClusterSummary()
+		summary.Centroid = centroid
+		summary.ParamSummaries = getParamSummaries(members)
+		// Every member passed both the significance test and the
+		// Regression check, so the group is interesting.
+		status := LOW
+		if g.up {
+			status = HIGH
+		}
+		summary.StepFit = stepFitAt(centroid, g.index, status)
+		summary.StepPoint = df.Header[g.index]
+		summary.Num = len(cps)
+		for _, cp := range cps[:numSampleKeys] {
+			summary.Keys = append(summary.Keys, cp.trace.Key)
+		}
+		ret.Clusters = append(ret.Clusters, summary)
+	}
+	sort.Sort(sortableClusterSummarySlice(ret.Clusters))
+	return ret, nil
+}
@@ -0,0 +1,146 @@
+package clustering2
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/perf/go/ctrace2"
+	"go.skia.org/infra/perf/go/dataframe"
+	"go.skia.org/infra/perf/go/ptracestore"
+)
+
+func TestRanks(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, []float64{2, 1, 3}, ranks([]float32{2, 1, 3}))
+	assert.Equal(t, []float64{1, 2.5, 2.5, 4}, ranks([]float32{1, 2, 2, 3}))
+	assert.Equal(t, []float64{2, 2, 2}, ranks([]float32{5, 5, 5}))
+	assert.Equal(t, []float64{}, ranks([]float32{}))
+}
+
+func TestMannWhitneyZ(t *testing.T) {
+	testutils.SmallTest(t)
+	// Perfect separation, step down.
+	z := mannWhitneyZ(ranks([]float32{2, 2, 2, 1, 1, 1}), 3)
+	assert.InDelta(t, 4.5/math.Sqrt(5.25), z, 0.0001)
+	// Perfect separation, step up.
+	z = mannWhitneyZ(ranks([]float32{1, 1, 1, 2, 2, 2}), 3)
+	assert.InDelta(t, -4.5/math.Sqrt(5.25), z, 0.0001)
+	// Constant.
+	assert.Equal(t, 0.0, mannWhitneyZ(ranks([]float32{1, 1, 1, 1, 1, 1}), 3))
+}
+
+// steps returns a trace of the given length with a little noise, which starts
+// at levels[0] and steps to levels[i] at index at[i-1].
+func steps(n int, levels []float32, at []int) []float32 {
+	noise := []float32{0, 0.02, -0.02, 0.01, -0.01}
+	ret := make([]float32, n)
+	level := 0
+	for i := range ret {
+		if level < len(at) && i == at[level] {
+			level++
+		}
+		ret[i] = levels[level] + noise[i%len(noise)]
+	}
+	return ret
+}
+
+func TestGetChangePoint(t *testing.T) {
+	testutils.SmallTest(t)
+	cp := getChangePoint(ctrace2.NewFullTrace("a", steps(21, []float32{1, 2}, []int{10}), 0.001), INTERESTING_THRESHHOLD)
+	assert.NotNil(t, cp)
+	assert.Equal(t, 10, cp.index)
+	assert.True(t, cp.z < 0)
+
+	// The step is significant, but too small to be interesting.
+	assert.Nil(t, getChangePoint(ctrace2.NewFullTrace("a", steps(21, []float32{1, 2}, []int{10}), 0.001), 1e6))
+
+	// Only the points around a change point are compared, so a step up
+	// followed by a step down is still found.
+	cp = getChangePoint(ctrace2.NewFullTrace("b", steps(41, []float32{1, 2, 1}, []int{10, 30}), 0.001), INTERESTING_THRESHHOLD)
+	assert.NotNil(t, cp)
+	assert.Equal(t, 10, cp.index)
+	assert.True(t, cp.z < 0)
+
+	// A perfectly separated step in a short trace isn't significant once
+	// corrected for the number of candidate change points.
+	assert.Nil(t, getChangePoint(ctrace2.NewFullTrace("c", steps(11, []float32{1, 2}, []int{5}), 0.001), INTERESTING_THRESHHOLD))
+
+	// Noise without a step.
+	assert.Nil(t, getChangePoint(ctrace2.NewFullTrace("d", []float32{1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1}, 0.001), INTERESTING_THRESHHOLD))
+	assert.Nil(t, getChangePoint(ctrace2.NewFullTrace("e", steps(21, []float32{1}, nil), 0.001), INTERESTING_THRESHHOLD))
+	// Too short.
+	assert.Nil(t, getChangePoint(ctrace2.NewFullTrace("f", []float32{1, 1, 2, 2}, 0.001), INTERESTING_THRESHHOLD))
+}
+
+func TestPValue(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.InDelta(t, 1.0, pValue(0), 0.0001)
+	assert.InDelta(t, 0.05, pValue(1.96), 0.001)
+	assert.InDelta(t, 0.01, pValue(-2.576), 0.001)
+}
+
+func TestCalcChangePointSummaries(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Now()
+	df := &dataframe.DataFrame{
+		TraceSet: ptracestore.TraceSet{
+			",arch=x86,config=8888,": steps(21, []float32{1, 2}, []int{10}),
+			",arch=x86,config=565,":  steps(21, []float32{5, 9}, []int{10}),
+			",arch=arm,config=8888,": steps(21, []float32{2, 1}, []int{10}),
+			",arch=arm,config=565,":  []float32{1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1},
+			",arch=arm,config=gpu,":  steps(21, []float32{1}, nil),
+		},
+		Header:   []*dataframe.ColumnHeader{},
+		ParamSet: paramtools.ParamSet{},
+		Skip:     0,
+	}
+	for i := 0; i < 21; i++ {
+		df.Header = append(df.Header, &dataframe.ColumnHeader{
+			Source:    "master",
+			Offset:    int64(i),
+			Timestamp: now.Add(time.Duration(i) * time.Minute).Unix(),
+		})
+	}
+	df.BuildParamSet()
+
+	sum, err := CalculateChangePointSummaries(df, 0.01, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(sum.Clusters))
+
+	// Step ups sort first.
+	up := sum.Clusters[0]
+	assert.Equal(t, HIGH, up.StepFit.Status)
+	assert.Equal(t, 10, up.StepFit.TurningPoint)
+	assert.True(t, up.StepFit.StepSize < 0)
+	assert.Equal(t, df.Header[10], up.StepPoint)
+	assert.Equal(t, 2, up.Num)
+	assert.Equal(t, 2, len(up.Keys))
+	assert.Equal(t, 21, len(up.Centroid))
+	assert.Equal(t, []ValueWeight{{"x86", 26}}, up.ParamSummaries["arch"])
+
+	down := sum.Clusters[1]
+	assert.Equal(t, LOW, down.StepFit.Status)
+	assert.Equal(t, df.Header[10], down.StepPoint)
+	assert.Equal(t, []string{",arch=arm,config=8888,"}, down.Keys)
+
+	// Nothing is interesting enough.
+	sum, err = CalculateChangePointSummaries(df, 0.01, 1e6)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(sum.Clusters))
+
+	_, err = CalculateChangePointSummaries(&dataframe.DataFrame{TraceSet: ptracestore.TraceSet{}}, 0.01, 0)
+	assert.Error(t, err)
+}
+
+func TestValidateClusterAlgo(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.NoError(t, ValidateClusterAlgo(""))
+	assert.NoError(t, ValidateClusterAlgo(KMEANS_ALGO))
+	assert.NoError(t, ValidateClusterAlgo(CHANGEPOINT_ALGO))
+	assert.Error(t, ValidateClusterAlgo("dbscan"))
+}
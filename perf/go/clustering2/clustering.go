@@ -208,19 +208,24 @@ func getStepFit(trace []float32) *StepFit {
 	}
 	lse = float32(math.Sqrt(float64(lse))) / float32(len(trace))
 	regression := stepSize / lse
-	status := UNINTERESTING
-	if regression > INTERESTING_THRESHHOLD {
-		status = LOW
-	} else if regression < -INTERESTING_THRESHHOLD {
-		status = HIGH
-	}
 	return &StepFit{
 		LeastSquares: lse,
 		StepSize:     stepSize,
 		TurningPoint: turn,
 		Regression:   regression,
-		Status:       status,
+		Status:       getStatus(regression, INTERESTING_THRESHHOLD),
+	}
+}
+
+// getStatus returns the status for a StepFit.Regression value given the
+// threshhold beyond which regression values are interesting.
+func getStatus(regression, interesting float32) string {
+	if regression > interesting {
+		return LOW
+	} else if regression < -interesting {
+		return HIGH
 	}
+	return UNINTERESTING
 }
 
 // SortableClusterable allows for sorting kmeans.Clusterables.
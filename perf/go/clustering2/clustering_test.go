@@ -146,3 +146,11 @@ func TestCalcCusterSummariesDegenerate(t *testing.T) {
 	_, err := CalculateClusterSummaries(df, 4, 0.01, nil)
 	assert.Error(t, err)
 }
+
+func TestGetStatus(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, LOW, getStatus(60, INTERESTING_THRESHHOLD))
+	assert.Equal(t, HIGH, getStatus(-60, INTERESTING_THRESHHOLD))
+	assert.Equal(t, UNINTERESTING, getStatus(40, INTERESTING_THRESHHOLD))
+	assert.Equal(t, LOW, getStatus(40, 30))
+}
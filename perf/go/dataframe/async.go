@@ -4,7 +4,6 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -403,11 +402,7 @@ func ResponseFromDataFrame(df *DataFrame, git *gitinfo.GitInfo, truncate bool, t
 // doSearch applies the given query and returns a dataframe that matches the
 // given time range [begin, end) in a DataFrame.
 func (p *FrameRequestProcess) doSearch(queryStr string, begin, end time.Time) (*DataFrame, error) {
-	urlValues, err := url.ParseQuery(queryStr)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse query: %s", err)
-	}
-	q, err := query.New(urlValues)
+	q, err := query.NewFromString(queryStr)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid Query: %s", err)
 	}
@@ -434,11 +429,7 @@ func (p *FrameRequestProcess) doCalc(formula string, begin, end time.Time) (*Dat
 	var df *DataFrame
 
 	rowsFromQuery := func(s string) (calc.Rows, error) {
-		urlValues, err := url.ParseQuery(s)
-		if err != nil {
-			return nil, err
-		}
-		q, err := query.New(urlValues)
+		q, err := query.NewFromString(s)
 		if err != nil {
 			return nil, err
 		}
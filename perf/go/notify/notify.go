@@ -0,0 +1,203 @@
+// Package notify sends notifications when continuous clustering finds new
+// regressions.
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	htemplate "html/template"
+	"strings"
+	"text/template"
+
+	"go.skia.org/infra/go/issues"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/perf/go/alerts"
+	"go.skia.org/infra/perf/go/cid"
+	"go.skia.org/infra/perf/go/clustering2"
+)
+
+const (
+	// SENDER_DISPLAY_NAME is the display name of the sender of notification
+	// emails.
+	SENDER_DISPLAY_NAME = "Skia Perf"
+
+	// MAX_KEYS is the maximum number of trace keys listed in a notification.
+	MAX_KEYS = 5
+
+	// BUG_URL_PREFIX is the prefix of the URL of a filed bug, the bug ID is
+	// appended.
+	BUG_URL_PREFIX = "https://bugs.chromium.org/p/skia/issues/detail?id="
+)
+
+var (
+	emailTemplate = htemplate.Must(htemplate.New("email").Parse(`<b>Alert</b>: {{.Alert.DisplayName}} ({{.Alert.Query}})<br>
+<b>Commit</b>: <a href="{{.Commit.URL}}">{{.Commit.Hash}}</a> by {{.Commit.Author}}<br>
+{{.Commit.Message}}<br>
+<br>
+<b>Regression</b>: {{.Cluster.StepFit.Status}} step of size {{.Cluster.StepFit.StepSize}} in {{.Cluster.Num}} traces, regression factor {{.Cluster.StepFit.Regression}}.<br>
+<br>
+<b>Traces</b>:<br>
+{{range .Keys}}{{.}}<br>
+{{end}}<br>
+<a href="{{.TriageURL}}">Triage this regression</a>
+{{if .Bug}}<br><a href="{{.BugURL}}">Bug {{.Bug}}</a>{{end}}
+`))
+
+	bugTemplate = template.Must(template.New("bug").Parse(`This bug was filed by SkiaPerf.
+
+Alert: {{.Alert.DisplayName}} ({{.Alert.Query}})
+
+The suspect commit is:
+
+  {{.Commit.URL}}
+  {{.Commit.Author}}: {{.Commit.Message}}
+
+The cluster has a {{.Cluster.StepFit.Status}} step of size {{.Cluster.StepFit.StepSize}} in {{.Cluster.Num}} traces, with a regression factor of {{.Cluster.StepFit.Regression}}. Some of the traces are:
+{{range .Keys}}
+  {{.}}{{end}}
+
+Visit this URL to triage the regression:
+
+  {{.TriageURL}}
+`))
+)
+
+// EmailSender sends emails. It is implemented by *email.GMail.
+type EmailSender interface {
+	Send(senderDisplayName string, to []string, subject string, body string) error
+}
+
+// Notifier sends emails and files bugs for new regressions, as configured in
+// the alerts.Config that found them.
+type Notifier struct {
+	email   EmailSender
+	tracker issues.IssueTracker
+	url     string
+}
+
+// New returns a new *Notifier.
+//
+// Either of email and tracker may be nil, in which case no emails are sent or
+// no bugs are filed. url is the base URL of the Perf instance, used to build
+// links to the triage page.
+func New(email EmailSender, tracker issues.IssueTracker, url string) *Notifier {
+	return &Notifier{
+		email:   email,
+		tracker: tracker,
+		url:     strings.TrimRight(url, "/"),
+	}
+}
+
+// context is the data used to expand the templates.
+type context struct {
+	Alert     *alerts.Config
+	Commit    *cid.CommitDetail
+	Cluster   *clustering2.ClusterSummary
+	Keys      []string
+	TriageURL string
+	Bug       string
+	BugURL    string
+}
+
+func (n *Notifier) newContext(c *cid.CommitDetail, cfg *alerts.Config, cl *clustering2.ClusterSummary) *context {
+	keys := cl.Keys
+	if len(keys) > MAX_KEYS {
+		keys = keys[:MAX_KEYS]
+	}
+	return &context{
+		Alert:     cfg,
+		Commit:    c,
+		Cluster:   cl,
+		Keys:      keys,
+		TriageURL: fmt.Sprintf("%s/t/?begin=%d&end=%d", n.url, c.Timestamp, c.Timestamp+1),
+	}
+}
+
+// subject returns the subject of the email or the summary of the bug.
+func subject(c *cid.CommitDetail, cfg *alerts.Config, cl *clustering2.ClusterSummary) string {
+	name := cfg.DisplayName
+	if name == "" {
+		name = cfg.Query
+	}
+	hash := c.Hash
+	if len(hash) > 7 {
+		hash = hash[:7]
+	}
+	return fmt.Sprintf("Perf regression (%s) found for %q at %s", cl.StepFit.Status, name, hash)
+}
+
+// Sent records which notifications were already sent for a regression, so
+// that RegressionFound can be retried without sending them twice.
+type Sent struct {
+	Bug     string // The ID of the filed bug, or the empty string if no bug was filed yet.
+	Emailed bool   // True if the email was sent.
+}
+
+// RegressionFound sends the notifications configured in cfg for the new
+// regression cl found at commit c, skipping those that sent says were already
+// sent, and updates sent with the notifications that succeeded. Failing to
+// file the bug doesn't prevent the email from being sent. Returns an error if
+// any of the notifications failed, in which case it should be retried later.
+func (n *Notifier) RegressionFound(c *cid.CommitDetail, cfg *alerts.Config, cl *clustering2.ClusterSummary, sent *Sent) error {
+	ctx := n.newContext(c, cfg, cl)
+	subj := subject(c, cfg, cl)
+
+	errs := []string{}
+	if cfg.FileBug && n.tracker != nil && sent.Bug == "" {
+		if bug, err := n.fileBug(ctx, cfg, subj); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			sent.Bug = bug
+			sklog.Infof("Filed bug %s for %q", bug, subj)
+		}
+	}
+	if sent.Bug != "" {
+		ctx.Bug = sent.Bug
+		ctx.BugURL = BUG_URL_PREFIX + sent.Bug
+	}
+
+	if len(cfg.Emails) > 0 && n.email != nil && !sent.Emailed {
+		if err := n.sendEmail(ctx, cfg, subj); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			sent.Emailed = true
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Failed to send notifications: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fileBug files a bug for the regression described by ctx and returns its ID.
+func (n *Notifier) fileBug(ctx *context, cfg *alerts.Config, subj string) (string, error) {
+	var b bytes.Buffer
+	if err := bugTemplate.Execute(&b, ctx); err != nil {
+		return "", fmt.Errorf("Failed to expand bug template: %s", err)
+	}
+	issue, err := n.tracker.AddIssue(issues.IssueRequest{
+		Status: "Untriaged",
+		Owner: issues.MonorailPerson{
+			Name: cfg.Owner,
+		},
+		Labels:      []string{"FromSkiaPerf", "Type-Defect", "Priority-Medium"},
+		Summary:     subj,
+		Description: b.String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to file bug: %s", err)
+	}
+	return fmt.Sprintf("%d", issue.ID), nil
+}
+
+// sendEmail sends the email for the regression described by ctx.
+func (n *Notifier) sendEmail(ctx *context, cfg *alerts.Config, subj string) error {
+	var b bytes.Buffer
+	if err := emailTemplate.Execute(&b, ctx); err != nil {
+		return fmt.Errorf("Failed to expand email template: %s", err)
+	}
+	if err := n.email.Send(SENDER_DISPLAY_NAME, cfg.Emails, subj, b.String()); err != nil {
+		return fmt.Errorf("Failed to send email: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.skia.org/infra/go/issues"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/perf/go/alerts"
+	"go.skia.org/infra/perf/go/cid"
+	"go.skia.org/infra/perf/go/clustering2"
+)
+
+type sentEmail struct {
+	to      []string
+	subject string
+	body    string
+}
+
+type mockEmailSender struct {
+	sent []sentEmail
+	err  error
+}
+
+func (m *mockEmailSender) Send(senderDisplayName string, to []string, subject string, body string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, sentEmail{to: to, subject: subject, body: body})
+	return nil
+}
+
+type mockIssueTracker struct {
+	filed []issues.IssueRequest
+	err   error
+}
+
+func (m *mockIssueTracker) FromQuery(q string) ([]issues.Issue, error) {
+	return nil, nil
+}
+
+func (m *mockIssueTracker) AddComment(id string, comment issues.CommentRequest) error {
+	return nil
+}
+
+func (m *mockIssueTracker) AddIssue(issue issues.IssueRequest) (*issues.Issue, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.filed = append(m.filed, issue)
+	return &issues.Issue{ID: 1234}, nil
+}
+
+func testData() (*cid.CommitDetail, *alerts.Config, *clustering2.ClusterSummary) {
+	c := &cid.CommitDetail{
+		CommitID: cid.CommitID{
+			Source: "master",
+			Offset: 1,
+		},
+		Author:    "someone@example.com",
+		Message:   "Make things slower",
+		URL:       "https://skia.googlesource.com/skia/+/abcdef0123456789",
+		Hash:      "abcdef0123456789",
+		Timestamp: 1479235651,
+	}
+	cfg := alerts.NewConfig()
+	cfg.DisplayName = "SKPs"
+	cfg.Query = "source_type=skp"
+	cfg.Owner = "owner@example.com"
+	cl := &clustering2.ClusterSummary{
+		Keys: []string{",config=8888,", ",config=565,"},
+		StepFit: &clustering2.StepFit{
+			StepSize:   -2,
+			Regression: -120,
+			Status:     clustering2.HIGH,
+		},
+		Num: 2,
+	}
+	return c, cfg, cl
+}
+
+func TestRegressionFound(t *testing.T) {
+	testutils.SmallTest(t)
+	email := &mockEmailSender{}
+	tracker := &mockIssueTracker{}
+	n := New(email, tracker, "https://perf.skia.org/")
+	c, cfg, cl := testData()
+
+	// No notifications configured.
+	s := &Sent{}
+	assert.NoError(t, n.RegressionFound(c, cfg, cl, s))
+	assert.Equal(t, &Sent{}, s)
+	assert.Equal(t, 0, len(email.sent))
+	assert.Equal(t, 0, len(tracker.filed))
+
+	cfg.Emails = []string{"team@example.com"}
+	cfg.FileBug = true
+	assert.NoError(t, n.RegressionFound(c, cfg, cl, s))
+	assert.Equal(t, &Sent{Bug: "1234", Emailed: true}, s)
+
+	assert.Equal(t, 1, len(tracker.filed))
+	filed := tracker.filed[0]
+	assert.Equal(t, "owner@example.com", filed.Owner.Name)
+	assert.Equal(t, `Perf regression (High) found for "SKPs" at abcdef0`, filed.Summary)
+	assert.True(t, strings.Contains(filed.Description, c.URL))
+	assert.True(t, strings.Contains(filed.Description, ",config=565,"))
+	assert.True(t, strings.Contains(filed.Description, "https://perf.skia.org/t/?begin=1479235651&end=1479235652"))
+
+	assert.Equal(t, 1, len(email.sent))
+	sent := email.sent[0]
+	assert.Equal(t, []string{"team@example.com"}, sent.to)
+	assert.Equal(t, filed.Summary, sent.subject)
+	assert.True(t, strings.Contains(sent.body, "https://perf.skia.org/t/?begin=1479235651&amp;end=1479235652"))
+	assert.True(t, strings.Contains(sent.body, BUG_URL_PREFIX+"1234"))
+
+	// Notifications that were already sent aren't sent again.
+	assert.NoError(t, n.RegressionFound(c, cfg, cl, s))
+	assert.Equal(t, 1, len(tracker.filed))
+	assert.Equal(t, 1, len(email.sent))
+}
+
+func TestRegressionFoundErrors(t *testing.T) {
+	testutils.SmallTest(t)
+	email := &mockEmailSender{}
+	tracker := &mockIssueTracker{err: fmt.Errorf("Monorail is down.")}
+	n := New(email, tracker, "https://perf.skia.org")
+	c, cfg, cl := testData()
+	cfg.Emails = []string{"team@example.com"}
+	cfg.FileBug = true
+
+	// The email is sent even though filing the bug failed.
+	s := &Sent{}
+	assert.Error(t, n.RegressionFound(c, cfg, cl, s))
+	assert.Equal(t, &Sent{Emailed: true}, s)
+	assert.Equal(t, 1, len(email.sent))
+
+	// Retrying only files the bug.
+	tracker.err = nil
+	assert.NoError(t, n.RegressionFound(c, cfg, cl, s))
+	assert.Equal(t, &Sent{Bug: "1234", Emailed: true}, s)
+	assert.Equal(t, 1, len(email.sent))
+	assert.Equal(t, 1, len(tracker.filed))
+
+	// Both failures are reported.
+	email.err = fmt.Errorf("GMail is down.")
+	tracker.err = fmt.Errorf("Monorail is down.")
+	err := n.RegressionFound(c, cfg, cl, &Sent{})
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "GMail is down."))
+	assert.True(t, strings.Contains(err.Error(), "Monorail is down."))
+
+	// Without a tracker or email sender nothing is sent.
+	n = New(nil, nil, "https://perf.skia.org")
+	s = &Sent{}
+	assert.NoError(t, n.RegressionFound(c, cfg, cl, s))
+	assert.Equal(t, &Sent{}, s)
+}
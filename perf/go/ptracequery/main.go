@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"math/rand"
-	"net/url"
 	"os"
 	"time"
 
@@ -31,7 +30,7 @@ var (
 	gitRepoDir     = flag.String("git_repo_dir", "../../../skia", "Directory location for the Skia repo.")
 	gitRepoURL     = flag.String("git_repo_url", "https://skia.googlesource.com/skia", "The URL to pass to git clone for the source repository.")
 	ptraceStoreDir = flag.String("ptrace_store_dir", "/tmp/ptracestore", "The directory where the ptracestore tiles are stored.")
-	queryStr       = flag.String("query", "", "A URL encoded query to filter traces against.")
+	queryStr       = flag.String("query", "", "A URL encoded query, or a boolean query expression, to filter traces against.")
 	verbose        = flag.Bool("verbose", false, "Verbose.")
 )
 
@@ -108,11 +107,7 @@ func count(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
 }
 
 func match(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
-	u, err := url.ParseQuery(*queryStr)
-	if err != nil {
-		fmt.Printf("Not a valid URL query %q: %s", *queryStr, err)
-	}
-	q, err := query.New(u)
+	q, err := query.NewFromString(*queryStr)
 	if err != nil {
 		fmt.Printf("Not a valid query %q: %s", *queryStr, err)
 	}
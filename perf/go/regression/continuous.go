@@ -6,13 +6,16 @@ import (
 	"go.skia.org/infra/go/git/gitinfo"
 	"go.skia.org/infra/go/metrics2"
 	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/perf/go/alerts"
 	"go.skia.org/infra/perf/go/cid"
 	"go.skia.org/infra/perf/go/clustering2"
+	"go.skia.org/infra/perf/go/notify"
 )
 
 const (
 	// How many commits we consider before and after a target commit when
-	// clustering. This means clustering will occur over 2*RADIUS+1 commits.
+	// clustering, unless an alert specifies otherwise. This means clustering
+	// will occur over 2*RADIUS+1 commits.
 	//
 	// Remember to change the state.radius default value in cluster-page-sk to match this value.
 	RADIUS = 7
@@ -23,21 +26,24 @@ const (
 type Continuous struct {
 	git        *gitinfo.GitInfo
 	cidl       *cid.CommitIDLookup
-	queries    []string
+	alerts     *alerts.Store
 	store      *Store
+	notifier   *notify.Notifier
 	numCommits int // Number of recent commits to do clustering over.
 }
 
 // NewContinuous creates a new *Continuous.
 //
-// queries is a slice of URL query encoded to perform against the datastore to
-// determine which traces participate in clustering.
-func NewContinuous(git *gitinfo.GitInfo, cidl *cid.CommitIDLookup, queries []string, store *Store, numCommits int) *Continuous {
+// The alerts.Config's in the alerts store determine which traces participate
+// in clustering and how regressions are found. The notifier is used to send
+// the notifications configured in the alerts for newly found regressions.
+func NewContinuous(git *gitinfo.GitInfo, cidl *cid.CommitIDLookup, alertStore *alerts.Store, store *Store, notifier *notify.Notifier, numCommits int) *Continuous {
 	return &Continuous{
 		git:        git,
 		cidl:       cidl,
-		queries:    queries,
+		alerts:     alertStore,
 		store:      store,
+		notifier:   notifier,
 		numCommits: numCommits,
 	}
 }
@@ -58,6 +64,50 @@ func (c *Continuous) reportUntriaged(newClustersGauge metrics2.Int64Metric) {
 	}()
 }
 
+// notify sends the notifications configured in the alerts for the regressions
+// found at commit whose notifications haven't all been sent yet, which
+// includes newly found regressions and those whose notifications failed
+// before, and records the results.
+func (c *Continuous) notify(commit *cid.CommitDetail, configs []*alerts.Config) {
+	if c.notifier == nil {
+		return
+	}
+	pending, err := c.store.PendingNotifications(commit)
+	if err != nil {
+		sklog.Errorf("Failed to load pending notifications: %s", err)
+		return
+	}
+	byID := map[int64]*alerts.Config{}
+	for _, cfg := range configs {
+		byID[cfg.ID] = cfg
+	}
+	for _, p := range pending {
+		n := Notification{
+			Emailed: p.Notification.Emailed,
+		}
+		sent := &notify.Sent{
+			Bug:     p.Bug,
+			Emailed: p.Notification.Emailed,
+		}
+		if cfg, ok := byID[p.AlertID]; ok {
+			if err := c.notifier.RegressionFound(commit, cfg, p.Cluster, sent); err != nil {
+				sklog.Errorf("Failed to send notification, will retry: %s", err)
+				n.Pending = true
+			}
+			n.Emailed = sent.Emailed
+		} else {
+			sklog.Warningf("Not sending notifications for %q, alert %d no longer exists.", p.Query, p.AlertID)
+		}
+		bug := ""
+		if sent.Bug != p.Bug {
+			bug = sent.Bug
+		}
+		if err := c.store.SetNotification(commit, p.Query, p.Status, p.AlertID, bug, n); err != nil {
+			sklog.Errorf("Failed to record notification state: %s", err)
+		}
+	}
+}
+
 // Run starts the continuous running of clustering over the last numCommits
 // commits.
 //
@@ -72,12 +122,14 @@ func (c *Continuous) Run() {
 	c.reportUntriaged(newClustersGauge)
 	for _ = range time.Tick(time.Minute) {
 		clusteringLatency.Start()
+		configs, err := c.alerts.List()
+		if err != nil {
+			sklog.Errorf("Failed to load alerts: %s", err)
+			continue
+		}
 		// Get the last numCommits commits.
 		indexCommits := c.git.LastNIndex(c.numCommits)
-		// Drop the RADIUS most recent, since we are clustering
-		// based on a radius of +/-RADIUS commits.
-		indexCommits = indexCommits[:(c.numCommits - RADIUS)]
-		for _, commit := range indexCommits {
+		for i, commit := range indexCommits {
 			id := &cid.CommitID{
 				Source: "master",
 				Offset: commit.Index,
@@ -87,15 +139,26 @@ func (c *Continuous) Run() {
 				sklog.Errorf("Failed to look up commit %v: %s", *id, err)
 				continue
 			}
-			for _, q := range c.queries {
+			for _, cfg := range configs {
+				radius := cfg.Radius
+				if radius <= 0 {
+					radius = RADIUS
+				}
+				// Skip the radius most recent commits, since we are clustering
+				// based on a radius of +/-radius commits.
+				if len(indexCommits)-1-i < radius {
+					continue
+				}
 				// Create ClusterRequest and run.
 				req := &clustering2.ClusterRequest{
-					Source: "master",
-					Offset: commit.Index,
-					Radius: RADIUS,
-					Query:  q,
+					Source:      "master",
+					Offset:      commit.Index,
+					Radius:      radius,
+					Query:       cfg.Query,
+					Algo:        cfg.Algo,
+					Interesting: cfg.Interesting,
 				}
-				sklog.Infof("Continuous: Clustering at %s for %q", details[0].Message, q)
+				sklog.Infof("Continuous: Clustering at %s for alert %d %q", details[0].Message, cfg.ID, cfg.Query)
 				resp, err := clustering2.Run(req, c.git, c.cidl)
 				if err != nil {
 					sklog.Errorf("Failed while clustering %v %s", *req, err)
@@ -103,22 +166,23 @@ func (c *Continuous) Run() {
 				}
 				// Update database if regression at the midpoint is found.
 				for _, cl := range resp.Summary.Clusters {
-					if cl.StepPoint.Offset == int64(commit.Index) {
-						if cl.StepFit.Status == clustering2.LOW {
-							if err := c.store.SetLow(details[0], q, resp.Frame, cl); err != nil {
-								sklog.Errorf("Failed to save newly found cluster: %s", err)
-							}
-							sklog.Infof("Found Low regression at %s for %q: %v", details[0].Message, q, *cl.StepFit)
-						}
-						if cl.StepFit.Status == clustering2.HIGH {
-							if err := c.store.SetHigh(details[0], q, resp.Frame, cl); err != nil {
-								sklog.Errorf("Failed to save newly found cluster: %s", err)
-							}
-							sklog.Infof("Found High regression at %s for %q: %v", id.ID(), q, *cl.StepFit)
-						}
+					if cl.StepPoint.Offset != int64(commit.Index) || !cfg.Reports(cl.StepFit.Status) {
+						continue
+					}
+					if cl.StepFit.Status == clustering2.LOW {
+						_, err = c.store.SetLow(details[0], cfg.Query, cfg.ID, resp.Frame, cl)
+						sklog.Infof("Found Low regression at %s for %q: %v", details[0].Message, cfg.Query, *cl.StepFit)
+					} else {
+						_, err = c.store.SetHigh(details[0], cfg.Query, cfg.ID, resp.Frame, cl)
+						sklog.Infof("Found High regression at %s for %q: %v", id.ID(), cfg.Query, *cl.StepFit)
+					}
+					if err != nil {
+						sklog.Errorf("Failed to save newly found cluster: %s", err)
+						continue
 					}
 				}
 			}
+			c.notify(details[0], configs)
 		}
 		clusteringLatency.Stop()
 		runsCounter.Inc(1)
@@ -120,28 +120,36 @@ func intx(f func(tx *sql.Tx) error) (err error) {
 	return err
 }
 
-// SetHigh sets the cluster for a high regression at the given commit and query.
-func (s *Store) SetHigh(cid *cid.CommitDetail, query string, df *dataframe.FrameResponse, high *clustering2.ClusterSummary) error {
-	return intx(func(tx *sql.Tx) error {
+// SetHigh sets the cluster for a high regression at the given commit and query,
+// found by the alert with the given ID. Returns true if there wasn't a high
+// regression at the commit for the query yet.
+func (s *Store) SetHigh(cid *cid.CommitDetail, query string, alertID int64, df *dataframe.FrameResponse, high *clustering2.ClusterSummary) (bool, error) {
+	isNew := false
+	err := intx(func(tx *sql.Tx) error {
 		r, err := s.load(tx, cid)
 		if err != nil {
 			r = New()
 		}
-		r.SetHigh(query, df, high)
+		isNew = r.SetHigh(query, alertID, df, high)
 		return s.store(tx, cid, r)
 	})
+	return isNew, err
 }
 
-// SetLow sets the cluster for a low regression at the given commit and query.
-func (s *Store) SetLow(cid *cid.CommitDetail, query string, df *dataframe.FrameResponse, low *clustering2.ClusterSummary) error {
-	return intx(func(tx *sql.Tx) error {
+// SetLow sets the cluster for a low regression at the given commit and query,
+// found by the alert with the given ID. Returns true if there wasn't a low
+// regression at the commit for the query yet.
+func (s *Store) SetLow(cid *cid.CommitDetail, query string, alertID int64, df *dataframe.FrameResponse, low *clustering2.ClusterSummary) (bool, error) {
+	isNew := false
+	err := intx(func(tx *sql.Tx) error {
 		r, err := s.load(tx, cid)
 		if err != nil {
 			r = New()
 		}
-		r.SetLow(query, df, low)
+		isNew = r.SetLow(query, alertID, df, low)
 		return s.store(tx, cid, r)
 	})
+	return isNew, err
 }
 
 // TriageLow sets the triage status for the low cluster at the given commit and query.
@@ -171,3 +179,55 @@ func (s *Store) TriageHigh(cid *cid.CommitDetail, query string, tr TriageStatus)
 		return s.store(tx, cid, r)
 	})
 }
+
+// PendingNotifications returns the clusters at the given commit whose
+// notifications haven't all been sent yet.
+func (s *Store) PendingNotifications(cid *cid.CommitDetail) ([]*PendingNotification, error) {
+	var ret []*PendingNotification
+	err := intx(func(tx *sql.Tx) error {
+		r, err := s.load(tx, cid)
+		if err != nil {
+			// No regressions were found at the commit.
+			return nil
+		}
+		ret = r.PendingNotifications()
+		return nil
+	})
+	return ret, err
+}
+
+// SetNotification records the ID of the bug filed for the cluster at the
+// given commit and query, if any, and the state of the notifications of the
+// alert with the given ID, where status is clustering2.LOW or clustering2.HIGH.
+func (s *Store) SetNotification(cid *cid.CommitDetail, query string, status string, alertID int64, bug string, n Notification) error {
+	return intx(func(tx *sql.Tx) error {
+		r, err := s.load(tx, cid)
+		if err != nil {
+			return fmt.Errorf("Failed to load Regressions: %s", err)
+		}
+		if bug != "" {
+			if err := r.SetBug(query, status, bug); err != nil {
+				return fmt.Errorf("Failed to update Regressions: %s", err)
+			}
+		}
+		if err := r.SetNotification(query, status, alertID, n); err != nil {
+			return fmt.Errorf("Failed to update Regressions: %s", err)
+		}
+		return s.store(tx, cid, r)
+	})
+}
+
+// SetBug records the ID of the bug filed for the cluster at the given commit
+// and query, where status is clustering2.LOW or clustering2.HIGH.
+func (s *Store) SetBug(cid *cid.CommitDetail, query string, status string, bug string) error {
+	return intx(func(tx *sql.Tx) error {
+		r, err := s.load(tx, cid)
+		if err != nil {
+			return fmt.Errorf("Failed to load Regressions: %s", err)
+		}
+		if err := r.SetBug(query, status, bug); err != nil {
+			return fmt.Errorf("Failed to update Regressions: %s", err)
+		}
+		return s.store(tx, cid, r)
+	})
+}
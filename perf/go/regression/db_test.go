@@ -35,7 +35,7 @@ func TestSetLowWithMissing(t *testing.T) {
 
 	df := &dataframe.FrameResponse{}
 	cl := &clustering2.ClusterSummary{}
-	r.SetLow("source_type=skp", df, cl)
+	r.SetLow("source_type=skp", 1, df, cl)
 
 	// body is what our expected body should look like after adding the low cluster.
 	body, err := r.JSON()
@@ -56,8 +56,9 @@ func TestSetLowWithMissing(t *testing.T) {
 
 	// Execute our method.
 	st := NewStore()
-	err = st.SetLow(c, "source_type=skp", df, cl)
+	isNew, err := st.SetLow(c, "source_type=skp", 1, df, cl)
 	assert.NoError(t, err)
+	assert.True(t, isNew)
 
 	// Make sure that all expectations were met.
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -86,7 +87,7 @@ func TestTriageWithExisting(t *testing.T) {
 
 	df := &dataframe.FrameResponse{}
 	cl := &clustering2.ClusterSummary{}
-	r.SetLow("source_type=skp", df, cl)
+	r.SetLow("source_type=skp", 1, df, cl)
 
 	body, err := r.JSON()
 	assert.NoError(t, err)
@@ -190,7 +191,7 @@ func TestRange(t *testing.T) {
 	}
 	df := &dataframe.FrameResponse{}
 	cl := &clustering2.ClusterSummary{}
-	r2.SetLow("source_type=skp", df, cl)
+	r2.SetLow("source_type=skp", 1, df, cl)
 
 	body1, err := r1.JSON()
 	assert.NoError(t, err)
@@ -4,8 +4,11 @@ package regression
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
 
+	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/perf/go/clustering2"
 	"go.skia.org/infra/perf/go/dataframe"
 )
@@ -33,6 +36,14 @@ type Regressions struct {
 type TriageStatus struct {
 	Status  Status `json:"status"`
 	Message string `json:"message"`
+	Bug     string `json:"bug"` // The ID of the bug filed for the regression, if any.
+}
+
+// Notification is the state of the notifications configured in the alert that
+// found a regression cluster, see notify.Notifier.
+type Notification struct {
+	Pending bool `json:"pending"` // True until all the notifications were sent.
+	Emailed bool `json:"emailed"` // True once the email was sent.
 }
 
 // Regression tracks the status of the Low and High regression clusters, if they
@@ -46,6 +57,13 @@ type Regression struct {
 	Frame      *dataframe.FrameResponse    `json:"frame"` // Describes the Low and High ClusterSummary's.
 	LowStatus  TriageStatus                `json:"low_status"`
 	HighStatus TriageStatus                `json:"high_status"`
+
+	// LowAlerts and HighAlerts map the IDs of the alerts.Config's that
+	// found the Low and High clusters to the notifications sent for them,
+	// so that failed notifications are retried. Several alerts may have the
+	// same query.
+	LowAlerts  map[int64]Notification `json:"low_alerts"`
+	HighAlerts map[int64]Notification `json:"high_alerts"`
 }
 
 func newRegression() *Regression {
@@ -65,8 +83,11 @@ func New() *Regressions {
 	}
 }
 
-// SetLow sets the cluster for a low regression.
-func (r *Regressions) SetLow(query string, df *dataframe.FrameResponse, low *clustering2.ClusterSummary) {
+// SetLow sets the cluster for a low regression found by the alert with the
+// given ID. Returns true if there wasn't a low regression for the query yet.
+// Notifications are pending for each alert that finds the cluster for the
+// first time.
+func (r *Regressions) SetLow(query string, alertID int64, df *dataframe.FrameResponse, low *clustering2.ClusterSummary) bool {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	reg, ok := r.ByQuery[query]
@@ -80,13 +101,24 @@ func (r *Regressions) SetLow(query string, df *dataframe.FrameResponse, low *clu
 	// TODO(jcgregorio) Add checks so that we only overwrite a cluster if the new
 	// cluster is 'better', for some definition of 'better'.
 	reg.Low = low
+	if reg.LowAlerts == nil {
+		reg.LowAlerts = map[int64]Notification{}
+	}
+	if _, ok := reg.LowAlerts[alertID]; !ok {
+		reg.LowAlerts[alertID] = Notification{Pending: true}
+	}
 	if reg.LowStatus.Status == NONE {
 		reg.LowStatus.Status = UNTRIAGED
+		return true
 	}
+	return false
 }
 
-// SetHigh sets the cluster for a high regression.
-func (r *Regressions) SetHigh(query string, df *dataframe.FrameResponse, high *clustering2.ClusterSummary) {
+// SetHigh sets the cluster for a high regression found by the alert with the
+// given ID. Returns true if there wasn't a high regression for the query yet.
+// Notifications are pending for each alert that finds the cluster for the
+// first time.
+func (r *Regressions) SetHigh(query string, alertID int64, df *dataframe.FrameResponse, high *clustering2.ClusterSummary) bool {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	reg, ok := r.ByQuery[query]
@@ -100,9 +132,17 @@ func (r *Regressions) SetHigh(query string, df *dataframe.FrameResponse, high *c
 	// TODO(jcgregorio) Add checks so that we only overwrite a cluster if the new
 	// cluster is 'better', for some definition of 'better'.
 	reg.High = high
+	if reg.HighAlerts == nil {
+		reg.HighAlerts = map[int64]Notification{}
+	}
+	if _, ok := reg.HighAlerts[alertID]; !ok {
+		reg.HighAlerts[alertID] = Notification{Pending: true}
+	}
 	if reg.HighStatus.Status == NONE {
 		reg.HighStatus.Status = UNTRIAGED
+		return true
 	}
+	return false
 }
 
 // TriageLow sets the triage status for the low cluster.
@@ -116,6 +156,9 @@ func (r *Regressions) TriageLow(query string, tr TriageStatus) error {
 	if reg.Low == nil {
 		return ErrNoClusterFound
 	}
+	if tr.Bug == "" {
+		tr.Bug = reg.LowStatus.Bug
+	}
 	reg.LowStatus = tr
 	return nil
 }
@@ -131,10 +174,137 @@ func (r *Regressions) TriageHigh(query string, tr TriageStatus) error {
 	if reg.High == nil {
 		return ErrNoClusterFound
 	}
+	if tr.Bug == "" {
+		tr.Bug = reg.HighStatus.Bug
+	}
 	reg.HighStatus = tr
 	return nil
 }
 
+// SetBug records the ID of the bug filed for the cluster of the given query,
+// where status is clustering2.LOW or clustering2.HIGH.
+func (r *Regressions) SetBug(query string, status string, bug string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	reg, ok := r.ByQuery[query]
+	if !ok {
+		return ErrNoClusterFound
+	}
+	switch status {
+	case clustering2.LOW:
+		if reg.Low == nil {
+			return ErrNoClusterFound
+		}
+		reg.LowStatus.Bug = bug
+	case clustering2.HIGH:
+		if reg.High == nil {
+			return ErrNoClusterFound
+		}
+		reg.HighStatus.Bug = bug
+	default:
+		return fmt.Errorf("Unknown cluster status: %q", status)
+	}
+	return nil
+}
+
+// SetNotification records the state of the notifications of the alert with
+// the given ID for the cluster of the given query, where status is
+// clustering2.LOW or clustering2.HIGH.
+func (r *Regressions) SetNotification(query string, status string, alertID int64, n Notification) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	reg, ok := r.ByQuery[query]
+	if !ok {
+		return ErrNoClusterFound
+	}
+	var alerts map[int64]Notification
+	switch status {
+	case clustering2.LOW:
+		if reg.Low == nil {
+			return ErrNoClusterFound
+		}
+		alerts = reg.LowAlerts
+	case clustering2.HIGH:
+		if reg.High == nil {
+			return ErrNoClusterFound
+		}
+		alerts = reg.HighAlerts
+	default:
+		return fmt.Errorf("Unknown cluster status: %q", status)
+	}
+	if _, ok := alerts[alertID]; !ok {
+		return ErrNoClusterFound
+	}
+	alerts[alertID] = n
+	return nil
+}
+
+// PendingNotification is a regression cluster whose notifications haven't all
+// been sent yet.
+type PendingNotification struct {
+	Query        string
+	Status       string // clustering2.LOW or clustering2.HIGH.
+	AlertID      int64
+	Cluster      *clustering2.ClusterSummary
+	Bug          string
+	Notification Notification
+}
+
+// pendingAlerts returns the IDs of the alerts whose notifications haven't all
+// been sent yet, sorted.
+func pendingAlerts(alerts map[int64]Notification) []int64 {
+	ret := []int64{}
+	for id, n := range alerts {
+		if n.Pending {
+			ret = append(ret, id)
+		}
+	}
+	sort.Sort(util.Int64Slice(ret))
+	return ret
+}
+
+// PendingNotifications returns the clusters whose notifications haven't all
+// been sent yet, once for each alert that found them, sorted by query and
+// alert ID.
+func (r *Regressions) PendingNotifications() []*PendingNotification {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	queries := make([]string, 0, len(r.ByQuery))
+	for query, _ := range r.ByQuery {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+	ret := []*PendingNotification{}
+	for _, query := range queries {
+		reg := r.ByQuery[query]
+		if reg.Low != nil {
+			for _, id := range pendingAlerts(reg.LowAlerts) {
+				ret = append(ret, &PendingNotification{
+					Query:        query,
+					Status:       clustering2.LOW,
+					AlertID:      id,
+					Cluster:      reg.Low,
+					Bug:          reg.LowStatus.Bug,
+					Notification: reg.LowAlerts[id],
+				})
+			}
+		}
+		if reg.High != nil {
+			for _, id := range pendingAlerts(reg.HighAlerts) {
+				ret = append(ret, &PendingNotification{
+					Query:        query,
+					Status:       clustering2.HIGH,
+					AlertID:      id,
+					Cluster:      reg.High,
+					Bug:          reg.HighStatus.Bug,
+					Notification: reg.HighAlerts[id],
+				})
+			}
+		}
+	}
+	return ret
+}
+
 // Triaged returns true if all clusters are triaged.
 func (r *Regressions) Triaged() bool {
 	ret := true
@@ -17,8 +17,9 @@ func TestRegressions(t *testing.T) {
 
 	df := &dataframe.FrameResponse{}
 	cl := &clustering2.ClusterSummary{}
-	r.SetLow("source_type=skp", df, cl)
+	assert.True(t, r.SetLow("source_type=skp", 1, df, cl))
 	assert.False(t, r.Triaged(), "Should not be Triaged.")
+	assert.False(t, r.SetLow("source_type=skp", 1, df, cl), "The cluster is not new.")
 
 	// Triage the low cluster.
 	err := r.TriageLow("source_type=skp", TriageStatus{
@@ -36,8 +37,10 @@ func TestRegressions(t *testing.T) {
 	assert.True(t, r.Triaged())
 
 	// Set a high cluster.
-	r.SetHigh("source_type=skp", df, cl)
+	r.SetHigh("source_type=skp", 2, df, cl)
 	assert.False(t, r.Triaged())
+	assert.Equal(t, map[int64]Notification{1: {Pending: true}}, r.ByQuery["source_type=skp"].LowAlerts)
+	assert.Equal(t, map[int64]Notification{2: {Pending: true}}, r.ByQuery["source_type=skp"].HighAlerts)
 
 	// And triage the high cluster.
 	err = r.TriageHigh("source_type=skp", TriageStatus{
@@ -56,5 +59,88 @@ func TestRegressions(t *testing.T) {
 	// Try serializing to JSON.
 	b, err := r.JSON()
 	assert.NoError(t, err)
-	assert.Equal(t, "{\"by_query\":{\"source_type=skp\":{\"low\":{\"centroid\":null,\"keys\":null,\"param_summaries\":null,\"step_fit\":null,\"step_point\":null,\"num\":0},\"high\":{\"centroid\":null,\"keys\":null,\"param_summaries\":null,\"step_fit\":null,\"step_point\":null,\"num\":0},\"frame\":{\"dataframe\":null,\"ticks\":null,\"skps\":null,\"msg\":\"\"},\"low_status\":{\"status\":\"positive\",\"message\":\"SKP Update\"},\"high_status\":{\"status\":\"negative\",\"message\":\"See bug #foo.\"}}}}", string(b))
+	assert.Equal(t, "{\"by_query\":{\"source_type=skp\":{\"low\":{\"centroid\":null,\"keys\":null,\"param_summaries\":null,\"step_fit\":null,\"step_point\":null,\"num\":0},\"high\":{\"centroid\":null,\"keys\":null,\"param_summaries\":null,\"step_fit\":null,\"step_point\":null,\"num\":0},\"frame\":{\"dataframe\":null,\"ticks\":null,\"skps\":null,\"msg\":\"\"},\"low_status\":{\"status\":\"positive\",\"message\":\"SKP Update\",\"bug\":\"\"},\"high_status\":{\"status\":\"negative\",\"message\":\"See bug #foo.\",\"bug\":\"\"},\"low_alerts\":{\"1\":{\"pending\":true,\"emailed\":false}},\"high_alerts\":{\"2\":{\"pending\":true,\"emailed\":false}}}}}", string(b))
+}
+
+func TestSetBug(t *testing.T) {
+	testutils.SmallTest(t)
+	r := New()
+	df := &dataframe.FrameResponse{}
+	cl := &clustering2.ClusterSummary{}
+	assert.Equal(t, ErrNoClusterFound, r.SetBug("source_type=skp", clustering2.LOW, "123"))
+
+	r.SetLow("source_type=skp", 1, df, cl)
+	assert.NoError(t, r.SetBug("source_type=skp", clustering2.LOW, "123"))
+	assert.Equal(t, "123", r.ByQuery["source_type=skp"].LowStatus.Bug)
+	assert.Equal(t, UNTRIAGED, r.ByQuery["source_type=skp"].LowStatus.Status)
+	assert.Equal(t, ErrNoClusterFound, r.SetBug("source_type=skp", clustering2.HIGH, "456"))
+	assert.Error(t, r.SetBug("source_type=skp", clustering2.UNINTERESTING, "456"))
+
+	// Triaging keeps the bug unless a new one is given.
+	assert.NoError(t, r.TriageLow("source_type=skp", TriageStatus{Status: NEGATIVE}))
+	assert.Equal(t, "123", r.ByQuery["source_type=skp"].LowStatus.Bug)
+	assert.NoError(t, r.TriageLow("source_type=skp", TriageStatus{Status: NEGATIVE, Bug: "789"}))
+	assert.Equal(t, "789", r.ByQuery["source_type=skp"].LowStatus.Bug)
+}
+
+func TestNotifications(t *testing.T) {
+	testutils.SmallTest(t)
+	r := New()
+	df := &dataframe.FrameResponse{}
+	cl := &clustering2.ClusterSummary{}
+	assert.Equal(t, []*PendingNotification{}, r.PendingNotifications())
+	assert.Equal(t, ErrNoClusterFound, r.SetNotification("source_type=skp", clustering2.LOW, 1, Notification{}))
+
+	// New clusters need to be notified.
+	r.SetLow("source_type=svg", 1, df, cl)
+	r.SetHigh("source_type=skp", 2, df, cl)
+	assert.NoError(t, r.SetBug("source_type=skp", clustering2.HIGH, "123"))
+	assert.Equal(t, []*PendingNotification{
+		&PendingNotification{
+			Query:        "source_type=skp",
+			Status:       clustering2.HIGH,
+			AlertID:      2,
+			Cluster:      cl,
+			Bug:          "123",
+			Notification: Notification{Pending: true},
+		},
+		&PendingNotification{
+			Query:        "source_type=svg",
+			Status:       clustering2.LOW,
+			AlertID:      1,
+			Cluster:      cl,
+			Notification: Notification{Pending: true},
+		},
+	}, r.PendingNotifications())
+
+	// The email was sent, but filing the bug failed.
+	assert.NoError(t, r.SetNotification("source_type=svg", clustering2.LOW, 1, Notification{Pending: true, Emailed: true}))
+	assert.Equal(t, Notification{Pending: true, Emailed: true}, r.PendingNotifications()[1].Notification)
+
+	// Finding the clusters again doesn't reset their notifications.
+	assert.NoError(t, r.SetNotification("source_type=skp", clustering2.HIGH, 2, Notification{Emailed: true}))
+	assert.False(t, r.SetHigh("source_type=skp", 2, df, cl))
+	assert.Equal(t, 1, len(r.PendingNotifications()))
+	assert.NoError(t, r.SetNotification("source_type=svg", clustering2.LOW, 1, Notification{Emailed: true}))
+	assert.Equal(t, []*PendingNotification{}, r.PendingNotifications())
+
+	assert.Equal(t, ErrNoClusterFound, r.SetNotification("source_type=svg", clustering2.HIGH, 1, Notification{}))
+	assert.Equal(t, ErrNoClusterFound, r.SetNotification("source_type=svg", clustering2.LOW, 3, Notification{}))
+	assert.Error(t, r.SetNotification("source_type=svg", clustering2.UNINTERESTING, 1, Notification{}))
+
+	// Another alert with the same query finding the cluster is notified
+	// separately.
+	assert.False(t, r.SetLow("source_type=svg", 3, df, cl))
+	assert.Equal(t, []*PendingNotification{
+		&PendingNotification{
+			Query:        "source_type=svg",
+			Status:       clustering2.LOW,
+			AlertID:      3,
+			Cluster:      cl,
+			Notification: Notification{Pending: true},
+		},
+	}, r.PendingNotifications())
+	assert.NoError(t, r.SetNotification("source_type=svg", clustering2.LOW, 3, Notification{Emailed: true}))
+	assert.Equal(t, []*PendingNotification{}, r.PendingNotifications())
+	assert.Equal(t, map[int64]Notification{1: {Emailed: true}, 3: {Emailed: true}}, r.ByQuery["source_type=svg"].LowAlerts)
 }
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -27,21 +29,26 @@ import (
 	"go.skia.org/infra/go/auth"
 	"go.skia.org/infra/go/calc"
 	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/email"
 	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/git/gitinfo"
 	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/ingestion"
+	"go.skia.org/infra/go/issues"
 	"go.skia.org/infra/go/login"
+	"go.skia.org/infra/go/metadata"
 	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/rietveld"
 	"go.skia.org/infra/go/sharedconfig"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/perf/go/activitylog"
+	"go.skia.org/infra/perf/go/alerts"
 	"go.skia.org/infra/perf/go/cid"
 	"go.skia.org/infra/perf/go/clustering2"
 	"go.skia.org/infra/perf/go/config"
 	"go.skia.org/infra/perf/go/dataframe"
 	idb "go.skia.org/infra/perf/go/db"
+	"go.skia.org/infra/perf/go/notify"
 	_ "go.skia.org/infra/perf/go/ptraceingest"
 	"go.skia.org/infra/perf/go/ptracestore"
 	"go.skia.org/infra/perf/go/regression"
@@ -63,9 +70,13 @@ var (
 
 // flags
 var (
-	clusterQueries = flag.String("cluster_queries", "source_type=skp&sub_result=min_ms source_type=svg&sub_result=min_ms source_type=image&sub_result=min_ms", "A space separated list of queries we want to cluster over.")
+	adminPort      = flag.String("admin_port", "localhost:9192", "HTTP service address for listing and reprocessing failed ingestion files, see ingestiontool. Should only be reachable from localhost.")
+	clusterQueries = flag.String("cluster_queries", "source_type=skp&sub_result=min_ms source_type=svg&sub_result=min_ms source_type=image&sub_result=min_ms", "A space separated list of queries used to create the initial alerts if no alerts are stored.")
 	configFilename = flag.String("config_filename", "default.toml", "Configuration file in TOML format.")
 	dataFrameSize  = flag.Int("dataframe_size", dataframe.DEFAULT_NUM_COMMITS, "The number of commits to include in the default dataframe.")
+	emailClientID  = flag.String("email_clientid", "", "OAuth Client ID for sending email. Only used with --local and --notifications.")
+	emailSecret    = flag.String("email_clientsecret", "", "OAuth Client Secret for sending email. Only used with --local and --notifications.")
+	emailTokenFile = flag.String("email_token_cache_file", "perf_gmail_token.data", "OAuth token cache file for sending email.")
 	gitRepoDir     = flag.String("git_repo_dir", "../../../skia", "Directory location for the Skia repo.")
 	gitRepoURL     = flag.String("git_repo_url", "https://skia.googlesource.com/skia", "The URL to pass to git clone for the source repository.")
 	internalOnly   = flag.Bool("internal_only", false, "Require the user to be logged in to see any page.")
@@ -74,8 +85,11 @@ var (
 	port           = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
 	promPort       = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
 	ptraceStoreDir = flag.String("ptrace_store_dir", "/tmp/ptracestore", "The directory where the ptracestore tiles are stored.")
+	pushToken      = flag.String("push_token", "", "Secret token that ingestion push notifications have to carry, either as the 'token' query parameter or as the channel token. If empty, push notifications are rejected.")
 	resourcesDir   = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
 	numContinuous  = flag.Int("num_continuous", 50, "The number of commits to do continuous clustering over looking for regressions.")
+	notifications  = flag.Bool("notifications", false, "Send the email and bug notifications configured in the alerts when regressions are found.")
+	perfURL        = flag.String("perf_url", "https://perf.skia.org", "The base URL of this Perf instance, used in links in notifications.")
 	numShift       = flag.Int("num_shift", 10, "The number of commits the shift navigation buttons should jump.")
 )
 
@@ -92,9 +106,13 @@ var (
 
 	regStore *regression.Store
 
+	alertStore *alerts.Store
+
 	continuous *regression.Continuous
 
 	storageClient *storage.Client
+
+	ingesters []*ingestion.Ingester
 )
 
 func loadTemplates() {
@@ -168,13 +186,67 @@ func Init() {
 	clusterRequests = clustering2.NewRunningClusterRequests(git, cidl)
 	dataframe.StartWarmer(git)
 	regStore = regression.NewStore()
+	alertStore = alerts.NewStore()
+	if err := initAlerts(); err != nil {
+		sklog.Fatalf("Failed to create the initial alerts: %s", err)
+	}
 
 	// Start running continuous clustering looking for regressions.
-	queries := strings.Split(*clusterQueries, " ")
-	continuous = regression.NewContinuous(git, cidl, queries, regStore, *numContinuous)
+	continuous = regression.NewContinuous(git, cidl, alertStore, regStore, newNotifier(), *numContinuous)
 	go continuous.Run()
 }
 
+// newNotifier returns the notify.Notifier used to send notifications for new
+// regressions. Unless --notifications is set it doesn't send anything.
+func newNotifier() *notify.Notifier {
+	if !*notifications {
+		return notify.New(nil, nil, *perfURL)
+	}
+	clientID := *emailClientID
+	clientSecret := *emailSecret
+	if !*local {
+		clientID = metadata.Must(metadata.ProjectGet(metadata.GMAIL_CLIENT_ID))
+		clientSecret = metadata.Must(metadata.ProjectGet(metadata.GMAIL_CLIENT_SECRET))
+		cachedGMailToken := metadata.Must(metadata.ProjectGet(metadata.GMAIL_CACHED_TOKEN))
+		if err := ioutil.WriteFile(*emailTokenFile, []byte(cachedGMailToken), os.ModePerm); err != nil {
+			sklog.Fatalf("Failed to cache token: %s", err)
+		}
+	}
+	gmail, err := email.NewGMail(clientID, clientSecret, *emailTokenFile)
+	if err != nil {
+		sklog.Fatalf("Failed to create email auth: %s", err)
+	}
+	client, err := auth.NewDefaultJWTServiceAccountClient("https://www.googleapis.com/auth/userinfo.email")
+	if err != nil {
+		sklog.Fatalf("Failed to create the issue tracker client: %s", err)
+	}
+	return notify.New(gmail, issues.NewMonorailIssueTracker(client), *perfURL)
+}
+
+// initAlerts creates an alert for each of the queries in --cluster_queries if
+// no alerts are stored yet.
+func initAlerts() error {
+	configs, err := alertStore.List()
+	if err != nil {
+		return err
+	}
+	if len(configs) > 0 {
+		return nil
+	}
+	for _, q := range strings.Split(*clusterQueries, " ") {
+		if q == "" {
+			continue
+		}
+		cfg := alerts.NewConfig()
+		cfg.DisplayName = q
+		cfg.Query = q
+		if err := alertStore.Save(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // activityHandler serves the HTML for the /activitylog/ page.
 //
 // If an optional number n is appended to the path, returns the most recent n
@@ -245,6 +317,90 @@ func alertsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// alertListHandler returns all the stored alerts.Config's.
+func alertListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	configs, err := alertStore.List()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve alerts.")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(configs); err != nil {
+		sklog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// alertNewHandler returns a new alerts.Config with default values, to be
+// filled in and sent to alertUpdateHandler.
+func alertNewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg := alerts.NewConfig()
+	cfg.Radius = regression.RADIUS
+	cfg.Interesting = clustering2.INTERESTING_THRESHHOLD
+	cfg.Owner = login.LoggedInAs(r)
+	if err := json.NewEncoder(w).Encode(cfg); err != nil {
+		sklog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// alertUpdateHandler creates or updates the POST'd alerts.Config. A Config
+// with an ID of alerts.INVALID_ID is created. The stored Config is returned.
+func alertUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to edit alerts.")
+		return
+	}
+	cfg := alerts.NewConfig()
+	if err := json.NewDecoder(r.Body).Decode(cfg); err != nil {
+		httputils.ReportError(w, r, err, "Failed to decode JSON.")
+		return
+	}
+	if err := alertStore.Save(cfg); err != nil {
+		httputils.ReportError(w, r, err, "Failed to save alert.")
+		return
+	}
+	a := &activitylog.Activity{
+		UserID: user,
+		Action: fmt.Sprintf("Perf Alert Update: %d %q", cfg.ID, cfg.Query),
+		URL:    r.Header.Get("Referer"),
+	}
+	if err := activitylog.Write(a); err != nil {
+		sklog.Errorf("Failed to log activity: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(cfg); err != nil {
+		sklog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// alertDeleteHandler deletes the alerts.Config with the given id.
+func alertDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to delete alerts.")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid alert id.")
+		return
+	}
+	if err := alertStore.Delete(id); err != nil {
+		httputils.ReportError(w, r, err, "Failed to delete alert.")
+		return
+	}
+	a := &activitylog.Activity{
+		UserID: user,
+		Action: fmt.Sprintf("Perf Alert Delete: %d", id),
+		URL:    r.Header.Get("Referer"),
+	}
+	if err := activitylog.Write(a); err != nil {
+		sklog.Errorf("Failed to log activity: %s", err)
+	}
+}
+
 func initpageHandler(w http.ResponseWriter, r *http.Request) {
 	df := freshDataFrame.Get()
 	resp, err := dataframe.ResponseFromDataFrame(&dataframe.DataFrame{
@@ -420,16 +576,18 @@ func frameResultsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // countHandler takes the POST'd query and runs that against the current
-// dataframe and returns how many traces match the query.
+// dataframe and returns how many traces match the query. The query is parsed
+// the same way as the queries of a FrameRequest, i.e. it can be a boolean
+// query expression, see query.NewFromString.
 func countHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if err := r.ParseForm(); err != nil {
-		httputils.ReportError(w, r, err, "Invalid URL query.")
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to read query.")
 		return
 	}
-	var err error
-	var q *query.Query
-	if q, err = query.New(r.Form); err != nil {
+	q, err := query.NewFromString(string(b))
+	if err != nil {
 		httputils.ReportError(w, r, err, "Invalid query.")
 		return
 	}
@@ -971,7 +1129,7 @@ func initIngestion() {
 		sklog.Fatalf("Unable to read config file %s. Got error: %s", *configFilename, err)
 	}
 
-	ingesters, err := ingestion.IngestersFromConfig(config, client, evt)
+	ingesters, err = ingestion.IngestersFromConfig(config, client, evt)
 	if err != nil {
 		sklog.Fatalf("Unable to instantiate ingesters: %s", err)
 	}
@@ -1062,8 +1220,23 @@ func main() {
 	router.HandleFunc("/_/reg/", regressionRangeHandler).Methods("POST")
 	router.HandleFunc("/_/triage/", triageHandler).Methods("POST")
 	router.HandleFunc("/_/alerts/", alertsHandler)
+	router.HandleFunc("/_/alerts/list", alertListHandler).Methods("GET")
+	router.HandleFunc("/_/alerts/new", alertNewHandler).Methods("GET")
+	router.HandleFunc("/_/alerts/update", alertUpdateHandler).Methods("POST")
+	router.HandleFunc("/_/alerts/delete/{id:[0-9]+}", alertDeleteHandler).Methods("POST")
 	router.HandleFunc("/_/details/", detailsHandler).Methods("POST")
 	router.HandleFunc("/_/shift/", shiftHandler).Methods("POST")
+	router.HandleFunc("/_/ingestion/push", ingestion.PushHandler(ingesters, *pushToken)).Methods("POST")
+
+	// Serve the dead-letter stores of the ingesters. Since reprocessing
+	// isn't authenticated, it's served separately on adminPort, which is
+	// bound to localhost by default.
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/failed", ingestion.FailedFilesHandler(ingesters))
+	adminMux.HandleFunc("/reprocess", ingestion.ReprocessHandler(ingesters))
+	go func() {
+		sklog.Fatal(http.ListenAndServe(*adminPort, httputils.LoggingGzipRequestResponse(adminMux)))
+	}()
 
 	var h http.Handler = router
 	if *internalOnly {
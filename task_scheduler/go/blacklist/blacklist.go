@@ -6,6 +6,7 @@ import (
 	"os"
 	"regexp"
 	"sync"
+	"time"
 
 	"go.skia.org/infra/go/sklog"
 
@@ -31,20 +32,39 @@ type Blacklist struct {
 	mtx         sync.RWMutex
 }
 
-// Match determines whether the given taskSpec/commit pair matches one of the
-// Rules in the Blacklist.
-func (b *Blacklist) Match(taskSpec, commit string) bool {
-	return b.MatchRule(taskSpec, commit) != ""
+// Match determines whether the given repo/taskSpec/commit tuple matches one of
+// the Rules in the Blacklist which has not expired as of the given time.
+func (b *Blacklist) Match(repo, taskSpec, commit string, now time.Time) bool {
+	return b.MatchRule(repo, taskSpec, commit, now) != ""
 }
 
-// MatchRule determines whether the given taskSpec/commit pair matches one of the
-// Rules in the Blacklist. Returns the name of the matched Rule or the empty
-// string if no Rules match.
-func (b *Blacklist) MatchRule(taskSpec, commit string) string {
+// MatchRule determines whether the given repo/taskSpec/commit tuple matches one
+// of the Rules in the Blacklist. Returns the name of the matched Rule or the
+// empty string if no Rules match. Quarantine Rules and Rules which have expired
+// as of the given time are ignored.
+func (b *Blacklist) MatchRule(repo, taskSpec, commit string, now time.Time) string {
+	return b.match(repo, taskSpec, commit, now, false)
+}
+
+// MatchQuarantine determines whether the given repo/taskSpec/commit tuple
+// matches one of the quarantine Rules in the Blacklist. Returns the name of the
+// matched Rule or the empty string if no quarantine Rules match. Rules which
+// have expired as of the given time are ignored.
+func (b *Blacklist) MatchQuarantine(repo, taskSpec, commit string, now time.Time) string {
+	return b.match(repo, taskSpec, commit, now, true)
+}
+
+// match returns the name of a Rule which has not expired as of now, whose
+// Quarantine field is equal to quarantine and which matches the given
+// repo/taskSpec/commit tuple, or the empty string if there is no such Rule.
+func (b *Blacklist) match(repo, taskSpec, commit string, now time.Time, quarantine bool) string {
 	b.mtx.RLock()
 	defer b.mtx.RUnlock()
 	for _, rule := range b.Rules {
-		if rule.Match(taskSpec, commit) {
+		if rule.Quarantine != quarantine || rule.Expired(now) {
+			continue
+		}
+		if rule.Match(repo, taskSpec, commit) {
 			return rule.Name
 		}
 	}
@@ -77,9 +97,10 @@ func (b *Blacklist) writeOut() error {
 	return json.NewEncoder(f).Encode(b)
 }
 
-// Add adds a new Rule to the Blacklist.
-func (b *Blacklist) AddRule(r *Rule, repos repograph.Map) error {
-	if err := ValidateRule(r, repos); err != nil {
+// Add adds a new Rule to the Blacklist. The Rule must not have expired as of
+// the given time.
+func (b *Blacklist) AddRule(r *Rule, repos repograph.Map, now time.Time) error {
+	if err := ValidateRule(r, repos, now); err != nil {
 		return err
 	}
 	return b.addRule(r)
@@ -101,7 +122,7 @@ func (b *Blacklist) addRule(r *Rule) error {
 }
 
 // NewCommitRangeRule creates a new Rule which covers a range of commits.
-func NewCommitRangeRule(name, user, description string, taskSpecPatterns []string, startCommit, endCommit string, repos repograph.Map) (*Rule, error) {
+func NewCommitRangeRule(name, user, description string, taskSpecPatterns []string, startCommit, endCommit string, repos repograph.Map, now time.Time) (*Rule, error) {
 	_, repoName, _, err := repos.FindCommit(startCommit)
 	if err != nil {
 		return nil, err
@@ -141,12 +162,13 @@ func NewCommitRangeRule(name, user, description string, taskSpecPatterns []strin
 
 	rule := &Rule{
 		AddedBy:          user,
+		Repo:             repoName,
 		TaskSpecPatterns: taskSpecPatterns,
 		Commits:          commits,
 		Description:      description,
 		Name:             name,
 	}
-	if err := ValidateRule(rule, repos); err != nil {
+	if err := ValidateRule(rule, repos, now); err != nil {
 		return nil, err
 	}
 	return rule, nil
@@ -168,6 +190,34 @@ func (b *Blacklist) removeRule(name string) error {
 	return nil
 }
 
+// RemoveExpiredRules removes all Rules which have expired as of the given time
+// from the Blacklist. Returns the names of the removed Rules.
+func (b *Blacklist) RemoveExpiredRules(now time.Time) ([]string, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	expired := map[string]*Rule{}
+	for name, r := range b.Rules {
+		if r.Expired(now) {
+			expired[name] = r
+		}
+	}
+	if len(expired) == 0 {
+		return []string{}, nil
+	}
+	removed := make([]string, 0, len(expired))
+	for name, _ := range expired {
+		delete(b.Rules, name)
+		removed = append(removed, name)
+	}
+	if err := b.writeOut(); err != nil {
+		for name, r := range expired {
+			b.Rules[name] = r
+		}
+		return nil, err
+	}
+	return removed, nil
+}
+
 // RemoveRule removes the Rule from the Blacklist.
 func (b *Blacklist) RemoveRule(name string) error {
 	for _, r := range DEFAULT_RULES {
@@ -181,6 +231,9 @@ func (b *Blacklist) RemoveRule(name string) error {
 // Rule is a struct which indicates a specific task or set of tasks which
 // should not be scheduled.
 //
+// Repo is the URL of the repo to which the Rule applies. If empty, the Rule
+// applies for all repos.
+//
 // TaskSpecPatterns consists of regular expressions used to match taskSpecs
 // which should not be triggered according to this Rule.
 //
@@ -188,16 +241,32 @@ func (b *Blacklist) RemoveRule(name string) error {
 // empty, the Rule applies for all commits.
 //
 // A Rule should specify TaskSpecPatterns or Commits or both.
+//
+// Expires is the time at which the Rule stops applying and is removed from the
+// Blacklist. If zero, the Rule never expires.
+//
+// Quarantine indicates that matching tasks should still be scheduled, but that
+// their failures should not cause their Jobs to fail.
 type Rule struct {
-	AddedBy          string   `json:"added_by"`
-	TaskSpecPatterns []string `json:"task_spec_patterns"`
-	Commits          []string `json:"commits"`
-	Description      string   `json:"description"`
-	Name             string   `json:"name"`
+	AddedBy          string    `json:"added_by"`
+	Repo             string    `json:"repo,omitempty"`
+	TaskSpecPatterns []string  `json:"task_spec_patterns"`
+	Commits          []string  `json:"commits"`
+	Description      string    `json:"description"`
+	Expires          time.Time `json:"expires"`
+	Name             string    `json:"name"`
+	Quarantine       bool      `json:"quarantine"`
 }
 
-// ValidateRule returns an error if the given Rule is not valid.
-func ValidateRule(r *Rule, repos repograph.Map) error {
+// Expired returns true iff the Rule has an expiration time which is not after
+// the given time.
+func (r *Rule) Expired(now time.Time) bool {
+	return !util.TimeIsZero(r.Expires) && !r.Expires.After(now)
+}
+
+// ValidateRule returns an error if the given Rule is not valid, including if it
+// has expired as of the given time.
+func ValidateRule(r *Rule, repos repograph.Map, now time.Time) error {
 	if r.Name == "" {
 		return fmt.Errorf("Rules must have a name.")
 	}
@@ -210,6 +279,14 @@ func ValidateRule(r *Rule, repos repograph.Map) error {
 	if len(r.TaskSpecPatterns) == 0 && len(r.Commits) == 0 {
 		return fmt.Errorf("Rules must include a taskSpec pattern and/or a commit/range.")
 	}
+	if r.Expired(now) {
+		return fmt.Errorf("Rule expiration time must be in the future.")
+	}
+	if r.Repo != "" {
+		if _, ok := repos[r.Repo]; !ok {
+			return fmt.Errorf("Unknown repo %q", r.Repo)
+		}
+	}
 	for _, c := range r.Commits {
 		if _, _, _, err := repos.FindCommit(c); err != nil {
 			return err
@@ -218,6 +295,12 @@ func ValidateRule(r *Rule, repos repograph.Map) error {
 	return nil
 }
 
+// matchRepo determines whether the repo portion of the Rule matches.
+func (r *Rule) matchRepo(repo string) bool {
+	// If no repo is specified, then the rule applies for ALL repos.
+	return r.Repo == "" || r.Repo == repo
+}
+
 // matchTaskSpec determines whether the taskSpec portion of the Rule matches.
 func (r *Rule) matchTaskSpec(taskSpec string) bool {
 	// If no taskSpecs are specified, then the rule applies for ALL taskSpecs.
@@ -254,9 +337,9 @@ func (r *Rule) matchCommit(commit string) bool {
 	return false
 }
 
-// Match returns true iff the Rule matches the given taskSpec and commit.
-func (r *Rule) Match(taskSpec, commit string) bool {
-	return r.matchTaskSpec(taskSpec) && r.matchCommit(commit)
+// Match returns true iff the Rule matches the given repo, taskSpec and commit.
+func (r *Rule) Match(repo, taskSpec, commit string) bool {
+	return r.matchRepo(repo) && r.matchTaskSpec(taskSpec) && r.matchCommit(commit)
 }
 
 // FromFile returns a Blacklist instance based on the given file. If the file
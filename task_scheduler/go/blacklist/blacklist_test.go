@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"path"
 	"testing"
+	"time"
 
 	"go.skia.org/infra/go/git/repograph"
 	git_testutils "go.skia.org/infra/go/git/testutils"
@@ -43,6 +44,72 @@ func TestAddRemove(t *testing.T) {
 	testutils.AssertDeepEqual(t, b1, b2)
 }
 
+func TestQuarantineAndExpiration(t *testing.T) {
+	testutils.SmallTest(t)
+	// Setup.
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	f := path.Join(tmp, "blacklist.json")
+	b, err := FromFile(f)
+	assert.NoError(t, err)
+
+	// Test.
+	now := time.Now().UTC().Round(time.Second)
+	blocked := &Rule{
+		AddedBy:          "test@google.com",
+		TaskSpecPatterns: []string{"^Build-.*"},
+		Name:             "blocked",
+	}
+	quarantined := &Rule{
+		AddedBy:          "test@google.com",
+		Repo:             "skia.git",
+		TaskSpecPatterns: []string{"^Test-.*"},
+		Name:             "quarantined",
+		Quarantine:       true,
+		Expires:          now.Add(time.Hour),
+	}
+	expired := &Rule{
+		AddedBy:          "test@google.com",
+		TaskSpecPatterns: []string{"^Perf-.*"},
+		Name:             "expired",
+		Expires:          now.Add(-time.Minute),
+	}
+	assert.NoError(t, b.addRule(blocked))
+	assert.NoError(t, b.addRule(quarantined))
+	assert.NoError(t, b.addRule(expired))
+
+	// Quarantine rules don't prevent scheduling.
+	assert.Equal(t, "blocked", b.MatchRule("skia.git", "Build-Ubuntu", "abc123", now))
+	assert.Equal(t, "", b.MatchQuarantine("skia.git", "Build-Ubuntu", "abc123", now))
+	assert.Equal(t, "", b.MatchRule("skia.git", "Test-Ubuntu", "abc123", now))
+	assert.Equal(t, "quarantined", b.MatchQuarantine("skia.git", "Test-Ubuntu", "abc123", now))
+
+	// Rules with a repo only match that repo.
+	assert.Equal(t, "blocked", b.MatchRule("infra.git", "Build-Ubuntu", "abc123", now))
+	assert.Equal(t, "", b.MatchQuarantine("infra.git", "Test-Ubuntu", "abc123", now))
+
+	// Expired rules don't match.
+	assert.False(t, b.Match("skia.git", "Perf-Ubuntu", "abc123", now))
+	assert.Equal(t, "", b.MatchQuarantine("skia.git", "Test-Ubuntu", "abc123", now.Add(time.Hour)))
+
+	// Expired rules are removed.
+	removed, err := b.RemoveExpiredRules(now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"expired"}, removed)
+	_, ok := b.Rules["expired"]
+	assert.False(t, ok)
+	b2, err := FromFile(f)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, b.Rules, b2.Rules)
+
+	removed, err = b.RemoveExpiredRules(now.Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"quarantined"}, removed)
+	assert.Equal(t, 1, len(b.Rules))
+	assert.Equal(t, "blocked", b.MatchRule("skia.git", "Build-Ubuntu", "abc123", now.Add(2*time.Hour)))
+}
+
 func TestRules(t *testing.T) {
 	testutils.SmallTest(t)
 	type testCase struct {
@@ -155,7 +222,7 @@ func TestRules(t *testing.T) {
 	}
 	for _, test := range tests {
 		for _, c := range test.cases {
-			assert.Equal(t, c.expectMatch, test.rule.Match(c.taskSpec, c.commit), c.msg)
+			assert.Equal(t, c.expectMatch, test.rule.Match("", c.taskSpec, c.commit), c.msg)
 		}
 	}
 }
@@ -239,6 +306,7 @@ func TestValidation(t *testing.T) {
 	repo, err := repograph.NewGraph(gb.RepoUrl(), tmp)
 	assert.NoError(t, err)
 	repos[gb.RepoUrl()] = repo
+	now := time.Date(2017, time.May, 10, 12, 0, 0, 0, time.UTC)
 
 	// Test.
 	tests := []struct {
@@ -354,10 +422,50 @@ func TestValidation(t *testing.T) {
 			expect: nil,
 			msg:    "Five commits",
 		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Expires:          now.Add(time.Hour),
+			},
+			expect: nil,
+			msg:    "Expires in the future",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Expires:          now.Add(-time.Hour),
+			},
+			expect: fmt.Errorf("Rule expiration time must be in the future."),
+			msg:    "Already expired",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				Repo:             gb.RepoUrl(),
+				TaskSpecPatterns: []string{".*"},
+			},
+			expect: nil,
+			msg:    "Known repo",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				Repo:             "bogus.git",
+				TaskSpecPatterns: []string{".*"},
+			},
+			expect: fmt.Errorf("Unknown repo \"bogus.git\""),
+			msg:    "Unknown repo",
+		},
 	}
 	for _, test := range tests {
 		sklog.Infof(test.msg)
-		assert.Equal(t, test.expect, ValidateRule(&test.rule, repos), test.msg)
+		assert.Equal(t, test.expect, ValidateRule(&test.rule, repos, now), test.msg)
 	}
 }
 
@@ -380,11 +488,12 @@ func TestCommitRange(t *testing.T) {
 	// Test.
 
 	// Create a commit range rule.
+	now := time.Now()
 	startCommit := commits[0]
 	endCommit := commits[6]
-	rule, err := NewCommitRangeRule("commit range", "test@google.com", "...", []string{}, startCommit, endCommit, repos)
+	rule, err := NewCommitRangeRule("commit range", "test@google.com", "...", []string{}, startCommit, endCommit, repos, now)
 	assert.NoError(t, err)
-	err = b.AddRule(rule, repos)
+	err = b.AddRule(rule, repos, now)
 	assert.NoError(t, err)
 
 	// Ensure that we got the expected list of commits.
@@ -393,6 +502,7 @@ func TestCommitRange(t *testing.T) {
 		commits[1],
 		commits[0],
 	}, b.Rules["commit range"].Commits)
+	assert.Equal(t, gb.RepoUrl(), b.Rules["commit range"].Repo)
 
 	// Test a few commits.
 	tc := []struct {
@@ -427,6 +537,6 @@ func TestCommitRange(t *testing.T) {
 		},
 	}
 	for _, c := range tc {
-		assert.Equal(t, c.expect, b.Match("", c.commit))
+		assert.Equal(t, c.expect, b.Match(gb.RepoUrl(), "", c.commit, now))
 	}
 }
@@ -26,6 +26,11 @@ type TaskCache interface {
 	// given commit, or nil if no such task exists.
 	GetTaskForCommit(string, string, string) (*Task, error)
 
+	// GetTaskForInput returns the most recent successful Task with the given
+	// name and InputHash which actually ran, ie. was not itself
+	// de-duplicated, or nil if no such task exists.
+	GetTaskForInput(string, string) (*Task, error)
+
 	// GetTasksByKey returns the tasks with the given TaskKey, sorted
 	// by creation time.
 	GetTasksByKey(*TaskKey) ([]*Task, error)
@@ -79,6 +84,8 @@ type taskCache struct {
 	tasks          map[string]*Task
 	// map[repo_name][commit_hash][task_spec_name]*Task
 	tasksByCommit map[string]map[string]map[string]*Task
+	// map[task_spec_name][input_hash]*Task
+	tasksByInput map[string]map[string]*Task
 	// map[TaskKey]map[task_id]*Task
 	tasksByKey map[TaskKey]map[string]*Task
 	// tasksByTime is sorted by Task.Created.
@@ -182,6 +189,17 @@ func (c *taskCache) GetTaskForCommit(repo, commit, name string) (*Task, error) {
 	return nil, nil
 }
 
+// See documentation for TaskCache interface.
+func (c *taskCache) GetTaskForInput(name, inputHash string) (*Task, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if t, ok := c.tasksByInput[name][inputHash]; ok {
+		return t.Copy(), nil
+	}
+	return nil, nil
+}
+
 // See documentation for TaskCache interface.
 func (c *taskCache) UnfinishedTasks() ([]*Task, error) {
 	c.mtx.RLock()
@@ -212,6 +230,19 @@ func (c *taskCache) removeFromTasksByCommit(task *Task) {
 
 }
 
+// removeFromTasksByInput removes task from c.tasksByInput if it is the indexed
+// Task for its name and InputHash. Assumes the caller holds a lock.
+func (c *taskCache) removeFromTasksByInput(task *Task) {
+	if byInput, ok := c.tasksByInput[task.Name]; ok {
+		if other, ok := byInput[task.InputHash]; ok && other.Id == task.Id {
+			delete(byInput, task.InputHash)
+			if len(byInput) == 0 {
+				delete(c.tasksByInput, task.Name)
+			}
+		}
+	}
+}
+
 // expireTasks removes data from c whose Created time is before the beginning
 // of the Window. Assumes the caller holds a lock. This is a helper for
 // expireAndUpdate.
@@ -235,6 +266,9 @@ func (c *taskCache) expireTasks() {
 		// Tasks by commit.
 		c.removeFromTasksByCommit(task)
 
+		// Tasks by input.
+		c.removeFromTasksByInput(task)
+
 		// Tasks by key.
 		byKey, ok := c.tasksByKey[task.TaskKey]
 		if ok {
@@ -326,6 +360,21 @@ func (c *taskCache) insertOrUpdateTask(task *Task) {
 		}
 	}
 
+	// Insert the task into tasksByInput, if its results may be reused.
+	if isUpdate {
+		c.removeFromTasksByInput(old)
+	}
+	if task.InputHash != "" && task.DeduplicatedFrom == "" && task.Success() && task.IsolatedOutput != "" {
+		byInput, ok := c.tasksByInput[task.Name]
+		if !ok {
+			byInput = map[string]*Task{}
+			c.tasksByInput[task.Name] = byInput
+		}
+		if other, ok := byInput[task.InputHash]; !ok || !task.Created.Before(other.Created) {
+			byInput[task.InputHash] = task
+		}
+	}
+
 	// Unfinished tasks.
 	if !task.Done() && !task.Fake() {
 		c.unfinished[task.Id] = task
@@ -378,6 +427,7 @@ func (c *taskCache) reset() error {
 	c.queryId = queryId
 	c.tasks = map[string]*Task{}
 	c.tasksByCommit = map[string]map[string]map[string]*Task{}
+	c.tasksByInput = map[string]map[string]*Task{}
 	c.tasksByKey = map[TaskKey]map[string]*Task{}
 	c.unfinished = map[string]*Task{}
 	c.expireAndUpdate(tasks)
@@ -99,6 +99,69 @@ func TestTaskCacheKnownTaskName(t *testing.T) {
 	assert.True(t, c.KnownTaskName(t3.Repo, t3.Name))
 }
 
+func TestTaskCacheGetTaskForInput(t *testing.T) {
+	testutils.SmallTest(t)
+	db := NewInMemoryTaskDB()
+	w, err := window.New(time.Hour, 0, nil)
+	assert.NoError(t, err)
+	c, err := NewTaskCache(db, w)
+	assert.NoError(t, err)
+
+	check := func(name, inputHash string, expect *Task) {
+		found, err := c.GetTaskForInput(name, inputHash)
+		assert.NoError(t, err)
+		if expect == nil {
+			assert.Nil(t, found)
+		} else {
+			testutils.AssertDeepEqual(t, expect, found)
+		}
+	}
+
+	// Unfinished tasks are not indexed.
+	startTime := time.Now().Add(-30 * time.Minute) // Arbitrary starting point.
+	t1 := makeTask(startTime, []string{"a"})
+	t1.InputHash = "abc"
+	assert.NoError(t, db.PutTask(t1))
+	assert.NoError(t, c.Update())
+	check(t1.Name, "abc", nil)
+
+	// Failed tasks are not indexed.
+	t1.Status = TASK_STATUS_FAILURE
+	assert.NoError(t, db.PutTask(t1))
+	assert.NoError(t, c.Update())
+	check(t1.Name, "abc", nil)
+
+	// Successful tasks are indexed.
+	t2 := makeTask(startTime.Add(time.Minute), []string{"b"})
+	t2.InputHash = "abc"
+	t2.IsolatedOutput = "def"
+	t2.Status = TASK_STATUS_SUCCESS
+	assert.NoError(t, db.PutTask(t2))
+	assert.NoError(t, c.Update())
+	check(t2.Name, "abc", t2)
+	check(t2.Name, "xyz", nil)
+	check("Other-Task", "abc", nil)
+
+	// De-duplicated tasks are not indexed.
+	t3 := makeTask(startTime.Add(2*time.Minute), []string{"c"})
+	t3.DeduplicatedFrom = t2.Id
+	t3.InputHash = "abc"
+	t3.IsolatedOutput = "def"
+	t3.Status = TASK_STATUS_SUCCESS
+	assert.NoError(t, db.PutTask(t3))
+	assert.NoError(t, c.Update())
+	check(t2.Name, "abc", t2)
+
+	// The most recent successful task is preferred.
+	t4 := makeTask(startTime.Add(3*time.Minute), []string{"d"})
+	t4.InputHash = "abc"
+	t4.IsolatedOutput = "ghi"
+	t4.Status = TASK_STATUS_SUCCESS
+	assert.NoError(t, db.PutTask(t4))
+	assert.NoError(t, c.Update())
+	check(t4.Name, "abc", t4)
+}
+
 func TestTaskCacheGetTasksFromDateRange(t *testing.T) {
 	testutils.SmallTest(t)
 	db := NewInMemoryTaskDB()
@@ -32,6 +32,10 @@ const (
 	// JOB_STATUS_CANCELED indicates that the Job has been canceled.
 	JOB_STATUS_CANCELED JobStatus = "CANCELED"
 
+	// JOB_STATUS_SKIPPED indicates that the Job was not run because none
+	// of its TaskSpecs could be affected by the changes in its commit.
+	JOB_STATUS_SKIPPED JobStatus = "SKIPPED"
+
 	// JOB_URL_TMPL is a template for Job URLs.
 	JOB_URL_TMPL = "%s/job/%s"
 
@@ -42,6 +46,7 @@ const (
 
 var (
 	JOB_STATUS_BADNESS = map[JobStatus]int{
+		JOB_STATUS_SKIPPED:     0,
 		JOB_STATUS_SUCCESS:     0,
 		JOB_STATUS_IN_PROGRESS: 1,
 		JOB_STATUS_CANCELED:    2,
@@ -259,16 +264,23 @@ func (j *Job) DeriveStatus() JobStatus {
 		}
 		canRetry := len(tasks) < maxAttempts
 		bestStatus := JOB_STATUS_MISHAP
+		quarantined := false
 		for _, t := range tasks {
 			status := JobStatusFromTaskStatus(t.Status)
 			if bestStatus.WorseThan(status) {
 				bestStatus = status
 			}
+			if t.Quarantined && t.Status == TASK_STATUS_FAILURE {
+				quarantined = true
+			}
 		}
 		if bestStatus == JOB_STATUS_SUCCESS || bestStatus == JOB_STATUS_IN_PROGRESS {
 			worstStatus = WorseJobStatus(worstStatus, bestStatus)
 		} else if canRetry {
 			worstStatus = WorseJobStatus(worstStatus, JOB_STATUS_IN_PROGRESS)
+		} else if bestStatus == JOB_STATUS_FAILURE && quarantined {
+			// Failures of quarantined Tasks are non-blocking.
+			worstStatus = WorseJobStatus(worstStatus, JOB_STATUS_SUCCESS)
 		} else {
 			worstStatus = WorseJobStatus(worstStatus, bestStatus)
 		}
@@ -219,6 +219,13 @@ func TestJobDeriveStatus(t *testing.T) {
 	t2.Status = TASK_STATUS_MISHAP
 	assert.Equal(t, j1.DeriveStatus(), JOB_STATUS_MISHAP)
 
+	// Failures of quarantined tasks don't fail the job, but mishaps do.
+	t2.Quarantined = true
+	assert.Equal(t, j1.DeriveStatus(), JOB_STATUS_MISHAP)
+	t2.Status = TASK_STATUS_FAILURE
+	assert.Equal(t, j1.DeriveStatus(), JOB_STATUS_IN_PROGRESS)
+	t2.Quarantined = false
+
 	// No, it succeeded.
 	t2.Status = TASK_STATUS_SUCCESS
 	assert.Equal(t, j1.DeriveStatus(), JOB_STATUS_IN_PROGRESS)
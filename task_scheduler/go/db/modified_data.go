@@ -112,6 +112,18 @@ func (m *modifiedData) StopTrackingModifiedEntries(id string) {
 	delete(m.expiration, id)
 }
 
+// StopTrackingAllEntries removes all subscribers, causing subsequent calls to
+// GetModifiedEntries to return ErrUnknownId. Used when modifications may have
+// been missed, so that subscribers know to reload their data.
+func (m *modifiedData) StopTrackingAllEntries() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for id, _ := range m.expiration {
+		delete(m.data, id)
+		delete(m.expiration, id)
+	}
+}
+
 type ModifiedTasks struct {
 	m modifiedData
 }
@@ -169,6 +181,12 @@ func (m *ModifiedTasks) StopTrackingModifiedTasks(id string) {
 	m.m.StopTrackingModifiedEntries(id)
 }
 
+// StopTrackingAllModifiedTasks removes all subscribers. Subsequent calls to
+// GetModifiedTasks with any existing id will return ErrUnknownId.
+func (m *ModifiedTasks) StopTrackingAllModifiedTasks() {
+	m.m.StopTrackingAllEntries()
+}
+
 type ModifiedJobs struct {
 	m modifiedData
 }
@@ -225,3 +243,9 @@ func (m *ModifiedJobs) StartTrackingModifiedJobs() (string, error) {
 func (m *ModifiedJobs) StopTrackingModifiedJobs(id string) {
 	m.m.StopTrackingModifiedEntries(id)
 }
+
+// StopTrackingAllModifiedJobs removes all subscribers. Subsequent calls to
+// GetModifiedJobs with any existing id will return ErrUnknownId.
+func (m *ModifiedJobs) StopTrackingAllModifiedJobs() {
+	m.m.StopTrackingAllEntries()
+}
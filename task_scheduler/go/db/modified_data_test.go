@@ -99,6 +99,33 @@ func TestModifiedTasksTooManyUsers(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestModifiedTasksStopTrackingAll(t *testing.T) {
+	testutils.SmallTest(t)
+	m := ModifiedTasks{}
+
+	id1, err := m.StartTrackingModifiedTasks()
+	assert.NoError(t, err)
+	id2, err := m.StartTrackingModifiedTasks()
+	assert.NoError(t, err)
+	m.TrackModifiedTask(makeTask(time.Unix(0, 1470674132000000), []string{"a"}))
+
+	m.StopTrackingAllModifiedTasks()
+	_, err = m.GetModifiedTasks(id1)
+	assert.True(t, IsUnknownId(err))
+	_, err = m.GetModifiedTasks(id2)
+	assert.True(t, IsUnknownId(err))
+
+	// New subscribers work as usual.
+	id3, err := m.StartTrackingModifiedTasks()
+	assert.NoError(t, err)
+	t1 := makeTask(time.Unix(0, 1470674376000000), []string{"b"})
+	t1.Id = "1"
+	m.TrackModifiedTask(t1)
+	tasks, err := m.GetModifiedTasks(id3)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Task{t1}, tasks)
+}
+
 func TestModifiedJobs(t *testing.T) {
 	testutils.SmallTest(t)
 	m := ModifiedJobs{}
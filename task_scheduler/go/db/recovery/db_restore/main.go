@@ -0,0 +1,92 @@
+// Restore the Task Scheduler DB as of a given time from backups in GCS or a
+// local directory, writing a new local_db file.
+//
+// Example:
+//   db_restore --time=2017-01-02T15:04:05Z --out=/tmp/restored.bdb
+//   db_restore --dir=/tmp/backups --out=/tmp/restored.bdb
+package main
+
+import (
+	"flag"
+	"os"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+
+	"go.skia.org/infra/go/auth"
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/task_scheduler/go/db/recovery"
+)
+
+var (
+	allowMissing = flag.Bool("allow_missing_tasks", false, "Exit successfully even if restored Jobs refer to missing Tasks.")
+	dir          = flag.String("dir", "", "Local directory containing backups, with the same layout as the GCS bucket and the output of \"gsutil ls -l\" in "+recovery.LOCAL_BACKUP_LISTING+". If not set, read from --bucket.")
+	gsBucket     = flag.String("bucket", "skia-task-scheduler", "GCS bucket to read.")
+	out          = flag.String("out", "", "File to write the restored DB. Must not exist.")
+	restoreTime  = flag.String("time", "", "RFC3339 timestamp at which to restore the DB. Defaults to now.")
+)
+
+func main() {
+	defer common.LogPanic()
+
+	// Global init.
+	common.Init()
+
+	if *out == "" {
+		sklog.Fatal("--out is required.")
+	}
+	ts := time.Now()
+	if *restoreTime != "" {
+		var err error
+		ts, err = time.Parse(time.RFC3339, *restoreTime)
+		if err != nil {
+			sklog.Fatalf("Invalid --time: %s", err)
+		}
+	}
+
+	var src recovery.BackupSource
+	if *dir != "" {
+		var err error
+		src, err = recovery.NewLocalBackupSource(*dir)
+		if err != nil {
+			sklog.Fatal(err)
+		}
+	} else {
+		// Authenticated HTTP client.
+		httpClient, err := auth.NewClient(true, "", auth.SCOPE_READ_ONLY)
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		ctx := context.Background()
+		gsClient, err := storage.NewClient(ctx, option.WithHTTPClient(httpClient))
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		src = recovery.NewGCSBackupSource(ctx, gsClient, *gsBucket)
+	}
+
+	res, err := recovery.Restore(src, ts, *out)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	sklog.Infof("Restored %s as of %s from %s (written %s) and %d Job backups.", *out, ts, res.Backup, res.BackupTime, res.Jobs)
+	if len(res.MissingTasks) > 0 {
+		ids := make([]string, 0, len(res.MissingTasks))
+		for id, _ := range res.MissingTasks {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			sklog.Warningf("Job %s refers to missing Tasks %v", id, res.MissingTasks[id])
+		}
+		if !*allowMissing {
+			sklog.Errorf("%d restored Jobs refer to missing Tasks.", len(res.MissingTasks))
+			sklog.Flush()
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+// Implementation of restoring a DB from backups written by DBBackup.
+package recovery
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/db/local_db"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// BackupSource provides read access to the backups written by DBBackup. Object
+// names are as written by DBBackup, eg. "db-backup/2017/01/02/name.bdb".
+type BackupSource interface {
+	// List calls fn for each object whose name begins with prefix, along
+	// with the time the object was last written.
+	List(prefix string, fn func(name string, updated time.Time) error) error
+	// Open returns the uncompressed content of the given object.
+	Open(name string) (io.ReadCloser, error)
+}
+
+// gsBackupSource implements BackupSource for a GCS bucket.
+type gsBackupSource struct {
+	bucket   *storage.BucketHandle
+	ctx      context.Context
+	gsBucket string
+}
+
+// NewGCSBackupSource returns a BackupSource which reads from the given GCS
+// bucket.
+func NewGCSBackupSource(ctx context.Context, gsClient *storage.Client, gsBucket string) BackupSource {
+	return &gsBackupSource{
+		bucket:   gsClient.Bucket(gsBucket),
+		ctx:      ctx,
+		gsBucket: gsBucket,
+	}
+}
+
+// See documentation for BackupSource.
+func (s *gsBackupSource) List(prefix string, fn func(string, time.Time) error) error {
+	q := &storage.Query{Prefix: prefix, Versions: false}
+	it := s.bucket.Objects(s.ctx, q)
+	for obj, err := it.Next(); err != iterator.Done; obj, err = it.Next() {
+		if err != nil {
+			return fmt.Errorf("Unable to list %s/%s: %s", s.gsBucket, prefix, err)
+		}
+		if err := fn(obj.Name, obj.Updated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// See documentation for BackupSource.
+func (s *gsBackupSource) Open(name string) (io.ReadCloser, error) {
+	// GCS transparently decompresses objects written by upload. See
+	// downloadGOB.
+	return s.bucket.Object(name).NewReader(s.ctx)
+}
+
+// LOCAL_BACKUP_LISTING is the name of the file in the directory of a
+// localBackupSource which lists the time each object was written to GCS.
+const LOCAL_BACKUP_LISTING = "gsutil_ls.txt"
+
+// localBackupSource implements BackupSource for a local directory with the same
+// layout as the GCS bucket, eg. as copied by "gsutil cp -r".
+type localBackupSource struct {
+	dir string
+	// updated maps object name to the time the object was written to GCS.
+	updated map[string]time.Time
+}
+
+// NewLocalBackupSource returns a BackupSource which reads from the given
+// directory. Files may be gzip-compressed or not.
+//
+// Copying objects, eg. with "gsutil cp -r", sets the modification time of every
+// file to the time of the copy, so the times at which the objects were written
+// are read from the file LOCAL_BACKUP_LISTING in dir instead. It must contain
+// the output of "gsutil ls -l" for the copied objects, eg.
+//
+//	gsutil -m cp -r gs://skia-task-scheduler/db-backup gs://skia-task-scheduler/job-backup $DIR
+//	gsutil ls -l -r gs://skia-task-scheduler/db-backup gs://skia-task-scheduler/job-backup > $DIR/gsutil_ls.txt
+//
+// Objects which are not listed are ignored.
+func NewLocalBackupSource(dir string) (BackupSource, error) {
+	f, err := os.Open(filepath.Join(dir, LOCAL_BACKUP_LISTING))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the object listing: %s", err)
+	}
+	defer util.Close(f)
+	updated, err := parseListing(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", f.Name(), err)
+	}
+	return &localBackupSource{
+		dir:     dir,
+		updated: updated,
+	}, nil
+}
+
+// parseListing parses the output of "gsutil ls -l" and returns a map from
+// object name to the time the object was written. Lines which do not describe
+// an object, eg. directories and the total, are ignored.
+func parseListing(r io.Reader) (map[string]time.Time, error) {
+	rv := map[string]time.Time{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Each object is listed as "<size>  <time>  gs://<bucket>/<name>".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "gs://") {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(strings.TrimPrefix(fields[2], "gs://"), "/", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid object URL %q", fields[2])
+		}
+		rv[parts[1]] = ts
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// See documentation for BackupSource.
+func (s *localBackupSource) List(prefix string, fn func(string, time.Time) error) error {
+	// Walk the directory containing prefix, since prefix may end partway
+	// through a filename.
+	walkDir := filepath.Join(s.dir, filepath.FromSlash(path.Dir(prefix+"x")))
+	if _, err := os.Stat(walkDir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(walkDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		updated, ok := s.updated[name]
+		if !ok {
+			sklog.Warningf("Ignoring %s, which is missing from %s.", name, LOCAL_BACKUP_LISTING)
+			return nil
+		}
+		return fn(name, updated)
+	})
+}
+
+// gzipReadCloser closes both the gzip.Reader and the underlying file.
+type gzipReadCloser struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+// See documentation for io.Closer.
+func (r *gzipReadCloser) Close() error {
+	err := r.Reader.Close()
+	if err2 := r.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// bufferedReadCloser allows peeking at the beginning of a file.
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// See documentation for BackupSource.
+func (s *localBackupSource) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzR, err := gzip.NewReader(r)
+		if err != nil {
+			util.Close(f)
+			return nil, err
+		}
+		return &gzipReadCloser{gzR, f}, nil
+	}
+	return &bufferedReadCloser{r, f}, nil
+}
+
+// RestoreResult describes a DB restored by Restore.
+type RestoreResult struct {
+	// Backup is the name of the full DB backup that was restored.
+	Backup string
+	// BackupTime is the time at which the full DB backup was written.
+	BackupTime time.Time
+	// Jobs is the number of incremental Job backups applied to the DB.
+	Jobs int
+	// MissingTasks maps Job ID to the IDs of Tasks referenced by the Job
+	// which do not exist in the restored DB.
+	MissingTasks map[string][]string
+}
+
+// findDBBackup returns the name and time of the most recent full DB backup
+// written at or before ts.
+func findDBBackup(src BackupSource, ts time.Time) (string, time.Time, error) {
+	name := ""
+	updated := time.Time{}
+	if err := src.List(DB_BACKUP_DIR+"/", func(n string, u time.Time) error {
+		if !strings.HasSuffix(n, "."+DB_FILE_NAME_EXTENSION) || u.After(ts) {
+			return nil
+		}
+		if name == "" || u.After(updated) {
+			name = n
+			updated = u
+		}
+		return nil
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	if name == "" {
+		return "", time.Time{}, fmt.Errorf("No DB backup found at or before %s.", ts)
+	}
+	return name, updated, nil
+}
+
+// download writes the content of the given object to the given file.
+func download(src BackupSource, name, filename string) (rv error) {
+	r, err := src.Open(name)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", name, err)
+	}
+	defer util.Close(r)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && rv == nil {
+			rv = err
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// retrieveJobsFromSource returns the most recent backup of each Job written
+// after since and at or before until, as a map[Job.Id]*Job.
+func retrieveJobsFromSource(src BackupSource, since, until time.Time) (map[string]*db.Job, error) {
+	sinceDir := path.Dir(formatJobObjectName(since, "dummy")) + "/"
+	names := map[string]string{}
+	times := map[string]time.Time{}
+	for t := until; ; t = t.Add(-24 * time.Hour) {
+		curDir := path.Dir(formatJobObjectName(t, "dummy")) + "/"
+		if curDir < sinceDir {
+			break
+		}
+		if err := src.List(curDir, func(name string, updated time.Time) error {
+			if !updated.After(since) || updated.After(until) {
+				return nil
+			}
+			id := parseIdFromJobObjectName(name)
+			if prev, ok := times[id]; !ok || updated.After(prev) {
+				names[id] = name
+				times[id] = updated
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	rv := make(map[string]*db.Job, len(names))
+	for id, name := range names {
+		r, err := src.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %s", name, err)
+		}
+		var job db.Job
+		err = gob.NewDecoder(r).Decode(&job)
+		util.Close(r)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding GOB data from %s: %s", name, err)
+		}
+		rv[id] = &job
+	}
+	return rv, nil
+}
+
+// applyJobs writes the given Jobs to d, replacing any older versions.
+func applyJobs(d db.JobDB, jobs map[string]*db.Job) (int, error) {
+	toPut := make([]*db.Job, 0, len(jobs))
+	for _, job := range jobs {
+		existing, err := d.GetJobById(job.Id)
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			if !job.DbModified.After(existing.DbModified) {
+				// The full backup already contains this version.
+				continue
+			}
+			// Satisfy PutJobs' check for concurrent updates.
+			job.DbModified = existing.DbModified
+		} else {
+			job.DbModified = time.Time{}
+		}
+		toPut = append(toPut, job)
+	}
+	sort.Sort(db.JobSlice(toPut))
+	if err := d.PutJobs(toPut); err != nil {
+		return 0, err
+	}
+	return len(toPut), nil
+}
+
+// findMissingTasks returns the IDs of Tasks referenced by Jobs created before
+// end which do not exist in d, keyed by Job ID.
+func findMissingTasks(d db.DB, end time.Time) (map[string][]string, error) {
+	jobs, err := d.GetJobsFromDateRange(time.Unix(0, 0), end)
+	if err != nil {
+		return nil, err
+	}
+	rv := map[string][]string{}
+	for _, job := range jobs {
+		for _, summaries := range job.Tasks {
+			for _, s := range summaries {
+				task, err := d.GetTaskById(s.Id)
+				if err != nil {
+					return nil, err
+				}
+				if task == nil {
+					rv[job.Id] = append(rv[job.Id], s.Id)
+				}
+			}
+		}
+	}
+	for _, ids := range rv {
+		sort.Strings(ids)
+	}
+	return rv, nil
+}
+
+// Restore writes a new local_db to dbFile containing the state of the DB as of
+// ts, by restoring the most recent full DB backup written at or before ts and
+// applying incremental Job backups written between that backup and ts. dbFile
+// must not already exist.
+//
+// Tasks are not backed up incrementally, so Tasks modified after the full DB
+// backup are restored as of the full backup. Incremental Job backups are
+// overwritten when a Job is modified more than once per day, so a Job may be
+// restored as of the full backup if it was modified again later that day. The
+// returned RestoreResult lists any Jobs which refer to Tasks missing from the
+// restored DB.
+//
+// The DB is restored to a temporary file which is renamed to dbFile on success,
+// so dbFile is not created if Restore fails.
+func Restore(src BackupSource, ts time.Time, dbFile string) (*RestoreResult, error) {
+	if _, err := os.Stat(dbFile); err == nil {
+		return nil, fmt.Errorf("%s already exists; refusing to overwrite.", dbFile)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dbFile), filepath.Base(dbFile)+".")
+	if err != nil {
+		return nil, err
+	}
+	tmpFile := f.Name()
+	util.Close(f)
+	res, err := restore(src, ts, tmpFile)
+	if err == nil {
+		err = os.Rename(tmpFile, dbFile)
+	}
+	if err != nil {
+		if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+			sklog.Errorf("Failed to remove %s: %s", tmpFile, err)
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// restore implements Restore, writing the restored DB to dbFile.
+func restore(src BackupSource, ts time.Time, dbFile string) (rv *RestoreResult, rvErr error) {
+	name, updated, err := findDBBackup(src, ts)
+	if err != nil {
+		return nil, err
+	}
+	sklog.Infof("Restoring DB backup %s written at %s.", name, updated)
+	if err := download(src, name, dbFile); err != nil {
+		return nil, err
+	}
+	d, err := local_db.NewDB("restore", dbFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := d.Close(); err != nil && rvErr == nil {
+			rv = nil
+			rvErr = err
+		}
+	}()
+
+	since, err := d.GetIncrementalBackupTime()
+	if err != nil {
+		return nil, err
+	}
+	if util.TimeIsZero(since) {
+		sklog.Warningf("DB backup has no incremental backup time; applying Job backups since %s.", updated)
+		since = updated
+	}
+	jobs, err := retrieveJobsFromSource(src, since, ts)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := applyJobs(d, jobs)
+	if err != nil {
+		return nil, err
+	}
+	sklog.Infof("Applied %d incremental Job backups written between %s and %s.", applied, since, ts)
+	if err := d.SetIncrementalBackupTime(ts); err != nil {
+		return nil, err
+	}
+
+	missing, err := findMissingTasks(d, ts.Add(time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	return &RestoreResult{
+		Backup:       name,
+		BackupTime:   updated,
+		Jobs:         applied,
+		MissingTasks: missing,
+	}, nil
+}
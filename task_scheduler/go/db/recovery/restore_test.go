@@ -0,0 +1,237 @@
+package recovery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/db/local_db"
+)
+
+// writeLocalObject writes the given content to the given object in dir,
+// optionally gzipped, and adds it to the LOCAL_BACKUP_LISTING in dir with the
+// given write time, like "gsutil ls -l" does.
+func writeLocalObject(t *testing.T, dir, name string, content []byte, gz bool, updated time.Time) {
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	assert.NoError(t, os.MkdirAll(path.Dir(filename), os.ModePerm))
+	if gz {
+		buf := bytes.Buffer{}
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(content)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		content = buf.Bytes()
+	}
+	assert.NoError(t, ioutil.WriteFile(filename, content, 0644))
+
+	listing, err := os.OpenFile(filepath.Join(dir, LOCAL_BACKUP_LISTING), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, err = fmt.Fprintf(listing, "%10d  %s  gs://bucket/%s\n", len(content), updated.UTC().Format(time.RFC3339), name)
+	assert.NoError(t, err)
+	assert.NoError(t, listing.Close())
+}
+
+func TestParseListing(t *testing.T) {
+	testutils.SmallTest(t)
+	listing := `gs://bucket/db-backup/2017/01/02/:
+      1234  2017-01-02T03:04:05Z  gs://bucket/db-backup/2017/01/02/task-scheduler.bdb
+        56  2017-01-02T04:05:06Z  gs://bucket/job-backup/2017/01/02/abc.gob
+TOTAL: 2 objects, 1290 bytes (1.26 KiB)
+`
+	updated, err := parseListing(strings.NewReader(listing))
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, map[string]time.Time{
+		"db-backup/2017/01/02/task-scheduler.bdb": time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+		"job-backup/2017/01/02/abc.gob":           time.Date(2017, 1, 2, 4, 5, 6, 0, time.UTC),
+	}, updated)
+
+	_, err = parseListing(strings.NewReader("1234  yesterday  gs://bucket/a.bdb\n"))
+	assert.Error(t, err)
+	_, err = parseListing(strings.NewReader("1234  2017-01-02T03:04:05Z  gs://bucket\n"))
+	assert.Error(t, err)
+}
+
+func TestLocalBackupSource(t *testing.T) {
+	testutils.MediumTest(t)
+	dir, err := ioutil.TempDir("", "TestLocalBackupSource")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, dir)
+
+	// A directory without a listing is rejected.
+	_, err = NewLocalBackupSource(dir)
+	assert.Error(t, err)
+
+	ts := time.Unix(1480000000, 0)
+	writeLocalObject(t, dir, "a/b/plain.txt", []byte("plain"), false, ts)
+	writeLocalObject(t, dir, "a/b/gz.txt", []byte("zipped"), true, ts.Add(time.Minute))
+	writeLocalObject(t, dir, "a/c.txt", []byte("other"), false, ts)
+	// Objects missing from the listing are ignored.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "b", "unlisted.txt"), []byte("unlisted"), 0644))
+
+	src, err := NewLocalBackupSource(dir)
+	assert.NoError(t, err)
+	found := map[string]time.Time{}
+	assert.NoError(t, src.List("a/b/", func(name string, updated time.Time) error {
+		found[name] = updated
+		return nil
+	}))
+	assert.Equal(t, 2, len(found))
+	assert.True(t, ts.Equal(found["a/b/plain.txt"]))
+	assert.True(t, ts.Add(time.Minute).Equal(found["a/b/gz.txt"]))
+
+	// Missing directories are empty.
+	assert.NoError(t, src.List("x/", func(name string, updated time.Time) error {
+		t.Fatalf("Unexpected object %s", name)
+		return nil
+	}))
+
+	for name, expect := range map[string]string{
+		"a/b/plain.txt": "plain",
+		"a/b/gz.txt":    "zipped",
+	} {
+		r, err := src.Open(name)
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, expect, string(content))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	testutils.MediumTest(t)
+	tmp, err := ioutil.TempDir("", "TestRestore")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	srcDir := path.Join(tmp, "backups")
+
+	// Create a DB containing one Task and one Job.
+	orig, err := local_db.NewDB("orig", path.Join(tmp, "orig.bdb"))
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, orig)
+	// "gsutil ls -l" lists times with a precision of one second.
+	now := time.Now().UTC().Truncate(time.Second)
+	t1 := &db.Task{
+		Created: now,
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     db.DEFAULT_TEST_REPO,
+				Revision: "abc123",
+			},
+			Name: "Test-Task",
+		},
+	}
+	assert.NoError(t, orig.PutTask(t1))
+	j1 := makeJob(now)
+	j1.Tasks["Test-Task"] = []*db.TaskSummary{{Id: t1.Id}}
+	assert.NoError(t, orig.PutJob(j1))
+	assert.NoError(t, orig.SetIncrementalBackupTime(now))
+
+	// Full backup.
+	buf := bytes.Buffer{}
+	assert.NoError(t, orig.WriteBackup(&buf))
+	backupTime := now.Add(time.Minute)
+	writeLocalObject(t, srcDir, "db-backup/2017/01/02/task-scheduler.bdb", buf.Bytes(), true, backupTime)
+
+	// Incremental Job backups.
+	writeJob := func(job *db.Job, ts time.Time) {
+		buf := bytes.Buffer{}
+		assert.NoError(t, gob.NewEncoder(&buf).Encode(job))
+		writeLocalObject(t, srcDir, formatJobObjectName(ts, job.Id), buf.Bytes(), true, ts)
+	}
+	j1.Status = db.JOB_STATUS_SUCCESS
+	assert.NoError(t, orig.PutJob(j1))
+	writeJob(j1, now.Add(2*time.Minute))
+	j2 := makeJob(now)
+	j2.Tasks["Test-Task"] = []*db.TaskSummary{{Id: "bogus"}}
+	assert.NoError(t, orig.PutJob(j2))
+	writeJob(j2, now.Add(3*time.Minute))
+
+	src, err := NewLocalBackupSource(srcDir)
+	assert.NoError(t, err)
+
+	// No backup before the requested time.
+	_, err = Restore(src, now, path.Join(tmp, "none.bdb"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No DB backup found")
+	assertNoFiles(t, tmp, "none.bdb")
+
+	// Restore after the first incremental backup.
+	out := path.Join(tmp, "restore1.bdb")
+	res, err := Restore(src, now.Add(150*time.Second), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "db-backup/2017/01/02/task-scheduler.bdb", res.Backup)
+	assert.True(t, backupTime.Equal(res.BackupTime))
+	assert.Equal(t, 1, res.Jobs)
+	assert.Equal(t, 0, len(res.MissingTasks))
+	d, err := local_db.NewDB("restore1", out)
+	assert.NoError(t, err)
+	job, err := d.GetJobById(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, job.Status)
+	job, err = d.GetJobById(j2.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+	task, err := d.GetTaskById(t1.Id)
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+	testutils.AssertCloses(t, d)
+
+	// Won't overwrite an existing file.
+	_, err = Restore(src, now.Add(150*time.Second), out)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to overwrite")
+
+	// Restore after both incremental backups. j2 refers to a missing Task.
+	out = path.Join(tmp, "restore2.bdb")
+	res, err = Restore(src, now.Add(5*time.Minute), out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Jobs)
+	testutils.AssertDeepEqual(t, map[string][]string{j2.Id: {"bogus"}}, res.MissingTasks)
+	d, err = local_db.NewDB("restore2", out)
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, d)
+	job, err = d.GetJobById(j2.Id)
+	assert.NoError(t, err)
+	assert.NotNil(t, job)
+	ts, err := d.GetIncrementalBackupTime()
+	assert.NoError(t, err)
+	assert.True(t, now.Add(5*time.Minute).Equal(ts))
+}
+
+// assertNoFiles asserts that dir contains no files whose names begin with
+// prefix.
+func assertNoFiles(t *testing.T, dir, prefix string) {
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(matches), "Unexpected files: %v", matches)
+}
+
+func TestRestoreCorruptBackup(t *testing.T) {
+	testutils.MediumTest(t)
+	tmp, err := ioutil.TempDir("", "TestRestoreCorruptBackup")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	srcDir := path.Join(tmp, "backups")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	writeLocalObject(t, srcDir, "db-backup/2017/01/02/task-scheduler.bdb", []byte("not a DB"), true, now)
+	src, err := NewLocalBackupSource(srcDir)
+	assert.NoError(t, err)
+
+	// The partially restored DB is removed.
+	_, err = Restore(src, now, path.Join(tmp, "restore.bdb"))
+	assert.Error(t, err)
+	assertNoFiles(t, tmp, "restore.bdb")
+}
@@ -44,7 +44,9 @@ const (
 
 // server translates HTTP requests to method calls on d.
 type server struct {
-	d db.RemoteDB
+	d        db.RemoteDB
+	jobFeed  *feed
+	taskFeed *feed
 }
 
 // RegisterServer adds handlers to r that handle requests from a client created
@@ -52,9 +54,14 @@ type server struct {
 //
 // Currently no authentication is required, so r should not be exposed on a
 // public port.
+//
+// The streaming endpoints require d to implement GetModifiedTasksGOB and
+// GetModifiedJobsGOB.
 func RegisterServer(d db.RemoteDB, r *mux.Router) error {
 	s := &server{
-		d: d,
+		d:        d,
+		jobFeed:  newFeed("jobs", d.StartTrackingModifiedJobs, d.GetModifiedJobsGOB),
+		taskFeed: newFeed("tasks", d.StartTrackingModifiedTasks, d.GetModifiedTasksGOB),
 	}
 	s.registerHandlers(r)
 	return nil
@@ -65,10 +72,12 @@ func (s *server) registerHandlers(r *mux.Router) {
 	r.HandleFunc("/"+MODIFIED_TASKS_PATH, s.PostModifiedTasksHandler).Methods(http.MethodPost)
 	r.HandleFunc("/"+MODIFIED_TASKS_PATH, s.DeleteModifiedTasksHandler).Methods(http.MethodDelete)
 	r.HandleFunc("/"+MODIFIED_TASKS_PATH, s.GetModifiedTasksHandler).Methods(http.MethodGet)
+	r.HandleFunc("/"+STREAM_TASKS_PATH, s.GetStreamTasksHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+TASKS_PATH, s.GetTasksHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+MODIFIED_JOBS_PATH, s.PostModifiedJobsHandler).Methods(http.MethodPost)
 	r.HandleFunc("/"+MODIFIED_JOBS_PATH, s.DeleteModifiedJobsHandler).Methods(http.MethodDelete)
 	r.HandleFunc("/"+MODIFIED_JOBS_PATH, s.GetModifiedJobsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/"+STREAM_JOBS_PATH, s.GetStreamJobsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+JOBS_PATH, s.GetJobsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+COMMENTS_PATH, s.GetCommentsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+TASK_COMMENTS_PATH, s.PostTaskCommentsHandler).Methods(http.MethodPost)
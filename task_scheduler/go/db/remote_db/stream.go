@@ -0,0 +1,547 @@
+package remote_db
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+/*
+	Streaming change feed for Tasks and Jobs.
+
+	The server tracks modified entries in the underlying DB using a single
+	tracking ID per feed, regardless of the number of clients, and assigns each
+	modification a sequence number. Clients hold open a GET request to the
+	stream path; the response is a GOB stream consisting of a StreamHeader
+	followed by StreamEntries, which are written as modifications occur. If the
+	connection is lost, the client reconnects and passes the stream ID and the
+	last sequence number it received in order to resume without missing any
+	modifications. If the server can no longer resume from that point, it
+	responds with ERR_UNKNOWN_ID_CODE, and the client starts a new stream and
+	removes its own subscribers so that they know to reload their data.
+*/
+
+const (
+	// Server handles streaming requests on these paths.
+	STREAM_TASKS_PATH = "modified-tasks/stream"
+	STREAM_JOBS_PATH  = "modified-jobs/stream"
+
+	// STREAM_BUFFER_SIZE is the number of modifications retained by the
+	// server, from which clients may resume.
+	STREAM_BUFFER_SIZE = 10000
+
+	// STREAM_HEARTBEAT_PERIOD is how often the server writes an entry to an
+	// idle stream. The client closes the connection if it does not receive
+	// anything for twice this period.
+	STREAM_HEARTBEAT_PERIOD = 30 * time.Second
+
+	// STREAM_RETRY_PERIOD is how long the client waits before reconnecting
+	// after a failed connection attempt.
+	STREAM_RETRY_PERIOD = 5 * time.Second
+)
+
+var (
+	// streamPollPeriod is how often the server checks the underlying DB for
+	// modifications. Variable for testing.
+	streamPollPeriod = time.Second
+)
+
+// StreamHeader is the first object written to a stream.
+type StreamHeader struct {
+	// StreamId identifies the sequence of modifications. Sequence numbers
+	// are only meaningful within a single stream.
+	StreamId string
+	// Seq is the sequence number of the last modification which will NOT
+	// be included in the stream.
+	Seq int64
+}
+
+// StreamEntry is a single modification written to a stream.
+type StreamEntry struct {
+	// Seq is the sequence number of this modification.
+	Seq int64
+	// Id is the ID of the modified Task or Job. Empty for heartbeats.
+	Id string
+	// Data is the GOB-encoded Task or Job. Empty for heartbeats.
+	Data []byte
+}
+
+// feed is the server side of a stream of modifications to either Tasks or
+// Jobs.
+type feed struct {
+	kind    string
+	startFn func() (string, error)
+	getFn   func(string) (map[string][]byte, error)
+	size    int
+
+	// Protects all of the below.
+	mtx sync.Mutex
+	// entries contains the most recent modifications, in order. The Seq of
+	// entries[i] is minSeq + i + 1.
+	entries []*StreamEntry
+	// id is the ID returned by startFn.
+	id string
+	// minSeq is the oldest sequence number from which a client may resume.
+	minSeq int64
+	// notify is closed and replaced when entries are added or the feed is
+	// restarted.
+	notify  chan struct{}
+	running bool
+	seq     int64
+	// streamId changes whenever modifications may have been missed.
+	streamId string
+}
+
+// newFeed returns a feed which uses the given functions, which are
+// StartTrackingModified(Tasks|Jobs) and GetModified(Tasks|Jobs)GOB.
+func newFeed(kind string, startFn func() (string, error), getFn func(string) (map[string][]byte, error)) *feed {
+	return &feed{
+		kind:    kind,
+		startFn: startFn,
+		getFn:   getFn,
+		size:    STREAM_BUFFER_SIZE,
+		notify:  make(chan struct{}),
+	}
+}
+
+// wake notifies streams that the feed has changed. Assumes the caller holds
+// f.mtx.
+func (f *feed) wake() {
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// restart begins tracking modifications in the DB, discarding any existing
+// entries. Assumes the caller holds f.mtx.
+func (f *feed) restart() error {
+	id, err := f.startFn()
+	if err != nil {
+		return err
+	}
+	f.id = id
+	f.entries = nil
+	f.minSeq = f.seq
+	f.streamId = uuid.NewV5(uuid.NewV1(), uuid.NewV4().String()).String()
+	f.wake()
+	return nil
+}
+
+// start begins polling the DB for modifications, if not already started. The
+// feed is started lazily, so that servers without streaming clients do not use
+// one of the DB's tracking IDs.
+func (f *feed) start() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.running {
+		return nil
+	}
+	if err := f.restart(); err != nil {
+		return err
+	}
+	f.running = true
+	go func() {
+		for _ = range time.Tick(streamPollPeriod) {
+			if err := f.update(); err != nil {
+				sklog.Errorf("Failed to update %s stream: %s", f.kind, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// update adds any modifications in the DB since the last call to the feed.
+func (f *feed) update() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	gobs, err := f.getFn(f.id)
+	if db.IsUnknownId(err) {
+		sklog.Warningf("Lost tracking of modified %s; restarting %s stream.", f.kind, f.kind)
+		return f.restart()
+	} else if err != nil {
+		return err
+	}
+	if len(gobs) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(gobs))
+	for id, _ := range gobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		f.seq++
+		f.entries = append(f.entries, &StreamEntry{
+			Seq:  f.seq,
+			Id:   id,
+			Data: gobs[id],
+		})
+	}
+	if len(f.entries) > f.size {
+		drop := len(f.entries) - f.size
+		f.minSeq = f.entries[drop-1].Seq
+		f.entries = append([]*StreamEntry{}, f.entries[drop:]...)
+	}
+	f.wake()
+	return nil
+}
+
+// current returns the current stream ID and sequence number.
+func (f *feed) current() (string, int64) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.streamId, f.seq
+}
+
+// since returns the entries in the given stream after the given sequence
+// number, along with a channel which is closed when the feed changes. Returns
+// db.ErrUnknownId if it is not possible to resume from that point.
+func (f *feed) since(streamId string, seq int64) ([]*StreamEntry, <-chan struct{}, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if streamId != f.streamId || seq < f.minSeq || seq > f.seq {
+		return nil, nil, db.ErrUnknownId
+	}
+	return f.entries[seq-f.minSeq:], f.notify, nil
+}
+
+// streamHandler writes modifications to the response as they occur.
+//   - format: must be "gob"; default "gob"
+//   - stream, seq (optional): StreamHeader.StreamId and the Seq of the last
+//     StreamEntry received; if not provided, a new stream is started.
+// Response is GOB stream; first object is a StreamHeader, the remaining
+// objects are StreamEntries. Entries with empty Id are heartbeats.
+func (f *feed) streamHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "gob" {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unsupported format %q", format))
+		return
+	}
+	if err := f.start(); err != nil {
+		reportDBError(w, r, err, fmt.Sprintf("Unable to start tracking %s", f.kind))
+		return
+	}
+	streamId, seq := f.current()
+	if r.URL.Query().Get("stream") != "" {
+		streamId = r.URL.Query().Get("stream")
+		seqStr := r.URL.Query().Get("seq")
+		var err error
+		seq, err = strconv.ParseInt(seqStr, 10, 64)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid seq param %q", seqStr))
+			return
+		}
+	}
+	entries, notify, err := f.since(streamId, seq)
+	if err != nil {
+		reportDBError(w, r, err, "Unable to resume stream")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gob")
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&StreamHeader{StreamId: streamId, Seq: seq}); err != nil {
+		httputils.ReportError(w, r, err, "Unable to encode stream header")
+		return
+	}
+	flush(w)
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+	heartbeat := time.NewTicker(STREAM_HEARTBEAT_PERIOD)
+	defer heartbeat.Stop()
+	for {
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				sklog.Warningf("Unable to write %s stream entry: %s", f.kind, err)
+				return
+			}
+			seq = e.Seq
+		}
+		flush(w)
+		select {
+		case <-notify:
+		case <-heartbeat.C:
+			if err := enc.Encode(&StreamEntry{Seq: seq}); err != nil {
+				sklog.Warningf("Unable to write %s stream heartbeat: %s", f.kind, err)
+				return
+			}
+			flush(w)
+		case <-closed:
+			return
+		}
+		entries, notify, err = f.since(streamId, seq)
+		if err != nil {
+			// The feed was restarted. End the stream; the client will
+			// find out when it tries to resume.
+			return
+		}
+	}
+}
+
+// GetStreamTasksHandler streams modified Tasks. See feed.streamHandler.
+func (s *server) GetStreamTasksHandler(w http.ResponseWriter, r *http.Request) {
+	s.taskFeed.streamHandler(w, r)
+}
+
+// GetStreamJobsHandler streams modified Jobs. See feed.streamHandler.
+func (s *server) GetStreamJobsHandler(w http.ResponseWriter, r *http.Request) {
+	s.jobFeed.streamHandler(w, r)
+}
+
+// stream is the client side of a feed.
+type stream struct {
+	client *http.Client
+	url    string
+	// track is called with each batch of modified entries.
+	track func(map[string][]byte)
+	// reset is called when modifications may have been missed.
+	reset func()
+
+	// Protects started. streamId and seq are only accessed in
+	// ensureStarted while holding mtx and afterward by run.
+	mtx      sync.Mutex
+	started  bool
+	streamId string
+	seq      int64
+}
+
+// newStream returns a stream which reads from the given URL.
+func newStream(url string, track func(map[string][]byte), reset func()) *stream {
+	return &stream{
+		// The default timeout client would end the stream after
+		// REQUEST_TIMEOUT.
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: httputils.DialTimeout,
+			},
+		},
+		url:   url,
+		track: track,
+		reset: reset,
+	}
+}
+
+// ensureStarted connects to the server if not already connected. Returns after
+// the initial connection is established, so that all modifications occurring
+// after ensureStarted returns will be passed to track.
+func (s *stream) ensureStarted() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.started {
+		return nil
+	}
+	body, dec, err := s.connect(false)
+	if err != nil {
+		return err
+	}
+	s.started = true
+	go s.run(body, dec)
+	return nil
+}
+
+// connect opens the stream and reads the StreamHeader. If resume is true,
+// requests modifications since the last StreamEntry received.
+func (s *stream) connect(resume bool) (io.ReadCloser, *gob.Decoder, error) {
+	params := url.Values{}
+	params.Set("format", "gob")
+	if resume {
+		params.Set("stream", s.streamId)
+		params.Set("seq", strconv.FormatInt(s.seq, 10))
+	}
+	r, err := s.client.Get(s.url + "?" + params.Encode())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := interpretStatusCode(r); err != nil {
+		util.Close(r.Body)
+		return nil, nil, err
+	}
+	dec := gob.NewDecoder(r.Body)
+	var h StreamHeader
+	if err := dec.Decode(&h); err != nil {
+		util.Close(r.Body)
+		return nil, nil, err
+	}
+	s.streamId = h.StreamId
+	s.seq = h.Seq
+	return r.Body, dec, nil
+}
+
+// read passes entries from the stream to track until the connection is lost.
+func (s *stream) read(body io.ReadCloser, dec *gob.Decoder) error {
+	defer util.Close(body)
+	// Close the connection if the server stops sending heartbeats, which
+	// causes Decode to return an error.
+	watchdog := time.AfterFunc(2*STREAM_HEARTBEAT_PERIOD, func() {
+		if err := body.Close(); err != nil {
+			sklog.Errorf("Failed to close stream: %s", err)
+		}
+	})
+	defer watchdog.Stop()
+	for {
+		var e StreamEntry
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		watchdog.Reset(2 * STREAM_HEARTBEAT_PERIOD)
+		if e.Id != "" {
+			s.track(map[string][]byte{e.Id: e.Data})
+		}
+		s.seq = e.Seq
+	}
+}
+
+// run reads from the stream, reconnecting as needed. Never returns.
+func (s *stream) run(body io.ReadCloser, dec *gob.Decoder) {
+	for {
+		if err := s.read(body, dec); err != nil {
+			sklog.Warningf("Lost connection to %s: %s", s.url, err)
+		}
+		for {
+			var err error
+			body, dec, err = s.connect(true)
+			if err == nil {
+				break
+			}
+			if db.IsUnknownId(err) {
+				// Start a new stream before resetting, so that
+				// subscribers started after the reset do not
+				// miss anything.
+				body, dec, err = s.connect(false)
+				if err == nil {
+					sklog.Warningf("Unable to resume %s; modifications may have been missed.", s.url)
+					s.reset()
+					break
+				}
+			}
+			sklog.Errorf("Failed to connect to %s: %s", s.url, err)
+			time.Sleep(STREAM_RETRY_PERIOD)
+		}
+	}
+}
+
+// taskStreamClient implements db.TaskReader using the streaming endpoint for
+// modified Tasks.
+type taskStreamClient struct {
+	c        *client
+	modified db.ModifiedTasks
+	stream   *stream
+}
+
+// NewTaskStreamClient returns a db.TaskReader that connects to the server
+// created by RegisterServer. Rather than polling the server for modified
+// Tasks, it receives them over a single long-lived connection and tracks them
+// locally, so that its users do not count against the server's
+// db.MAX_MODIFIED_DATA_USERS. serverRoot should end with a slash.
+func NewTaskStreamClient(serverRoot string) (db.TaskReader, error) {
+	c := &taskStreamClient{
+		c: &client{
+			serverRoot: serverRoot,
+			client:     httputils.NewTimeoutClient(),
+		},
+	}
+	c.stream = newStream(serverRoot+STREAM_TASKS_PATH, c.modified.TrackModifiedTasksGOB, c.modified.StopTrackingAllModifiedTasks)
+	return c, nil
+}
+
+// See documentation for db.TaskReader.
+func (c *taskStreamClient) GetModifiedTasks(id string) ([]*db.Task, error) {
+	return c.modified.GetModifiedTasks(id)
+}
+
+// See documentation for db.TaskReader.
+func (c *taskStreamClient) GetModifiedTasksGOB(id string) (map[string][]byte, error) {
+	return c.modified.GetModifiedTasksGOB(id)
+}
+
+// See documentation for db.TaskReader.
+func (c *taskStreamClient) GetTaskById(id string) (*db.Task, error) {
+	return c.c.GetTaskById(id)
+}
+
+// See documentation for db.TaskReader.
+func (c *taskStreamClient) GetTasksFromDateRange(from time.Time, to time.Time) ([]*db.Task, error) {
+	return c.c.GetTasksFromDateRange(from, to)
+}
+
+// See documentation for db.TaskReader.
+func (c *taskStreamClient) StartTrackingModifiedTasks() (string, error) {
+	if err := c.stream.ensureStarted(); err != nil {
+		return "", err
+	}
+	return c.modified.StartTrackingModifiedTasks()
+}
+
+// See documentation for db.TaskReader.
+func (c *taskStreamClient) StopTrackingModifiedTasks(id string) {
+	c.modified.StopTrackingModifiedTasks(id)
+}
+
+// jobStreamClient implements db.JobReader using the streaming endpoint for
+// modified Jobs.
+type jobStreamClient struct {
+	c        *client
+	modified db.ModifiedJobs
+	stream   *stream
+}
+
+// NewJobStreamClient is the db.JobReader equivalent of NewTaskStreamClient.
+func NewJobStreamClient(serverRoot string) (db.JobReader, error) {
+	c := &jobStreamClient{
+		c: &client{
+			serverRoot: serverRoot,
+			client:     httputils.NewTimeoutClient(),
+		},
+	}
+	c.stream = newStream(serverRoot+STREAM_JOBS_PATH, c.modified.TrackModifiedJobsGOB, c.modified.StopTrackingAllModifiedJobs)
+	return c, nil
+}
+
+// See documentation for db.JobReader.
+func (c *jobStreamClient) GetModifiedJobs(id string) ([]*db.Job, error) {
+	return c.modified.GetModifiedJobs(id)
+}
+
+// See documentation for db.JobReader.
+func (c *jobStreamClient) GetModifiedJobsGOB(id string) (map[string][]byte, error) {
+	return c.modified.GetModifiedJobsGOB(id)
+}
+
+// See documentation for db.JobReader.
+func (c *jobStreamClient) GetJobById(id string) (*db.Job, error) {
+	return c.c.GetJobById(id)
+}
+
+// See documentation for db.JobReader.
+func (c *jobStreamClient) GetJobsFromDateRange(from time.Time, to time.Time) ([]*db.Job, error) {
+	return c.c.GetJobsFromDateRange(from, to)
+}
+
+// See documentation for db.JobReader.
+func (c *jobStreamClient) StartTrackingModifiedJobs() (string, error) {
+	if err := c.stream.ensureStarted(); err != nil {
+		return "", err
+	}
+	return c.modified.StartTrackingModifiedJobs()
+}
+
+// See documentation for db.JobReader.
+func (c *jobStreamClient) StopTrackingModifiedJobs(id string) {
+	c.modified.StopTrackingModifiedJobs(id)
+}
+
+// Compile-time asserts that the stream clients implement the reader interfaces.
+var _ db.TaskReader = &taskStreamClient{}
+var _ db.JobReader = &jobStreamClient{}
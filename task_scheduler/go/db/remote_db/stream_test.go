@@ -0,0 +1,173 @@
+package remote_db
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+func makeStreamTask(ts time.Time) *db.Task {
+	return &db.Task{
+		Created: ts,
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     db.DEFAULT_TEST_REPO,
+				Revision: "abc123",
+			},
+			Name: "Test-Task",
+		},
+	}
+}
+
+func TestFeed(t *testing.T) {
+	testutils.SmallTest(t)
+	m := &db.ModifiedTasks{}
+	f := newFeed("tasks", m.StartTrackingModifiedTasks, m.GetModifiedTasksGOB)
+	f.size = 2
+	assert.NoError(t, f.restart())
+	streamId, seq := f.current()
+	assert.Equal(t, int64(0), seq)
+
+	// No modifications yet.
+	assert.NoError(t, f.update())
+	entries, _, err := f.since(streamId, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	// Add some modifications.
+	t1 := makeStreamTask(time.Unix(0, 1470674132000000))
+	t1.Id = "1"
+	m.TrackModifiedTask(t1)
+	_, notify, err := f.since(streamId, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, f.update())
+	select {
+	case <-notify:
+	default:
+		t.Fatal("Expected notification.")
+	}
+	entries, _, err = f.since(streamId, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, int64(1), entries[0].Seq)
+	assert.Equal(t, "1", entries[0].Id)
+
+	// Resume from the latest entry.
+	entries, _, err = f.since(streamId, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	// Old entries are dropped when the buffer is full.
+	t2 := makeStreamTask(time.Unix(0, 1470674376000000))
+	t2.Id = "2"
+	t3 := makeStreamTask(time.Unix(0, 1470674884000000))
+	t3.Id = "3"
+	m.TrackModifiedTask(t2)
+	m.TrackModifiedTask(t3)
+	assert.NoError(t, f.update())
+	_, _, err = f.since(streamId, 0)
+	assert.True(t, db.IsUnknownId(err))
+	entries, _, err = f.since(streamId, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "2", entries[0].Id)
+	assert.Equal(t, "3", entries[1].Id)
+
+	// Can't resume from the future or a different stream.
+	_, _, err = f.since(streamId, 4)
+	assert.True(t, db.IsUnknownId(err))
+	_, _, err = f.since("bogus", 3)
+	assert.True(t, db.IsUnknownId(err))
+
+	// If tracking is lost, the feed restarts with a new stream.
+	m.StopTrackingAllModifiedTasks()
+	assert.NoError(t, f.update())
+	_, _, err = f.since(streamId, 3)
+	assert.True(t, db.IsUnknownId(err))
+	newStreamId, seq := f.current()
+	assert.NotEqual(t, streamId, newStreamId)
+	assert.Equal(t, int64(3), seq)
+	entries, _, err = f.since(newStreamId, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+// waitForModifiedTasks calls GetModifiedTasks until the expected number of
+// Tasks have been returned.
+func waitForModifiedTasks(t *testing.T, r db.TaskReader, id string, expect int) []*db.Task {
+	rv := []*db.Task{}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(rv) < expect && time.Now().Before(deadline) {
+		tasks, err := r.GetModifiedTasks(id)
+		assert.NoError(t, err)
+		rv = append(rv, tasks...)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expect, len(rv))
+	return rv
+}
+
+func TestTaskStreamClient(t *testing.T) {
+	testutils.MediumTest(t)
+	streamPollPeriod = 10 * time.Millisecond
+	baseDB := db.NewInMemoryDB()
+	r := mux.NewRouter()
+	assert.NoError(t, RegisterServer(baseDB, r.PathPrefix("/db").Subrouter()))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	c, err := NewTaskStreamClient(ts.URL + "/db/")
+	assert.NoError(t, err)
+	_, err = c.GetModifiedTasks("dummy-id")
+	assert.True(t, db.IsUnknownId(err))
+	id, err := c.StartTrackingModifiedTasks()
+	assert.NoError(t, err)
+	tasks, err := c.GetModifiedTasks(id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(tasks))
+
+	// Modifications are streamed to the client.
+	t1 := makeStreamTask(time.Unix(0, 1470674132000000))
+	assert.NoError(t, baseDB.PutTask(t1))
+	tasks = waitForModifiedTasks(t, c, id, 1)
+	testutils.AssertDeepEqual(t, []*db.Task{t1}, tasks)
+
+	// Other methods go directly to the server.
+	t1Again, err := c.GetTaskById(t1.Id)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, t1, t1Again)
+	tasks, err = c.GetTasksFromDateRange(t1.Created, t1.Created.Add(time.Nanosecond))
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*db.Task{t1}, tasks)
+
+	// Subscribers of the client don't count against the server's limit; the
+	// server uses a single tracking ID for all streams.
+	c2, err := NewTaskStreamClient(ts.URL + "/db/")
+	assert.NoError(t, err)
+	for i := 1; i < db.MAX_MODIFIED_DATA_USERS; i++ {
+		_, err := c.StartTrackingModifiedTasks()
+		assert.NoError(t, err)
+		_, err = c2.StartTrackingModifiedTasks()
+		assert.NoError(t, err)
+	}
+	_, err = baseDB.StartTrackingModifiedTasks()
+	assert.NoError(t, err)
+
+	// Multiple modifications.
+	t2 := makeStreamTask(time.Unix(0, 1470674376000000))
+	t3 := makeStreamTask(time.Unix(0, 1470674884000000))
+	assert.NoError(t, baseDB.PutTasks([]*db.Task{t2, t3}))
+	tasks = waitForModifiedTasks(t, c, id, 2)
+	ids := map[string]bool{tasks[0].Id: true, tasks[1].Id: true}
+	assert.True(t, ids[t2.Id])
+	assert.True(t, ids[t3.Id])
+
+	c.StopTrackingModifiedTasks(id)
+	_, err = c.GetModifiedTasks(id)
+	assert.True(t, db.IsUnknownId(err))
+}
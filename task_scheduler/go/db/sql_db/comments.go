@@ -0,0 +1,180 @@
+package sql_db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+// encodeComment returns the GOB encoding of the given comment.
+func encodeComment(c interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// putComment inserts a comment into the given table, keyed by the given
+// columns. Returns nil if an identical comment already exists, or
+// db.ErrAlreadyExists if a different comment has the same key.
+func (d *sqlDB) putComment(table string, keyCols []string, keyVals []interface{}, c interface{}) error {
+	data, err := encodeComment(c)
+	if err != nil {
+		return err
+	}
+	where := strings.Join(keyCols, " = ? AND ") + " = ?"
+	return d.tx(func(tx *sql.Tx) error {
+		var existing []byte
+		err := tx.QueryRow(fmt.Sprintf(`SELECT data FROM %s WHERE %s`, table, where), keyVals...).Scan(&existing)
+		if err == nil {
+			if bytes.Equal(existing, data) {
+				return nil
+			}
+			return db.ErrAlreadyExists
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		cols := append(util.CopyStringSlice(keyCols), "data")
+		vals := append(append([]interface{}{}, keyVals...), data)
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, table, strings.Join(cols, ", "), placeholders), vals...)
+		return err
+	})
+}
+
+// deleteComment deletes the comment with the given key from the given table,
+// if it exists.
+func (d *sqlDB) deleteComment(table string, keyCols []string, keyVals []interface{}) error {
+	where := strings.Join(keyCols, " = ? AND ") + " = ?"
+	_, err := d.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s`, table, where), keyVals...)
+	return err
+}
+
+// queryComments runs the given query and calls fn with the data column of
+// each resulting row.
+func (d *sqlDB) queryComments(fn func([]byte) error, query string, args ...interface{}) error {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer util.Close(rows)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// See documentation for CommentDB.GetCommentsForRepos.
+func (d *sqlDB) GetCommentsForRepos(repos []string, from time.Time) ([]*db.RepoComments, error) {
+	rv := make([]*db.RepoComments, 0, len(repos))
+	for _, repo := range repos {
+		rc := &db.RepoComments{Repo: repo}
+		if err := d.queryComments(func(data []byte) error {
+			var c db.TaskComment
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+				return err
+			}
+			if rc.TaskComments == nil {
+				rc.TaskComments = map[string]map[string][]*db.TaskComment{}
+			}
+			nameMap, ok := rc.TaskComments[c.Revision]
+			if !ok {
+				nameMap = map[string][]*db.TaskComment{}
+				rc.TaskComments[c.Revision] = nameMap
+			}
+			nameMap[c.Name] = append(nameMap[c.Name], &c)
+			return nil
+		}, `SELECT data FROM task_comments WHERE repo = ? AND ts >= ? ORDER BY ts`, repo, from.UnixNano()); err != nil {
+			return nil, err
+		}
+		if err := d.queryComments(func(data []byte) error {
+			var c db.TaskSpecComment
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+				return err
+			}
+			if rc.TaskSpecComments == nil {
+				rc.TaskSpecComments = map[string][]*db.TaskSpecComment{}
+			}
+			rc.TaskSpecComments[c.Name] = append(rc.TaskSpecComments[c.Name], &c)
+			return nil
+		}, `SELECT data FROM task_spec_comments WHERE repo = ? ORDER BY ts`, repo); err != nil {
+			return nil, err
+		}
+		if err := d.queryComments(func(data []byte) error {
+			var c db.CommitComment
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+				return err
+			}
+			if rc.CommitComments == nil {
+				rc.CommitComments = map[string][]*db.CommitComment{}
+			}
+			rc.CommitComments[c.Revision] = append(rc.CommitComments[c.Revision], &c)
+			return nil
+		}, `SELECT data FROM commit_comments WHERE repo = ? AND ts >= ? ORDER BY ts`, repo, from.UnixNano()); err != nil {
+			return nil, err
+		}
+		rv = append(rv, rc)
+	}
+	return rv, nil
+}
+
+// See documentation for CommentDB.PutTaskComment.
+func (d *sqlDB) PutTaskComment(c *db.TaskComment) error {
+	if c.Repo == "" || c.Revision == "" || c.Name == "" || util.TimeIsZero(c.Timestamp) {
+		return fmt.Errorf("TaskComment missing required fields. %#v", c)
+	}
+	return d.putComment("task_comments", []string{"repo", "revision", "name", "ts"}, []interface{}{c.Repo, c.Revision, c.Name, c.Timestamp.UnixNano()}, c)
+}
+
+// See documentation for CommentDB.DeleteTaskComment.
+func (d *sqlDB) DeleteTaskComment(c *db.TaskComment) error {
+	if c.Repo == "" || c.Revision == "" || c.Name == "" || util.TimeIsZero(c.Timestamp) {
+		return fmt.Errorf("TaskComment missing required fields. %#v", c)
+	}
+	return d.deleteComment("task_comments", []string{"repo", "revision", "name", "ts"}, []interface{}{c.Repo, c.Revision, c.Name, c.Timestamp.UnixNano()})
+}
+
+// See documentation for CommentDB.PutTaskSpecComment.
+func (d *sqlDB) PutTaskSpecComment(c *db.TaskSpecComment) error {
+	if c.Repo == "" || c.Name == "" || util.TimeIsZero(c.Timestamp) {
+		return fmt.Errorf("TaskSpecComment missing required fields. %#v", c)
+	}
+	return d.putComment("task_spec_comments", []string{"repo", "name", "ts"}, []interface{}{c.Repo, c.Name, c.Timestamp.UnixNano()}, c)
+}
+
+// See documentation for CommentDB.DeleteTaskSpecComment.
+func (d *sqlDB) DeleteTaskSpecComment(c *db.TaskSpecComment) error {
+	if c.Repo == "" || c.Name == "" || util.TimeIsZero(c.Timestamp) {
+		return fmt.Errorf("TaskSpecComment missing required fields. %#v", c)
+	}
+	return d.deleteComment("task_spec_comments", []string{"repo", "name", "ts"}, []interface{}{c.Repo, c.Name, c.Timestamp.UnixNano()})
+}
+
+// See documentation for CommentDB.PutCommitComment.
+func (d *sqlDB) PutCommitComment(c *db.CommitComment) error {
+	if c.Repo == "" || c.Revision == "" || util.TimeIsZero(c.Timestamp) {
+		return fmt.Errorf("CommitComment missing required fields. %#v", c)
+	}
+	return d.putComment("commit_comments", []string{"repo", "revision", "ts"}, []interface{}{c.Repo, c.Revision, c.Timestamp.UnixNano()}, c)
+}
+
+// See documentation for CommentDB.DeleteCommitComment.
+func (d *sqlDB) DeleteCommitComment(c *db.CommitComment) error {
+	if c.Repo == "" || c.Revision == "" || util.TimeIsZero(c.Timestamp) {
+		return fmt.Errorf("CommitComment missing required fields. %#v", c)
+	}
+	return d.deleteComment("commit_comments", []string{"repo", "revision", "ts"}, []interface{}{c.Repo, c.Revision, c.Timestamp.UnixNano()})
+}
@@ -0,0 +1,613 @@
+package sql_db
+
+/*
+	Implementation of db.DB backed by a SQL database, via go/database.
+
+	Each Task and Job is stored as a GOB in a single row, alongside the columns
+	needed to query it. Every write to the tasks or jobs table first increments
+	the corresponding row in the sequences table, which serializes writers of
+	each type. The new sequence number is stored in the seq column of each
+	written row, so that GetModifiedTasks and GetModifiedJobs can find all rows
+	written since the last call by querying for larger sequence numbers.
+*/
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// Names of the rows in the sequences table.
+	SEQUENCE_TASKS = "tasks"
+	SEQUENCE_JOBS  = "jobs"
+)
+
+// MigrationSteps returns the migration (up and down) for the database.
+func MigrationSteps() []database.MigrationStep {
+	return migrationSteps
+}
+
+// migrationSteps define the steps it takes to migrate the db between versions.
+// Note: Only add to this list, once a step has landed in version control it
+// must not be changed. Statements are MySQL specific, e.g. LONGBLOB and
+// REPLACE INTO.
+var migrationSteps = []database.MigrationStep{
+	// version 1
+	{
+		MySQLUp: []string{
+			`CREATE TABLE sequences (
+				name   VARCHAR(32)  NOT NULL PRIMARY KEY,
+				value  BIGINT       NOT NULL
+			)`,
+			`INSERT INTO sequences (name, value) VALUES ('tasks', 0), ('jobs', 0)`,
+
+			`CREATE TABLE tasks (
+				id           VARCHAR(64)  NOT NULL PRIMARY KEY,
+				created      BIGINT       NOT NULL,
+				db_modified  BIGINT       NOT NULL,
+				seq          BIGINT       NOT NULL,
+				data         LONGBLOB     NOT NULL
+			)`,
+			`CREATE INDEX tasks_created_idx ON tasks (created)`,
+			`CREATE INDEX tasks_seq_idx ON tasks (seq)`,
+
+			`CREATE TABLE jobs (
+				id           VARCHAR(64)  NOT NULL PRIMARY KEY,
+				created      BIGINT       NOT NULL,
+				db_modified  BIGINT       NOT NULL,
+				seq          BIGINT       NOT NULL,
+				data         LONGBLOB     NOT NULL
+			)`,
+			`CREATE INDEX jobs_created_idx ON jobs (created)`,
+			`CREATE INDEX jobs_seq_idx ON jobs (seq)`,
+
+			`CREATE TABLE task_comments (
+				repo      VARCHAR(255)  NOT NULL,
+				revision  VARCHAR(64)   NOT NULL,
+				name      VARCHAR(255)  NOT NULL,
+				ts        BIGINT        NOT NULL,
+				data      BLOB          NOT NULL,
+				PRIMARY KEY (repo, revision, name, ts)
+			)`,
+
+			`CREATE TABLE task_spec_comments (
+				repo  VARCHAR(255)  NOT NULL,
+				name  VARCHAR(255)  NOT NULL,
+				ts    BIGINT        NOT NULL,
+				data  BLOB          NOT NULL,
+				PRIMARY KEY (repo, name, ts)
+			)`,
+
+			`CREATE TABLE commit_comments (
+				repo      VARCHAR(255)  NOT NULL,
+				revision  VARCHAR(64)   NOT NULL,
+				ts        BIGINT        NOT NULL,
+				data      BLOB          NOT NULL,
+				PRIMARY KEY (repo, revision, ts)
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS commit_comments`,
+			`DROP TABLE IF EXISTS task_spec_comments`,
+			`DROP TABLE IF EXISTS task_comments`,
+			`DROP TABLE IF EXISTS jobs`,
+			`DROP TABLE IF EXISTS tasks`,
+			`DROP TABLE IF EXISTS sequences`,
+		},
+	},
+}
+
+// sqlDB is an implementation of db.DB backed by a SQL database. Multiple
+// sqlDB instances, possibly in different processes, may share the same
+// database.
+type sqlDB struct {
+	db  *sql.DB
+	vdb *database.VersionedDB
+
+	modifiedTasks *modifiedTracker
+	modifiedJobs  *modifiedTracker
+}
+
+// NewDB returns a db.DBCloser backed by the given database, which must have
+// been migrated to the latest version. Closing the returned DB closes the
+// VersionedDB.
+func NewDB(vdb *database.VersionedDB) (db.DBCloser, error) {
+	if !vdb.IsLatestVersion() {
+		return nil, fmt.Errorf("Database is not at the latest version; please run the migrations.")
+	}
+	return &sqlDB{
+		db:            vdb.DB,
+		vdb:           vdb,
+		modifiedTasks: newModifiedTracker(vdb.DB, "tasks", SEQUENCE_TASKS),
+		modifiedJobs:  newModifiedTracker(vdb.DB, "jobs", SEQUENCE_JOBS),
+	}, nil
+}
+
+// See docs for io.Closer interface.
+func (d *sqlDB) Close() error {
+	return d.vdb.Close()
+}
+
+// tx runs the given function in a transaction, which is committed if the
+// function returns nil and rolled back otherwise. Unlike
+// database.CommitOrRollback, the error returned by the function is returned
+// unmodified, so that callers can check for db.ErrConcurrentUpdate.
+func (d *sqlDB) tx(fn func(*sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to start transaction: %s", err)
+	}
+	if err := fn(tx); err != nil {
+		if err2 := tx.Rollback(); err2 != nil {
+			sklog.Errorf("Failed to roll back transaction: %s", err2)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// nextSequence increments and returns the given sequence number. The row lock
+// obtained by the UPDATE is held until the end of the transaction, which
+// prevents concurrent writers from committing sequence numbers out of order.
+func nextSequence(tx *sql.Tx, name string) (int64, error) {
+	if _, err := tx.Exec(`UPDATE sequences SET value = value + 1 WHERE name = ?`, name); err != nil {
+		return 0, err
+	}
+	var rv int64
+	if err := tx.QueryRow(`SELECT value FROM sequences WHERE name = ?`, name).Scan(&rv); err != nil {
+		return 0, err
+	}
+	return rv, nil
+}
+
+// getModifiedTime returns the db_modified time of the given row, or false if
+// the row does not exist.
+func getModifiedTime(tx *sql.Tx, table, id string) (int64, bool, error) {
+	var rv int64
+	err := tx.QueryRow(fmt.Sprintf(`SELECT db_modified FROM %s WHERE id = ?`, table), id).Scan(&rv)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return rv, true, nil
+}
+
+// getData returns the data column of the row with the given ID, or nil if no
+// such row exists.
+func (d *sqlDB) getData(table, id string) ([]byte, error) {
+	var rv []byte
+	err := d.db.QueryRow(fmt.Sprintf(`SELECT data FROM %s WHERE id = ?`, table), id).Scan(&rv)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// getDataFromDateRange returns the data column of all rows with created time
+// in the given range.
+func (d *sqlDB) getDataFromDateRange(table string, start, end time.Time) ([][]byte, error) {
+	rows, err := d.db.Query(fmt.Sprintf(`SELECT data FROM %s WHERE created >= ? AND created < ?`, table), start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+	rv := [][]byte{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		rv = append(rv, data)
+	}
+	return rv, rows.Err()
+}
+
+// entry is a Task or Job to be written to the database.
+type entry struct {
+	id         string
+	created    time.Time
+	dbModified time.Time
+}
+
+// putEntries writes rows to the given table in a single transaction. It
+// checks each entry's DbModified time against the database, returning
+// db.ErrConcurrentUpdate if any have been modified, then calls setModified with
+// the new DbModified time and encode to obtain the data for each row.
+func (d *sqlDB) putEntries(table, sequence string, entries []entry, setModified func(time.Time), encode func(int) ([]byte, error)) error {
+	return d.tx(func(tx *sql.Tx) error {
+		seq, err := nextSequence(tx, sequence)
+		if err != nil {
+			return err
+		}
+		// DbModified must change on every write, even if the clock
+		// moves backward.
+		now := time.Now().UTC()
+		for _, e := range entries {
+			modTs, exists, err := getModifiedTime(tx, table, e.id)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if modTs != e.dbModified.UnixNano() {
+				sklog.Warningf("Cached %s %s has been modified in the DB.", table, e.id)
+				return db.ErrConcurrentUpdate
+			}
+			if now.UnixNano() <= modTs {
+				now = time.Unix(0, modTs+1).UTC()
+			}
+		}
+		setModified(now)
+		for i, e := range entries {
+			data, err := encode(i)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(fmt.Sprintf(`REPLACE INTO %s (id, created, db_modified, seq, data) VALUES (?, ?, ?, ?, ?)`, table), e.id, e.created.UnixNano(), now.UnixNano(), seq, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// newId returns a new unique ID for a Task or Job.
+func newId() string {
+	return uuid.NewV5(uuid.NewV1(), uuid.NewV4().String()).String()
+}
+
+// See docs for TaskDB interface.
+func (d *sqlDB) AssignId(t *db.Task) error {
+	if t.Id != "" {
+		return fmt.Errorf("Task Id already assigned: %v", t.Id)
+	}
+	t.Id = newId()
+	return nil
+}
+
+// See docs for TaskReader interface.
+func (d *sqlDB) GetTaskById(id string) (*db.Task, error) {
+	data, err := d.getData("tasks", id)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var t db.Task
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// decodeTasks decodes the given GOBs and returns the Tasks sorted by Created
+// timestamp.
+func decodeTasks(gobs [][]byte) ([]*db.Task, error) {
+	decoder := db.TaskDecoder{}
+	for _, g := range gobs {
+		if !decoder.Process(g) {
+			break
+		}
+	}
+	rv, err := decoder.Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(db.TaskSlice(rv))
+	return rv, nil
+}
+
+// See docs for TaskReader interface.
+func (d *sqlDB) GetTasksFromDateRange(start, end time.Time) ([]*db.Task, error) {
+	gobs, err := d.getDataFromDateRange("tasks", start, end)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTasks(gobs)
+}
+
+// See docs for TaskDB interface.
+func (d *sqlDB) PutTask(t *db.Task) error {
+	return d.PutTasks([]*db.Task{t})
+}
+
+// See docs for TaskDB interface.
+func (d *sqlDB) PutTasks(tasks []*db.Task) error {
+	// If there is an error, we should leave the tasks unchanged. Save the
+	// old Ids and DbModified times since we set them below.
+	entries := make([]entry, 0, len(tasks))
+	oldIds := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		if util.TimeIsZero(t.Created) {
+			return fmt.Errorf("Created not set. Task %s created time is %s. %v", t.Id, t.Created, t)
+		}
+		entries = append(entries, entry{
+			id:         t.Id,
+			created:    t.Created,
+			dbModified: t.DbModified,
+		})
+		oldIds = append(oldIds, t.Id)
+	}
+	for i, t := range tasks {
+		if t.Id == "" {
+			t.Id = newId()
+			entries[i].id = t.Id
+		}
+	}
+	err := d.putEntries("tasks", SEQUENCE_TASKS, entries, func(now time.Time) {
+		for _, t := range tasks {
+			t.DbModified = now
+		}
+	}, func(i int) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(tasks[i]); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		for i, t := range tasks {
+			t.Id = oldIds[i]
+			t.DbModified = entries[i].dbModified
+		}
+	}
+	return err
+}
+
+// See docs for TaskReader interface.
+func (d *sqlDB) GetModifiedTasks(id string) ([]*db.Task, error) {
+	gobs, err := d.modifiedTasks.getModified(id)
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, 0, len(gobs))
+	for _, g := range gobs {
+		data = append(data, g)
+	}
+	return decodeTasks(data)
+}
+
+// See docs for TaskReader interface.
+func (d *sqlDB) GetModifiedTasksGOB(id string) (map[string][]byte, error) {
+	return d.modifiedTasks.getModified(id)
+}
+
+// See docs for TaskReader interface.
+func (d *sqlDB) StartTrackingModifiedTasks() (string, error) {
+	return d.modifiedTasks.startTracking()
+}
+
+// See docs for TaskReader interface.
+func (d *sqlDB) StopTrackingModifiedTasks(id string) {
+	d.modifiedTasks.stopTracking(id)
+}
+
+// See docs for JobReader interface.
+func (d *sqlDB) GetJobById(id string) (*db.Job, error) {
+	data, err := d.getData("jobs", id)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var j db.Job
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// decodeJobs decodes the given GOBs and returns the Jobs sorted by Created
+// timestamp.
+func decodeJobs(gobs [][]byte) ([]*db.Job, error) {
+	decoder := db.JobDecoder{}
+	for _, g := range gobs {
+		if !decoder.Process(g) {
+			break
+		}
+	}
+	rv, err := decoder.Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(db.JobSlice(rv))
+	return rv, nil
+}
+
+// See docs for JobReader interface.
+func (d *sqlDB) GetJobsFromDateRange(start, end time.Time) ([]*db.Job, error) {
+	gobs, err := d.getDataFromDateRange("jobs", start, end)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJobs(gobs)
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) PutJob(j *db.Job) error {
+	return d.PutJobs([]*db.Job{j})
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) PutJobs(jobs []*db.Job) error {
+	// If there is an error, we should leave the jobs unchanged. Save the
+	// old Ids and DbModified times since we set them below.
+	entries := make([]entry, 0, len(jobs))
+	oldIds := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		if util.TimeIsZero(j.Created) {
+			return fmt.Errorf("Created not set. Job %s created time is %s. %v", j.Id, j.Created, j)
+		}
+		entries = append(entries, entry{
+			id:         j.Id,
+			created:    j.Created,
+			dbModified: j.DbModified,
+		})
+		oldIds = append(oldIds, j.Id)
+	}
+	for i, j := range jobs {
+		if j.Id == "" {
+			j.Id = newId()
+			entries[i].id = j.Id
+		}
+	}
+	err := d.putEntries("jobs", SEQUENCE_JOBS, entries, func(now time.Time) {
+		for _, j := range jobs {
+			j.DbModified = now
+		}
+	}, func(i int) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(jobs[i]); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		for i, j := range jobs {
+			j.Id = oldIds[i]
+			j.DbModified = entries[i].dbModified
+		}
+	}
+	return err
+}
+
+// See docs for JobReader interface.
+func (d *sqlDB) GetModifiedJobs(id string) ([]*db.Job, error) {
+	gobs, err := d.modifiedJobs.getModified(id)
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, 0, len(gobs))
+	for _, g := range gobs {
+		data = append(data, g)
+	}
+	return decodeJobs(data)
+}
+
+// See docs for JobReader interface.
+func (d *sqlDB) GetModifiedJobsGOB(id string) (map[string][]byte, error) {
+	return d.modifiedJobs.getModified(id)
+}
+
+// See docs for JobReader interface.
+func (d *sqlDB) StartTrackingModifiedJobs() (string, error) {
+	return d.modifiedJobs.startTracking()
+}
+
+// See docs for JobReader interface.
+func (d *sqlDB) StopTrackingModifiedJobs(id string) {
+	d.modifiedJobs.stopTracking(id)
+}
+
+// subscriber is a user of GetModifiedTasks or GetModifiedJobs.
+type subscriber struct {
+	expiration time.Time
+	seq        int64
+}
+
+// modifiedTracker keeps track of the last sequence number seen by each
+// subscriber for one table. Subscribers are local to the process.
+type modifiedTracker struct {
+	db          *sql.DB
+	mtx         sync.Mutex
+	sequence    string
+	subscribers map[string]*subscriber
+	table       string
+}
+
+// newModifiedTracker returns a modifiedTracker for the given table.
+func newModifiedTracker(d *sql.DB, table, sequence string) *modifiedTracker {
+	return &modifiedTracker{
+		db:          d,
+		sequence:    sequence,
+		subscribers: map[string]*subscriber{},
+		table:       table,
+	}
+}
+
+// expire removes any subscribers which have not been seen within
+// db.MODIFIED_DATA_TIMEOUT. Assumes the caller holds m.mtx.
+func (m *modifiedTracker) expire() {
+	now := time.Now()
+	for id, s := range m.subscribers {
+		if now.After(s.expiration) {
+			sklog.Warningf("Deleting expired subscriber with id %s; expiration time %s.", id, s.expiration)
+			delete(m.subscribers, id)
+		}
+	}
+}
+
+// startTracking adds a new subscriber, returning its ID.
+func (m *modifiedTracker) startTracking() (string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.expire()
+	if len(m.subscribers) >= db.MAX_MODIFIED_DATA_USERS {
+		return "", db.ErrTooManyUsers
+	}
+	var seq int64
+	if err := m.db.QueryRow(`SELECT value FROM sequences WHERE name = ?`, m.sequence).Scan(&seq); err != nil {
+		return "", err
+	}
+	id := newId()
+	m.subscribers[id] = &subscriber{
+		expiration: time.Now().Add(db.MODIFIED_DATA_TIMEOUT),
+		seq:        seq,
+	}
+	return id, nil
+}
+
+// stopTracking removes the given subscriber.
+func (m *modifiedTracker) stopTracking(id string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.subscribers, id)
+}
+
+// getModified returns the GOBs of all rows written since the last call for the
+// given subscriber, keyed by ID.
+func (m *modifiedTracker) getModified(id string) (map[string][]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.expire()
+	s, ok := m.subscribers[id]
+	if !ok {
+		return nil, db.ErrUnknownId
+	}
+	rows, err := m.db.Query(fmt.Sprintf(`SELECT id, seq, data FROM %s WHERE seq > ?`, m.table), s.seq)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+	rv := map[string][]byte{}
+	maxSeq := s.seq
+	for rows.Next() {
+		var entryId string
+		var seq int64
+		var data []byte
+		if err := rows.Scan(&entryId, &seq, &data); err != nil {
+			return nil, err
+		}
+		rv[entryId] = data
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.seq = maxSeq
+	s.expiration = time.Now().Add(db.MODIFIED_DATA_TIMEOUT)
+	return rv, nil
+}
@@ -0,0 +1,74 @@
+package sql_db
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/database/testutil"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+// runTest runs the given shared DB test against a freshly-migrated test
+// database.
+func runTest(t *testing.T, fn func(*testing.T, db.DB)) {
+	testutils.LargeTest(t)
+	mysqlDB := testutil.SetupMySQLTestDatabase(t, migrationSteps)
+	defer mysqlDB.Close(t)
+
+	vdb, err := testutil.LocalTestDatabaseConfig(migrationSteps).NewVersionedDB()
+	assert.NoError(t, err)
+	d, err := NewDB(vdb)
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, d)
+	fn(t, d)
+}
+
+func TestMySQLVersioning(t *testing.T) {
+	testutils.LargeTest(t)
+	testutil.MySQLVersioningTests(t, "task_scheduler", migrationSteps)
+}
+
+func TestSQLTaskDB(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestTaskDB(t, d) })
+}
+
+func TestSQLTaskDBTooManyUsers(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestTaskDBTooManyUsers(t, d) })
+}
+
+func TestSQLTaskDBConcurrentUpdate(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestTaskDBConcurrentUpdate(t, d) })
+}
+
+func TestSQLPutTasksLeavesTasksUnchanged(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestPutTasksLeavesTasksUnchanged(t, d) })
+}
+
+func TestSQLUpdateTasksWithRetries(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestUpdateTasksWithRetries(t, d) })
+}
+
+func TestSQLJobDB(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestJobDB(t, d) })
+}
+
+func TestSQLJobDBTooManyUsers(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestJobDBTooManyUsers(t, d) })
+}
+
+func TestSQLJobDBConcurrentUpdate(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestJobDBConcurrentUpdate(t, d) })
+}
+
+func TestSQLPutJobsLeavesJobsUnchanged(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestPutJobsLeavesJobsUnchanged(t, d) })
+}
+
+func TestSQLUpdateJobsWithRetries(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestUpdateJobsWithRetries(t, d) })
+}
+
+func TestSQLCommentDB(t *testing.T) {
+	runTest(t, func(t *testing.T, d db.DB) { db.TestCommentDB(t, d) })
+}
@@ -119,11 +119,21 @@ type Task struct {
 	// Created is the creation timestamp.
 	Created time.Time `json:"created"`
 
+	// Culprit is the commit which bisection identified as having caused
+	// this Task to fail, if any.
+	Culprit string `json:"culprit"`
+
 	// DbModified is the time of the last successful call to TaskDB.PutTask/s for this
 	// Task, or zero if the task is new. It is not related to the ModifiedTs time
 	// of the associated Swarming task.
 	DbModified time.Time `json:"dbModified"`
 
+	// DeduplicatedFrom is the ID of a previous successful Task with the
+	// same Name and InputHash whose results were reused instead of running
+	// this Task on Swarming, if any. The Swarming fields and IsolatedOutput
+	// of a de-duplicated Task are copied from that Task.
+	DeduplicatedFrom string `json:"deduplicatedFrom"`
+
 	// Finished is the time the task stopped running or expired from the queue, or
 	// zero if the task is pending or running.
 	Finished time.Time `json:"finished"`
@@ -132,6 +142,12 @@ type Task struct {
 	// URL-safe.
 	Id string `json:"id"`
 
+	// InputHash identifies the inputs of this Task: the isolated hash of
+	// its inputs, including the outputs of its dependencies, combined with
+	// the parts of its TaskSpec which affect how it runs. Tasks with the
+	// same Name and InputHash are expected to produce the same results.
+	InputHash string `json:"inputHash"`
+
 	// IsolatedOutput is the isolated hash of any outputs produced by this Task.
 	// Filled in when the task is completed. This field will not be set if the
 	// Task does not correspond to a Swarming task.
@@ -148,6 +164,11 @@ type Task struct {
 	// base64 encoding for binary data.
 	Properties map[string]string `json:"properties"`
 
+	// Quarantined indicates that the TaskSpec matched a quarantine rule in
+	// the blacklist when the task was triggered, ie. it is known to be
+	// flaky, so a failure of the task does not cause its Jobs to fail.
+	Quarantined bool `json:"quarantined"`
+
 	// RetryOf is the ID of the task which this task is a retry of, if any.
 	RetryOf string `json:"retryOf"`
 
@@ -387,22 +408,26 @@ func (t *Task) Copy() *Task {
 	commits := util.CopyStringSlice(t.Commits)
 	parentTaskIds := util.CopyStringSlice(t.ParentTaskIds)
 	return &Task{
-		Attempt:        t.Attempt,
-		Commits:        commits,
-		Created:        t.Created,
-		DbModified:     t.DbModified,
-		Finished:       t.Finished,
-		Id:             t.Id,
-		IsolatedOutput: t.IsolatedOutput,
-		MaxAttempts:    t.MaxAttempts,
-		ParentTaskIds:  parentTaskIds,
-		Properties:     util.CopyStringMap(t.Properties),
-		RetryOf:        t.RetryOf,
-		Started:        t.Started,
-		Status:         t.Status,
-		SwarmingBotId:  t.SwarmingBotId,
-		SwarmingTaskId: t.SwarmingTaskId,
-		TaskKey:        t.TaskKey.Copy(),
+		Attempt:          t.Attempt,
+		Commits:          commits,
+		Created:          t.Created,
+		Culprit:          t.Culprit,
+		DbModified:       t.DbModified,
+		DeduplicatedFrom: t.DeduplicatedFrom,
+		Finished:         t.Finished,
+		Id:               t.Id,
+		InputHash:        t.InputHash,
+		IsolatedOutput:   t.IsolatedOutput,
+		MaxAttempts:      t.MaxAttempts,
+		ParentTaskIds:    parentTaskIds,
+		Properties:       util.CopyStringMap(t.Properties),
+		Quarantined:      t.Quarantined,
+		RetryOf:          t.RetryOf,
+		Started:          t.Started,
+		Status:           t.Status,
+		SwarmingBotId:    t.SwarmingBotId,
+		SwarmingTaskId:   t.SwarmingTaskId,
+		TaskKey:          t.TaskKey.Copy(),
 	}
 }
 
@@ -436,6 +461,7 @@ type TaskSummary struct {
 	Attempt        int        `json:"attempt"`
 	Id             string     `json:"id"`
 	MaxAttempts    int        `json:"max_attempts"`
+	Quarantined    bool       `json:"quarantined"`
 	Status         TaskStatus `json:"status"`
 	SwarmingTaskId string     `json:"swarmingTaskId"`
 }
@@ -446,6 +472,7 @@ func (t *Task) MakeTaskSummary() *TaskSummary {
 		Attempt:        t.Attempt,
 		Id:             t.Id,
 		MaxAttempts:    t.MaxAttempts,
+		Quarantined:    t.Quarantined,
 		Status:         t.Status,
 		SwarmingTaskId: t.SwarmingTaskId,
 	}
@@ -457,6 +484,7 @@ func (t *TaskSummary) Copy() *TaskSummary {
 		Attempt:        t.Attempt,
 		Id:             t.Id,
 		MaxAttempts:    t.MaxAttempts,
+		Quarantined:    t.Quarantined,
 		Status:         t.Status,
 		SwarmingTaskId: t.SwarmingTaskId,
 	}
@@ -652,19 +652,23 @@ func TestCopyTask(t *testing.T) {
 	testutils.SmallTest(t)
 	now := time.Now()
 	v := &Task{
-		Attempt:        3,
-		Commits:        []string{"a", "b"},
-		Created:        now.Add(time.Nanosecond),
-		DbModified:     now.Add(time.Millisecond),
-		Finished:       now.Add(time.Second),
-		Id:             "42",
-		IsolatedOutput: "lonely-result",
-		MaxAttempts:    2,
-		ParentTaskIds:  []string{"38", "39", "40"},
+		Attempt:          3,
+		Commits:          []string{"a", "b"},
+		Created:          now.Add(time.Nanosecond),
+		Culprit:          "b",
+		DbModified:       now.Add(time.Millisecond),
+		DeduplicatedFrom: "37",
+		Finished:         now.Add(time.Second),
+		Id:               "42",
+		InputHash:        "deadbeef",
+		IsolatedOutput:   "lonely-result",
+		MaxAttempts:      2,
+		ParentTaskIds:    []string{"38", "39", "40"},
 		Properties: map[string]string{
 			"color":   "blue",
 			"awesome": "true",
 		},
+		Quarantined:    true,
 		RetryOf:        "41",
 		Started:        now.Add(time.Minute),
 		Status:         TASK_STATUS_MISHAP,
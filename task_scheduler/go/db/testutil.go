@@ -258,6 +258,69 @@ func TestTaskDBConcurrentUpdate(t *testing.T, db TaskDB) {
 	}
 }
 
+// Test that PutTasks leaves the given Tasks unchanged, including their Ids and
+// DbModified timestamps, when it returns an error.
+func TestPutTasksLeavesTasksUnchanged(t *testing.T, db TaskDB) {
+	begin := time.Now().Add(-time.Nanosecond)
+
+	// Create and insert a task that will cause ErrConcurrentUpdate.
+	task1 := &Task{
+		Created: time.Now(),
+	}
+	assert.NoError(t, db.PutTask(task1))
+
+	// Retrieve a copy, modify original.
+	task1Cached, err := db.GetTaskById(task1.Id)
+	assert.NoError(t, err)
+	task1.Status = TASK_STATUS_RUNNING
+	assert.NoError(t, db.PutTask(task1))
+	task1InDb := task1.Copy()
+
+	// Create and insert a task to check PutTasks doesn't change DbModified.
+	task2 := &Task{
+		Created: time.Now(),
+	}
+	assert.NoError(t, db.PutTask(task2))
+	task2InDb := task2.Copy()
+	task2.Status = TASK_STATUS_MISHAP
+
+	// Create a task with an Id already set.
+	task3 := &Task{}
+	assert.NoError(t, db.AssignId(task3))
+	task3.Created = time.Now()
+
+	// Create a task without an Id set.
+	task4 := &Task{
+		Created: time.Now(),
+	}
+
+	// Make an update to task1Cached.
+	task1Cached.Commits = []string{"a", "b"}
+
+	// Copy to compare later.
+	expectedTasks := []*Task{task1Cached.Copy(), task2.Copy(), task3.Copy(), task4.Copy()}
+
+	// Attempt to insert; put task1Cached last so that the error comes last.
+	err = db.PutTasks([]*Task{task2, task3, task4, task1Cached})
+	assert.True(t, IsConcurrentUpdate(err))
+	testutils.AssertDeepEqual(t, expectedTasks, []*Task{task1Cached, task2, task3, task4})
+
+	// Check that nothing was updated in the DB.
+	tasksInDb, err := db.GetTasksFromDateRange(begin, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasksInDb))
+	for _, task := range tasksInDb {
+		switch task.Id {
+		case task1.Id:
+			testutils.AssertDeepEqual(t, task1InDb, task)
+		case task2.Id:
+			testutils.AssertDeepEqual(t, task2InDb, task)
+		default:
+			assert.Fail(t, "Unexpected task in DB: %v", task)
+		}
+	}
+}
+
 // Test UpdateTasksWithRetries when no errors or retries.
 func testUpdateTasksWithRetriesSimple(t *testing.T, db TaskDB) {
 	begin := time.Now()
@@ -827,6 +890,64 @@ func TestJobDBConcurrentUpdate(t *testing.T, db JobDB) {
 	}
 }
 
+// Test that PutJobs leaves the given Jobs unchanged, including their Ids and
+// DbModified timestamps, when it returns an error.
+func TestPutJobsLeavesJobsUnchanged(t *testing.T, db JobDB) {
+	begin := time.Now().Add(-time.Nanosecond)
+
+	// Create and insert a job that will cause ErrConcurrentUpdate.
+	job1 := &Job{
+		Created: time.Now(),
+	}
+	assert.NoError(t, db.PutJob(job1))
+
+	// Retrieve a copy, modify original.
+	job1Cached, err := db.GetJobById(job1.Id)
+	assert.NoError(t, err)
+	job1.Status = JOB_STATUS_SUCCESS
+	assert.NoError(t, db.PutJob(job1))
+	job1InDb := job1.Copy()
+
+	// Create and insert a job to check PutJobs doesn't change DbModified.
+	job2 := &Job{
+		Created: time.Now(),
+	}
+	assert.NoError(t, db.PutJob(job2))
+	job2InDb := job2.Copy()
+	job2.Status = JOB_STATUS_MISHAP
+
+	// Create a job without an Id set.
+	job3 := &Job{
+		Created: time.Now(),
+	}
+
+	// Make an update to job1Cached.
+	job1Cached.Status = JOB_STATUS_FAILURE
+
+	// Copy to compare later.
+	expectedJobs := []*Job{job1Cached.Copy(), job2.Copy(), job3.Copy()}
+
+	// Attempt to insert; put job1Cached last so that the error comes last.
+	err = db.PutJobs([]*Job{job2, job3, job1Cached})
+	assert.True(t, IsConcurrentUpdate(err))
+	testutils.AssertDeepEqual(t, expectedJobs, []*Job{job1Cached, job2, job3})
+
+	// Check that nothing was updated in the DB.
+	jobsInDb, err := db.GetJobsFromDateRange(begin, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobsInDb))
+	for _, job := range jobsInDb {
+		switch job.Id {
+		case job1.Id:
+			testutils.AssertDeepEqual(t, job1InDb, job)
+		case job2.Id:
+			testutils.AssertDeepEqual(t, job2InDb, job)
+		default:
+			assert.Fail(t, "Unexpected job in DB: %v", job)
+		}
+	}
+}
+
 // Test UpdateJobsWithRetries when no errors or retries.
 func testUpdateJobsWithRetriesSimple(t *testing.T, db JobDB) {
 	begin := time.Now()
@@ -0,0 +1,301 @@
+package scheduling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"go.skia.org/infra/go/git/repograph"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// Bisection tasks have high priority, above try jobs, so that culprits
+	// are identified quickly.
+	CANDIDATE_SCORE_BISECT = 20.0
+
+	// BISECTIONS_JSON_FILE is the name of a JSON file containing the
+	// in-progress bisections.
+	BISECTIONS_JSON_FILE = "bisections.json"
+)
+
+// bisection tracks the search for the commit which caused a TaskSpec to
+// begin failing.
+type bisection struct {
+	// Commits are the commits which are known to contain the culprit.
+	Commits []string `json:"commits"`
+
+	// Name is the name of the TaskSpec.
+	Name string `json:"name"`
+
+	// Repo is the repository in which the failure occurred.
+	Repo string `json:"repo"`
+
+	// Suspect is the ID of the most recently-finished failed Task whose
+	// blamelist contains the culprit.
+	Suspect string `json:"suspect"`
+
+	// TaskId is the ID of the failed Task which started the bisection.
+	TaskId string `json:"taskId"`
+}
+
+// bisections tracks all in-progress bisections and persists them to a file.
+type bisections struct {
+	Bisections map[string]*bisection `json:"bisections"`
+	jsonFile   string
+	mtx        sync.Mutex
+}
+
+// newBisections returns a bisections instance, pre-filled with data from a
+// file.
+func newBisections(workdir string) (*bisections, error) {
+	var rv bisections
+	jsonFile := path.Join(workdir, BISECTIONS_JSON_FILE)
+	f, err := os.Open(jsonFile)
+	if err == nil {
+		defer util.Close(f)
+		if err := json.NewDecoder(f).Decode(&rv); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if rv.Bisections == nil {
+		rv.Bisections = map[string]*bisection{}
+	}
+	rv.jsonFile = jsonFile
+	return &rv, nil
+}
+
+// write writes the in-progress bisections to a JSON file. Assumes the caller
+// holds b.mtx.
+func (b *bisections) write() (rv error) {
+	f, err := os.Create(b.jsonFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			if rv == nil {
+				rv = err
+			} else {
+				rv = fmt.Errorf("%s; failed to close file: %s", rv, err)
+			}
+		}
+	}()
+	return json.NewEncoder(f).Encode(b)
+}
+
+// failurePredatesBlamelist returns true iff a Task covering a parent of the
+// given Task's blamelist also failed, which indicates that the culprit is not
+// in the Task's blamelist.
+func (s *TaskScheduler) failurePredatesBlamelist(t *db.Task) (bool, error) {
+	repo, ok := s.repos[t.Repo]
+	if !ok {
+		return false, fmt.Errorf("No such repo: %s", t.Repo)
+	}
+	blamelist := util.NewStringSet(t.Commits)
+	for _, hash := range t.Commits {
+		c := repo.Get(hash)
+		if c == nil {
+			return false, fmt.Errorf("No such commit %s in %s.", hash, t.Repo)
+		}
+		for _, p := range c.GetParents() {
+			if blamelist[p.Hash] {
+				continue
+			}
+			prev, err := s.tCache.GetTaskForCommit(t.Repo, p.Hash, t.Name)
+			if err != nil {
+				return false, err
+			}
+			if prev != nil && prev.Status == db.TASK_STATUS_FAILURE {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// taskFinished updates the in-progress bisections based on the given
+// finished Task. If the Task failed and is not part of an existing
+// bisection, starts a new bisection of its blamelist. Safe to call more than
+// once for the same Task.
+func (s *TaskScheduler) taskFinished(t *db.Task) error {
+	if t.Status != db.TASK_STATUS_SUCCESS && t.Status != db.TASK_STATUS_FAILURE {
+		// Mishaps are retried, so they don't tell us anything.
+		return nil
+	}
+	if t.IsTryJob() || t.IsForceRun() || t.Culprit != "" {
+		return nil
+	}
+	if t.Quarantined {
+		// Results of known-flaky Tasks are not reliable enough to bisect.
+		return nil
+	}
+
+	s.bisections.mtx.Lock()
+	defer s.bisections.mtx.Unlock()
+
+	// If this Task ran at a commit which is suspected for an existing
+	// bisection, narrow down the set of suspected commits.
+	for _, b := range s.bisections.Bisections {
+		if b.Repo != t.Repo || b.Name != t.Name {
+			continue
+		}
+		if t.Id == b.TaskId || t.Id == b.Suspect {
+			return nil
+		}
+		if !util.In(t.Revision, b.Commits) {
+			continue
+		}
+		// If the Task failed, the culprit is in its blamelist. Otherwise,
+		// the culprit is in the remainder of the suspected commits.
+		failed := t.Status == db.TASK_STATUS_FAILURE
+		blamelist := util.NewStringSet(t.Commits)
+		commits := make([]string, 0, len(b.Commits))
+		for _, c := range b.Commits {
+			if blamelist[c] == failed {
+				commits = append(commits, c)
+			}
+		}
+		b.Commits = commits
+		if failed {
+			b.Suspect = t.Id
+		}
+		return s.maybeFinishBisection(b)
+	}
+
+	if t.Status != db.TASK_STATUS_FAILURE || len(t.Commits) == 0 {
+		return nil
+	}
+	predates, err := s.failurePredatesBlamelist(t)
+	if err != nil {
+		return err
+	}
+	if predates {
+		return nil
+	}
+	b := &bisection{
+		Commits: util.CopyStringSlice(t.Commits),
+		Name:    t.Name,
+		Repo:    t.Repo,
+		Suspect: t.Id,
+		TaskId:  t.Id,
+	}
+	sklog.Infof("Starting bisection of %s @ %s (%d commits).", t.Name, t.Revision, len(t.Commits))
+	s.bisections.Bisections[b.TaskId] = b
+	return s.maybeFinishBisection(b)
+}
+
+// maybeFinishBisection records the culprit on the relevant Tasks if the given
+// bisection has narrowed its suspected commits down to one. Persists the
+// in-progress bisections. Assumes the caller holds s.bisections.mtx.
+func (s *TaskScheduler) maybeFinishBisection(b *bisection) error {
+	if len(b.Commits) > 1 {
+		return s.bisections.write()
+	}
+	delete(s.bisections.Bisections, b.TaskId)
+	if len(b.Commits) == 0 {
+		sklog.Warningf("Bisection of task %s found no culprit; the failure may be flaky.", b.TaskId)
+		return s.bisections.write()
+	}
+	culprit := b.Commits[0]
+	sklog.Infof("Bisection of task %s identified culprit %s.", b.TaskId, culprit)
+	for _, id := range util.NewStringSet([]string{b.TaskId, b.Suspect}).Keys() {
+		if _, err := db.UpdateTaskWithRetries(s.db, id, func(t *db.Task) error {
+			t.Culprit = culprit
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return s.bisections.write()
+}
+
+// sortCommits returns the given commits in the order in which they are
+// encountered when tracing history backward from the newest of them. Unlike
+// sorting by timestamp, this is deterministic for commits which landed in the
+// same second.
+func sortCommits(repo *repograph.Graph, hashes []string) ([]string, error) {
+	set := util.NewStringSet(hashes)
+	isParent := util.StringSet{}
+	for _, hash := range hashes {
+		c := repo.Get(hash)
+		if c == nil {
+			return nil, fmt.Errorf("No such commit: %s", hash)
+		}
+		for _, p := range c.GetParents() {
+			isParent[p.Hash] = true
+		}
+	}
+	heads := make([]string, 0, 1)
+	for _, hash := range hashes {
+		if !isParent[hash] {
+			heads = append(heads, hash)
+		}
+	}
+	sort.Strings(heads)
+	rv := make([]string, 0, len(hashes))
+	for _, head := range heads {
+		if err := repo.Get(head).Recurse(func(c *repograph.Commit) (bool, error) {
+			if !set[c.Hash] {
+				return false, nil
+			}
+			delete(set, c.Hash)
+			rv = append(rv, c.Hash)
+			return true, nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// bisectTargets returns the TaskKeys at which Tasks should run in order to
+// make progress on the in-progress bisections. Bisections whose Tasks have
+// scrolled out of the scheduling window are abandoned.
+func (s *TaskScheduler) bisectTargets() (map[db.TaskKey]bool, error) {
+	s.bisections.mtx.Lock()
+	defer s.bisections.mtx.Unlock()
+
+	rv := make(map[db.TaskKey]bool, len(s.bisections.Bisections))
+	modified := false
+	for id, b := range s.bisections.Bisections {
+		if _, err := s.tCache.GetTask(id); err == db.ErrNotFound {
+			sklog.Warningf("Task %s is no longer in the cache; abandoning bisection.", id)
+			delete(s.bisections.Bisections, id)
+			modified = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		repo, ok := s.repos[b.Repo]
+		if !ok {
+			return nil, fmt.Errorf("No such repo: %s", b.Repo)
+		}
+		commits, err := sortCommits(repo, b.Commits)
+		if err != nil {
+			return nil, err
+		}
+		// The commits are sorted newest first, so the midpoint is never
+		// the commit at which the suspected Task ran.
+		rv[db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     b.Repo,
+				Revision: commits[len(commits)/2],
+			},
+			Name: b.Name,
+		}] = true
+	}
+	if modified {
+		if err := s.bisections.write(); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
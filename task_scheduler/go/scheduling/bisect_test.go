@@ -0,0 +1,124 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/git/repograph"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestBisect(t *testing.T) {
+	gb, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	// Add some commits. The repo now contains 12 commits.
+	makeDummyCommits(gb, 10)
+	assert.NoError(t, s.updateRepos())
+	commits, err := s.repos[gb.RepoUrl()].Repo().RevList("HEAD")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(commits))
+
+	now := time.Now()
+	makeFinishedTask := func(id string, status db.TaskStatus, revision string, blamelist []string) *db.Task {
+		task := makeTask(specs_testutils.BuildTask, gb.RepoUrl(), revision)
+		task.Id = id
+		task.Commits = blamelist
+		task.Created = now
+		task.Status = status
+		now = now.Add(time.Second)
+		assert.NoError(t, d.PutTask(task))
+		assert.NoError(t, s.tCache.Update())
+		return task
+	}
+
+	checkTarget := func(expect string) {
+		targets, err := s.bisectTargets()
+		assert.NoError(t, err)
+		assert.Equal(t, map[db.TaskKey]bool{
+			db.TaskKey{
+				RepoState: db.RepoState{
+					Repo:     gb.RepoUrl(),
+					Revision: expect,
+				},
+				Name: specs_testutils.BuildTask,
+			}: true,
+		}, targets)
+	}
+
+	// The task succeeded at the oldest commits, then failed at HEAD.
+	makeFinishedTask("t0", db.TASK_STATUS_SUCCESS, commits[10], commits[10:])
+	t1 := makeFinishedTask("t1", db.TASK_STATUS_FAILURE, commits[0], commits[:10])
+	assert.NoError(t, s.taskFinished(t1))
+	checkTarget(commits[5])
+
+	// Calling taskFinished again has no effect.
+	assert.NoError(t, s.taskFinished(t1))
+	checkTarget(commits[5])
+
+	// Bisection candidates get a high score.
+	c := &taskCandidate{
+		JobCreated: now.Add(-time.Hour),
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     gb.RepoUrl(),
+				Revision: commits[5],
+			},
+			Name: specs_testutils.BuildTask,
+		},
+	}
+	targets, err := s.bisectTargets()
+	assert.NoError(t, err)
+	commitsBuf := make([]*repograph.Commit, 0, MAX_BLAMELIST_COMMITS)
+	assert.NoError(t, s.processTaskCandidate(c, now, newCacheWrapper(s.tCache), commitsBuf, targets))
+	assert.Equal(t, CANDIDATE_SCORE_BISECT+1.0, c.Score)
+
+	// The task fails at the midpoint, so the culprit is in its blamelist.
+	t2 := makeFinishedTask("t2", db.TASK_STATUS_FAILURE, commits[5], commits[5:10])
+	assert.NoError(t, s.taskFinished(t2))
+	checkTarget(commits[7])
+
+	// The task succeeds at the next midpoint, so the culprit is in the
+	// remainder of the previous blamelist.
+	t3 := makeFinishedTask("t3", db.TASK_STATUS_SUCCESS, commits[7], commits[7:10])
+	assert.NoError(t, s.taskFinished(t3))
+	checkTarget(commits[6])
+
+	// The bisection state persists across restarts.
+	b, err := newBisections(s.workdir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(b.Bisections))
+	assert.Equal(t, []string{commits[5], commits[6]}, b.Bisections[t1.Id].Commits)
+	assert.Equal(t, t2.Id, b.Bisections[t1.Id].Suspect)
+
+	// The last step identifies the culprit.
+	t4 := makeFinishedTask("t4", db.TASK_STATUS_SUCCESS, commits[6], commits[6:7])
+	assert.NoError(t, s.taskFinished(t4))
+	targets, err = s.bisectTargets()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(targets))
+	for _, id := range []string{t1.Id, t2.Id} {
+		task, err := d.GetTaskById(id)
+		assert.NoError(t, err)
+		assert.Equal(t, commits[5], task.Culprit)
+	}
+	task, err := d.GetTaskById(t3.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "", task.Culprit)
+
+	// A failure whose previous task also failed does not start a bisection.
+	makeDummyCommits(gb, 2)
+	assert.NoError(t, s.updateRepos())
+	newCommits, err := s.repos[gb.RepoUrl()].Repo().RevList("HEAD")
+	assert.NoError(t, err)
+	t5 := makeFinishedTask("t5", db.TASK_STATUS_FAILURE, newCommits[0], newCommits[:2])
+	assert.NoError(t, s.taskFinished(t5))
+	assert.Equal(t, 0, len(s.bisections.Bisections))
+
+	// Mishaps are ignored.
+	t6 := makeFinishedTask("t6", db.TASK_STATUS_MISHAP, newCommits[1], newCommits[1:2])
+	assert.NoError(t, s.taskFinished(t6))
+	assert.Equal(t, 0, len(s.bisections.Bisections))
+}
@@ -41,6 +41,11 @@ func (c *cacheWrapper) GetTaskForCommit(repo, commit, name string) (*db.Task, er
 	return c.c.GetTaskForCommit(repo, commit, name)
 }
 
+// See documentation for TaskCache interface.
+func (c *cacheWrapper) GetTaskForInput(name, inputHash string) (*db.Task, error) {
+	return c.c.GetTaskForInput(name, inputHash)
+}
+
 // See documentation for TaskCache interface.
 func (c *cacheWrapper) GetTasksByKey(*db.TaskKey) ([]*db.Task, error) {
 	return nil, fmt.Errorf("cacheWrapper.GetTasksByKey not implemented.")
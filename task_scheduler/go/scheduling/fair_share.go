@@ -0,0 +1,154 @@
+package scheduling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// DEFAULT_FAIR_SHARE_WEIGHT is the weight given to repos which are not
+	// listed in the FairShareConfig.
+	DEFAULT_FAIR_SHARE_WEIGHT = 1.0
+
+	// FAIR_SHARE_JSON_FILE is the name of a JSON file in the workdir
+	// containing the FairShareConfig. If it does not exist, all candidates
+	// belong to a single share and are scheduled strictly by score.
+	FAIR_SHARE_JSON_FILE = "fair_share.json"
+
+	// Measurement names for fair-share usage and entitlement, expressed as
+	// fractions of the bots assigned in a single scheduling round.
+	MEASUREMENT_FAIR_SHARE_ENTITLEMENT = "fair-share-entitlement"
+	MEASUREMENT_FAIR_SHARE_USAGE       = "fair-share-usage"
+)
+
+// FairShareConfig describes the relative shares of free bots which should be
+// given to task candidates from each repo and JobSpec.
+type FairShareConfig struct {
+	// Repos maps repo URLs to weights. Repos which are not listed have a
+	// weight of DEFAULT_FAIR_SHARE_WEIGHT.
+	Repos map[string]float64 `json:"repos"`
+
+	// JobSpecs maps JobSpec names to weights. Task candidates needed by a
+	// listed JobSpec are given their own share, separate from that of their
+	// repo.
+	JobSpecs map[string]float64 `json:"jobSpecs"`
+}
+
+// ReadFairShareConfig reads a FairShareConfig from the given JSON file.
+// Returns nil, nil if the file does not exist.
+func ReadFairShareConfig(file string) (*FairShareConfig, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer util.Close(f)
+	var rv FairShareConfig
+	if err := json.NewDecoder(f).Decode(&rv); err != nil {
+		return nil, fmt.Errorf("Failed to decode fair-share config: %s", err)
+	}
+	if err := rv.Validate(); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// Validate returns an error if the FairShareConfig is not valid.
+func (c *FairShareConfig) Validate() error {
+	for repo, weight := range c.Repos {
+		if weight <= 0.0 {
+			return fmt.Errorf("Fair-share weight for repo %q must be positive; got %f", repo, weight)
+		}
+	}
+	for name, weight := range c.JobSpecs {
+		if weight <= 0.0 {
+			return fmt.Errorf("Fair-share weight for JobSpec %q must be positive; got %f", name, weight)
+		}
+	}
+	return nil
+}
+
+// shareFor returns the name and weight of the share to which the given
+// candidate belongs. If c is nil, all candidates belong to the same share.
+func (c *FairShareConfig) shareFor(candidate *taskCandidate) (string, float64) {
+	if c == nil {
+		return "", DEFAULT_FAIR_SHARE_WEIGHT
+	}
+	// JobNames is sorted, so this choice is deterministic.
+	for _, name := range candidate.JobNames {
+		if weight, ok := c.JobSpecs[name]; ok {
+			return "job:" + name, weight
+		}
+	}
+	if weight, ok := c.Repos[candidate.Repo]; ok {
+		return "repo:" + candidate.Repo, weight
+	}
+	return "repo:" + candidate.Repo, DEFAULT_FAIR_SHARE_WEIGHT
+}
+
+// fairShare is a group of task candidates which share an entitlement.
+type fairShare struct {
+	name       string
+	weight     float64
+	candidates []*taskCandidate
+	scheduled  int
+}
+
+// groupByShare organizes the given candidates into shares, preserving their
+// relative order. Returns the shares in order of their first candidate.
+func (c *FairShareConfig) groupByShare(candidates []*taskCandidate) []*fairShare {
+	byName := map[string]*fairShare{}
+	rv := []*fairShare{}
+	for _, candidate := range candidates {
+		name, weight := c.shareFor(candidate)
+		share, ok := byName[name]
+		if !ok {
+			share = &fairShare{
+				name:   name,
+				weight: weight,
+			}
+			byName[name] = share
+			rv = append(rv, share)
+		}
+		share.candidates = append(share.candidates, candidate)
+	}
+	return rv
+}
+
+// recordFairShareMetrics reports the usage and entitlement of each share for
+// which there were candidates in the queue.
+func recordFairShareMetrics(c *FairShareConfig, queue, scheduled []*taskCandidate) {
+	if c == nil {
+		return
+	}
+	eligible := make([]*taskCandidate, 0, len(queue))
+	for _, candidate := range queue {
+		if candidate.Score > 0.0 {
+			eligible = append(eligible, candidate)
+		}
+	}
+	shares := c.groupByShare(eligible)
+	totalWeight := 0.0
+	for _, share := range shares {
+		totalWeight += share.weight
+	}
+	usage := map[string]int{}
+	for _, candidate := range scheduled {
+		name, _ := c.shareFor(candidate)
+		usage[name]++
+	}
+	for _, share := range shares {
+		tags := map[string]string{"share": share.name}
+		metrics2.GetFloat64Metric(MEASUREMENT_FAIR_SHARE_ENTITLEMENT, tags).Update(share.weight / totalWeight)
+		u := 0.0
+		if len(scheduled) > 0 {
+			u = float64(usage[share.name]) / float64(len(scheduled))
+		}
+		metrics2.GetFloat64Metric(MEASUREMENT_FAIR_SHARE_USAGE, tags).Update(u)
+	}
+}
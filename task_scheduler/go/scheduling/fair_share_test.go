@@ -0,0 +1,100 @@
+package scheduling
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestReadFairShareConfig(t *testing.T) {
+	testutils.MediumTest(t)
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+
+	// Missing file.
+	file := path.Join(tmp, FAIR_SHARE_JSON_FILE)
+	cfg, err := ReadFairShareConfig(file)
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	// Valid file.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"repos": {"a.git": 2}, "jobSpecs": {"Perf": 0.5}}`), 0644))
+	cfg, err = ReadFairShareConfig(file)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, &FairShareConfig{
+		Repos:    map[string]float64{"a.git": 2.0},
+		JobSpecs: map[string]float64{"Perf": 0.5},
+	}, cfg)
+
+	// Invalid weight.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"repos": {"a.git": 0}}`), 0644))
+	_, err = ReadFairShareConfig(file)
+	assert.EqualError(t, err, "Fair-share weight for repo \"a.git\" must be positive; got 0.000000")
+}
+
+func TestGetCandidatesToScheduleFairShare(t *testing.T) {
+	testutils.SmallTest(t)
+
+	dims := []string{"k:v"}
+	bots := []*swarming_api.SwarmingRpcsBotInfo{
+		makeSwarmingBot("bot1", dims),
+		makeSwarmingBot("bot2", dims),
+		makeSwarmingBot("bot3", dims),
+		makeSwarmingBot("bot4", dims),
+	}
+	makeCandidate := func(name, repo string, score float64, jobs ...string) *taskCandidate {
+		c := makeTaskCandidate(name, dims)
+		c.Repo = repo
+		c.Score = score
+		c.JobNames = jobs
+		return c
+	}
+	a1 := makeCandidate("a1", "a.git", 10.0, "A")
+	a2 := makeCandidate("a2", "a.git", 9.0, "A")
+	a3 := makeCandidate("a3", "a.git", 8.0, "A")
+	a4 := makeCandidate("a4", "a.git", 7.0, "A")
+	b1 := makeCandidate("b1", "b.git", 2.0, "B")
+	b2 := makeCandidate("b2", "b.git", 1.0, "B")
+	queue := []*taskCandidate{a1, a2, a3, a4, b1, b2}
+
+	// Without a config, repo a.git takes all of the bots.
+	rv := getCandidatesToSchedule(bots, queue, nil)
+	testutils.AssertDeepEqual(t, []*taskCandidate{a1, a2, a3, a4}, rv)
+
+	// With equal weights, the repos split the bots.
+	rv = getCandidatesToSchedule(bots, queue, &FairShareConfig{})
+	testutils.AssertDeepEqual(t, []*taskCandidate{a1, a2, b1, b2}, rv)
+
+	// Repo weights.
+	rv = getCandidatesToSchedule(bots, queue, &FairShareConfig{
+		Repos: map[string]float64{"a.git": 3.0},
+	})
+	testutils.AssertDeepEqual(t, []*taskCandidate{a1, a2, a3, b1}, rv)
+
+	// Unused shares don't prevent other shares from using free bots.
+	rv = getCandidatesToSchedule(bots, []*taskCandidate{a1, a2, a3, a4, b1}, &FairShareConfig{})
+	testutils.AssertDeepEqual(t, []*taskCandidate{a1, a2, a3, b1}, rv)
+
+	// JobSpecs can be given their own share.
+	a3.JobNames = []string{"A", "C"}
+	a4.JobNames = []string{"C"}
+	rv = getCandidatesToSchedule(bots, queue, &FairShareConfig{
+		JobSpecs: map[string]float64{"C": 2.0},
+	})
+	testutils.AssertDeepEqual(t, []*taskCandidate{a1, a3, a4, b1}, rv)
+}
+
+func TestAddJobName(t *testing.T) {
+	testutils.SmallTest(t)
+	c := &taskCandidate{}
+	c.addJobName("b")
+	c.addJobName("a")
+	c.addJobName("c")
+	c.addJobName("b")
+	assert.Equal(t, []string{"a", "b", "c"}, c.JobNames)
+}
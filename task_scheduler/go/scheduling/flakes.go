@@ -0,0 +1,302 @@
+package scheduling
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.skia.org/infra/go/human"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/blacklist"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// DEFAULT_FLAKE_WINDOW is the period of time over which flake
+	// statistics are computed if the FlakeConfig does not specify one.
+	DEFAULT_FLAKE_WINDOW = 24 * time.Hour
+
+	// DEFAULT_QUARANTINE_DURATION is the lifetime of automatically-created
+	// quarantine rules if the FlakeConfig does not specify one.
+	DEFAULT_QUARANTINE_DURATION = 24 * time.Hour
+
+	// FLAKE_CONFIG_JSON_FILE is the name of a JSON file in the workdir
+	// containing the FlakeConfig. If it does not exist, flake statistics
+	// are still computed, but no TaskSpecs are quarantined.
+	FLAKE_CONFIG_JSON_FILE = "flake_config.json"
+
+	// FLAKE_UPDATE_PERIOD is how often flake statistics are recomputed and
+	// expired blacklist rules are removed.
+	FLAKE_UPDATE_PERIOD = 5 * time.Minute
+
+	// QUARANTINE_ADDED_BY is the AddedBy user for automatically-created
+	// quarantine rules.
+	QUARANTINE_ADDED_BY = "task-scheduler"
+
+	// QUARANTINE_RULE_PREFIX is the prefix of the names of
+	// automatically-created quarantine rules.
+	QUARANTINE_RULE_PREFIX = "quarantine-"
+)
+
+// FlakeConfig describes when TaskSpecs should be quarantined because they are
+// flaky.
+type FlakeConfig struct {
+	// Threshold is the flake rate, between zero and one, above which a
+	// TaskSpec is quarantined.
+	Threshold float64 `json:"threshold"`
+
+	// MinSamples is the minimum number of commits at which a TaskSpec must
+	// have results before it may be quarantined.
+	MinSamples int `json:"minSamples"`
+
+	// Window is the period of time over which flake statistics are
+	// computed, eg. "1d". Defaults to DEFAULT_FLAKE_WINDOW.
+	Window string `json:"window"`
+
+	// QuarantineDuration is the lifetime of quarantine rules, eg. "12h".
+	// Defaults to DEFAULT_QUARANTINE_DURATION.
+	QuarantineDuration string `json:"quarantineDuration"`
+
+	window             time.Duration
+	quarantineDuration time.Duration
+}
+
+// ReadFlakeConfig reads a FlakeConfig from the given JSON file. Returns nil,
+// nil if the file does not exist.
+func ReadFlakeConfig(file string) (*FlakeConfig, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer util.Close(f)
+	var rv FlakeConfig
+	if err := json.NewDecoder(f).Decode(&rv); err != nil {
+		return nil, fmt.Errorf("Failed to decode flake config: %s", err)
+	}
+	if err := rv.Validate(); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// Validate returns an error if the FlakeConfig is not valid. It also parses
+// the durations in the FlakeConfig.
+func (c *FlakeConfig) Validate() error {
+	if c.Threshold <= 0.0 || c.Threshold > 1.0 {
+		return fmt.Errorf("Flake threshold must be in (0, 1]; got %f", c.Threshold)
+	}
+	if c.MinSamples < 1 {
+		return fmt.Errorf("Flake minSamples must be positive; got %d", c.MinSamples)
+	}
+	parse := func(name, val string, dflt time.Duration) (time.Duration, error) {
+		if val == "" {
+			return dflt, nil
+		}
+		d, err := human.ParseDuration(val)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid flake %s %q: %s", name, val, err)
+		}
+		if d <= 0 {
+			return 0, fmt.Errorf("Flake %s must be positive; got %q", name, val)
+		}
+		return d, nil
+	}
+	var err error
+	c.window, err = parse("window", c.Window, DEFAULT_FLAKE_WINDOW)
+	if err != nil {
+		return err
+	}
+	c.quarantineDuration, err = parse("quarantineDuration", c.QuarantineDuration, DEFAULT_QUARANTINE_DURATION)
+	return err
+}
+
+// FlakeStats describes the flakiness of a TaskSpec over a period of time.
+type FlakeStats struct {
+	Repo string `json:"repo"`
+	Name string `json:"name"`
+
+	// Samples is the number of commits at which the TaskSpec succeeded or
+	// failed.
+	Samples int `json:"samples"`
+
+	// Flakes is the number of commits at which the TaskSpec failed and
+	// then succeeded on retry.
+	Flakes int `json:"flakes"`
+
+	// FlakeRate is Flakes / Samples.
+	FlakeRate float64 `json:"flakeRate"`
+
+	// Quarantine is the name of the blacklist rule which quarantines the
+	// TaskSpec, if any.
+	Quarantine string `json:"quarantine"`
+}
+
+// Copy returns a copy of the FlakeStats.
+func (s *FlakeStats) Copy() *FlakeStats {
+	rv := *s
+	return &rv
+}
+
+// flakeStatsSlice is a helper type for sorting FlakeStats, flakiest first.
+type flakeStatsSlice []*FlakeStats
+
+func (s flakeStatsSlice) Len() int      { return len(s) }
+func (s flakeStatsSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s flakeStatsSlice) Less(i, j int) bool {
+	if s[i].FlakeRate != s[j].FlakeRate {
+		return s[i].FlakeRate > s[j].FlakeRate
+	}
+	if s[i].Repo != s[j].Repo {
+		return s[i].Repo < s[j].Repo
+	}
+	return s[i].Name < s[j].Name
+}
+
+// flakes holds the most recently computed flake statistics.
+type flakes struct {
+	stats []*FlakeStats
+	mtx   sync.RWMutex
+}
+
+// computeFlakeStats returns FlakeStats for each TaskSpec with results among
+// the given Tasks, sorted flakiest first. Try jobs are ignored, since failures
+// may be caused by the patch under test. A commit counts as a flake if the
+// TaskSpec failed there and a later attempt at the same RepoState succeeded.
+func computeFlakeStats(tasks []*db.Task) []*FlakeStats {
+	type attempts struct {
+		firstFailure time.Time
+		lastSuccess  time.Time
+	}
+	byKey := map[db.TaskKey]*attempts{}
+	for _, t := range tasks {
+		if t.IsTryJob() {
+			continue
+		}
+		if t.Status != db.TASK_STATUS_SUCCESS && t.Status != db.TASK_STATUS_FAILURE {
+			continue
+		}
+		k := db.TaskKey{
+			RepoState: t.RepoState,
+			Name:      t.Name,
+		}
+		a, ok := byKey[k]
+		if !ok {
+			a = &attempts{}
+			byKey[k] = a
+		}
+		if t.Status == db.TASK_STATUS_FAILURE {
+			if util.TimeIsZero(a.firstFailure) || t.Created.Before(a.firstFailure) {
+				a.firstFailure = t.Created
+			}
+		} else if t.Created.After(a.lastSuccess) {
+			a.lastSuccess = t.Created
+		}
+	}
+
+	byName := map[string]map[string]*FlakeStats{}
+	rv := make([]*FlakeStats, 0, len(byKey))
+	for k, a := range byKey {
+		names, ok := byName[k.Repo]
+		if !ok {
+			names = map[string]*FlakeStats{}
+			byName[k.Repo] = names
+		}
+		s, ok := names[k.Name]
+		if !ok {
+			s = &FlakeStats{
+				Repo: k.Repo,
+				Name: k.Name,
+			}
+			names[k.Name] = s
+			rv = append(rv, s)
+		}
+		s.Samples++
+		if !util.TimeIsZero(a.firstFailure) && a.lastSuccess.After(a.firstFailure) {
+			s.Flakes++
+		}
+	}
+	for _, s := range rv {
+		s.FlakeRate = float64(s.Flakes) / float64(s.Samples)
+	}
+	sort.Sort(flakeStatsSlice(rv))
+	return rv
+}
+
+// quarantineRuleName returns the name of the automatically-created quarantine
+// rule for the given TaskSpec. TaskSpec names may be longer than
+// blacklist.MAX_NAME_CHARS, so a hash is used.
+func quarantineRuleName(repo, name string) string {
+	return fmt.Sprintf("%s%x", QUARANTINE_RULE_PREFIX, sha1.Sum([]byte(repo+name)))[:len(QUARANTINE_RULE_PREFIX)+16]
+}
+
+// updateFlakes removes expired blacklist rules, recomputes flake statistics
+// for recent Tasks, and quarantines TaskSpecs whose flake rate exceeds the
+// configured threshold.
+func (s *TaskScheduler) updateFlakes() error {
+	now := s.now()
+	removed, err := s.bl.RemoveExpiredRules(now)
+	if err != nil {
+		return err
+	}
+	for _, name := range removed {
+		sklog.Infof("Removed expired blacklist rule %q", name)
+	}
+
+	window := DEFAULT_FLAKE_WINDOW
+	if s.flakeConfig != nil {
+		window = s.flakeConfig.window
+	}
+	tasks, err := s.tCache.GetTasksFromDateRange(now.Add(-window), now)
+	if err != nil {
+		return err
+	}
+	stats := computeFlakeStats(tasks)
+	for _, st := range stats {
+		st.Quarantine = s.bl.MatchQuarantine(st.Repo, st.Name, "", now)
+		if st.Quarantine != "" || s.flakeConfig == nil {
+			continue
+		}
+		if st.Samples < s.flakeConfig.MinSamples || st.FlakeRate <= s.flakeConfig.Threshold {
+			continue
+		}
+		rule := &blacklist.Rule{
+			AddedBy:          QUARANTINE_ADDED_BY,
+			Repo:             st.Repo,
+			TaskSpecPatterns: []string{"^" + regexp.QuoteMeta(st.Name) + "$"},
+			Description:      fmt.Sprintf("%s in %s flaked at %d of %d commits (%.1f%%) in the last %s.", st.Name, st.Repo, st.Flakes, st.Samples, 100.0*st.FlakeRate, window),
+			Expires:          now.Add(s.flakeConfig.quarantineDuration),
+			Name:             quarantineRuleName(st.Repo, st.Name),
+			Quarantine:       true,
+		}
+		if err := s.bl.AddRule(rule, s.repos, now); err != nil {
+			return fmt.Errorf("Failed to quarantine %s: %s", st.Name, err)
+		}
+		sklog.Infof("Quarantined %s until %s: %s", st.Name, rule.Expires, rule.Description)
+		st.Quarantine = rule.Name
+	}
+
+	s.flakes.mtx.Lock()
+	defer s.flakes.mtx.Unlock()
+	s.flakes.stats = stats
+	return nil
+}
+
+// FlakeStats returns the most recently computed flake statistics, flakiest
+// first.
+func (s *TaskScheduler) FlakeStats() []*FlakeStats {
+	s.flakes.mtx.RLock()
+	defer s.flakes.mtx.RUnlock()
+	rv := make([]*FlakeStats, 0, len(s.flakes.stats))
+	for _, st := range s.flakes.stats {
+		rv = append(rv, st.Copy())
+	}
+	return rv
+}
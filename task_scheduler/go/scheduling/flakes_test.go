@@ -0,0 +1,184 @@
+package scheduling
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestReadFlakeConfig(t *testing.T) {
+	testutils.MediumTest(t)
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+
+	// Missing file.
+	file := path.Join(tmp, FLAKE_CONFIG_JSON_FILE)
+	cfg, err := ReadFlakeConfig(file)
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	// Valid file; durations are defaulted.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"threshold": 0.2, "minSamples": 5}`), 0644))
+	cfg, err = ReadFlakeConfig(file)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, &FlakeConfig{
+		Threshold:          0.2,
+		MinSamples:         5,
+		window:             DEFAULT_FLAKE_WINDOW,
+		quarantineDuration: DEFAULT_QUARANTINE_DURATION,
+	}, cfg)
+
+	// Valid file with durations.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"threshold": 0.2, "minSamples": 5, "window": "2d", "quarantineDuration": "12h"}`), 0644))
+	cfg, err = ReadFlakeConfig(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 48*time.Hour, cfg.window)
+	assert.Equal(t, 12*time.Hour, cfg.quarantineDuration)
+
+	// Invalid threshold.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"threshold": 1.5, "minSamples": 5}`), 0644))
+	_, err = ReadFlakeConfig(file)
+	assert.EqualError(t, err, "Flake threshold must be in (0, 1]; got 1.500000")
+
+	// Invalid minSamples.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"threshold": 0.5}`), 0644))
+	_, err = ReadFlakeConfig(file)
+	assert.EqualError(t, err, "Flake minSamples must be positive; got 0")
+}
+
+func TestComputeFlakeStats(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Now()
+	makeFinishedTask := func(name, revision string, status db.TaskStatus, created time.Time) *db.Task {
+		task := makeTask(name, "a.git", revision)
+		task.Created = created
+		task.Status = status
+		return task
+	}
+	tasks := []*db.Task{
+		// Build fails and then succeeds at c1: a flake.
+		makeFinishedTask("Build", "c1", db.TASK_STATUS_FAILURE, now),
+		makeFinishedTask("Build", "c1", db.TASK_STATUS_SUCCESS, now.Add(time.Minute)),
+		// Build succeeds at c2.
+		makeFinishedTask("Build", "c2", db.TASK_STATUS_SUCCESS, now),
+		// Build succeeds and then fails at c3: not a flake.
+		makeFinishedTask("Build", "c3", db.TASK_STATUS_SUCCESS, now),
+		makeFinishedTask("Build", "c3", db.TASK_STATUS_FAILURE, now.Add(time.Minute)),
+		// Build mishaps at c4; ignored.
+		makeFinishedTask("Build", "c4", db.TASK_STATUS_MISHAP, now),
+		// Test fails twice at c1: not a flake.
+		makeFinishedTask("Test", "c1", db.TASK_STATUS_FAILURE, now),
+		makeFinishedTask("Test", "c1", db.TASK_STATUS_FAILURE, now.Add(time.Minute)),
+	}
+	// Try jobs are ignored.
+	try := makeFinishedTask("Test", "c1", db.TASK_STATUS_SUCCESS, now.Add(2*time.Minute))
+	try.Server = "fake"
+	try.Issue = "1"
+	try.Patchset = "1"
+	tasks = append(tasks, try)
+
+	testutils.AssertDeepEqual(t, []*FlakeStats{
+		{
+			Repo:      "a.git",
+			Name:      "Build",
+			Samples:   3,
+			Flakes:    1,
+			FlakeRate: 1.0 / 3.0,
+		},
+		{
+			Repo:      "a.git",
+			Name:      "Test",
+			Samples:   1,
+			Flakes:    0,
+			FlakeRate: 0.0,
+		},
+	}, computeFlakeStats(tasks))
+}
+
+func TestUpdateFlakes(t *testing.T) {
+	gb, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	makeDummyCommits(gb, 3)
+	assert.NoError(t, s.updateRepos())
+	commits, err := s.repos[gb.RepoUrl()].Repo().RevList("HEAD")
+	assert.NoError(t, err)
+
+	// The task flakes at two of the commits.
+	now := time.Now()
+	for i, c := range commits[:4] {
+		task := makeTask(specs_testutils.BuildTask, gb.RepoUrl(), c)
+		task.Created = now.Add(-time.Duration(10-i) * time.Minute)
+		task.Status = db.TASK_STATUS_SUCCESS
+		if i%2 == 0 {
+			task.Status = db.TASK_STATUS_FAILURE
+			retry := makeTask(specs_testutils.BuildTask, gb.RepoUrl(), c)
+			retry.Created = task.Created.Add(time.Second)
+			retry.Status = db.TASK_STATUS_SUCCESS
+			assert.NoError(t, d.PutTask(retry))
+		}
+		assert.NoError(t, d.PutTask(task))
+	}
+	assert.NoError(t, s.tCache.Update())
+
+	// Without a FlakeConfig, statistics are computed but nothing is
+	// quarantined.
+	assert.NoError(t, s.updateFlakes())
+	stats := s.FlakeStats()
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, 4, stats[0].Samples)
+	assert.Equal(t, 2, stats[0].Flakes)
+	assert.Equal(t, "", stats[0].Quarantine)
+	assert.Equal(t, "", s.bl.MatchQuarantine(gb.RepoUrl(), specs_testutils.BuildTask, commits[0], now))
+
+	// The flake rate is below the threshold.
+	s.flakeConfig = &FlakeConfig{
+		Threshold:  0.6,
+		MinSamples: 2,
+	}
+	assert.NoError(t, s.flakeConfig.Validate())
+	assert.NoError(t, s.updateFlakes())
+	assert.Equal(t, "", s.FlakeStats()[0].Quarantine)
+
+	// Too few samples.
+	s.flakeConfig.Threshold = 0.4
+	s.flakeConfig.MinSamples = 5
+	assert.NoError(t, s.updateFlakes())
+	assert.Equal(t, "", s.FlakeStats()[0].Quarantine)
+
+	// Quarantine the task. It is still scheduled, but its failures are
+	// not blocking.
+	s.flakeConfig.MinSamples = 4
+	assert.NoError(t, s.updateFlakes())
+	name := quarantineRuleName(gb.RepoUrl(), specs_testutils.BuildTask)
+	assert.Equal(t, name, s.FlakeStats()[0].Quarantine)
+	assert.Equal(t, name, s.bl.MatchQuarantine(gb.RepoUrl(), specs_testutils.BuildTask, commits[0], now))
+	assert.Equal(t, "", s.bl.MatchRule(gb.RepoUrl(), specs_testutils.BuildTask, commits[0], now))
+	assert.Equal(t, "", s.bl.MatchQuarantine(gb.RepoUrl(), specs_testutils.TestTask, commits[0], now))
+	// The quarantine only applies to the repo in which the task flaked.
+	assert.Equal(t, "", s.bl.MatchQuarantine("other.git", specs_testutils.BuildTask, commits[0], now))
+	rule := s.bl.Rules[name]
+	assert.True(t, rule.Quarantine)
+	assert.True(t, rule.Expires.After(now.Add(DEFAULT_QUARANTINE_DURATION-time.Minute)))
+
+	// Updating again doesn't add a duplicate rule.
+	assert.NoError(t, s.updateFlakes())
+	assert.Equal(t, name, s.FlakeStats()[0].Quarantine)
+
+	// The rule is removed once it expires.
+	s.now = func() time.Time {
+		return now.Add(2 * DEFAULT_QUARANTINE_DURATION)
+	}
+	s.flakeConfig = nil
+	assert.NoError(t, s.updateFlakes())
+	_, ok := s.bl.Rules[name]
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(s.FlakeStats()))
+}
@@ -2,35 +2,30 @@ package main
 
 /*
 	Performance test for TaskScheduler.
+
+	Replays a synthetic history with lots of TaskSpecs, commits and bots
+	through the simulator, with the profiler running.
 */
 
 import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"path"
-	"reflect"
+	"strings"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
-	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/depot_tools"
 	"go.skia.org/infra/go/exec"
-	"go.skia.org/infra/go/git/repograph"
-	"go.skia.org/infra/go/isolate"
 	"go.skia.org/infra/go/sklog"
 	"go.skia.org/infra/go/swarming"
 	"go.skia.org/infra/task_scheduler/go/db"
-	"go.skia.org/infra/task_scheduler/go/db/local_db"
-	"go.skia.org/infra/task_scheduler/go/scheduling"
+	"go.skia.org/infra/task_scheduler/go/scheduling/simulator"
 	"go.skia.org/infra/task_scheduler/go/specs"
-	"go.skia.org/infra/task_scheduler/go/tryjobs"
-	"go.skia.org/infra/task_scheduler/go/window"
 )
 
 func assertNoError(err error) {
@@ -39,33 +34,7 @@ func assertNoError(err error) {
 	}
 }
 
-func assertEqual(a, b interface{}) {
-	if a != b {
-		sklog.Fatalf("Expected %v but got %v", a, b)
-	}
-}
-
-func assertDeepEqual(a, b interface{}) {
-	if !reflect.DeepEqual(a, b) {
-		sklog.Fatalf("Objects do not match: \na:\n%s\n\nb:\n%s\n", spew.Sprint(a), spew.Sprint(b))
-	}
-}
-
-func makeBot(id string, dims map[string]string) *swarming_api.SwarmingRpcsBotInfo {
-	dimensions := make([]*swarming_api.SwarmingRpcsStringListPair, 0, len(dims))
-	for k, v := range dims {
-		dimensions = append(dimensions, &swarming_api.SwarmingRpcsStringListPair{
-			Key:   k,
-			Value: []string{v},
-		})
-	}
-	return &swarming_api.SwarmingRpcsBotInfo{
-		BotId:      id,
-		Dimensions: dimensions,
-	}
-}
-
-var commitDate = time.Unix(1472647568, 0)
+var commitDate = time.Unix(1472647568, 0).UTC()
 
 func commit(repoDir, message string) {
 	assertNoError(exec.Run(&exec.Command{
@@ -80,17 +49,13 @@ func commit(repoDir, message string) {
 }
 
 func makeDummyCommits(repoDir string, numCommits int) {
-	_, err := exec.RunCwd(repoDir, "git", "checkout", "master")
-	assertNoError(err)
 	dummyFile := path.Join(repoDir, "dummyfile.txt")
 	for i := 0; i < numCommits; i++ {
 		title := fmt.Sprintf("Dummy #%d", i)
 		assertNoError(ioutil.WriteFile(dummyFile, []byte(title), os.ModePerm))
-		_, err = exec.RunCwd(repoDir, "git", "add", dummyFile)
+		_, err := exec.RunCwd(repoDir, "git", "add", dummyFile)
 		assertNoError(err)
 		commit(repoDir, title)
-		_, err = exec.RunCwd(repoDir, "git", "push", "origin", "master")
-		assertNoError(err)
 	}
 }
 
@@ -121,7 +86,6 @@ func main() {
 	repoDir := path.Join(workdir, repoName)
 	assertNoError(os.Mkdir(path.Join(workdir, repoName), os.ModePerm))
 	run(repoDir, "git", "init")
-	run(repoDir, "git", "remote", "add", "origin", ".")
 
 	// Write some files.
 	assertNoError(ioutil.WriteFile(path.Join(workdir, ".gclient"), []byte("dummy"), os.ModePerm))
@@ -228,104 +192,43 @@ func main() {
 	assertNoError(f.Close())
 	run(repoDir, "git", "add", specs.TASKS_CFG_FILE)
 	commit(repoDir, "Add more tasks!")
-	run(repoDir, "git", "push", "origin", "master")
-	run(repoDir, "git", "branch", "-u", "origin/master")
-
-	// Create a bunch of bots.
-	bots := make([]*swarming_api.SwarmingRpcsBotInfo, 100)
-	for idx, _ := range bots {
-		dims := map[string]string{
-			"pool": "Skia",
-		}
-		if idx >= 50 {
-			dims["os"] = "Ubuntu"
-		} else {
-			dims["os"] = "Android"
-			dims["device_type"] = "grouper"
-		}
-		bots[idx] = makeBot(fmt.Sprintf("bot%d", idx), dims)
-	}
-
-	// Create the task scheduler.
-	repo, err := repograph.NewGraph(repoName, workdir)
-	assertNoError(err)
-	head, err := repo.Repo().RevParse("HEAD")
-	assertNoError(err)
-
-	commits, err := repo.Repo().RevList(head)
-	assertNoError(err)
-	assertDeepEqual([]string{head}, commits)
-
-	d, err := local_db.NewDB("testdb", path.Join(workdir, "tasks.db"))
-	assertNoError(err)
-	w, err := window.New(time.Hour, 0, nil)
-	assertNoError(err)
-	tCache, err := db.NewTaskCache(d, w)
-	assertNoError(err)
-	// Use dummy GetRevisionTimestamp function so that nothing ever expires from
-	// the cache.
-	dummyGetRevisionTimestamp := func(string, string) (time.Time, error) {
-		return time.Now(), nil
-	}
-	jCache, err := db.NewJobCache(d, w, dummyGetRevisionTimestamp)
-	assertNoError(err)
-
-	isolateClient, err := isolate.NewClient(workdir, isolate.ISOLATE_SERVER_URL_FAKE)
-	assertNoError(err)
-	swarmingClient := swarming.NewTestClient()
-	depotTools, err := depot_tools.Find()
-	assertNoError(err)
-	s, err := scheduling.NewTaskScheduler(d, time.Duration(math.MaxInt64), 0, workdir, "fake.server", repograph.Map{repoName: repo}, isolateClient, swarmingClient, http.DefaultClient, 0.9, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, map[string]string{"skia": repoName}, swarming.POOLS_PUBLIC, "", depotTools)
+	out, err := exec.RunCwd(repoDir, "git", "rev-parse", "HEAD")
 	assertNoError(err)
+	head := strings.TrimSpace(out)
+	start := commitDate.Add(-10 * time.Second)
 
-	runTasks := func(bots []*swarming_api.SwarmingRpcsBotInfo) {
-		swarmingClient.MockBots(bots)
-		assertNoError(s.MainLoop())
-		assertNoError(w.Update())
-		assertNoError(tCache.Update())
-		tasks, err := tCache.GetTasksForCommits(repoName, commits)
-		assertNoError(err)
-		newTasks := map[string]*db.Task{}
-		for _, v := range tasks {
-			for _, task := range v {
-				if task.Status == db.TASK_STATUS_PENDING {
-					if _, ok := newTasks[task.Id]; !ok {
-						newTasks[task.Id] = task
-					}
-				}
-			}
-		}
-		insert := make([]*db.Task, 0, len(newTasks))
-		for _, task := range newTasks {
-			task.Status = db.TASK_STATUS_SUCCESS
-			task.Finished = time.Now()
-			task.IsolatedOutput = "abc123"
-			insert = append(insert, task)
-		}
-		assertNoError(d.PutTasks(insert))
-		assertNoError(tCache.Update())
-		assertNoError(jCache.Update())
-	}
+	// Add more commits to the repo.
+	makeDummyCommits(repoDir, 200)
 
-	// Consume all tasks.
-	for {
-		runTasks(bots)
-		unfinished, err := jCache.UnfinishedJobs()
-		assertNoError(err)
-		sklog.Infof("Found %d unfinished jobs.", len(unfinished))
-		if len(unfinished) == 0 {
-			tasks, err := tCache.GetTasksForCommits(repoName, commits)
-			assertNoError(err)
-			assertEqual(s.QueueLen(), 0)
-			assertEqual(len(moarTasks), len(tasks[head]))
-			break
+	// Create a bunch of bots. The simulator simulates the bots which ran
+	// recorded Tasks, so record one Task for each bot at the first commit.
+	recorded := db.NewInMemoryDB()
+	for idx := 0; idx < 100; idx++ {
+		name := "Build-Ubuntu-GCC-Arm7-Release-Android0"
+		if idx < 50 {
+			name = "Test-Android-GCC-Nexus7-GPU-Tegra3-Arm7-Release0"
 		}
+		assertNoError(recorded.PutTask(&db.Task{
+			Commits:       []string{head},
+			Created:       start,
+			Started:       start,
+			Finished:      start.Add(time.Minute),
+			Status:        db.TASK_STATUS_SUCCESS,
+			SwarmingBotId: fmt.Sprintf("bot%d", idx),
+			TaskKey: db.TaskKey{
+				RepoState: db.RepoState{
+					Repo:     repoDir,
+					Revision: head,
+				},
+				Name: name,
+			},
+		}))
 	}
 
-	// Add more commits to the repo.
-	makeDummyCommits(repoDir, 200)
-	commits, err = repo.Repo().RevList(fmt.Sprintf("%s..HEAD", head))
+	depotTools, err := depot_tools.Find()
 	assertNoError(err)
+	simDir := path.Join(workdir, "simulator")
+	assertNoError(os.Mkdir(simDir, os.ModePerm))
 
 	// Start the profiler.
 	go func() {
@@ -333,12 +236,20 @@ func main() {
 	}()
 
 	// Actually run the test.
-	i := 0
-	for ; ; i++ {
-		runTasks(bots)
-		if s.QueueLen() == 0 {
-			break
-		}
-	}
-	sklog.Infof("Finished in %d iterations.", i)
+	begin := time.Now()
+	res, err := simulator.Run(&simulator.Config{
+		Recorded:         recorded,
+		Repos:            []string{repoDir},
+		Start:            start,
+		End:              commitDate.Add(10 * time.Minute),
+		Tick:             10 * time.Second,
+		TimeDecayAmt24Hr: 0.9,
+		Period:           24 * time.Hour,
+		NumCommits:       0,
+		Pools:            swarming.POOLS_PUBLIC,
+		DepotTools:       depotTools,
+		Workdir:          simDir,
+	})
+	assertNoError(err)
+	sklog.Infof("Finished in %s.\n%s", time.Now().Sub(begin), res.Simulated)
 }
@@ -157,7 +157,7 @@ func (s *TaskScheduler) triggerPeriodicJobs() error {
 		for rs, cfg := range cfgs {
 			for name, spec := range cfg.Jobs {
 				if spec.Trigger == trigger {
-					j, err := s.taskCfgCache.MakeJob(rs, name)
+					j, err := s.taskCfgCache.MakeJob(rs, name, s.now())
 					if err != nil {
 						return err
 					}
@@ -0,0 +1,159 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+// Stats summarizes a set of values.
+type Stats struct {
+	Count  int
+	Mean   float64
+	Median float64
+	P90    float64
+	Max    float64
+}
+
+// newStats returns a Stats instance summarizing the given values.
+func newStats(vals []float64) Stats {
+	if len(vals) == 0 {
+		return Stats{}
+	}
+	sorted := make([]float64, len(vals))
+	copy(sorted, vals)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	percentile := func(p float64) float64 {
+		idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return sorted[idx]
+	}
+	return Stats{
+		Count:  len(sorted),
+		Mean:   sum / float64(len(sorted)),
+		Median: percentile(0.5),
+		P90:    percentile(0.9),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// String returns a human-readable summary of the Stats.
+func (s Stats) String() string {
+	return fmt.Sprintf("n=%d mean=%.1f median=%.1f p90=%.1f max=%.1f", s.Count, s.Mean, s.Median, s.P90, s.Max)
+}
+
+// commit is a commit which landed during the replayed period.
+type commit struct {
+	Repo      string
+	Hash      string
+	Timestamp time.Time
+}
+
+// Report summarizes the behavior of the scheduler over a period of time.
+type Report struct {
+	// Tasks is the number of non-try-job Tasks created during the period.
+	Tasks int
+
+	// Latency describes the time, in minutes, from each commit landing
+	// until the first result of each TaskSpec whose blamelist includes
+	// that commit.
+	Latency Stats
+
+	// NoResult is the number of (commit, TaskSpec) pairs for which there
+	// was no result by the end of the period.
+	NoResult int
+
+	// Blamelist describes the number of commits covered by each Task.
+	Blamelist Stats
+
+	// Utilization is the fraction of the available bot time spent running
+	// Tasks.
+	Utilization float64
+}
+
+// String returns a human-readable summary of the Report.
+func (r *Report) String() string {
+	return fmt.Sprintf("Tasks: %d\nLatency to first result (minutes): %s\nNo result: %d\nBlamelist length: %s\nBot utilization: %.1f%%", r.Tasks, r.Latency, r.NoResult, r.Blamelist, 100.0*r.Utilization)
+}
+
+// makeReport returns a Report describing the given Tasks, which were created
+// between start and end. Latency is computed for each of the given commits and
+// TaskSpec names, and utilization is computed for the given number of bots.
+func makeReport(tasks []*db.Task, commits []*commit, names util.StringSet, start, end time.Time, numBots int) *Report {
+	// Find the earliest result for each commit and TaskSpec.
+	firstResult := map[string]map[string]map[string]time.Time{}
+	blamelists := make([]float64, 0, len(tasks))
+	busy := time.Duration(0)
+	for _, t := range tasks {
+		if t.IsTryJob() {
+			continue
+		}
+		blamelists = append(blamelists, float64(len(t.Commits)))
+		if !util.TimeIsZero(t.Started) {
+			s := t.Started
+			if s.Before(start) {
+				s = start
+			}
+			f := t.Finished
+			if util.TimeIsZero(f) || f.After(end) {
+				f = end
+			}
+			if f.After(s) {
+				busy += f.Sub(s)
+			}
+		}
+		if !t.Done() || util.TimeIsZero(t.Finished) || t.Finished.After(end) {
+			continue
+		}
+		byCommit, ok := firstResult[t.Repo]
+		if !ok {
+			byCommit = map[string]map[string]time.Time{}
+			firstResult[t.Repo] = byCommit
+		}
+		for _, hash := range t.Commits {
+			byName, ok := byCommit[hash]
+			if !ok {
+				byName = map[string]time.Time{}
+				byCommit[hash] = byName
+			}
+			if prev, ok := byName[t.Name]; !ok || t.Finished.Before(prev) {
+				byName[t.Name] = t.Finished
+			}
+		}
+	}
+
+	latencies := make([]float64, 0, len(commits)*len(names))
+	noResult := 0
+	for _, c := range commits {
+		for name, _ := range names {
+			ts, ok := firstResult[c.Repo][c.Hash][name]
+			if !ok {
+				noResult++
+				continue
+			}
+			latencies = append(latencies, ts.Sub(c.Timestamp).Minutes())
+		}
+	}
+
+	utilization := 0.0
+	if numBots > 0 && end.After(start) {
+		utilization = float64(busy) / (float64(numBots) * float64(end.Sub(start)))
+	}
+	return &Report{
+		Tasks:       len(blamelists),
+		Latency:     newStats(latencies),
+		NoResult:    noResult,
+		Blamelist:   newStats(blamelists),
+		Utilization: utilization,
+	}
+}
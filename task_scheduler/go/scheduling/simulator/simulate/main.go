@@ -0,0 +1,108 @@
+// Replay recorded Task Scheduler history through the scheduler with different
+// parameters and compare the results. Only the time decay is configurable
+// here; alternative testedness functions may be evaluated by setting
+// simulator.Config.Testedness.
+//
+// Example:
+//   db_restore --time=2017-01-03T00:00:00Z --out=/tmp/recorded.bdb
+//   simulate --db=/tmp/recorded.bdb --start=2017-01-02T00:00:00Z \
+//       --end=2017-01-02T12:00:00Z --scoreDecay24Hr=0.5
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/depot_tools"
+	"go.skia.org/infra/go/human"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/swarming"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db/local_db"
+	"go.skia.org/infra/task_scheduler/go/scheduling/simulator"
+)
+
+var (
+	commitWindow   = flag.Int("commitWindow", 10, "Minimum number of recent commits to keep in the timeWindow.")
+	dbFile         = flag.String("db", "", "local_db file containing the recorded history, eg. as written by db_restore.")
+	endTime        = flag.String("end", "", "RFC3339 timestamp at which to end the simulation.")
+	repoUrls       = common.NewMultiStringFlag("repo", nil, "Repositories whose history to replay. May be local paths.")
+	scoreDecay24Hr = flag.Float64("scoreDecay24Hr", 0.9, "Task candidate scores are penalized using linear time decay. This is the desired value after 24 hours.")
+	startTime      = flag.String("start", "", "RFC3339 timestamp at which to start the simulation.")
+	swarmingPools  = common.NewMultiStringFlag("pool", swarming.POOLS_PUBLIC, "Which Swarming pools to use.")
+	tick           = flag.Duration("tick", time.Minute, "Simulated time between scheduling loops.")
+	timePeriod     = flag.String("timeWindow", "4d", "Time period to use.")
+	workdir        = flag.String("workdir", "", "Working directory to use. If not set, a temporary directory is used and removed afterward.")
+)
+
+func main() {
+	defer common.LogPanic()
+
+	// Global init.
+	common.Init()
+
+	if *dbFile == "" || *startTime == "" || *endTime == "" {
+		sklog.Fatal("--db, --start, and --end are required.")
+	}
+	start, err := time.Parse(time.RFC3339, *startTime)
+	if err != nil {
+		sklog.Fatalf("Invalid --start: %s", err)
+	}
+	end, err := time.Parse(time.RFC3339, *endTime)
+	if err != nil {
+		sklog.Fatalf("Invalid --end: %s", err)
+	}
+	period, err := human.ParseDuration(*timePeriod)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	if *repoUrls == nil {
+		*repoUrls = []string{common.REPO_SKIA}
+	}
+
+	wd := *workdir
+	if wd == "" {
+		wd, err = ioutil.TempDir("", "simulate")
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		defer util.RemoveAll(wd)
+	}
+	wd, err = filepath.Abs(wd)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	depotTools, err := depot_tools.Find()
+	if err != nil {
+		sklog.Fatal(err)
+	}
+
+	d, err := local_db.NewDB("recorded", *dbFile)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	defer util.Close(d)
+
+	res, err := simulator.Run(&simulator.Config{
+		Recorded:         d,
+		Repos:            *repoUrls,
+		Start:            start,
+		End:              end,
+		Tick:             *tick,
+		TimeDecayAmt24Hr: *scoreDecay24Hr,
+		Period:           period,
+		NumCommits:       *commitWindow,
+		Pools:            *swarmingPools,
+		DepotTools:       depotTools,
+		Workdir:          wd,
+	})
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	fmt.Fprintf(os.Stdout, "Recorded:\n%s\n\nSimulated:\n%s\n", res.Recorded, res.Simulated)
+}
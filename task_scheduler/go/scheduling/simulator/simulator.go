@@ -0,0 +1,667 @@
+// Package simulator replays recorded history through the TaskScheduler, so
+// that the effects of changes to the scheduling parameters can be evaluated
+// before they are deployed. Commits land at the times they were originally
+// committed and the bots which ran the recorded Tasks are simulated, while
+// Swarming and Isolate are faked and simulated time is used in place of the
+// wall clock.
+package simulator
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/git/repograph"
+	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/scheduling"
+	"go.skia.org/infra/task_scheduler/go/specs"
+	"go.skia.org/infra/task_scheduler/go/tryjobs"
+)
+
+const (
+	// DEFAULT_TASK_DURATION is the simulated duration of Tasks for which
+	// there is no recorded history.
+	DEFAULT_TASK_DURATION = 10 * time.Minute
+
+	// RECORDED_REF is the ref in each simulated origin repo which points
+	// to the most recent recorded commit. The master branch is moved along
+	// the history of this ref as simulated time passes.
+	RECORDED_REF = "refs/simulator/master"
+)
+
+// Config describes a simulation.
+type Config struct {
+	// Recorded contains the recorded history, eg. a local_db restored by
+	// db_restore. It is not modified.
+	Recorded db.RemoteDB
+
+	// Repos are the URLs of the repos whose history is replayed.
+	Repos []string
+
+	// Start and End define the period to replay.
+	Start time.Time
+	End   time.Time
+
+	// Tick is the amount of simulated time between scheduling loops.
+	Tick time.Duration
+
+	// The following are passed to scheduling.NewTaskScheduler.
+	TimeDecayAmt24Hr float64
+	Period           time.Duration
+	NumCommits       int
+	Pools            []string
+	DepotTools       string
+
+	// Testedness, if not nil, replaces the function used by the
+	// TaskScheduler to compute the "testedness" of a blamelist of N
+	// commits, see TaskScheduler.SetTestedness. The fixed scores given to
+	// forced and bisect candidates are not varied; try jobs are not
+	// simulated.
+	Testedness func(int) float64
+
+	// Workdir is the directory in which to create repos and other files.
+	Workdir string
+}
+
+// Result describes the recorded and simulated history.
+type Result struct {
+	Recorded  *Report
+	Simulated *Report
+}
+
+// originRepo is a git repo from which the TaskScheduler syncs, whose master
+// branch only contains commits which have landed as of the simulated time.
+type originRepo struct {
+	dir     string
+	commits []*commit // First-parent history, oldest first.
+	head    string
+}
+
+// newOriginRepo fetches the master branch of the given repo into a new bare
+// repo in dir and returns an originRepo instance.
+func newOriginRepo(repoUrl, dir string) (*originRepo, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if _, err := exec.RunCwd(dir, "git", "init", "--bare"); err != nil {
+		return nil, err
+	}
+	if _, err := exec.RunCwd(dir, "git", "fetch", repoUrl, "+refs/heads/master:"+RECORDED_REF); err != nil {
+		return nil, err
+	}
+	out, err := exec.RunCwd(dir, "git", "rev-list", "--first-parent", "--timestamp", RECORDED_REF)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	commits := make([]*commit, 0, len(lines))
+	for i := len(lines) - 1; i >= 0; i-- {
+		fields := strings.Fields(lines[i])
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid output from git rev-list: %q", lines[i])
+		}
+		ts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid output from git rev-list: %q", lines[i])
+		}
+		commits = append(commits, &commit{
+			Repo:      dir,
+			Hash:      fields[1],
+			Timestamp: time.Unix(ts, 0).UTC(),
+		})
+	}
+	return &originRepo{
+		dir:     dir,
+		commits: commits,
+	}, nil
+}
+
+// update moves the master branch to the most recent commit which landed at or
+// before now.
+func (r *originRepo) update(now time.Time) error {
+	idx := sort.Search(len(r.commits), func(i int) bool {
+		return r.commits[i].Timestamp.After(now)
+	}) - 1
+	if idx < 0 {
+		return fmt.Errorf("No commits in %s at or before %s.", r.dir, now)
+	}
+	if r.commits[idx].Hash == r.head {
+		return nil
+	}
+	if _, err := exec.RunCwd(r.dir, "git", "update-ref", "refs/heads/master", r.commits[idx].Hash); err != nil {
+		return err
+	}
+	r.head = r.commits[idx].Hash
+	return nil
+}
+
+// landed returns the commits which landed after start and at or before end.
+func (r *originRepo) landed(start, end time.Time) []*commit {
+	rv := []*commit{}
+	for _, c := range r.commits {
+		if c.Timestamp.After(start) && !c.Timestamp.After(end) {
+			rv = append(rv, c)
+		}
+	}
+	return rv
+}
+
+// simBot is a simulated Swarming bot.
+type simBot struct {
+	dimensions map[string]util.StringSet
+	id         string
+	task       *simTask
+}
+
+// matches returns true iff the bot has all of the given dimensions.
+func (b *simBot) matches(dims []*swarming_api.SwarmingRpcsStringPair) bool {
+	for _, d := range dims {
+		if !b.dimensions[d.Key][d.Value] {
+			return false
+		}
+	}
+	return true
+}
+
+// botInfo returns a swarming_api.SwarmingRpcsBotInfo describing the bot.
+func (b *simBot) botInfo() *swarming_api.SwarmingRpcsBotInfo {
+	keys := make([]string, 0, len(b.dimensions))
+	for k, _ := range b.dimensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dims := make([]*swarming_api.SwarmingRpcsStringListPair, 0, len(keys))
+	for _, k := range keys {
+		vals := b.dimensions[k].Keys()
+		sort.Strings(vals)
+		dims = append(dims, &swarming_api.SwarmingRpcsStringListPair{
+			Key:   k,
+			Value: vals,
+		})
+	}
+	rv := &swarming_api.SwarmingRpcsBotInfo{
+		BotId:      b.id,
+		Dimensions: dims,
+	}
+	if b.task != nil {
+		rv.TaskId = b.task.swarmingId
+	}
+	return rv
+}
+
+// makeBots returns simulated bots for each bot which ran any of the given
+// Tasks. The dimensions of each bot are the union of the dimensions of the
+// TaskSpecs it ran.
+func makeBots(tasks []*db.Task, getTaskSpec func(db.RepoState, string) (*specs.TaskSpec, error)) []*simBot {
+	byId := map[string]*simBot{}
+	for _, t := range tasks {
+		if t.SwarmingBotId == "" {
+			continue
+		}
+		spec, err := getTaskSpec(t.RepoState, t.Name)
+		if err != nil {
+			sklog.Warningf("Unable to find TaskSpec %s at %s; ignoring Task %s: %s", t.Name, t.Revision, t.Id, err)
+			continue
+		}
+		bot, ok := byId[t.SwarmingBotId]
+		if !ok {
+			bot = &simBot{
+				dimensions: map[string]util.StringSet{},
+				id:         t.SwarmingBotId,
+			}
+			byId[t.SwarmingBotId] = bot
+		}
+		for _, d := range spec.Dimensions {
+			split := strings.SplitN(d, ":", 2)
+			if len(split) != 2 {
+				continue
+			}
+			if _, ok := bot.dimensions[split[0]]; !ok {
+				bot.dimensions[split[0]] = util.StringSet{}
+			}
+			bot.dimensions[split[0]][split[1]] = true
+		}
+	}
+	rv := make([]*simBot, 0, len(byId))
+	for _, b := range byId {
+		rv = append(rv, b)
+	}
+	sort.Sort(simBotSlice(rv))
+	return rv
+}
+
+// simBotSlice implements sort.Interface.
+type simBotSlice []*simBot
+
+func (s simBotSlice) Len() int           { return len(s) }
+func (s simBotSlice) Less(i, j int) bool { return s[i].id < s[j].id }
+func (s simBotSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// simTask is a Task triggered during the simulation.
+type simTask struct {
+	bot        *simBot
+	dimensions []*swarming_api.SwarmingRpcsStringPair
+	finish     time.Time
+	id         string
+	name       string
+	status     db.TaskStatus
+	swarmingId string
+}
+
+// simTaskSlice implements sort.Interface, ordering by name.
+type simTaskSlice []*simTask
+
+func (s simTaskSlice) Len() int           { return len(s) }
+func (s simTaskSlice) Less(i, j int) bool { return s[i].name < s[j].name }
+func (s simTaskSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// medianDurations returns the median duration of the given finished Tasks for
+// each TaskSpec name.
+func medianDurations(tasks []*db.Task) map[string]time.Duration {
+	byName := map[string][]float64{}
+	for _, t := range tasks {
+		if !t.Done() || util.TimeIsZero(t.Started) || !t.Finished.After(t.Started) {
+			continue
+		}
+		byName[t.Name] = append(byName[t.Name], float64(t.Finished.Sub(t.Started)))
+	}
+	rv := make(map[string]time.Duration, len(byName))
+	for name, durations := range byName {
+		rv[name] = time.Duration(newStats(durations).Median)
+	}
+	return rv
+}
+
+// findRecordedTask returns the recorded Task with the same name as t whose
+// blamelist includes t's revision. It prefers a recorded Task with the same
+// attempt number, followed by the last recorded attempt. Returns nil if there
+// is no such Task.
+func findRecordedTask(recorded []*db.Task, t *db.Task) *db.Task {
+	var rv *db.Task
+	for _, r := range recorded {
+		if r.Repo != t.Repo || r.Name != t.Name {
+			continue
+		}
+		if r.Revision != t.Revision && !util.In(t.Revision, r.Commits) {
+			continue
+		}
+		if rv == nil || rv.Attempt != t.Attempt && (r.Attempt == t.Attempt || r.Attempt > rv.Attempt) {
+			rv = r
+		}
+	}
+	return rv
+}
+
+// simulator contains the state of a simulation.
+type simulator struct {
+	bots      []*simBot
+	d         db.DB
+	durations map[string]time.Duration
+	known     map[string]bool
+	now       time.Time
+	pending   []*simTask
+	recorded  map[string][]*db.Task
+	running   []*simTask
+	swarming  *swarmingClient
+	trackId   string
+}
+
+// clock returns the simulated time.
+func (s *simulator) clock() time.Time {
+	return s.now
+}
+
+// outcome returns the duration and result of the given simulated Task. If a
+// recorded Task covers the same commit, its duration and result are used.
+// Otherwise the Task succeeds after the median duration of recorded Tasks with
+// the same name.
+func (s *simulator) outcome(t *db.Task) (time.Duration, db.TaskStatus) {
+	if r := findRecordedTask(s.recorded[t.Name], t); r != nil && r.Done() && !util.TimeIsZero(r.Started) && r.Finished.After(r.Started) {
+		return r.Finished.Sub(r.Started), r.Status
+	}
+	d, ok := s.durations[t.Name]
+	if !ok {
+		d = DEFAULT_TASK_DURATION
+	}
+	return d, db.TASK_STATUS_SUCCESS
+}
+
+// finishTasks marks simulated Tasks which have completed as of the simulated
+// time as finished.
+func (s *simulator) finishTasks() error {
+	running := make([]*simTask, 0, len(s.running))
+	for _, st := range s.running {
+		if st.finish.After(s.now) {
+			running = append(running, st)
+			continue
+		}
+		t, err := s.d.GetTaskById(st.id)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("No such task: %s", st.id)
+		}
+		t.Status = st.status
+		t.Finished = st.finish
+		if st.status != db.TASK_STATUS_MISHAP {
+			t.IsolatedOutput = fmt.Sprintf("%x", sha1.Sum([]byte(t.Id)))
+		}
+		if err := s.d.PutTask(t); err != nil {
+			return err
+		}
+		s.swarming.setTaskState(st.swarmingId, func(task *swarming_api.SwarmingRpcsTaskRequestMetadata) {
+			task.TaskResult.State = db.SWARMING_STATE_COMPLETED
+		})
+		st.bot.task = nil
+	}
+	s.running = running
+	return nil
+}
+
+// startTasks starts pending simulated Tasks on free bots.
+func (s *simulator) startTasks() error {
+	pending := make([]*simTask, 0, len(s.pending))
+	for _, st := range s.pending {
+		var bot *simBot
+		for _, b := range s.bots {
+			if b.task == nil && b.matches(st.dimensions) {
+				bot = b
+				break
+			}
+		}
+		if bot == nil {
+			pending = append(pending, st)
+			continue
+		}
+		t, err := s.d.GetTaskById(st.id)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("No such task: %s", st.id)
+		}
+		duration, status := s.outcome(t)
+		t.Started = s.now
+		t.Status = db.TASK_STATUS_RUNNING
+		t.SwarmingBotId = bot.id
+		if err := s.d.PutTask(t); err != nil {
+			return err
+		}
+		s.swarming.setTaskState(st.swarmingId, func(task *swarming_api.SwarmingRpcsTaskRequestMetadata) {
+			task.TaskResult.BotId = bot.id
+			task.TaskResult.State = db.SWARMING_STATE_RUNNING
+		})
+		st.bot = bot
+		st.finish = s.now.Add(duration)
+		st.status = status
+		bot.task = st
+		s.running = append(s.running, st)
+	}
+	s.pending = pending
+	return nil
+}
+
+// collectTriggeredTasks finds the Tasks triggered by the TaskScheduler since
+// the last call and adds them to the pending queue.
+func (s *simulator) collectTriggeredTasks() error {
+	tasks, err := s.d.GetModifiedTasks(s.trackId)
+	if err != nil {
+		return err
+	}
+	triggered := []*simTask{}
+	for _, t := range tasks {
+		if t.Status != db.TASK_STATUS_PENDING || s.known[t.Id] {
+			continue
+		}
+		s.known[t.Id] = true
+		md, err := s.swarming.GetTaskMetadata(t.SwarmingTaskId)
+		if err != nil {
+			return err
+		}
+		triggered = append(triggered, &simTask{
+			dimensions: md.Request.Properties.Dimensions,
+			id:         t.Id,
+			name:       t.Name,
+			swarmingId: t.SwarmingTaskId,
+		})
+	}
+	sort.Sort(simTaskSlice(triggered))
+	s.pending = append(s.pending, triggered...)
+	return nil
+}
+
+// botInfos returns the current state of the simulated bots.
+func (s *simulator) botInfos() []*swarming_api.SwarmingRpcsBotInfo {
+	rv := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(s.bots))
+	for _, b := range s.bots {
+		rv = append(rv, b.botInfo())
+	}
+	return rv
+}
+
+// seed inserts the recorded Tasks and Jobs which were created before the
+// simulation start into the DB, as of the simulation start. Tasks which had
+// not finished by then are omitted, so that the TaskScheduler may schedule
+// them again.
+func (s *simulator) seed(start time.Time, tasks []*db.Task, jobs []*db.Job) error {
+	seeded := map[string]bool{}
+	putTasks := []*db.Task{}
+	for _, t := range tasks {
+		if !t.Created.Before(start) || !t.Done() || t.Finished.After(start) {
+			continue
+		}
+		t = t.Copy()
+		t.DbModified = time.Time{}
+		putTasks = append(putTasks, t)
+		seeded[t.Id] = true
+	}
+	if err := s.d.PutTasks(putTasks); err != nil {
+		return err
+	}
+	putJobs := []*db.Job{}
+	for _, j := range jobs {
+		if !j.Created.Before(start) {
+			continue
+		}
+		j = j.Copy()
+		j.DbModified = time.Time{}
+		if !j.Done() || j.Finished.After(start) {
+			j.Status = db.JOB_STATUS_IN_PROGRESS
+			j.Finished = time.Time{}
+			for name, summaries := range j.Tasks {
+				keep := []*db.TaskSummary{}
+				for _, summary := range summaries {
+					if seeded[summary.Id] {
+						keep = append(keep, summary)
+					}
+				}
+				j.Tasks[name] = keep
+			}
+		}
+		putJobs = append(putJobs, j)
+	}
+	sklog.Infof("Seeding DB with %d Tasks and %d Jobs.", len(putTasks), len(putJobs))
+	return s.d.PutJobs(putJobs)
+}
+
+// Run replays the recorded history described by cfg and returns Reports
+// describing the recorded and simulated results.
+//
+// Tasks and Jobs created before cfg.Start are copied from the recorded DB as
+// of cfg.Start. Each bot which ran a recorded Task between cfg.Start and
+// cfg.End is simulated for the entire period. Simulated Tasks which cover a
+// commit covered by a recorded Task take the same time and have the same
+// result as the recorded Task; others succeed after the median recorded
+// duration. Try jobs and periodic jobs are not simulated.
+func Run(cfg *Config) (*Result, error) {
+	if !cfg.End.After(cfg.Start) {
+		return nil, fmt.Errorf("End time %s must be after start time %s.", cfg.End, cfg.Start)
+	}
+	if cfg.Tick <= 0 {
+		return nil, fmt.Errorf("Tick must be positive.")
+	}
+
+	// Set up the repos. Recorded Tasks and Jobs refer to the original repo
+	// URLs, which are replaced by the simulated origin repos.
+	repos := repograph.Map{}
+	origins := make([]*originRepo, 0, len(cfg.Repos))
+	simRepoUrl := make(map[string]string, len(cfg.Repos))
+	for i, repoUrl := range cfg.Repos {
+		o, err := newOriginRepo(repoUrl, path.Join(cfg.Workdir, "origin", strconv.Itoa(i), path.Base(repoUrl)))
+		if err != nil {
+			return nil, err
+		}
+		if err := o.update(cfg.Start); err != nil {
+			return nil, err
+		}
+		reposDir := path.Join(cfg.Workdir, "repos", strconv.Itoa(i))
+		if err := os.MkdirAll(reposDir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		g, err := repograph.NewGraph(o.dir, reposDir)
+		if err != nil {
+			return nil, err
+		}
+		repos[o.dir] = g
+		origins = append(origins, o)
+		simRepoUrl[repoUrl] = o.dir
+	}
+
+	// Load the recorded history.
+	allTasks, err := cfg.Recorded.GetTasksFromDateRange(cfg.Start.Add(-cfg.Period), cfg.End)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]*db.Task, 0, len(allTasks))
+	for _, t := range allTasks {
+		if repo, ok := simRepoUrl[t.Repo]; ok && !t.IsTryJob() {
+			t.Repo = repo
+			tasks = append(tasks, t)
+		}
+	}
+	allJobs, err := cfg.Recorded.GetJobsFromDateRange(cfg.Start.Add(-cfg.Period), cfg.Start)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*db.Job, 0, len(allJobs))
+	for _, j := range allJobs {
+		if repo, ok := simRepoUrl[j.Repo]; ok && !j.IsTryJob() {
+			j.Repo = repo
+			jobs = append(jobs, j)
+		}
+	}
+	recorded := []*db.Task{}
+	recordedByName := map[string][]*db.Task{}
+	names := util.StringSet{}
+	for _, t := range tasks {
+		if !t.Created.Before(cfg.Start) {
+			recorded = append(recorded, t)
+			recordedByName[t.Name] = append(recordedByName[t.Name], t)
+			names[t.Name] = true
+		}
+	}
+	sklog.Infof("Loaded %d recorded Tasks and %d Jobs.", len(tasks), len(jobs))
+
+	// Simulate the bots which ran the recorded Tasks.
+	taskCfgCache, err := specs.NewTaskCfgCache(repos, cfg.DepotTools, path.Join(cfg.Workdir, "taskCfgCache"), specs.DEFAULT_NUM_WORKERS)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(taskCfgCache)
+	bots := makeBots(recorded, taskCfgCache.GetTaskSpec)
+	if len(bots) == 0 {
+		return nil, fmt.Errorf("No bots ran recorded Tasks between %s and %s.", cfg.Start, cfg.End)
+	}
+	sklog.Infof("Simulating %d bots.", len(bots))
+
+	// Create the TaskScheduler.
+	sim := &simulator{
+		bots:      bots,
+		d:         db.NewInMemoryDB(),
+		durations: medianDurations(tasks),
+		known:     map[string]bool{},
+		now:       cfg.Start,
+		recorded:  recordedByName,
+	}
+	sim.swarming = newSwarmingClient(sim.clock)
+	schedulerDir := path.Join(cfg.Workdir, "scheduler")
+	if err := os.MkdirAll(schedulerDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	isolateClient, err := isolate.NewClient(schedulerDir, isolate.ISOLATE_SERVER_URL_FAKE)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := scheduling.NewTaskScheduler(sim.d, cfg.Period, cfg.NumCommits, schedulerDir, "fake.server", repos, isolateClient, sim.swarming, http.DefaultClient, cfg.TimeDecayAmt24Hr, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, map[string]string{}, cfg.Pools, "", cfg.DepotTools)
+	if err != nil {
+		return nil, err
+	}
+	// The window must be updated to the simulated time before seeding the
+	// DB, so that the TaskScheduler's caches don't expire the seeded data.
+	if err := ts.SetClock(sim.clock); err != nil {
+		return nil, err
+	}
+	if cfg.Testedness != nil {
+		ts.SetTestedness(cfg.Testedness)
+	}
+	if err := sim.seed(cfg.Start, tasks, jobs); err != nil {
+		return nil, err
+	}
+	sim.trackId, err = sim.d.StartTrackingModifiedTasks()
+	if err != nil {
+		return nil, err
+	}
+	defer sim.d.StopTrackingModifiedTasks(sim.trackId)
+
+	// Run the simulation.
+	for ; !sim.now.After(cfg.End); sim.now = sim.now.Add(cfg.Tick) {
+		if err := sim.finishTasks(); err != nil {
+			return nil, err
+		}
+		for _, o := range origins {
+			if err := o.update(sim.now); err != nil {
+				return nil, err
+			}
+		}
+		if err := sim.startTasks(); err != nil {
+			return nil, err
+		}
+		sim.swarming.MockBots(sim.botInfos())
+		if err := ts.MainLoop(); err != nil {
+			return nil, err
+		}
+		if err := sim.collectTriggeredTasks(); err != nil {
+			return nil, err
+		}
+		if err := sim.startTasks(); err != nil {
+			return nil, err
+		}
+		sklog.Infof("Simulated %s: %d tasks pending, %d running.", sim.now, len(sim.pending), len(sim.running))
+	}
+
+	// Compare the recorded and simulated results.
+	commits := []*commit{}
+	for _, o := range origins {
+		commits = append(commits, o.landed(cfg.Start, cfg.End)...)
+	}
+	simulated, err := sim.d.GetTasksFromDateRange(cfg.Start, cfg.End.Add(time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Recorded:  makeReport(recorded, commits, names, cfg.Start, cfg.End, len(bots)),
+		Simulated: makeReport(simulated, commits, names, cfg.Start, cfg.End, len(bots)),
+	}, nil
+}
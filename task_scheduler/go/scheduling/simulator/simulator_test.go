@@ -0,0 +1,231 @@
+package simulator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+func makeTask(name, revision string, commits []string, created, started, finished time.Time) *db.Task {
+	t := &db.Task{
+		Commits:  commits,
+		Created:  created,
+		Started:  started,
+		Finished: finished,
+		Status:   db.TASK_STATUS_SUCCESS,
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     db.DEFAULT_TEST_REPO,
+				Revision: revision,
+			},
+			Name: name,
+		},
+	}
+	if util.TimeIsZero(finished) {
+		t.Status = db.TASK_STATUS_RUNNING
+	}
+	return t
+}
+
+func TestNewStats(t *testing.T) {
+	testutils.SmallTest(t)
+	testutils.AssertDeepEqual(t, Stats{}, newStats(nil))
+	vals := []float64{}
+	for i := 10; i > 0; i-- {
+		vals = append(vals, float64(i))
+	}
+	testutils.AssertDeepEqual(t, Stats{
+		Count:  10,
+		Mean:   5.5,
+		Median: 5,
+		P90:    9,
+		Max:    10,
+	}, newStats(vals))
+	// The input is not modified.
+	assert.Equal(t, 10.0, vals[0])
+}
+
+func TestMakeReport(t *testing.T) {
+	testutils.SmallTest(t)
+	start := time.Unix(1480000000, 0).UTC()
+	end := start.Add(time.Hour)
+	commits := []*commit{
+		{Repo: db.DEFAULT_TEST_REPO, Hash: "a", Timestamp: start},
+		{Repo: db.DEFAULT_TEST_REPO, Hash: "b", Timestamp: start.Add(10 * time.Minute)},
+		{Repo: db.DEFAULT_TEST_REPO, Hash: "c", Timestamp: start.Add(20 * time.Minute)},
+	}
+	names := util.NewStringSet([]string{"Build", "Test"})
+	tasks := []*db.Task{
+		// Covers a; finished after 30 minutes.
+		makeTask("Build", "a", []string{"a"}, start, start, start.Add(30*time.Minute)),
+		// Covers b and c; finished after 40 and 30 minutes.
+		makeTask("Build", "c", []string{"b", "c"}, start.Add(20*time.Minute), start.Add(20*time.Minute), start.Add(50*time.Minute)),
+		// Covers a; finished after the end of the period.
+		makeTask("Test", "a", []string{"a"}, start.Add(30*time.Minute), start.Add(30*time.Minute), end.Add(time.Minute)),
+		// Still running.
+		makeTask("Test", "c", []string{"b", "c"}, start.Add(50*time.Minute), start.Add(50*time.Minute), time.Time{}),
+	}
+	r := makeReport(tasks, commits, names, start, end, 2)
+	assert.Equal(t, 4, r.Tasks)
+	testutils.AssertDeepEqual(t, newStats([]float64{30, 40, 30}), r.Latency)
+	assert.Equal(t, 3, r.NoResult)
+	testutils.AssertDeepEqual(t, newStats([]float64{1, 2, 1, 2}), r.Blamelist)
+	// 30 + 30 + 30 + 10 busy minutes out of 120 available.
+	assert.InDelta(t, 100.0/120.0, r.Utilization, 0.0001)
+
+	// Try jobs are ignored.
+	try := makeTask("Test", "c", []string{}, start, start, start.Add(time.Minute))
+	try.Server = "fake"
+	try.Issue = "1"
+	try.Patchset = "1"
+	r2 := makeReport(append(tasks, try), commits, names, start, end, 2)
+	testutils.AssertDeepEqual(t, r, r2)
+}
+
+func TestMakeBots(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Now()
+	dims := map[string][]string{
+		"Build": {"pool:Skia", "os:Ubuntu"},
+		"Test":  {"pool:Skia", "os:Ubuntu-16.04", "gpu:none"},
+	}
+	getTaskSpec := func(rs db.RepoState, name string) (*specs.TaskSpec, error) {
+		d, ok := dims[name]
+		if !ok {
+			return nil, fmt.Errorf("No such task spec: %s", name)
+		}
+		return &specs.TaskSpec{Dimensions: d}, nil
+	}
+	t1 := makeTask("Build", "a", []string{"a"}, now, now, now)
+	t1.SwarmingBotId = "bot2"
+	t2 := makeTask("Test", "a", []string{"a"}, now, now, now)
+	t2.SwarmingBotId = "bot2"
+	t3 := makeTask("Build", "b", []string{"b"}, now, now, now)
+	t3.SwarmingBotId = "bot1"
+	t4 := makeTask("Bogus", "b", []string{"b"}, now, now, now)
+	t4.SwarmingBotId = "bot3"
+	t5 := makeTask("Test", "b", []string{"b"}, now, time.Time{}, time.Time{})
+
+	bots := makeBots([]*db.Task{t1, t2, t3, t4, t5}, getTaskSpec)
+	assert.Equal(t, 2, len(bots))
+	assert.Equal(t, "bot1", bots[0].id)
+	assert.Equal(t, "bot2", bots[1].id)
+	testutils.AssertDeepEqual(t, map[string]util.StringSet{
+		"pool": {"Skia": true},
+		"os":   {"Ubuntu": true, "Ubuntu-16.04": true},
+		"gpu":  {"none": true},
+	}, bots[1].dimensions)
+
+	req := []*swarming_api.SwarmingRpcsStringPair{
+		{Key: "pool", Value: "Skia"},
+		{Key: "os", Value: "Ubuntu-16.04"},
+	}
+	assert.False(t, bots[0].matches(req))
+	assert.True(t, bots[1].matches(req))
+
+	info := bots[1].botInfo()
+	assert.Equal(t, "bot2", info.BotId)
+	assert.Equal(t, "", info.TaskId)
+	assert.Equal(t, 3, len(info.Dimensions))
+	assert.Equal(t, "gpu", info.Dimensions[0].Key)
+	assert.Equal(t, []string{"Ubuntu", "Ubuntu-16.04"}, info.Dimensions[1].Value)
+	bots[1].task = &simTask{swarmingId: "swarming-id"}
+	assert.Equal(t, "swarming-id", bots[1].botInfo().TaskId)
+}
+
+func TestOutcome(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Unix(1480000000, 0).UTC()
+	r1 := makeTask("Test", "c", []string{"a", "b", "c"}, now, now, now.Add(5*time.Minute))
+	r1.Status = db.TASK_STATUS_FAILURE
+	r2 := makeTask("Test", "c", []string{"a", "b", "c"}, now, now, now.Add(7*time.Minute))
+	r2.Attempt = 1
+	r3 := makeTask("Test", "d", []string{"d"}, now, now, now.Add(9*time.Minute))
+	sim := &simulator{
+		durations: medianDurations([]*db.Task{r1, r2, r3}),
+		recorded: map[string][]*db.Task{
+			"Test": {r1, r2, r3},
+		},
+	}
+	testutils.AssertDeepEqual(t, map[string]time.Duration{"Test": 7 * time.Minute}, sim.durations)
+
+	// Same commit and attempt.
+	d, status := sim.outcome(makeTask("Test", "c", nil, now, time.Time{}, time.Time{}))
+	assert.Equal(t, 5*time.Minute, d)
+	assert.Equal(t, db.TASK_STATUS_FAILURE, status)
+
+	// Commit in the blamelist; no matching attempt, so use the last one.
+	sim.recorded["Test"] = []*db.Task{r2, r3}
+	d, status = sim.outcome(makeTask("Test", "b", nil, now, time.Time{}, time.Time{}))
+	assert.Equal(t, 7*time.Minute, d)
+	assert.Equal(t, db.TASK_STATUS_SUCCESS, status)
+
+	// No recorded Task covers the commit; use the median.
+	d, status = sim.outcome(makeTask("Test", "e", nil, now, time.Time{}, time.Time{}))
+	assert.Equal(t, 7*time.Minute, d)
+	assert.Equal(t, db.TASK_STATUS_SUCCESS, status)
+
+	// No recorded Tasks with the same name.
+	d, status = sim.outcome(makeTask("Other", "c", nil, now, time.Time{}, time.Time{}))
+	assert.Equal(t, DEFAULT_TASK_DURATION, d)
+	assert.Equal(t, db.TASK_STATUS_SUCCESS, status)
+}
+
+func TestSeed(t *testing.T) {
+	testutils.SmallTest(t)
+	start := time.Unix(1480000000, 0).UTC()
+	before := start.Add(-time.Hour)
+	t1 := makeTask("Build", "a", []string{"a"}, before, before, before.Add(time.Minute))
+	t1.Id = "t1"
+	t2 := makeTask("Test", "a", []string{"a"}, before, before, start.Add(time.Minute))
+	t2.Id = "t2"
+	t3 := makeTask("Build", "b", []string{"b"}, start, start, start.Add(time.Minute))
+	t3.Id = "t3"
+	j1 := &db.Job{
+		Created:   before,
+		Finished:  start.Add(time.Minute),
+		Id:        "j1",
+		Name:      "Test",
+		RepoState: t1.RepoState,
+		Status:    db.JOB_STATUS_SUCCESS,
+		Tasks: map[string][]*db.TaskSummary{
+			"Build": {t1.MakeTaskSummary()},
+			"Test":  {t2.MakeTaskSummary()},
+		},
+	}
+	j2 := &db.Job{
+		Created:   start,
+		Id:        "j2",
+		Name:      "Build",
+		RepoState: t3.RepoState,
+		Tasks:     map[string][]*db.TaskSummary{},
+	}
+
+	sim := &simulator{
+		d: db.NewInMemoryDB(),
+	}
+	assert.NoError(t, sim.seed(start, []*db.Task{t1, t2, t3}, []*db.Job{j1, j2}))
+	tasks, err := sim.d.GetTasksFromDateRange(before, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "t1", tasks[0].Id)
+	jobs, err := sim.d.GetJobsFromDateRange(before, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "j1", jobs[0].Id)
+	assert.Equal(t, db.JOB_STATUS_IN_PROGRESS, jobs[0].Status)
+	assert.True(t, util.TimeIsZero(jobs[0].Finished))
+	assert.Equal(t, 1, len(jobs[0].Tasks["Build"]))
+	assert.Equal(t, 0, len(jobs[0].Tasks["Test"]))
+
+	// The inputs are not modified.
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, j1.Status)
+	assert.Equal(t, 1, len(j1.Tasks["Test"]))
+}
@@ -0,0 +1,46 @@
+package simulator
+
+import (
+	"time"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/swarming"
+)
+
+// swarmingClient is a fake swarming.ApiClient which uses the simulated clock
+// for the creation time of triggered tasks.
+type swarmingClient struct {
+	*swarming.TestClient
+	now func() time.Time
+}
+
+// newSwarmingClient returns a swarmingClient instance.
+func newSwarmingClient(now func() time.Time) *swarmingClient {
+	return &swarmingClient{
+		TestClient: swarming.NewTestClient(),
+		now:        now,
+	}
+}
+
+// See documentation for swarming.ApiClient.
+func (c *swarmingClient) TriggerTask(t *swarming_api.SwarmingRpcsNewTaskRequest) (*swarming_api.SwarmingRpcsTaskRequestMetadata, error) {
+	rv, err := c.TestClient.TriggerTask(t)
+	if err != nil {
+		return nil, err
+	}
+	createdTs := c.now().UTC().Format(swarming.TIMESTAMP_FORMAT)
+	c.setTaskState(rv.TaskId, func(task *swarming_api.SwarmingRpcsTaskRequestMetadata) {
+		task.Request.CreatedTs = createdTs
+		task.TaskResult.CreatedTs = createdTs
+	})
+	return rv, nil
+}
+
+// setTaskState calls fn for the task with the given ID.
+func (c *swarmingClient) setTaskState(id string, fn func(*swarming_api.SwarmingRpcsTaskRequestMetadata)) {
+	c.DoMockTasks(func(task *swarming_api.SwarmingRpcsTaskRequestMetadata) {
+		if task.TaskId == id {
+			fn(task)
+		}
+	})
+}
@@ -2,10 +2,13 @@ package scheduling
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -22,9 +25,11 @@ import (
 type taskCandidate struct {
 	Attempt        int       `json:"attempt"`
 	Commits        []string  `json:"commits"`
+	InputHash      string    `json:"inputHash"`
 	IsolatedInput  string    `json:"isolatedInput"`
 	IsolatedHashes []string  `json:"isolatedHashes"`
 	JobCreated     time.Time `json:"jobCreated"`
+	JobNames       []string  `json:"jobNames"`
 	ParentTaskIds  []string  `json:"parentTaskIds"`
 	RetryOf        string    `json:"retryOf"`
 	Score          float64   `json:"score"`
@@ -38,9 +43,11 @@ func (c *taskCandidate) Copy() *taskCandidate {
 	return &taskCandidate{
 		Attempt:        c.Attempt,
 		Commits:        util.CopyStringSlice(c.Commits),
+		InputHash:      c.InputHash,
 		IsolatedInput:  c.IsolatedInput,
 		IsolatedHashes: util.CopyStringSlice(c.IsolatedHashes),
 		JobCreated:     c.JobCreated,
+		JobNames:       util.CopyStringSlice(c.JobNames),
 		ParentTaskIds:  util.CopyStringSlice(c.ParentTaskIds),
 		RetryOf:        c.RetryOf,
 		Score:          c.Score,
@@ -50,6 +57,18 @@ func (c *taskCandidate) Copy() *taskCandidate {
 	}
 }
 
+// addJobName adds the given JobSpec name to the candidate's sorted list of
+// JobNames, if it is not already present.
+func (c *taskCandidate) addJobName(name string) {
+	idx := sort.SearchStrings(c.JobNames, name)
+	if idx < len(c.JobNames) && c.JobNames[idx] == name {
+		return
+	}
+	c.JobNames = append(c.JobNames, "")
+	copy(c.JobNames[idx+1:], c.JobNames[idx:])
+	c.JobNames[idx] = name
+}
+
 // MakeId generates a string ID for the taskCandidate.
 func (c *taskCandidate) MakeId() string {
 	var buf bytes.Buffer
@@ -94,6 +113,7 @@ func (c *taskCandidate) MakeTask() *db.Task {
 		Attempt:       c.Attempt,
 		Commits:       commits,
 		Id:            "", // Filled in when the task is inserted into the DB.
+		InputHash:     c.InputHash,
 		MaxAttempts:   maxAttempts,
 		ParentTaskIds: parentTaskIds,
 		RetryOf:       c.RetryOf,
@@ -101,6 +121,37 @@ func (c *taskCandidate) MakeTask() *db.Task {
 	}
 }
 
+// MakeInputHash returns a hash of the inputs of the taskCandidate, which must
+// already have been isolated: its IsolatedInput, which includes the outputs of
+// its dependencies, and the parts of its TaskSpec which affect how it runs.
+// Variables in ExtraArgs are expanded, so that eg. tasks which receive the
+// revision as an argument have different hashes at different revisions.
+func (c *taskCandidate) MakeInputHash() (string, error) {
+	if c.IsolatedInput == "" {
+		return "", fmt.Errorf("Cannot hash inputs of %s@%s; it has not been isolated.", c.Name, c.Revision)
+	}
+	spec := c.TaskSpec.Copy()
+	for i, arg := range spec.ExtraArgs {
+		spec.ExtraArgs[i] = replaceVars(c, arg)
+	}
+	// These fields affect scheduling but not results.
+	spec.Expiration = 0
+	spec.MaxAttempts = 0
+	spec.Priority = 0
+	spec.RunIfChanged = nil
+	b, err := json.Marshal(struct {
+		IsolatedInput string          `json:"isolatedInput"`
+		TaskSpec      *specs.TaskSpec `json:"taskSpec"`
+	}{
+		IsolatedInput: c.IsolatedInput,
+		TaskSpec:      spec,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(b)), nil
+}
+
 // MakeIsolateTask creates an isolate.Task from this taskCandidate.
 func (c *taskCandidate) MakeIsolateTask(infraBotsDir, baseDir string) *isolate.Task {
 	os := "linux"
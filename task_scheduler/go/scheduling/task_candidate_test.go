@@ -16,6 +16,7 @@ func TestCopyTaskCandidate(t *testing.T) {
 	v := &taskCandidate{
 		Attempt:        3,
 		Commits:        []string{"a", "b"},
+		InputHash:      "deadbeef",
 		IsolatedInput:  "lonely-parameter",
 		IsolatedHashes: []string{"browns"},
 		JobCreated:     time.Now(),
@@ -76,6 +77,58 @@ func TestTaskCandidateId(t *testing.T) {
 	}
 }
 
+func TestMakeInputHash(t *testing.T) {
+	testutils.SmallTest(t)
+	c := makeTaskCandidate("c", []string{"k:v"})
+	c.Repo = "my-repo"
+	c.Revision = "abc123"
+
+	// The candidate must be isolated first.
+	_, err := c.MakeInputHash()
+	assert.Error(t, err)
+
+	c.IsolatedInput = "isolated"
+	h1, err := c.MakeInputHash()
+	assert.NoError(t, err)
+
+	// The revision doesn't matter unless it's passed to the task.
+	c.Revision = "def456"
+	h2, err := c.MakeInputHash()
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h2)
+
+	// Neither does the priority.
+	c.TaskSpec.Priority = 0.5
+	h2, err = c.MakeInputHash()
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h2)
+
+	// The isolated inputs do.
+	c.IsolatedInput = "isolated2"
+	h2, err = c.MakeInputHash()
+	assert.NoError(t, err)
+	assert.NotEqual(t, h1, h2)
+	c.IsolatedInput = "isolated"
+
+	// So do the dimensions.
+	c.TaskSpec.Dimensions = []string{"k:v2"}
+	h2, err = c.MakeInputHash()
+	assert.NoError(t, err)
+	assert.NotEqual(t, h1, h2)
+	c.TaskSpec.Dimensions = []string{"k:v"}
+
+	// Expanded variables in ExtraArgs count.
+	c.TaskSpec.ExtraArgs = []string{"--revision", "<(REVISION)"}
+	h3, err := c.MakeInputHash()
+	assert.NoError(t, err)
+	c.Revision = "abc123"
+	h4, err := c.MakeInputHash()
+	assert.NoError(t, err)
+	assert.NotEqual(t, h3, h4)
+	// The TaskSpec is not modified.
+	assert.Equal(t, "<(REVISION)", c.TaskSpec.ExtraArgs[1])
+}
+
 func TestReplaceVar(t *testing.T) {
 	testutils.SmallTest(t)
 	c := makeTaskCandidate("c", []string{"k:v"})
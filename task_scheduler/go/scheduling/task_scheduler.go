@@ -54,14 +54,23 @@ var (
 
 // TaskScheduler is a struct used for scheduling tasks on bots.
 type TaskScheduler struct {
+	bisections    *bisections
 	bl            *blacklist.Blacklist
 	busyBots      *busyBots
 	db            db.DB
+	deduplicate   bool
 	depotToolsDir string
+	fairShare     *FairShareConfig
+	flakeConfig   *FlakeConfig
+	flakes        *flakes
 	isolate       *isolate.Client
 	jCache        db.JobCache
 	lastScheduled time.Time // protected by queueMtx.
 
+	// now returns the current time. It is replaced by SetClock when
+	// simulating the scheduler against recorded history.
+	now func() time.Time
+
 	// TODO(benjaminwagner): newTasks probably belongs in the TaskCfgCache.
 	newTasks    map[db.RepoState]util.StringSet
 	newTasksMtx sync.RWMutex
@@ -74,6 +83,7 @@ type TaskScheduler struct {
 	swarming         swarming.ApiClient
 	taskCfgCache     *specs.TaskCfgCache
 	tCache           db.TaskCache
+	testedness       func(int) float64
 	timeDecayAmt24Hr float64
 	triggerMetrics   *periodicTriggerMetrics
 	tryjobs          *tryjobs.TryJobIntegrator
@@ -87,6 +97,16 @@ func NewTaskScheduler(d db.DB, period time.Duration, numCommits int, workdir, ho
 		return nil, err
 	}
 
+	fs, err := ReadFairShareConfig(path.Join(workdir, FAIR_SHARE_JSON_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	fc, err := ReadFlakeConfig(path.Join(workdir, FLAKE_CONFIG_JSON_FILE))
+	if err != nil {
+		return nil, err
+	}
+
 	w, err := window.New(period, numCommits, repos)
 	if err != nil {
 		return nil, err
@@ -117,15 +137,25 @@ func NewTaskScheduler(d db.DB, period time.Duration, numCommits int, workdir, ho
 		return nil, err
 	}
 
+	bisections, err := newBisections(workdir)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &TaskScheduler{
+		bisections:       bisections,
 		bl:               bl,
 		busyBots:         newBusyBots(),
 		db:               d,
 		depotToolsDir:    depotTools,
+		fairShare:        fs,
+		flakeConfig:      fc,
+		flakes:           &flakes{},
 		isolate:          isolateClient,
 		jCache:           jCache,
 		newTasks:         map[db.RepoState]util.StringSet{},
 		newTasksMtx:      sync.RWMutex{},
+		now:              time.Now,
 		pools:            pools,
 		pubsubTopic:      pubsubTopic,
 		queue:            []*taskCandidate{},
@@ -134,6 +164,7 @@ func NewTaskScheduler(d db.DB, period time.Duration, numCommits int, workdir, ho
 		swarming:         swarmingClient,
 		taskCfgCache:     taskCfgCache,
 		tCache:           tCache,
+		testedness:       testedness,
 		timeDecayAmt24Hr: timeDecayAmt24Hr,
 		triggerMetrics:   pm,
 		tryjobs:          tryjobs,
@@ -143,6 +174,29 @@ func NewTaskScheduler(d db.DB, period time.Duration, numCommits int, workdir, ho
 	return s, nil
 }
 
+// SetClock replaces the function used by the TaskScheduler to obtain the
+// current time and updates the time window accordingly. This allows the
+// TaskScheduler to be run against recorded history, eg. by the simulator.
+func (s *TaskScheduler) SetClock(now func() time.Time) error {
+	s.now = now
+	return s.window.UpdateWithTime(s.now())
+}
+
+// SetTestedness replaces the function used by the TaskScheduler to compute the
+// total "testedness" of a blamelist of N commits when scoring task candidates.
+// See testedness for the default. This allows alternative scoring to be
+// evaluated, eg. by the simulator.
+func (s *TaskScheduler) SetTestedness(fn func(int) float64) {
+	s.testedness = fn
+}
+
+// SetDeduplicateTasks determines whether the TaskScheduler reuses the results
+// of previous successful Tasks with identical inputs instead of triggering new
+// Swarming tasks. Disabled by default.
+func (s *TaskScheduler) SetDeduplicateTasks(deduplicate bool) {
+	s.deduplicate = deduplicate
+}
+
 // Start initiates the TaskScheduler's goroutines for scheduling tasks. beforeMainLoop
 // will be run before each scheduling iteration.
 func (s *TaskScheduler) Start(ctx context.Context, beforeMainLoop func()) {
@@ -164,6 +218,14 @@ func (s *TaskScheduler) Start(ctx context.Context, beforeMainLoop func()) {
 			lvUpdate.Reset()
 		}
 	})
+	lvFlakes := metrics2.NewLiveness("last-successful-flakes-update")
+	go util.RepeatCtx(FLAKE_UPDATE_PERIOD, ctx, func() {
+		if err := s.updateFlakes(); err != nil {
+			sklog.Errorf("Failed to update flake statistics: %s", err)
+		} else {
+			lvFlakes.Reset()
+		}
+	})
 }
 
 // TaskSchedulerStatus is a struct which provides status information about the
@@ -202,7 +264,7 @@ func (s *TaskScheduler) TriggerJob(repo, commit, jobName string) (string, error)
 	j, err := s.taskCfgCache.MakeJob(db.RepoState{
 		Repo:     repo,
 		Revision: commit,
-	}, jobName)
+	}, jobName, s.now())
 	if err != nil {
 		return "", err
 	}
@@ -356,16 +418,20 @@ func (s *TaskScheduler) findTaskCandidatesForJobs(unfinishedJobs []*db.Job) (map
 		}
 		for tsName, _ := range j.Dependencies {
 			key := j.MakeTaskKey(tsName)
-			spec, err := s.taskCfgCache.GetTaskSpec(j.RepoState, tsName)
-			if err != nil {
-				return nil, err
-			}
-			c := &taskCandidate{
-				JobCreated: j.Created,
-				TaskKey:    key,
-				TaskSpec:   spec,
+			c, ok := candidates[key]
+			if !ok {
+				spec, err := s.taskCfgCache.GetTaskSpec(j.RepoState, tsName)
+				if err != nil {
+					return nil, err
+				}
+				c = &taskCandidate{
+					JobCreated: j.Created,
+					TaskKey:    key,
+					TaskSpec:   spec,
+				}
+				candidates[key] = c
 			}
-			candidates[key] = c
+			c.addJobName(j.Name)
 		}
 	}
 	sklog.Infof("Found %d task candidates for %d unfinished jobs.", len(candidates), len(unfinishedJobs))
@@ -377,11 +443,12 @@ func (s *TaskScheduler) findTaskCandidatesForJobs(unfinishedJobs []*db.Job) (map
 func (s *TaskScheduler) filterTaskCandidates(preFilterCandidates map[db.TaskKey]*taskCandidate) (map[string]map[string][]*taskCandidate, error) {
 	defer metrics2.FuncTimer().Stop()
 
+	now := s.now()
 	candidatesBySpec := map[string]map[string][]*taskCandidate{}
 	total := 0
 	for _, c := range preFilterCandidates {
 		// Reject blacklisted tasks.
-		if rule := s.bl.MatchRule(c.Name, c.Revision); rule != "" {
+		if rule := s.bl.MatchRule(c.Repo, c.Name, c.Revision, now); rule != "" {
 			sklog.Warningf("Skipping blacklisted task candidate: %s @ %s due to rule %q", c.Name, c.Revision, rule)
 			continue
 		}
@@ -465,7 +532,7 @@ func (s *TaskScheduler) filterTaskCandidates(preFilterCandidates map[db.TaskKey]
 
 // processTaskCandidate computes the remaining information about the task
 // candidate, eg. blamelists and scoring.
-func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, cache *cacheWrapper, commitsBuf []*repograph.Commit) error {
+func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, cache *cacheWrapper, commitsBuf []*repograph.Commit, bisectTargets map[db.TaskKey]bool) error {
 	if c.IsTryJob() {
 		c.Score = CANDIDATE_SCORE_TRY_JOB + now.Sub(c.JobCreated).Hours()
 		return nil
@@ -506,6 +573,11 @@ func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, ca
 		return nil
 	}
 
+	if bisectTargets[c.TaskKey] {
+		c.Score = CANDIDATE_SCORE_BISECT + now.Sub(c.JobCreated).Hours()
+		return nil
+	}
+
 	// Score the candidate.
 	// The score for a candidate is based on the "testedness" increase
 	// provided by running the task.
@@ -520,7 +592,7 @@ func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, ca
 			stoleFromCommits = len(stealingFrom.Commits)
 		}
 	}
-	score := testednessIncrease(len(c.Commits), stoleFromCommits)
+	score := testednessIncrease(s.testedness, len(c.Commits), stoleFromCommits)
 
 	// Scale the score by other factors, eg. time decay.
 	decay, err := s.timeDecayForCommit(now, revision)
@@ -542,6 +614,12 @@ func (s *TaskScheduler) processTaskCandidates(candidates map[string]map[string][
 		return nil, err
 	}
 
+	// Find the commits at which we need to run tasks for bisection.
+	bisectTargets, err := s.bisectTargets()
+	if err != nil {
+		return nil, err
+	}
+
 	s.newTasksMtx.RLock()
 	defer s.newTasksMtx.RUnlock()
 
@@ -561,7 +639,7 @@ func (s *TaskScheduler) processTaskCandidates(candidates map[string]map[string][
 					var best *taskCandidate
 					for i, candidate := range candidates {
 						c := candidate.Copy()
-						if err := s.processTaskCandidate(c, now, cache, commitsBuf); err != nil {
+						if err := s.processTaskCandidate(c, now, cache, commitsBuf, bisectTargets); err != nil {
 							errs <- err
 							return
 						}
@@ -720,8 +798,11 @@ func (s *TaskScheduler) regenerateTaskQueue(now time.Time) ([]*taskCandidate, er
 
 // getCandidatesToSchedule matches the list of free Swarming bots to task
 // candidates in the queue and returns the candidates which should be run.
-// Assumes that the tasks are sorted in decreasing order by score.
-func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate) []*taskCandidate {
+// Assumes that the tasks are sorted in decreasing order by score. Bots are
+// distributed among the shares defined by fs in proportion to their weights;
+// within each share, candidates are chosen in order of score. If fs is nil,
+// all candidates are chosen in order of score.
+func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate, fs *FairShareConfig) []*taskCandidate {
 	defer metrics2.FuncTimer().Stop()
 	// Create a bots-by-swarming-dimension mapping.
 	botsByDim := map[string]util.StringSet{}
@@ -741,13 +822,36 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 	// TODO(borenet): Some tasks require a more specialized bot. We should
 	// match so that less-specialized tasks don't "steal" more-specialized
 	// bots which they don't actually need.
-	rv := make([]*taskCandidate, 0, len(bots))
+	eligible := make([]*taskCandidate, 0, len(tasks))
 	for _, c := range tasks {
 		// TODO(borenet): Make this threshold configurable.
 		if c.Score <= 0.0 {
 			sklog.Warningf("candidate %s @ %s has a score of %2f; skipping (%d commits).", c.Name, c.Revision, c.Score, len(c.Commits))
 			continue
 		}
+		eligible = append(eligible, c)
+	}
+	shares := fs.groupByShare(eligible)
+
+	rv := make([]*taskCandidate, 0, len(bots))
+	for {
+		// Choose the share which would be furthest below its entitlement
+		// after receiving another bot. Break ties in favor of the share
+		// whose first candidate had the highest score.
+		var share *fairShare
+		for _, sh := range shares {
+			if len(sh.candidates) == 0 {
+				continue
+			}
+			if share == nil || float64(sh.scheduled+1)/sh.weight < float64(share.scheduled+1)/share.weight {
+				share = sh
+			}
+		}
+		if share == nil {
+			break
+		}
+		c := share.candidates[0]
+		share.candidates = share.candidates[1:]
 
 		// For each dimension of the task, find the set of bots which matches.
 		matches := util.StringSet{}
@@ -778,6 +882,7 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 
 			// Add the task to the scheduling list.
 			rv = append(rv, c)
+			share.scheduled++
 
 			// If we've exhausted the bot list, stop here.
 			if len(botsByDim) == 0 {
@@ -810,11 +915,40 @@ func (s *TaskScheduler) isolateTasks(rs db.RepoState, candidates []*taskCandidat
 		}
 		for i, c := range candidates {
 			c.IsolatedInput = hashes[i]
+			inputHash, err := c.MakeInputHash()
+			if err != nil {
+				return err
+			}
+			c.InputHash = inputHash
 		}
 		return nil
 	})
 }
 
+// findDeduplicationTarget returns a previous successful Task whose results
+// may be reused for the given candidate instead of triggering a Swarming task,
+// or nil if there is none. Try jobs and forced Jobs always run.
+func (s *TaskScheduler) findDeduplicationTarget(c *taskCandidate) (*db.Task, error) {
+	if !s.deduplicate || c.IsTryJob() || c.IsForceRun() || c.InputHash == "" {
+		return nil, nil
+	}
+	return s.tCache.GetTaskForInput(c.Name, c.InputHash)
+}
+
+// deduplicateTask fills in t, which has not been triggered, so that it reuses
+// the results of prev, which has the same name and InputHash.
+func (s *TaskScheduler) deduplicateTask(t, prev *db.Task) {
+	now := s.now()
+	t.Created = now
+	t.DeduplicatedFrom = prev.Id
+	t.Finished = now
+	t.IsolatedOutput = prev.IsolatedOutput
+	t.Started = now
+	t.Status = prev.Status
+	t.SwarmingBotId = prev.SwarmingBotId
+	t.SwarmingTaskId = prev.SwarmingTaskId
+}
+
 // isolateCandidates uploads inputs for the taskCandidates to the Isolate
 // server. Returns a channel of the successfully-isolated candidates which is
 // closed after all candidates have been isolated or failed. Each failure is
@@ -868,10 +1002,22 @@ func (s *TaskScheduler) triggerTasks(isolated <-chan *taskCandidate, errCh chan<
 		go func(candidate *taskCandidate) {
 			defer wg.Done()
 			t := candidate.MakeTask()
+			t.Quarantined = s.bl.MatchQuarantine(t.Repo, t.Name, t.Revision, s.now()) != ""
 			if err := s.db.AssignId(t); err != nil {
 				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
 				return
 			}
+			prev, err := s.findDeduplicationTarget(candidate)
+			if err != nil {
+				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
+				return
+			}
+			if prev != nil {
+				sklog.Infof("Reusing results of task %s for %s@%s; inputs are identical.", prev.Id, t.Name, t.Revision)
+				s.deduplicateTask(t, prev)
+				triggered <- t
+				return
+			}
 			req, err := candidate.MakeTaskRequest(t.Id, s.isolate.ServerURL(), s.pubsubTopic)
 			if err != nil {
 				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
@@ -904,7 +1050,8 @@ func (s *TaskScheduler) triggerTasks(isolated <-chan *taskCandidate, errCh chan<
 func (s *TaskScheduler) scheduleTasks(bots []*swarming_api.SwarmingRpcsBotInfo, queue []*taskCandidate) error {
 	defer metrics2.FuncTimer().Stop()
 	// Match free bots with tasks.
-	schedule := getCandidatesToSchedule(bots, queue)
+	schedule := getCandidatesToSchedule(bots, queue, s.fairShare)
+	recordFairShareMetrics(s.fairShare, queue, schedule)
 
 	// Setup the error channel.
 	errs := []error{}
@@ -982,7 +1129,7 @@ func (s *TaskScheduler) scheduleTasks(bots []*swarming_api.SwarmingRpcsBotInfo,
 	s.queueMtx.Lock()
 	defer s.queueMtx.Unlock()
 	s.queue = queue
-	s.lastScheduled = time.Now()
+	s.lastScheduled = s.now()
 
 	if len(errs) > 0 {
 		rvErr := "Got failures: "
@@ -1022,7 +1169,7 @@ func (s *TaskScheduler) gatherNewJobs() error {
 			}
 			for name, spec := range cfg.Jobs {
 				if spec.Trigger == "" {
-					j, err := s.taskCfgCache.MakeJob(rs, name)
+					j, err := s.taskCfgCache.MakeConditionalJob(rs, name, s.now())
 					if err != nil {
 						return false, err
 					}
@@ -1113,7 +1260,7 @@ func (s *TaskScheduler) MainLoop() error {
 		}
 	}()
 
-	now := time.Now()
+	now := s.now()
 	// TODO(borenet): This is only needed for the perftest because it no
 	// longer has access to the TaskCache used by TaskScheduler. Since it
 	// pushes tasks into the DB between executions of MainLoop, we need to
@@ -1167,7 +1314,7 @@ func (s *TaskScheduler) updateRepos() error {
 			return err
 		}
 	}
-	if err := s.window.Update(); err != nil {
+	if err := s.window.UpdateWithTime(s.now()); err != nil {
 		return err
 	}
 	return nil
@@ -1238,8 +1385,9 @@ func testedness(n int) float64 {
 // "testedness" for every commit affected by the task,  before and after the
 // task would run. We subtract the "before" score from the "after" score to
 // obtain the "testedness" increase at each commit, then sum them to find the
-// total increase in "testedness" obtained by running the task.
-func testednessIncrease(blamelistLength, stoleFromBlamelistLength int) float64 {
+// total increase in "testedness" obtained by running the task. The given
+// function computes the "testedness" of a blamelist, see testedness.
+func testednessIncrease(testednessFn func(int) float64, blamelistLength, stoleFromBlamelistLength int) float64 {
 	// Invalid inputs.
 	if blamelistLength <= 0 || stoleFromBlamelistLength < 0 {
 		return -1.0
@@ -1249,7 +1397,7 @@ func testednessIncrease(blamelistLength, stoleFromBlamelistLength int) float64 {
 		// This task covers previously-untested commits. Previous testedness
 		// is -1.0 for each commit in the blamelist.
 		beforeTestedness := float64(-blamelistLength)
-		afterTestedness := testedness(blamelistLength)
+		afterTestedness := testednessFn(blamelistLength)
 		return afterTestedness - beforeTestedness
 	} else if blamelistLength == stoleFromBlamelistLength {
 		// This is a retry. It provides no testedness increase, so shortcut here
@@ -1257,8 +1405,8 @@ func testednessIncrease(blamelistLength, stoleFromBlamelistLength int) float64 {
 		return 0.0
 	} else {
 		// This is a bisect/backfill.
-		beforeTestedness := testedness(stoleFromBlamelistLength)
-		afterTestedness := testedness(blamelistLength) + testedness(stoleFromBlamelistLength-blamelistLength)
+		beforeTestedness := testednessFn(stoleFromBlamelistLength)
+		afterTestedness := testednessFn(blamelistLength) + testednessFn(stoleFromBlamelistLength-blamelistLength)
 		return afterTestedness - beforeTestedness
 	}
 }
@@ -1369,7 +1517,23 @@ func (s *TaskScheduler) updateUnfinishedTasks() error {
 		}
 	}
 
-	return s.tCache.Update()
+	if err := s.tCache.Update(); err != nil {
+		return err
+	}
+
+	// Update bisections for any tasks which have finished.
+	for _, t := range tasks {
+		updated, err := s.tCache.GetTask(t.Id)
+		if err != nil {
+			return err
+		}
+		if updated.Done() {
+			if err := s.taskFinished(updated); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // jobFinished marks the Job as finished.
@@ -1377,7 +1541,7 @@ func (s *TaskScheduler) jobFinished(j *db.Job) error {
 	if !j.Done() {
 		return fmt.Errorf("jobFinished called on Job with status %q", j.Status)
 	}
-	j.Finished = time.Now()
+	j.Finished = s.now()
 	return nil
 }
 
@@ -1622,7 +1786,7 @@ func (s *TaskScheduler) ValidateAndAddTask(task *db.Task) error {
 	}
 
 	if util.TimeIsZero(task.Created) {
-		task.Created = time.Now().UTC()
+		task.Created = s.now().UTC()
 	}
 	if len(task.Commits) > 0 {
 		sklog.Warning("Ignoring Commits in ValidateAndAddTask. %v", task)
@@ -1706,7 +1870,7 @@ func (s *TaskScheduler) updateTaskFromSwarmingPubSub(swarmingTaskId string) bool
 				sklog.Errorf("Failed to parse timestamp: %s; %s", res.CreatedTs, err)
 				return true
 			}
-			if time.Now().Sub(created) < 2*time.Minute {
+			if s.now().Sub(created) < 2*time.Minute {
 				sklog.Infof("Failed to update task %q: No such task ID: %q. Less than two minutes old; try again later.", swarmingTaskId, id)
 				return false
 			}
@@ -1733,5 +1897,19 @@ func (s *TaskScheduler) updateTaskFromSwarmingPubSub(swarmingTaskId string) bool
 			return true
 		}
 	}
+	// Update bisections for the finished task.
+	id, err := swarming.GetTagValue(res, db.SWARMING_TAG_ID)
+	if err != nil {
+		sklog.Errorf("Failed to update bisections for task %q: %s", swarmingTaskId, err)
+		return true
+	}
+	t, err := s.db.GetTaskById(id)
+	if err != nil {
+		sklog.Errorf("Failed to update bisections for task %q: %s", swarmingTaskId, err)
+	} else if t != nil {
+		if err := s.taskFinished(t); err != nil {
+			sklog.Errorf("Failed to update bisections for task %q: %s", swarmingTaskId, err)
+		}
+	}
 	return true
 }
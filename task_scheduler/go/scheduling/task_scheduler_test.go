@@ -286,6 +286,7 @@ func TestFindTaskCandidatesForJobs(t *testing.T) {
 	}
 	tc1 := &taskCandidate{
 		JobCreated: now,
+		JobNames:   []string{"j1"},
 		TaskKey: db.TaskKey{
 			RepoState: rs1.Copy(),
 			Name:      specs_testutils.BuildTask,
@@ -294,6 +295,7 @@ func TestFindTaskCandidatesForJobs(t *testing.T) {
 	}
 	tc2 := &taskCandidate{
 		JobCreated: now,
+		JobNames:   []string{"j1"},
 		TaskKey: db.TaskKey{
 			RepoState: rs1.Copy(),
 			Name:      specs_testutils.TestTask,
@@ -324,6 +326,7 @@ func TestFindTaskCandidatesForJobs(t *testing.T) {
 	}
 	tc3 := &taskCandidate{
 		JobCreated: now,
+		JobNames:   []string{"j2", "j3"},
 		TaskKey: db.TaskKey{
 			RepoState: rs2.Copy(),
 			Name:      specs_testutils.BuildTask,
@@ -332,6 +335,7 @@ func TestFindTaskCandidatesForJobs(t *testing.T) {
 	}
 	tc4 := &taskCandidate{
 		JobCreated: now,
+		JobNames:   []string{"j2"},
 		TaskKey: db.TaskKey{
 			RepoState: rs2.Copy(),
 			Name:      specs_testutils.TestTask,
@@ -340,6 +344,7 @@ func TestFindTaskCandidatesForJobs(t *testing.T) {
 	}
 	tc5 := &taskCandidate{
 		JobCreated: now,
+		JobNames:   []string{"j3"},
 		TaskKey: db.TaskKey{
 			RepoState: rs2.Copy(),
 			Name:      specs_testutils.PerfTask,
@@ -591,7 +596,7 @@ func TestProcessTaskCandidate(t *testing.T) {
 			},
 		},
 	}
-	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf))
+	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf, nil))
 	assert.Equal(t, CANDIDATE_SCORE_TRY_JOB+1.0, c.Score)
 	assert.Nil(t, c.Commits)
 
@@ -606,7 +611,7 @@ func TestProcessTaskCandidate(t *testing.T) {
 			ForcedJobId: "my-job",
 		},
 	}
-	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf))
+	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf, nil))
 	assert.Equal(t, CANDIDATE_SCORE_FORCE_RUN+2.0, c.Score)
 	assert.Equal(t, 2, len(c.Commits))
 
@@ -619,7 +624,7 @@ func TestProcessTaskCandidate(t *testing.T) {
 			},
 		},
 	}
-	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf))
+	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf, nil))
 	assert.True(t, c.Score > 0)
 	assert.Equal(t, 2, len(c.Commits))
 
@@ -637,7 +642,7 @@ func TestProcessTaskCandidate(t *testing.T) {
 			},
 		},
 	}
-	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf))
+	assert.NoError(t, s.processTaskCandidate(c, now, cache, commitsBuf, nil))
 	assert.Equal(t, 0, len(c.Commits))
 }
 
@@ -897,8 +902,16 @@ func TestTestednessIncrease(t *testing.T) {
 		},
 	}
 	for i, c := range tc {
-		assert.Equal(t, c.out, testednessIncrease(c.a, c.b), fmt.Sprintf("test case #%d", i))
+		assert.Equal(t, c.out, testednessIncrease(testedness, c.a, c.b), fmt.Sprintf("test case #%d", i))
 	}
+
+	// Alternative testedness functions may be used.
+	linear := func(n int) float64 {
+		return float64(n) / 2.0
+	}
+	assert.Equal(t, 3.0+1.5, testednessIncrease(linear, 3, 0))
+	assert.Equal(t, 0.0, testednessIncrease(linear, 3, 3))
+	assert.Equal(t, 1.5+1.0-2.5, testednessIncrease(linear, 3, 5))
 }
 
 func TestComputeBlamelist(t *testing.T) {
@@ -1402,44 +1415,44 @@ func makeSwarmingBot(id string, dims []string) *swarming_api.SwarmingRpcsBotInfo
 func TestGetCandidatesToSchedule(t *testing.T) {
 	testutils.MediumTest(t)
 	// Empty lists.
-	rv := getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{})
+	rv := getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	t1 := makeTaskCandidate("task1", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{t1}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	b1 := makeSwarmingBot("bot1", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	// Single match.
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 
 	// No match.
 	t1.TaskSpec.Dimensions[0] = "k:v2"
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	// Add a task candidate to match b1.
 	t1 = makeTaskCandidate("task1", []string{"k:v2"})
 	t2 := makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Switch the task order.
 	t1 = makeTaskCandidate("task1", []string{"k:v2"})
 	t2 = makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Make both tasks match the bot, ensure that we pick the first one.
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Multiple dimensions. Ensure that different permutations of the bots
@@ -1454,21 +1467,21 @@ func TestGetCandidatesToSchedule(t *testing.T) {
 	// is first in sorted order. The second task does not get scheduled
 	// because there is no bot available which can run it.
 	// TODO(borenet): Use a more optimal solution to avoid this case.
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 	// In these two cases, the task with more dimensions has the higher
 	// priority. Both tasks get scheduled.
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2, t1}, rv)
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2, t1}, rv)
 
 	// Matching dimensions. More bots than tasks.
@@ -1477,14 +1490,14 @@ func TestGetCandidatesToSchedule(t *testing.T) {
 	t1 = makeTaskCandidate("task1", dims)
 	t2 = makeTaskCandidate("task2", dims)
 	t3 := makeTaskCandidate("task3", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1, t2}, rv)
 
 	// More tasks than bots.
 	t1 = makeTaskCandidate("task1", dims)
 	t2 = makeTaskCandidate("task2", dims)
 	t3 = makeTaskCandidate("task3", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2, t3})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2, t3}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1, t2}, rv)
 }
 
@@ -2120,6 +2133,57 @@ func TestSchedulingRetry(t *testing.T) {
 	assert.Equal(t, 5, i)
 }
 
+func TestDeduplicateTasks(t *testing.T) {
+	gb, d, swarmingClient, s, _, cleanup := setup(t)
+	defer cleanup()
+	s.SetDeduplicateTasks(true)
+
+	// Run the available compile task at c2.
+	bot1 := makeBot("bot1", linuxTaskDims)
+	swarmingClient.MockBots([]*swarming_api.SwarmingRpcsBotInfo{bot1})
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, s.tCache.Update())
+	tasks, err := s.tCache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	t1 := tasks[0]
+	assert.NotEqual(t, "", t1.InputHash)
+	t1.Status = db.TASK_STATUS_SUCCESS
+	t1.Finished = time.Now()
+	t1.IsolatedOutput = "abc123"
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, s.tCache.Update())
+
+	// Add a commit which doesn't change the inputs of the compile task.
+	makeDummyCommits(gb, 1)
+	assert.NoError(t, s.updateRepos())
+	head, err := s.repos[gb.RepoUrl()].Repo().RevParse("HEAD")
+	assert.NoError(t, err)
+
+	// The compile task at the new commit reuses the results of t1 rather
+	// than running on Swarming.
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, s.tCache.Update())
+	tasks, err = s.tCache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(tasks))
+	t2, err := s.tCache.GetTaskForCommit(gb.RepoUrl(), head, specs_testutils.BuildTask)
+	assert.NoError(t, err)
+	assert.NotNil(t, t2)
+	assert.Equal(t, head, t2.Revision)
+	assert.Equal(t, t1.Id, t2.DeduplicatedFrom)
+	assert.Equal(t, t1.InputHash, t2.InputHash)
+	assert.Equal(t, db.TASK_STATUS_SUCCESS, t2.Status)
+	assert.Equal(t, t1.IsolatedOutput, t2.IsolatedOutput)
+	assert.Equal(t, t1.SwarmingTaskId, t2.SwarmingTaskId)
+	assert.Equal(t, []string{head}, t2.Commits)
+
+	// De-duplicated tasks are not themselves used for de-duplication.
+	found, err := s.tCache.GetTaskForInput(specs_testutils.BuildTask, t1.InputHash)
+	assert.NoError(t, err)
+	assert.Equal(t, t1.Id, found.Id)
+}
+
 func TestParentTaskId(t *testing.T) {
 	_, d, swarmingClient, s, _, cleanup := setup(t)
 	defer cleanup()
@@ -2178,7 +2242,7 @@ func TestBlacklist(t *testing.T) {
 		Commits:          []string{c1},
 		Description:      "desc",
 		Name:             "My-Rule",
-	}, s.repos))
+	}, s.repos, s.now()))
 	assert.NoError(t, s.MainLoop())
 	assert.NoError(t, s.tCache.Update())
 	tasks, err := s.tCache.UnfinishedTasks()
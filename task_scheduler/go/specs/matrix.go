@@ -0,0 +1,246 @@
+package specs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.skia.org/infra/go/util"
+)
+
+var (
+	// RESERVED_VARIABLES are the variables which are replaced at the time a
+	// task is triggered. They may not be used as matrix axes.
+	RESERVED_VARIABLES = []string{
+		VARIABLE_CODEREVIEW_SERVER,
+		VARIABLE_ISSUE,
+		VARIABLE_ISSUE_SHORT,
+		VARIABLE_PATCH_REPO,
+		VARIABLE_PATCH_STORAGE,
+		VARIABLE_PATCHSET,
+		VARIABLE_REPO,
+		VARIABLE_REVISION,
+		VARIABLE_TASK_NAME,
+	}
+)
+
+// TaskSpecMatrix is a template which expands into one TaskSpec, and
+// optionally one JobSpec, for each combination of values of its axes. The
+// name of the matrix, along with all strings in the TaskSpec and JobSpec, may
+// contain placeholders of the form "<(AXIS)>", which are replaced by the value
+// of the corresponding axis. Be sure to add any new fields to the Copy()
+// method.
+type TaskSpecMatrix struct {
+	// Axes maps axis names to the values they may take.
+	Axes map[string][]string `json:"axes"`
+
+	// Exclude lists combinations of axis values which should not be
+	// expanded. A combination is excluded if it matches all of the values
+	// in any one entry.
+	Exclude []map[string]string `json:"exclude,omitempty"`
+
+	// Job, if provided, is expanded into a JobSpec with the same name as
+	// each expanded TaskSpec.
+	Job *JobSpec `json:"job,omitempty"`
+
+	// Task is expanded into a TaskSpec for each combination of axis values.
+	Task *TaskSpec `json:"task"`
+}
+
+// Copy returns a copy of the TaskSpecMatrix.
+func (m *TaskSpecMatrix) Copy() *TaskSpecMatrix {
+	var axes map[string][]string
+	if m.Axes != nil {
+		axes = make(map[string][]string, len(m.Axes))
+		for k, v := range m.Axes {
+			axes[k] = util.CopyStringSlice(v)
+		}
+	}
+	var exclude []map[string]string
+	if m.Exclude != nil {
+		exclude = make([]map[string]string, 0, len(m.Exclude))
+		for _, e := range m.Exclude {
+			exclude = append(exclude, util.CopyStringMap(e))
+		}
+	}
+	var job *JobSpec
+	if m.Job != nil {
+		job = m.Job.Copy()
+	}
+	var task *TaskSpec
+	if m.Task != nil {
+		task = m.Task.Copy()
+	}
+	return &TaskSpecMatrix{
+		Axes:    axes,
+		Exclude: exclude,
+		Job:     job,
+		Task:    task,
+	}
+}
+
+// Validate returns an error if the TaskSpecMatrix is not valid.
+func (m *TaskSpecMatrix) Validate(name string) error {
+	if m.Task == nil {
+		return fmt.Errorf("Matrix %q has no task.", name)
+	}
+	if len(m.Axes) == 0 {
+		return fmt.Errorf("Matrix %q has no axes.", name)
+	}
+	for axis, values := range m.Axes {
+		if util.In(axis, RESERVED_VARIABLES) {
+			return fmt.Errorf("Matrix %q uses reserved variable %q as an axis.", name, axis)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("Matrix %q axis %q has no values.", name, axis)
+		}
+		if !strings.Contains(name, fmt.Sprintf(VARIABLE_SYNTAX, axis)) {
+			return fmt.Errorf("Matrix name %q does not contain placeholder for axis %q.", name, axis)
+		}
+	}
+	for _, e := range m.Exclude {
+		for axis, _ := range e {
+			if _, ok := m.Axes[axis]; !ok {
+				return fmt.Errorf("Matrix %q excludes unknown axis %q.", name, axis)
+			}
+		}
+	}
+	return nil
+}
+
+// combinations returns all combinations of axis values which are not
+// excluded, in a deterministic order.
+func (m *TaskSpecMatrix) combinations() []map[string]string {
+	axes := make([]string, 0, len(m.Axes))
+	for axis, _ := range m.Axes {
+		axes = append(axes, axis)
+	}
+	sort.Strings(axes)
+	rv := []map[string]string{}
+	var expand func(int, map[string]string)
+	expand = func(idx int, combo map[string]string) {
+		if idx == len(axes) {
+			for _, e := range m.Exclude {
+				excluded := true
+				for axis, value := range e {
+					if combo[axis] != value {
+						excluded = false
+						break
+					}
+				}
+				if excluded {
+					return
+				}
+			}
+			rv = append(rv, util.CopyStringMap(combo))
+			return
+		}
+		axis := axes[idx]
+		for _, value := range m.Axes[axis] {
+			combo[axis] = value
+			expand(idx+1, combo)
+		}
+		delete(combo, axis)
+	}
+	expand(0, map[string]string{})
+	return rv
+}
+
+// replaceAll returns a copy of the given slice with r applied to each element.
+func replaceAll(r *strings.Replacer, s []string) []string {
+	if s == nil {
+		return nil
+	}
+	rv := make([]string, 0, len(s))
+	for _, v := range s {
+		rv = append(rv, r.Replace(v))
+	}
+	return rv
+}
+
+// expand returns the TaskSpecs and JobSpecs generated by the matrix with the
+// given name, keyed by name.
+func (m *TaskSpecMatrix) expand(name string) (map[string]*TaskSpec, map[string]*JobSpec) {
+	combos := m.combinations()
+	tasks := make(map[string]*TaskSpec, len(combos))
+	jobs := map[string]*JobSpec{}
+	for _, combo := range combos {
+		oldnew := make([]string, 0, 2*len(combo))
+		for axis, value := range combo {
+			oldnew = append(oldnew, fmt.Sprintf(VARIABLE_SYNTAX, axis), value)
+		}
+		r := strings.NewReplacer(oldnew...)
+
+		t := m.Task.Copy()
+		for _, p := range t.CipdPackages {
+			p.Name = r.Replace(p.Name)
+			p.Path = r.Replace(p.Path)
+			p.Version = r.Replace(p.Version)
+		}
+		t.Dependencies = replaceAll(r, t.Dependencies)
+		t.Dimensions = replaceAll(r, t.Dimensions)
+		for k, v := range t.Environment {
+			t.Environment[k] = r.Replace(v)
+		}
+		t.ExtraArgs = replaceAll(r, t.ExtraArgs)
+		t.Isolate = r.Replace(t.Isolate)
+		t.RunIfChanged = replaceAll(r, t.RunIfChanged)
+		expandedName := r.Replace(name)
+		tasks[expandedName] = t
+
+		if m.Job != nil {
+			j := m.Job.Copy()
+			if j.Paths != nil {
+				j.Paths.Exclude = replaceAll(r, j.Paths.Exclude)
+				j.Paths.Include = replaceAll(r, j.Paths.Include)
+			}
+			j.TaskSpecs = replaceAll(r, j.TaskSpecs)
+			j.Trigger = r.Replace(j.Trigger)
+			jobs[expandedName] = j
+		}
+	}
+	return tasks, jobs
+}
+
+// Expand returns a copy of the TasksCfg in which each TaskSpecMatrix has been
+// replaced by the TaskSpecs and JobSpecs it generates. Returns an error if any
+// generated name collides with an existing TaskSpec or JobSpec.
+func (c *TasksCfg) Expand() (*TasksCfg, error) {
+	rv := &TasksCfg{
+		Jobs:  make(map[string]*JobSpec, len(c.Jobs)),
+		Tasks: make(map[string]*TaskSpec, len(c.Tasks)),
+	}
+	for name, j := range c.Jobs {
+		rv.Jobs[name] = j.Copy()
+	}
+	for name, t := range c.Tasks {
+		rv.Tasks[name] = t.Copy()
+	}
+	// Expand the matrices in sorted order, so that errors are
+	// deterministic.
+	names := make([]string, 0, len(c.Matrix))
+	for name, _ := range c.Matrix {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := c.Matrix[name]
+		if err := m.Validate(name); err != nil {
+			return nil, err
+		}
+		tasks, jobs := m.expand(name)
+		for taskName, t := range tasks {
+			if _, ok := rv.Tasks[taskName]; ok {
+				return nil, fmt.Errorf("Matrix %q generates duplicate task %q.", name, taskName)
+			}
+			rv.Tasks[taskName] = t
+		}
+		for jobName, j := range jobs {
+			if _, ok := rv.Jobs[jobName]; ok {
+				return nil, fmt.Errorf("Matrix %q generates duplicate job %q.", name, jobName)
+			}
+			rv.Jobs[jobName] = j
+		}
+	}
+	return rv, nil
+}
@@ -0,0 +1,153 @@
+package specs
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestCopyTaskSpecMatrix(t *testing.T) {
+	testutils.SmallTest(t)
+	v := &TaskSpecMatrix{
+		Axes: map[string][]string{
+			"os": []string{"Ubuntu", "Win"},
+		},
+		Exclude: []map[string]string{
+			{"os": "Win"},
+		},
+		Job: &JobSpec{
+			Priority:  0.5,
+			TaskSpecs: []string{"Test-<(os)>"},
+			Trigger:   "nightly",
+		},
+		Task: &TaskSpec{
+			Dimensions: []string{"os:<(os)>"},
+			Isolate:    "test.isolate",
+		},
+	}
+	testutils.AssertCopy(t, v, v.Copy())
+}
+
+func TestTaskSpecMatrix(t *testing.T) {
+	testutils.SmallTest(t)
+
+	cfg, err := ParseTasksCfg(`{
+  "tasks": {
+    "Build": {
+      "dimensions": ["os:Ubuntu"],
+      "isolate": "compile.isolate"
+    }
+  },
+  "jobs": {
+    "Build": {
+      "tasks": ["Build"]
+    }
+  },
+  "matrix": {
+    "Test-<(os)>-<(gpu)>": {
+      "axes": {
+        "os": ["Ubuntu", "Win"],
+        "gpu": ["Intel", "Nvidia"]
+      },
+      "exclude": [{"os": "Win", "gpu": "Intel"}],
+      "job": {
+        "priority": 0.8,
+        "tasks": ["Test-<(os)>-<(gpu)>"]
+      },
+      "task": {
+        "dependencies": ["Build"],
+        "dimensions": ["os:<(os)>", "gpu:<(gpu)>"],
+        "extra_args": ["--gpu", "<(gpu)>", "--revision", "<(REVISION)>"],
+        "isolate": "test.isolate",
+        "run_if_changed": ["src/gpu/<(gpu)>"]
+      }
+    }
+  }
+}`)
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.Matrix)
+	assert.Equal(t, 4, len(cfg.Tasks))
+	assert.Equal(t, 4, len(cfg.Jobs))
+	_, ok := cfg.Tasks["Test-Win-Intel"]
+	assert.False(t, ok)
+	testutils.AssertDeepEqual(t, &TaskSpec{
+		Dependencies: []string{"Build"},
+		Dimensions:   []string{"os:Win", "gpu:Nvidia"},
+		ExtraArgs:    []string{"--gpu", "Nvidia", "--revision", "<(REVISION)>"},
+		Isolate:      "test.isolate",
+		RunIfChanged: []string{"src/gpu/Nvidia"},
+	}, cfg.Tasks["Test-Win-Nvidia"])
+	testutils.AssertDeepEqual(t, &JobSpec{
+		Priority:  0.8,
+		TaskSpecs: []string{"Test-Ubuntu-Intel"},
+	}, cfg.Jobs["Test-Ubuntu-Intel"])
+
+	// Cycle detection runs on the expanded form.
+	_, err = ParseTasksCfg(`{
+  "jobs": {
+    "j": {"tasks": ["a-x"]}
+  },
+  "matrix": {
+    "a-<(v)>": {
+      "axes": {"v": ["x", "y"]},
+      "task": {
+        "dependencies": ["a-y"],
+        "dimensions": [],
+        "isolate": "a.isolate"
+      }
+    }
+  }
+}`)
+	assert.EqualError(t, err, "Found a circular dependency involving \"a-y\" and \"a-y\"")
+
+	// Expanded names may not collide with existing TaskSpecs.
+	_, err = ParseTasksCfg(`{
+  "tasks": {
+    "a-x": {"dimensions": [], "isolate": "a.isolate"}
+  },
+  "matrix": {
+    "a-<(v)>": {
+      "axes": {"v": ["x"]},
+      "task": {"dimensions": [], "isolate": "a.isolate"}
+    }
+  }
+}`)
+	assert.EqualError(t, err, "Matrix \"a-<(v)>\" generates duplicate task \"a-x\".")
+
+	// Reserved variables may not be used as axes.
+	_, err = ParseTasksCfg(`{
+  "matrix": {
+    "a-<(REVISION)>": {
+      "axes": {"REVISION": ["x"]},
+      "task": {"dimensions": [], "isolate": "a.isolate"}
+    }
+  }
+}`)
+	assert.EqualError(t, err, "Matrix \"a-<(REVISION)>\" uses reserved variable \"REVISION\" as an axis.")
+
+	// Every axis must appear in the name.
+	_, err = ParseTasksCfg(`{
+  "matrix": {
+    "a": {
+      "axes": {"v": ["x", "y"]},
+      "task": {"dimensions": [], "isolate": "a.isolate"}
+    }
+  }
+}`)
+	assert.EqualError(t, err, "Matrix name \"a\" does not contain placeholder for axis \"v\".")
+
+	// Expanded TaskSpecs are validated.
+	_, err = ParseTasksCfg(`{
+  "jobs": {
+    "j": {"tasks": ["a-x"]}
+  },
+  "matrix": {
+    "a-<(v)>": {
+      "axes": {"v": ["x"]},
+      "task": {"dimensions": ["<(v)>"], "isolate": "a.isolate"}
+    }
+  }
+}`)
+	assert.EqualError(t, err, "Dimension \"x\" does not contain a colon!")
+}
@@ -54,16 +54,25 @@ var (
 )
 
 // ParseTasksCfg parses the given task cfg file contents and returns the config.
+// Any TaskSpecMatrix entries are expanded, so that the returned config only
+// contains concrete TaskSpecs and JobSpecs.
 func ParseTasksCfg(contents string) (*TasksCfg, error) {
-	var rv TasksCfg
-	if err := json.Unmarshal([]byte(contents), &rv); err != nil {
+	rv := &TasksCfg{}
+	if err := json.Unmarshal([]byte(contents), rv); err != nil {
 		return nil, fmt.Errorf("Failed to read tasks cfg: could not parse file: %s\nContents:\n%s", err, string(contents))
 	}
+	if len(rv.Matrix) > 0 {
+		expanded, err := rv.Expand()
+		if err != nil {
+			return nil, err
+		}
+		rv = expanded
+	}
 	if err := rv.Validate(); err != nil {
 		return nil, err
 	}
 
-	return &rv, nil
+	return rv, nil
 }
 
 // EncoderTasksCfg writes the TasksCfg to a byte slice.
@@ -110,19 +119,38 @@ type TasksCfg struct {
 	// which describe sets of tasks to run.
 	Jobs map[string]*JobSpec `json:"jobs"`
 
+	// Matrix is a map whose keys are name templates and values are
+	// TaskSpecMatrix instances which expand into TaskSpecs and JobSpecs.
+	Matrix map[string]*TaskSpecMatrix `json:"matrix,omitempty"`
+
 	// Tasks is a map whose keys are TaskSpec names and values are TaskSpecs
 	// detailing the Swarming tasks which may be run.
 	Tasks map[string]*TaskSpec `json:"tasks"`
 }
 
-// Validate returns an error if the TasksCfg is not valid.
+// Validate returns an error if the TasksCfg is not valid. If the TasksCfg
+// contains any TaskSpecMatrix entries, the expanded form is validated.
 func (c *TasksCfg) Validate() error {
+	if len(c.Matrix) > 0 {
+		expanded, err := c.Expand()
+		if err != nil {
+			return err
+		}
+		return expanded.Validate()
+	}
+
 	for _, t := range c.Tasks {
 		if err := t.Validate(c); err != nil {
 			return err
 		}
 	}
 
+	for name, j := range c.Jobs {
+		if err := j.Validate(); err != nil {
+			return fmt.Errorf("Invalid job %q: %s", name, err)
+		}
+	}
+
 	if err := findCycles(c.Tasks, c.Jobs); err != nil {
 		return err
 	}
@@ -171,6 +199,11 @@ type TaskSpec struct {
 
 	// Priority indicates the relative priority of the task, with 0 < p <= 1
 	Priority float64 `json:"priority"`
+
+	// RunIfChanged is a list of directories, relative to the repo root. If
+	// provided, the task only runs automatically for commits which modify
+	// at least one file within one of the directories.
+	RunIfChanged []string `json:"run_if_changed,omitempty"`
 }
 
 // Validate ensures that the TaskSpec is defined properly.
@@ -195,6 +228,13 @@ func (t *TaskSpec) Validate(cfg *TasksCfg) error {
 		return fmt.Errorf("Isolate file is required.")
 	}
 
+	// Paths must be relative to the repo root.
+	for _, p := range t.RunIfChanged {
+		if p == "" || path.IsAbs(p) || strings.HasPrefix(path.Clean(p), "..") {
+			return fmt.Errorf("Invalid path %q in run_if_changed; paths must be relative to the repo root.", p)
+		}
+	}
+
 	return nil
 }
 
@@ -213,6 +253,7 @@ func (t *TaskSpec) Copy() *TaskSpec {
 	dims := util.CopyStringSlice(t.Dimensions)
 	environment := util.CopyStringMap(t.Environment)
 	extraArgs := util.CopyStringSlice(t.ExtraArgs)
+	runIfChanged := util.CopyStringSlice(t.RunIfChanged)
 	return &TaskSpec{
 		CipdPackages:     cipdPackages,
 		Dependencies:     deps,
@@ -225,7 +266,28 @@ func (t *TaskSpec) Copy() *TaskSpec {
 		Isolate:          t.Isolate,
 		MaxAttempts:      t.MaxAttempts,
 		Priority:         t.Priority,
+		RunIfChanged:     runIfChanged,
+	}
+}
+
+// ShouldRun returns true iff the TaskSpec should run automatically for a
+// commit which modifies the given files.
+func (t *TaskSpec) ShouldRun(changedFiles []string) bool {
+	if len(t.RunIfChanged) == 0 {
+		return true
+	}
+	for _, dir := range t.RunIfChanged {
+		dir = path.Clean(dir)
+		if dir == "." {
+			return len(changedFiles) > 0
+		}
+		for _, f := range changedFiles {
+			if f == dir || strings.HasPrefix(f, dir+"/") {
+				return true
+			}
+		}
 	}
+	return false
 }
 
 // CipdPackage is a struct representing a CIPD package which needs to be
@@ -236,12 +298,108 @@ type CipdPackage struct {
 	Version string `json:"version"`
 }
 
+// PathFilter describes the files which may affect the results of a Job. Paths
+// are relative to the repo root and are matched using the syntax of
+// path.Match, with the addition that a "**" segment matches any number of
+// directories.
+type PathFilter struct {
+	// Exclude lists globs for files which never affect the Job.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Include lists globs for files which may affect the Job. If empty,
+	// all files which are not excluded may affect the Job.
+	Include []string `json:"include,omitempty"`
+}
+
+// Copy returns a copy of the PathFilter.
+func (f *PathFilter) Copy() *PathFilter {
+	if f == nil {
+		return nil
+	}
+	return &PathFilter{
+		Exclude: util.CopyStringSlice(f.Exclude),
+		Include: util.CopyStringSlice(f.Include),
+	}
+}
+
+// Validate returns an error if the PathFilter is not valid.
+func (f *PathFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	for _, globs := range [][]string{f.Exclude, f.Include} {
+		for _, glob := range globs {
+			for _, segment := range strings.Split(glob, "/") {
+				if _, err := path.Match(segment, ""); err != nil {
+					return fmt.Errorf("Invalid path glob %q: %s", glob, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchSegments returns true iff the given file path segments match the
+// given glob segments.
+func matchSegments(glob, file []string) bool {
+	if len(glob) == 0 {
+		return len(file) == 0
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(file); i++ {
+			if matchSegments(glob[1:], file[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(file) == 0 {
+		return false
+	}
+	if ok, err := path.Match(glob[0], file[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(glob[1:], file[1:])
+}
+
+// matchAny returns true iff the given file matches any of the given globs.
+func matchAny(globs []string, file string) bool {
+	segments := strings.Split(file, "/")
+	for _, glob := range globs {
+		if matchSegments(strings.Split(glob, "/"), segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true iff any of the given changed files may affect the Job.
+// A nil PathFilter matches any set of files.
+func (f *PathFilter) Matches(changedFiles []string) bool {
+	if f == nil {
+		return true
+	}
+	for _, file := range changedFiles {
+		if len(f.Include) > 0 && !matchAny(f.Include, file) {
+			continue
+		}
+		if matchAny(f.Exclude, file) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
 // JobSpec is a struct which describes a set of TaskSpecs to run as part of a
 // larger effort.
 type JobSpec struct {
-	Priority  float64  `json:"priority"`
-	TaskSpecs []string `json:"tasks"`
-	Trigger   string   `json:"trigger,omitempty"`
+	// Paths, if provided, restricts the commits for which the Job is
+	// triggered to those which modify at least one matching file.
+	Paths     *PathFilter `json:"paths,omitempty"`
+	Priority  float64     `json:"priority"`
+	TaskSpecs []string    `json:"tasks"`
+	Trigger   string      `json:"trigger,omitempty"`
 }
 
 // Copy returns a copy of the JobSpec.
@@ -252,12 +410,18 @@ func (j *JobSpec) Copy() *JobSpec {
 		copy(taskSpecs, j.TaskSpecs)
 	}
 	return &JobSpec{
+		Paths:     j.Paths.Copy(),
 		Priority:  j.Priority,
 		TaskSpecs: taskSpecs,
 		Trigger:   j.Trigger,
 	}
 }
 
+// Validate returns an error if the JobSpec is not valid.
+func (j *JobSpec) Validate() error {
+	return j.Paths.Validate()
+}
+
 // GetTaskSpecDAG returns a map describing all of the dependencies of the
 // JobSpec. Its keys are TaskSpec names and values are TaskSpec names upon
 // which the keys depend.
@@ -303,6 +467,8 @@ type TaskCfgCache struct {
 	mtx           sync.RWMutex
 	// protected by mtx
 	addedTasksCache map[db.RepoState]util.StringSet
+	// protected by mtx
+	changedFiles    map[db.RepoState][]string
 	recentCommits   map[string]time.Time
 	recentJobSpecs  map[string]time.Time
 	recentMtx       sync.RWMutex
@@ -325,6 +491,7 @@ type gobTaskCfgCache struct {
 func NewTaskCfgCache(repos repograph.Map, depotToolsDir, workdir string, numWorkers int) (*TaskCfgCache, error) {
 	file := path.Join(workdir, "taskCfgCache.gob")
 	c := &TaskCfgCache{
+		changedFiles:  map[db.RepoState][]string{},
 		depotToolsDir: depotToolsDir,
 		file:          file,
 		queue:         make(chan func(int)),
@@ -606,8 +773,8 @@ func (c *TaskCfgCache) GetJobSpec(rs db.RepoState, name string) (*JobSpec, error
 }
 
 // MakeJob is a helper function which retrieves the given JobSpec at the given
-// RepoState and uses it to create a Job instance.
-func (c *TaskCfgCache) MakeJob(rs db.RepoState, name string) (*db.Job, error) {
+// RepoState and uses it to create a Job instance, created at the given time.
+func (c *TaskCfgCache) MakeJob(rs db.RepoState, name string, now time.Time) (*db.Job, error) {
 	cfg, err := c.ReadTasksCfg(rs)
 	if err != nil {
 		return nil, err
@@ -622,7 +789,7 @@ func (c *TaskCfgCache) MakeJob(rs db.RepoState, name string) (*db.Job, error) {
 	}
 
 	return &db.Job{
-		Created:      time.Now(),
+		Created:      now,
 		Dependencies: deps,
 		Name:         name,
 		Priority:     spec.Priority,
@@ -631,6 +798,131 @@ func (c *TaskCfgCache) MakeJob(rs db.RepoState, name string) (*db.Job, error) {
 	}, nil
 }
 
+// ChangedFiles returns the paths of the files modified by the commit at the
+// given RepoState, relative to the repo root. Merge commits are compared to
+// their first parent.
+func (c *TaskCfgCache) ChangedFiles(rs db.RepoState) ([]string, error) {
+	if rs.IsTryJob() {
+		return nil, fmt.Errorf("TaskCfgCache.ChangedFiles does not apply patches, and should not be called for try jobs.")
+	}
+	c.mtx.RLock()
+	rv, ok := c.changedFiles[rs]
+	c.mtx.RUnlock()
+	if ok {
+		return util.CopyStringSlice(rv), nil
+	}
+
+	repo, ok := c.repos[rs.Repo]
+	if !ok {
+		return nil, fmt.Errorf("Unknown repo %q", rs.Repo)
+	}
+	commit := repo.Get(rs.Revision)
+	if commit == nil {
+		return nil, fmt.Errorf("Unknown revision %s in %s", rs.Revision, rs.Repo)
+	}
+	var output string
+	var err error
+	if len(commit.Parents) == 0 {
+		output, err = repo.Repo().Git("ls-tree", "-r", "--name-only", rs.Revision)
+	} else {
+		output, err = repo.Repo().Git("diff", "--name-only", "--no-renames", commit.Parents[0], rs.Revision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rv = []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			rv = append(rv, line)
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.changedFiles[rs] = rv
+	return util.CopyStringSlice(rv), nil
+}
+
+// MakeConditionalJob is like MakeJob, but takes into account the files
+// modified by the commit at the given RepoState. If the JobSpec's PathFilter
+// does not match the modified files, or if none of its TaskSpecs should run
+// according to their RunIfChanged conditions, the returned Job is marked as
+// skipped. Otherwise, any TaskSpecs which should not run are omitted, along
+// with any TaskSpecs which depend on them.
+func (c *TaskCfgCache) MakeConditionalJob(rs db.RepoState, name string, now time.Time) (*db.Job, error) {
+	j, err := c.MakeJob(rs, name, now)
+	if err != nil {
+		return nil, err
+	}
+	if rs.IsTryJob() {
+		return j, nil
+	}
+	cfg, err := c.ReadTasksCfg(rs)
+	if err != nil {
+		return nil, err
+	}
+	spec := cfg.Jobs[name].Copy()
+	conditional := spec.Paths != nil
+	for taskName, _ := range j.Dependencies {
+		if len(cfg.Tasks[taskName].RunIfChanged) > 0 {
+			conditional = true
+			break
+		}
+	}
+	if !conditional {
+		return j, nil
+	}
+
+	changedFiles, err := c.ChangedFiles(rs)
+	if err != nil {
+		return nil, err
+	}
+	skip := func() *db.Job {
+		j.Dependencies = map[string][]string{}
+		j.Finished = j.Created
+		j.Status = db.JOB_STATUS_SKIPPED
+		return j
+	}
+	if !spec.Paths.Matches(changedFiles) {
+		return skip(), nil
+	}
+
+	// A TaskSpec is omitted if it should not run or if any of its
+	// dependencies are omitted.
+	omitted := map[string]bool{}
+	var visit func(string) bool
+	visit = func(taskName string) bool {
+		if rv, ok := omitted[taskName]; ok {
+			return rv
+		}
+		rv := !cfg.Tasks[taskName].ShouldRun(changedFiles)
+		for _, d := range j.Dependencies[taskName] {
+			if visit(d) {
+				rv = true
+			}
+		}
+		omitted[taskName] = rv
+		return rv
+	}
+	taskSpecs := make([]string, 0, len(spec.TaskSpecs))
+	for _, taskName := range spec.TaskSpecs {
+		if !visit(taskName) {
+			taskSpecs = append(taskSpecs, taskName)
+		}
+	}
+	if len(taskSpecs) == 0 {
+		return skip(), nil
+	}
+	// Recompute the DAG so that TaskSpecs which were only needed by
+	// omitted TaskSpecs are also omitted.
+	spec.TaskSpecs = taskSpecs
+	j.Dependencies, err = spec.GetTaskSpecDAG(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
 // Cleanup removes cache entries which are outside of our scheduling window.
 func (c *TaskCfgCache) Cleanup(period time.Duration) error {
 	c.mtx.Lock()
@@ -648,6 +940,12 @@ func (c *TaskCfgCache) Cleanup(period time.Duration) error {
 			delete(c.addedTasksCache, repoState)
 		}
 	}
+	for repoState, _ := range c.changedFiles {
+		details, err := repoState.GetCommit(c.repos)
+		if err != nil || details.Timestamp.Before(periodStart) {
+			delete(c.changedFiles, repoState)
+		}
+	}
 	c.recentMtx.Lock()
 	defer c.recentMtx.Unlock()
 	for k, ts := range c.recentCommits {
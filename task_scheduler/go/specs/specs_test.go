@@ -47,6 +47,7 @@ func TestCopyTaskSpec(t *testing.T) {
 		Isolate:          "abc123",
 		MaxAttempts:      5,
 		Priority:         19.0,
+		RunIfChanged:     []string{"src/gpu"},
 	}
 	testutils.AssertCopy(t, v, v.Copy())
 }
@@ -54,6 +55,10 @@ func TestCopyTaskSpec(t *testing.T) {
 func TestCopyJobSpec(t *testing.T) {
 	testutils.SmallTest(t)
 	v := &JobSpec{
+		Paths: &PathFilter{
+			Exclude: []string{"docs/**"},
+			Include: []string{"src/**"},
+		},
 		TaskSpecs: []string{"Build", "Test"},
 		Trigger:   "trigger-name",
 		Priority:  753,
@@ -669,3 +674,186 @@ func TestTaskCfgCacheSerialization(t *testing.T) {
 	}
 	assert.NoError(t, c.write())
 }
+
+func TestTaskSpecShouldRun(t *testing.T) {
+	testutils.SmallTest(t)
+	check := func(runIfChanged, changedFiles []string, expect bool) {
+		ts := &TaskSpec{
+			RunIfChanged: runIfChanged,
+		}
+		assert.Equal(t, expect, ts.ShouldRun(changedFiles))
+	}
+	check(nil, []string{}, true)
+	check(nil, []string{"a/b"}, true)
+	check([]string{"a"}, []string{}, false)
+	check([]string{"a"}, []string{"a/b"}, true)
+	check([]string{"a/"}, []string{"a/b"}, true)
+	check([]string{"a"}, []string{"ab/c"}, false)
+	check([]string{"a/b"}, []string{"a/c", "a/b/c"}, true)
+	check([]string{"a", "c"}, []string{"b", "c"}, true)
+	check([]string{"."}, []string{"b"}, true)
+
+	cfg := &TasksCfg{}
+	ts := &TaskSpec{
+		Isolate:      "a.isolate",
+		RunIfChanged: []string{"/a"},
+	}
+	assert.EqualError(t, ts.Validate(cfg), "Invalid path \"/a\" in run_if_changed; paths must be relative to the repo root.")
+	ts.RunIfChanged = []string{"../a"}
+	assert.EqualError(t, ts.Validate(cfg), "Invalid path \"../a\" in run_if_changed; paths must be relative to the repo root.")
+	ts.RunIfChanged = []string{"a/b"}
+	assert.NoError(t, ts.Validate(cfg))
+}
+
+func TestMakeConditionalJob(t *testing.T) {
+	testutils.LargeTest(t)
+	testutils.SkipIfShort(t)
+
+	gb := git_testutils.GitInit(t)
+	defer gb.Cleanup()
+	gb.Add(TASKS_CFG_FILE, `{
+  "tasks": {
+    "Build": {
+      "dimensions": ["os:Ubuntu"],
+      "isolate": "compile.isolate"
+    },
+    "Docs": {
+      "dimensions": ["os:Ubuntu"],
+      "isolate": "docs.isolate",
+      "run_if_changed": ["docs"]
+    },
+    "Test": {
+      "dependencies": ["Build"],
+      "dimensions": ["os:Ubuntu"],
+      "isolate": "test.isolate",
+      "run_if_changed": ["src"]
+    }
+  },
+  "jobs": {
+    "Build": {"tasks": ["Build"]},
+    "Docs": {"tasks": ["Docs"]},
+    "Lint": {
+      "paths": {"include": ["**/*.cpp"]},
+      "tasks": ["Build"]
+    },
+    "Test": {"tasks": ["Test", "Docs"]}
+  }
+}`)
+	c1 := gb.CommitMsg("initial commit")
+	c2 := gb.CommitGen("docs/README.md")
+	c3 := gb.CommitGen("src/foo.cpp")
+
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+
+	repos, err := repograph.NewMap([]string{gb.RepoUrl()}, tmp)
+	assert.NoError(t, err)
+
+	cache, err := NewTaskCfgCache(repos, specs_testutils.GetDepotTools(t), tmp, DEFAULT_NUM_WORKERS)
+	assert.NoError(t, err)
+
+	now := time.Date(2017, time.May, 10, 12, 0, 0, 0, time.UTC)
+	check := func(commit, name string, expect map[string][]string) {
+		rs := db.RepoState{
+			Repo:     gb.RepoUrl(),
+			Revision: commit,
+		}
+		j, err := cache.MakeConditionalJob(rs, name, now)
+		assert.NoError(t, err)
+		assert.Equal(t, now, j.Created)
+		if expect == nil {
+			assert.Equal(t, db.JOB_STATUS_SKIPPED, j.Status)
+			assert.True(t, j.Done())
+			assert.Equal(t, 0, len(j.Dependencies))
+		} else {
+			assert.Equal(t, db.JOB_STATUS_IN_PROGRESS, j.Status)
+			testutils.AssertDeepEqual(t, expect, j.Dependencies)
+		}
+	}
+
+	// The initial commit only adds the tasks cfg file.
+	files, err := cache.ChangedFiles(db.RepoState{
+		Repo:     gb.RepoUrl(),
+		Revision: c1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{TASKS_CFG_FILE}, files)
+	check(c1, "Build", map[string][]string{"Build": []string{}})
+	check(c1, "Docs", nil)
+	check(c1, "Lint", nil)
+	check(c1, "Test", nil)
+
+	// c2 modifies docs.
+	check(c2, "Build", map[string][]string{"Build": []string{}})
+	check(c2, "Docs", map[string][]string{"Docs": []string{}})
+	check(c2, "Lint", nil)
+	check(c2, "Test", map[string][]string{"Docs": []string{}})
+
+	// c3 modifies src.
+	check(c3, "Docs", nil)
+	check(c3, "Lint", map[string][]string{"Build": []string{}})
+	check(c3, "Test", map[string][]string{
+		"Build": []string{},
+		"Test":  []string{"Build"},
+	})
+
+	// MakeJob ignores conditions.
+	j, err := cache.MakeJob(db.RepoState{
+		Repo:     gb.RepoUrl(),
+		Revision: c3,
+	}, "Docs", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now, j.Created)
+	testutils.AssertDeepEqual(t, map[string][]string{"Docs": []string{}}, j.Dependencies)
+}
+
+func TestPathFilter(t *testing.T) {
+	testutils.SmallTest(t)
+	check := func(f *PathFilter, changedFiles []string, expect bool) {
+		assert.Equal(t, expect, f.Matches(changedFiles))
+	}
+	var f *PathFilter
+	check(f, []string{}, true)
+	check(f, []string{"a"}, true)
+
+	f = &PathFilter{
+		Include: []string{"src/**"},
+	}
+	check(f, []string{}, false)
+	check(f, []string{"docs/README.md"}, false)
+	check(f, []string{"docs/README.md", "src/a/b.cpp"}, true)
+	check(f, []string{"src"}, false)
+
+	f = &PathFilter{
+		Exclude: []string{"**/*.md", "docs/**"},
+	}
+	check(f, []string{"README.md", "src/README.md", "docs/a.txt"}, false)
+	check(f, []string{"README.md", "src/a.cpp"}, true)
+
+	f = &PathFilter{
+		Exclude: []string{"src/third_party/**"},
+		Include: []string{"src/**/*.cpp", "BUILD.gn"},
+	}
+	check(f, []string{"src/a.cpp"}, true)
+	check(f, []string{"src/a/b/c.cpp"}, true)
+	check(f, []string{"src/a.h"}, false)
+	check(f, []string{"src/third_party/a.cpp"}, false)
+	check(f, []string{"BUILD.gn"}, true)
+	check(f, []string{"a/BUILD.gn"}, false)
+
+	assert.NoError(t, f.Validate())
+	f.Include = []string{"src/[a"}
+	assert.EqualError(t, f.Validate(), "Invalid path glob \"src/[a\": syntax error in pattern")
+
+	// Invalid globs are caught when parsing the config.
+	_, err := ParseTasksCfg(`{
+  "tasks": {
+    "a": {"dimensions": [], "isolate": "a.isolate"}
+  },
+  "jobs": {
+    "j": {"paths": {"exclude": ["[a"]}, "tasks": ["a"]}
+  }
+}`)
+	assert.EqualError(t, err, "Invalid job \"j\": Invalid path glob \"[a\": syntax error in pattern")
+}
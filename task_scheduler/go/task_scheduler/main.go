@@ -70,6 +70,7 @@ var (
 	triggerTemplate   *template.Template = nil
 
 	// Flags.
+	dedupTasks     = flag.Bool("dedup_tasks", false, "Whether to reuse the results of previous successful tasks with identical inputs instead of triggering new Swarming tasks.")
 	host           = flag.String("host", "localhost", "HTTP service host")
 	port           = flag.String("port", ":8000", "HTTP service port for the web server (e.g., ':8000')")
 	dbPort         = flag.String("db_port", ":8008", "HTTP service port for the database RPC server (e.g., ':8008')")
@@ -220,14 +221,14 @@ func jsonBlacklistHandler(w http.ResponseWriter, r *http.Request) {
 		defer util.Close(r.Body)
 		rule.AddedBy = login.LoggedInAs(r)
 		if len(rule.Commits) == 2 {
-			rangeRule, err := blacklist.NewCommitRangeRule(rule.Name, rule.AddedBy, rule.Description, rule.TaskSpecPatterns, rule.Commits[0], rule.Commits[1], repos)
+			rangeRule, err := blacklist.NewCommitRangeRule(rule.Name, rule.AddedBy, rule.Description, rule.TaskSpecPatterns, rule.Commits[0], rule.Commits[1], repos, time.Now())
 			if err != nil {
 				httputils.ReportError(w, r, err, fmt.Sprintf("Failed to create commit range rule: %s", err))
 				return
 			}
 			rule = *rangeRule
 		}
-		if err := ts.GetBlacklist().AddRule(&rule, repos); err != nil {
+		if err := ts.GetBlacklist().AddRule(&rule, repos, time.Now()); err != nil {
 			httputils.ReportError(w, r, err, fmt.Sprintf("Failed to add blacklist rule: %s", err))
 			return
 		}
@@ -238,6 +239,14 @@ func jsonBlacklistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func jsonFlakesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ts.FlakeStats()); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode response.")
+		return
+	}
+}
+
 func jsonTriggerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "https://status.skia.org")
@@ -435,6 +444,7 @@ func runServer(serverURL string) {
 	r.HandleFunc("/job/{id}", jobHandler)
 	r.HandleFunc("/trigger", triggerHandler)
 	r.HandleFunc("/json/blacklist", jsonBlacklistHandler).Methods(http.MethodPost, http.MethodDelete)
+	r.HandleFunc("/json/flakes", jsonFlakesHandler)
 	r.HandleFunc("/json/job/{id}", jsonJobHandler)
 	r.HandleFunc("/json/job/{id}/cancel", jsonCancelJobHandler).Methods(http.MethodPost)
 	r.HandleFunc("/json/jobs/search", jsonJobSearchHandler)
@@ -589,6 +599,7 @@ func main() {
 	if err != nil {
 		sklog.Fatal(err)
 	}
+	ts.SetDeduplicateTasks(*dedupTasks)
 
 	sklog.Infof("Created task scheduler. Starting loop.")
 	ts.Start(ctx, b.Tick)
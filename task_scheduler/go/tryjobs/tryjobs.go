@@ -354,7 +354,7 @@ func (t *TryJobIntegrator) getJobToSchedule(b *buildbucket_api.ApiBuildMessage,
 	}
 
 	// Create a Job.
-	j, err := t.taskCfgCache.MakeJob(rs, params.BuilderName)
+	j, err := t.taskCfgCache.MakeJob(rs, params.BuilderName, time.Now())
 	if err != nil {
 		return nil, t.remoteCancelBuild(b.Id, fmt.Sprintf("Failed to obtain JobSpec: %s", err))
 	}
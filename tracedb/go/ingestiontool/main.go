@@ -0,0 +1,173 @@
+// ingestiontool is a command-line tool for inspecting and reprocessing the
+// files that a running ingestion server failed to ingest.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/human"
+	"go.skia.org/infra/go/ingestion"
+	"go.skia.org/infra/go/util"
+)
+
+// flags
+var (
+	address = flag.String("address", "localhost:9192", "The admin address of the ingestion server, i.e. the value of its --admin_port flag.")
+	begin   = flag.String("begin", "1w", "Select the files that failed in the range beginning this long ago.")
+	end     = flag.String("end", "0s", "Select the files that failed in the range ending this long ago.")
+	id      = flag.String("id", "", "The id of the ingester.")
+	names   = flag.String("names", "", "A comma separated list of names of failed files.")
+)
+
+var Usage = func() {
+	fmt.Printf(`Usage: ingestiontool <command> [OPTIONS]...
+Inspect and reprocess the failed files of a running ingestion server.
+
+Commands:
+
+  failed      List the files in the dead-letter stores of the ingesters.
+
+              Flags: --id
+
+              If --id is given then only the failed files of that ingester
+              are listed.
+
+  reprocess   Re-ingest failed files, e.g. after a bug in a Processor has been
+              fixed.
+
+              Flags: --id --names --begin --end
+
+              If --names is given then the failed files with those names are
+              re-ingested, otherwise all the files that failed in the time
+              range given by --begin and --end are re-ingested.
+
+Examples:
+
+  To list the failed files of all ingesters:
+
+    ingestiontool failed
+
+  To reprocess all the files of the gold ingester that failed in the last day:
+
+    ingestiontool reprocess -id gold -begin 1d
+
+Flags:
+
+`)
+	flag.PrintDefaults()
+}
+
+// post sends the given request as JSON to the given path of the ingestion
+// server and decodes the JSON response into resp.
+func post(path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("Failed to encode request: %s", err)
+	}
+	r, err := http.Post(fmt.Sprintf("http://%s%s", *address, path), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Request failed: %s", err)
+	}
+	defer util.Close(r.Body)
+	return decode(r, resp)
+}
+
+// get retrieves the given path of the ingestion server and decodes the JSON
+// response into resp.
+func get(path string, resp interface{}) error {
+	r, err := http.Get(fmt.Sprintf("http://%s%s", *address, path))
+	if err != nil {
+		return fmt.Errorf("Request failed: %s", err)
+	}
+	defer util.Close(r.Body)
+	return decode(r, resp)
+}
+
+// decode decodes the JSON body of the given response into resp.
+func decode(r *http.Response, resp interface{}) error {
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("Request failed with status %s", r.Status)
+	}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("Failed to decode response: %s", err)
+	}
+	return nil
+}
+
+func failed() {
+	resp := map[string][]*ingestion.FailedFile{}
+	if err := get("/failed?id="+*id, &resp); err != nil {
+		fmt.Printf("Failed to retrieve the failed files: %s\n", err)
+		return
+	}
+	for ingesterID, files := range resp {
+		fmt.Printf("%s: %d failed files\n", ingesterID, len(files))
+		for _, f := range files {
+			fmt.Printf("  %s  %s  attempts: %d  error: %s\n", time.Unix(f.Failed, 0).UTC().Format(time.RFC3339), f.Name, f.Attempts, f.Error)
+		}
+	}
+}
+
+func reprocess() {
+	if *id == "" {
+		fmt.Printf("The --id flag is required.\n")
+		return
+	}
+	req := &ingestion.ReprocessRequest{
+		ID: *id,
+	}
+	if *names != "" {
+		req.Names = strings.Split(*names, ",")
+	} else {
+		now := time.Now()
+		b, err := human.ParseDuration(*begin)
+		if err != nil {
+			fmt.Printf("Invalid begin value: %s\n", err)
+			return
+		}
+		e, err := human.ParseDuration(*end)
+		if err != nil {
+			fmt.Printf("Invalid end value: %s\n", err)
+			return
+		}
+		req.Begin = now.Add(-b).Unix()
+		req.End = now.Add(-e).Unix() + 1
+	}
+	resp := &ingestion.ReprocessResponse{}
+	if err := post("/reprocess", req, resp); err != nil {
+		fmt.Printf("Failed to reprocess files: %s\n", err)
+		return
+	}
+	fmt.Printf("Reprocessed %d files.\n", resp.Reprocessed)
+}
+
+func main() {
+	// Grab the first argument off of os.Args, the command, before we call flag.Parse.
+	if len(os.Args) < 2 {
+		Usage()
+		return
+	}
+	cmd := os.Args[1]
+	os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+
+	// Now parse the flags.
+	common.Init()
+
+	switch cmd {
+	case "failed":
+		failed()
+	case "reprocess":
+		reprocess()
+	default:
+		fmt.Printf("Unknown command: %s\n", cmd)
+		Usage()
+	}
+}
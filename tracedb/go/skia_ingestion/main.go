@@ -6,6 +6,7 @@ package main
 import (
 	"flag"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/geventbus"
+	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/ingestion"
 	"go.skia.org/infra/go/sharedconfig"
 	"go.skia.org/infra/go/sklog"
@@ -27,11 +29,14 @@ import (
 
 // Command line flags.
 var (
+	adminPort          = flag.String("admin_port", "localhost:9192", "HTTP service address for listing and reprocessing failed files, see ingestiontool. Should only be reachable from localhost.")
 	configFilename     = flag.String("config_filename", "default.toml", "Configuration file in TOML format.")
 	local              = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
 	memProfile         = flag.Duration("memprofile", 0, "Duration for which to profile memory. After this duration the program writes the memory profile and exits.")
 	nsqdAddress        = flag.String("nsqd", "", "Address and port of nsqd instance.")
+	port               = flag.String("port", ":9092", "HTTP service address for push notifications (e.g., ':9092')")
 	promPort           = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
+	pushToken          = flag.String("push_token", "", "Secret token that push notifications have to carry, either as the 'token' query parameter or as the channel token. If empty, push notifications are rejected.")
 	serviceAccountFile = flag.String("service_account_file", "", "Credentials file for service account.")
 )
 
@@ -76,6 +81,22 @@ func main() {
 		oneIngester.Start()
 	}
 
+	// Receive push notifications for watched sources.
+	http.HandleFunc("/push", ingestion.PushHandler(ingesters, *pushToken))
+	go func() {
+		sklog.Fatal(http.ListenAndServe(*port, httputils.LoggingGzipRequestResponse(http.DefaultServeMux)))
+	}()
+
+	// Serve the dead-letter stores of the ingesters. Since reprocessing
+	// isn't authenticated, it's served separately on adminPort, which is
+	// bound to localhost by default.
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/failed", ingestion.FailedFilesHandler(ingesters))
+	adminMux.HandleFunc("/reprocess", ingestion.ReprocessHandler(ingesters))
+	go func() {
+		sklog.Fatal(http.ListenAndServe(*adminPort, httputils.LoggingGzipRequestResponse(adminMux)))
+	}()
+
 	// Enable the memory profiler if memProfile was set.
 	if *memProfile > 0 {
 		writeProfileFn := func() {
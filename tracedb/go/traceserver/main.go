@@ -21,12 +21,13 @@ import (
 
 // flags
 var (
-	cpuprofile = flag.String("cpuprofile", "", "Write cpu profile to file.")
-	db_file    = flag.String("db_file", "", "The name of the BoltDB file that will store the traces.")
-	local      = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
-	port       = flag.String("port", ":9090", "The port to serve the gRPC endpoint on.")
-	promPort   = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
-	sharedbDir = flag.String("sharedb_dir", "", "Directory used by shareDB. If empty shareDB service will not enabled.")
+	cpuprofile      = flag.String("cpuprofile", "", "Write cpu profile to file.")
+	db_file         = flag.String("db_file", "", "The name of the BoltDB file that will store the traces.")
+	local           = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
+	port            = flag.String("port", ":9090", "The port to serve the gRPC endpoint on.")
+	promPort        = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
+	retentionConfig = flag.String("retention_config", "", "TOML file with the retention policy for the stored data. If empty all data is kept forever.")
+	sharedbDir      = flag.String("sharedb_dir", "", "Directory used by shareDB. If empty shareDB service will not enabled.")
 )
 
 func main() {
@@ -40,6 +41,15 @@ func main() {
 		sklog.Fatalf("Failed to initialize the tracestore server: %s", err)
 	}
 
+	// Remove expired data in the background if a retention policy was given.
+	if *retentionConfig != "" {
+		policy, err := traceservice.RetentionPolicyFromTomlFile(*retentionConfig)
+		if err != nil {
+			sklog.Fatalf("Failed to read the retention policy: %s", err)
+		}
+		ts.StartRetention(policy)
+	}
+
 	lis, err := net.Listen("tcp", *port)
 	if err != nil {
 		sklog.Fatalf("failed to listen: %v", err)
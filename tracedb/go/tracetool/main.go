@@ -3,8 +3,13 @@ package main
 
 import (
 	"crypto/md5"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"os"
 	"regexp"
@@ -31,6 +36,9 @@ var (
 	verbose = flag.Bool("verbose", false, "Verbose output.")
 	only    = flag.Bool("only", false, "If true then only print values, otherwise print keys and values.")
 	showMD5 = flag.Bool("md5", false, "If true then include the MD5 hash value for each commit id to compare across databases. Warning: Slow !")
+	format  = flag.String("format", "csv", "The output format of export, either 'csv' or 'json'.")
+	query   = flag.String("query", "", "A query, e.g. 'config=8888&arch=x86', that the params of the exported traces must match.")
+	perf    = flag.Bool("perf", false, "If true then the values are Perf values (float64), otherwise they are Gold digests (strings).")
 )
 
 var Usage = func() {
@@ -73,6 +81,14 @@ Commands:
 	  					This only makes sense for Gold data since the digests are stored
 	  					strings.
 
+  export      Export the values of the traces for all commits in the given time range.
+  						Flags: --begin --end --regex --query --format --perf
+
+	  					The values are streamed from the server and written to stdout,
+	  					one line per commit and trace, either as CSV or as JSON
+	  					objects. Only the traces whose params match --query and whose
+	  					traceids match --regex are exported.
+
 Examples:
 
   To list all the commits for the first 6 days of the previous week:
@@ -83,6 +99,10 @@ Examples:
 
     tracetool -begin 1d
 
+  To export the Perf values of all the 8888 traces of the last week as CSV:
+
+    tracetool export -begin 1w -query config=8888 -perf
+
 Flags:
 
 `)
@@ -203,6 +223,14 @@ func goldConverter(b []byte) interface{} {
 	return string(b)
 }
 
+// perfConverter in an implementation of converter for Perf values (float64).
+func perfConverter(b []byte) interface{} {
+	if len(b) != 8 {
+		return math.NaN()
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
 func sample(client traceservice.TraceServiceClient) {
 	// Get all the CommitIDs in the given time range.
 	listResp, err := _list(client)
@@ -361,6 +389,94 @@ func value_grep(client traceservice.TraceServiceClient) {
 	}
 }
 
+// exportRow is a single exported value.
+type exportRow struct {
+	CommitID  string      `json:"commitid"`
+	Source    string      `json:"source"`
+	Timestamp int64       `json:"timestamp"`
+	TraceID   string      `json:"traceid"`
+	Value     interface{} `json:"value"`
+}
+
+func export(client traceservice.TraceServiceClient) {
+	var r *regexp.Regexp
+	if *regex != "" {
+		var err error
+		if r, err = regexp.Compile(*regex); err != nil {
+			sklog.Fatalf("Invalid value for regex %q: %s\n", *regex, err)
+		}
+	}
+	conv := goldConverter
+	if *perf {
+		conv = perfConverter
+	}
+
+	// write writes a single row in the requested format.
+	var write func(row *exportRow) error
+	switch *format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+		if err := w.Write([]string{"commitid", "source", "timestamp", "traceid", "value"}); err != nil {
+			sklog.Fatalf("Failed to write header: %s", err)
+		}
+		write = func(row *exportRow) error {
+			return w.Write([]string{row.CommitID, row.Source, fmt.Sprintf("%d", row.Timestamp), row.TraceID, fmt.Sprintf("%v", row.Value)})
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		write = func(row *exportRow) error {
+			return enc.Encode(row)
+		}
+	default:
+		sklog.Fatalf("Unknown format: %s", *format)
+	}
+
+	now := time.Now()
+	b, err := human.ParseDuration(*begin)
+	if err != nil {
+		sklog.Fatalf("Invalid begin value: %s", err)
+	}
+	e, err := human.ParseDuration(*end)
+	if err != nil {
+		sklog.Fatalf("Invalid end value: %s", err)
+	}
+	req := &traceservice.GetValuesRangeRequest{
+		Begin: now.Add(-b).Unix(),
+		End:   now.Add(-e).Unix(),
+		Query: *query,
+	}
+	stream, err := client.GetValuesRange(context.Background(), req)
+	if err != nil {
+		sklog.Fatalf("Failed to request values: %s", err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			sklog.Fatalf("Failed to retrieve values: %s", err)
+		}
+		cid := resp.Commitid
+		for _, pair := range resp.Values {
+			if r != nil && !r.MatchString(pair.Key) {
+				continue
+			}
+			row := &exportRow{
+				CommitID:  cid.Id,
+				Source:    cid.Source,
+				Timestamp: cid.Timestamp,
+				TraceID:   pair.Key,
+				Value:     conv(pair.Value),
+			}
+			if err := write(row); err != nil {
+				sklog.Fatalf("Failed to write values: %s", err)
+			}
+		}
+	}
+}
+
 func main() {
 	rand.Seed(time.Now().Unix())
 	// Grab the first argument off of os.Args, the command, before we call flag.Parse.
@@ -395,6 +511,8 @@ func main() {
 		param_grep(client)
 	case "value_grep":
 		value_grep(client)
+	case "export":
+		export(client)
 	default:
 		fmt.Printf("Unknown command: %s\n", cmd)
 		Usage()